@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// accessReviewCacheTTL es cuánto se reutiliza el resultado de un SubjectAccessReview;
+// un permiso revocado deja de valer como mucho después de este plazo
+const accessReviewCacheTTL = 30 * time.Second
+
+type cachedAccessReview struct {
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+var (
+	accessReviewCache   = make(map[string]cachedAccessReview)
+	accessReviewCacheMu sync.Mutex
+)
+
+// checkPortForwardAccess comprueba con un SubjectAccessReview que el usuario de la
+// petición pueda crear pods/portforward sobre el pod en Kubernetes, para que el
+// backend no otorgue más acceso que el del propio usuario. Solo con
+// SUBJECT_ACCESS_REVIEW. Devuelve el status HTTP a responder.
+func checkPortForwardAccess(ctx context.Context, clientset *kubernetes.Clientset, identity RequestIdentity, namespace, pod string) (int, error) {
+	if !appConfig.SubjectAccessReview {
+		return http.StatusOK, nil
+	}
+	return checkResourceAccess(ctx, clientset, identity, "port-forward", authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        "create",
+		Resource:    "pods",
+		Subresource: "portforward",
+		Name:        pod,
+	})
+}
+
+// checkResourceAccess comprueba con un SubjectAccessReview que el usuario de la
+// petición (Argocd-Username y sus grupos, con SAR_USER_PREFIX y SAR_GROUP_PREFIX)
+// tenga en Kubernetes el permiso de attrs. A diferencia de checkPortForwardAccess no
+// depende de SUBJECT_ACCESS_REVIEW: lo usan las operaciones que crean pods o
+// ejecutan comandos en ellos. action nombra la operación en el error.
+func checkResourceAccess(ctx context.Context, clientset *kubernetes.Clientset, identity RequestIdentity, action string, attrs authorizationv1.ResourceAttributes) (int, error) {
+	if identity.User == "" {
+		return http.StatusForbidden, fmt.Errorf("%s denegado: la petición no identifica al usuario", action)
+	}
+
+	user := appConfig.SARUserPrefix + identity.User
+	groups := make([]string, 0, len(identity.Groups))
+	for _, group := range identity.Groups {
+		groups = append(groups, appConfig.SARGroupPrefix+group)
+	}
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource += "/" + attrs.Subresource
+	}
+	key := fmt.Sprintf("%s|%s|%s %s|%s/%s", user, strings.Join(groups, ","), attrs.Verb, resource, attrs.Namespace, attrs.Name)
+
+	accessReviewCacheMu.Lock()
+	cached, ok := accessReviewCache[key]
+	accessReviewCacheMu.Unlock()
+	if !ok || time.Now().After(cached.expires) {
+		review, err := clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               user,
+				Groups:             groups,
+				ResourceAttributes: &attrs,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			addCounter("pod_forward_access_reviews_total", map[string]string{"project": identity.Project, "result": "error"}, 1)
+			return http.StatusInternalServerError, fmt.Errorf("error al verificar permisos del usuario: %v", err)
+		}
+		cached = cachedAccessReview{allowed: review.Status.Allowed, reason: review.Status.Reason, expires: time.Now().Add(accessReviewCacheTTL)}
+
+		accessReviewCacheMu.Lock()
+		for k, entry := range accessReviewCache {
+			if time.Now().After(entry.expires) {
+				delete(accessReviewCache, k)
+			}
+		}
+		accessReviewCache[key] = cached
+		accessReviewCacheMu.Unlock()
+
+		result := "denied"
+		if cached.allowed {
+			result = "allowed"
+		}
+		addCounter("pod_forward_access_reviews_total", map[string]string{"project": identity.Project, "result": result}, 1)
+	}
+
+	if !cached.allowed {
+		slog.Info("Acceso denegado por SubjectAccessReview", "component", "checkResourceAccess", "user", user, "verb", attrs.Verb, "resource", resource, "namespace", attrs.Namespace, "name", attrs.Name, "reason", cached.reason)
+		return http.StatusForbidden, fmt.Errorf("%s denegado: el usuario %s no tiene permiso %s %s en %s", action, identity.User, attrs.Verb, resource, attrs.Namespace)
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatos de ACCESS_LOG_FORMAT
+const (
+	accessLogJSON     = "json"
+	accessLogCombined = "combined"
+)
+
+// accessLogger escribe una línea por petición en stdout, sin el prefijo de fecha
+// de log, para que los analizadores la lean tal cual
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// accessLogEntryKey guarda en el contexto de la petición la entrada que completa proxyHTTP
+type accessLogEntryKey struct{}
+
+// accessLogEntry son los campos de la petición que solo conoce el proxy
+type accessLogEntry struct {
+	Session   string
+	Key       string
+	Target    string
+	Namespace string
+	Pod       string
+	Port      int
+	// session es la sesión por la que pasó la petición, para la auditoría
+	session *PortForwardSession
+}
+
+// accessLogWriter registra el status y los bytes enviados al cliente
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush y Hijack se delegan para que los streams y los WebSockets sigan funcionando
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap permite a http.ResponseController llegar a la conexión (ej: plazos de escritura)
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("el ResponseWriter no soporta Hijack")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// accessLog asigna el ID de la petición y, al terminarla, registra un resumen
+// estructurado (sesión, target, status, duración) en el logger. Además escribe el
+// access log en el formato de ACCESS_LOG_FORMAT: json o combined (Apache/NCSA con
+// los campos session y target al final); vacío no lo escribe.
+func accessLog(next http.Handler) http.Handler {
+	format := appConfig.AccessLogFormat
+	switch format {
+	case "", accessLogJSON, accessLogCombined:
+	default:
+		slog.Warn("Formato de access log desconocido, access log deshabilitado", "component", "accessLog", "format", format)
+		format = ""
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		r = withRequestID(w, r)
+		entry := &accessLogEntry{}
+		recorder := &accessLogWriter{ResponseWriter: w}
+		// La ruta se toma antes de que argocdProxyCompat quite el prefijo
+		uri := r.URL.RequestURI()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessLogEntryKey{}, entry)))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if entry.session != nil {
+			auditRequest(r, entry.session, recorder.status, recorder.bytes, started)
+		}
+		user := identityFromRequest(r).User
+		level := slog.LevelInfo
+		if isHealthPath(r.URL.Path) || r.URL.Path == "/metrics" {
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("requestId", requestID(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("user", user),
+			slog.String("session", entry.Session),
+			slog.String("sessionKey", entry.Key),
+			slog.String("namespace", entry.Namespace),
+			slog.String("pod", entry.Pod),
+			slog.Int("port", entry.Port),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Int64("durationMs", time.Since(started).Milliseconds()))
+		if format == "" {
+			return
+		}
+		if format == accessLogJSON {
+			line, _ := json.Marshal(map[string]interface{}{
+				"time":       started.UTC().Format(time.RFC3339),
+				"requestId":  requestID(r.Context()),
+				"remote":     remoteHost(r),
+				"user":       user,
+				"method":     r.Method,
+				"uri":        uri,
+				"proto":      r.Proto,
+				"status":     recorder.status,
+				"bytes":      recorder.bytes,
+				"referer":    r.Referer(),
+				"userAgent":  r.UserAgent(),
+				"session":    entry.Session,
+				"target":     entry.Target,
+				"durationMs": time.Since(started).Milliseconds(),
+			})
+			accessLogger.Print(string(line))
+			return
+		}
+		accessLogger.Printf(`%s - %s [%s] "%s %s %s" %d %s "%s" "%s" session=%s target=%s duration_ms=%d`,
+			remoteHost(r), combinedField(user), started.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, uri, r.Proto, recorder.status, combinedBytes(recorder.bytes),
+			combinedQuoted(r.Referer()), combinedQuoted(r.UserAgent()),
+			combinedField(entry.Session), combinedField(entry.Target), time.Since(started).Milliseconds())
+	})
+}
+
+// noteAccessLogSession agrega la sesión y el target a la línea del access log de la petición
+func noteAccessLogSession(r *http.Request, session *PortForwardSession) {
+	entry, ok := r.Context().Value(accessLogEntryKey{}).(*accessLogEntry)
+	if !ok {
+		return
+	}
+	entry.session = session
+	entry.Session = session.ID
+	entry.Key = session.Key
+	entry.Namespace, entry.Pod, entry.Port = session.Namespace, session.Pod, session.Port
+	entry.Target = fmt.Sprintf("%s/%s:%d", session.Namespace, session.Pod, session.Port)
+}
+
+// remoteHost devuelve la IP del cliente sin el puerto
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// combinedField devuelve "-" para los campos vacíos, como el formato combined
+func combinedField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, " ", "%20")
+}
+
+// combinedBytes devuelve "-" si no se envió cuerpo, como %b de Apache
+func combinedBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// combinedQuoted escapa comillas y barras de un campo entre comillas
+func combinedQuoted(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// draining indica que el backend no acepta sesiones nuevas
+var draining atomic.Bool
+
+// requireRole responde 403 si el usuario no tiene al menos el rol indicado
+func requireRole(w http.ResponseWriter, r *http.Request, role Role) bool {
+	identity := identityFromRequest(r)
+	if identity.Role() < role {
+		slog.Info("Acceso denegado por rol", "component", "requireRole", "method", r.Method, "path", r.URL.Path,
+			"user", identity.User, "role", identity.Role().String(), "required", role.String())
+		http.Error(w, "Permisos insuficientes", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleAdminDrain activa (POST) o desactiva (DELETE) el modo drain.
+// Al activarlo se cierran todas las sesiones y se rechazan las nuevas.
+func handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, RoleAdmin) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		draining.Store(true)
+		sessionsMu.RLock()
+		sessions := make([]*PortForwardSession, 0, len(activeSessions))
+		for _, sess := range activeSessions {
+			sessions = append(sessions, sess)
+		}
+		sessionsMu.RUnlock()
+		for _, sess := range sessions {
+			sess.Close("drain")
+		}
+		slog.Info("Drain activado", "component", "handleAdminDrain", "user", identityFromRequest(r).User, "closedSessions", len(sessions))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"draining": true, "closed": len(sessions)})
+	case http.MethodDelete:
+		draining.Store(false)
+		slog.Info("Drain desactivado", "component", "handleAdminDrain", "user", identityFromRequest(r).User)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"draining": false})
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminReport resume las sesiones activas por proyecto, namespace y usuario
+func handleAdminReport(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, RoleAdmin) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := struct {
+		Draining    bool           `json:"draining"`
+		Total       int            `json:"total"`
+		ByProject   map[string]int `json:"byProject"`
+		ByNamespace map[string]int `json:"byNamespace"`
+		ByUser      map[string]int `json:"byUser"`
+	}{
+		Draining:    draining.Load(),
+		ByProject:   map[string]int{},
+		ByNamespace: map[string]int{},
+		ByUser:      map[string]int{},
+	}
+	sessionsMu.RLock()
+	for _, sess := range activeSessions {
+		report.Total++
+		report.ByProject[sess.Project]++
+		report.ByNamespace[sess.Namespace]++
+		report.ByUser[sess.User]++
+	}
+	sessionsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const apiV2Prefix = "/api/v2"
+
+// setDeprecationHeaders marca una respuesta de la API v1 como obsoleta e indica
+// la API que la reemplaza (RFC 8594 / draft-ietf-httpapi-deprecation-header)
+func setDeprecationHeaders(w http.ResponseWriter) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "<"+apiV2Prefix+"/sessions>; rel=\"successor-version\"")
+}
+
+// createSessionRequest es el cuerpo de POST /api/v2/sessions
+type createSessionRequest struct {
+	// Cluster es el nombre o el server de un cluster de Argo CD; vacío es el local
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// Job o CronJob se pueden usar en lugar de Pod para apuntar a su pod más reciente
+	Job     string `json:"job,omitempty"`
+	CronJob string `json:"cronJob,omitempty"`
+	Port    int    `json:"port"`
+	// PortName es un puerto con nombre del contenedor (por ejemplo "http") que
+	// se usa en lugar de Port
+	PortName string `json:"portName,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	Raw      bool   `json:"raw,omitempty"`
+	// Selector elige el pod listo más reciente con esas labels; con Wait la creación
+	// espera hasta WaitTimeout (o WAIT_MAX_TIMEOUT) a que haya uno
+	Selector    string `json:"selector,omitempty"`
+	Wait        bool   `json:"wait,omitempty"`
+	WaitTimeout string `json:"waitTimeout,omitempty"`
+	// ClientToken hace idempotente la creación: repetir la petición con el mismo
+	// token devuelve la sesión que ya creó (también se acepta el header Idempotency-Key)
+	ClientToken string `json:"clientToken,omitempty"`
+	// Service apunta a un pod listo detrás del Service; Port es el puerto del Service.
+	// Strategy elige el endpoint: random (default), zone (Zone o la zona del backend)
+	// o ip (Endpoint).
+	Service  string `json:"service,omitempty"`
+	Strategy string `json:"strategy,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Tunnels abre varios port-forwards hacia el pod y reparte las peticiones entre
+	// ellos, hasta MAX_SESSION_TUNNELS
+	Tunnels int `json:"tunnels,omitempty"`
+	// Workload apunta a un pod listo de un Deployment o StatefulSet
+	// (deployment/<nombre> o statefulset/<nombre>); Wait aplica igual que con Selector
+	Workload string `json:"workload,omitempty"`
+	// Scheme https conecta con el pod por TLS; el certificado del pod se valida con
+	// las CA del sistema, con el ca.crt del Secret CASecret o no se valida
+	// (InsecureSkipVerify)
+	Scheme             string `json:"scheme,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	CASecret           string `json:"caSecret,omitempty"`
+	// Protocol h2 envía todas las peticiones al pod por HTTP/2 (h2c con scheme http);
+	// las peticiones gRPC van por HTTP/2 siempre
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// hasPort indica si la petición trae un puerto válido, por número o por nombre
+func (body createSessionRequest) hasPort() bool {
+	return body.PortName != "" || (body.Port > 0 && body.Port <= 65535)
+}
+
+// handleAPIv2 enruta la API v2 de sesiones y targets
+func handleAPIv2(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, config *rest.Config, dynamicClient dynamic.Interface) {
+	path := strings.TrimPrefix(r.URL.Path, apiV2Prefix)
+	switch {
+	case path == "/sessions" && r.Method == http.MethodGet:
+		handleListSessions(w, r)
+	case path == "/sessions":
+		handleCreateSession(w, r, clientset)
+	case strings.HasPrefix(path, "/sessions/"):
+		handleSessionByID(w, r, strings.TrimPrefix(path, "/sessions"))
+	case path == "/targets/status":
+		handleTargetsStatus(w, r, clientset)
+	case path == "/targets":
+		handleApplicationTargets(w, r, dynamicClient)
+	case path == "/helpers/db":
+		handleDBHelper(w, r, clientset, config)
+	case path == "/toolbox":
+		handleToolbox(w, r, clientset, config)
+	case path == "/files":
+		handleFiles(w, r)
+	case path == "/templates" || strings.HasPrefix(path, "/templates/"):
+		handleTemplates(w, r, strings.TrimPrefix(path, "/templates"), clientset)
+	case path == "/recent" || strings.HasPrefix(path, "/recent/"):
+		handleRecent(w, r, strings.TrimPrefix(path, "/recent"), clientset)
+	case path == "/links":
+		handleCreateLink(w, r)
+	case path == "/csrf":
+		handleCSRFToken(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleCreateSession crea (o reutiliza) una sesión a partir de un cuerpo JSON
+func handleCreateSession(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := decodeCreateSessionRequest(w, r)
+	if err != nil {
+		http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	identity := identityFromRequest(r)
+	if body.Wait && wantsEventStream(r) {
+		streamCreateSession(w, r, identity, body, clientset)
+		return
+	}
+	session, status, err := createSession(r.Context(), identity, body, clientset, nil)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeCreatedSession(w, r, session, status)
+}
+
+// decodeCreateSessionRequest lee el cuerpo de POST /api/v2/sessions; sin clientToken
+// usa el header Idempotency-Key
+func decodeCreateSessionRequest(w http.ResponseWriter, r *http.Request) (createSessionRequest, error) {
+	var body createSessionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		return body, err
+	}
+	if body.ClientToken == "" {
+		body.ClientToken = r.Header.Get("Idempotency-Key")
+	}
+	return body, nil
+}
+
+// streamCreateSession crea la sesión informando con Server-Sent Events el avance
+// de la espera: eventos waiting con el estado de los pods y un evento final
+// session o error
+func streamCreateSession(w http.ResponseWriter, r *http.Request, identity RequestIdentity, body createSessionRequest, clientset *kubernetes.Clientset) {
+	stream := newEventStream(w)
+	progress := func(message string) {
+		stream.send("waiting", map[string]string{"message": message})
+	}
+	session, status, err := createSession(r.Context(), identity, body, clientset, progress)
+	if err != nil {
+		stream.send("error", map[string]interface{}{"status": status, "error": err.Error()})
+		return
+	}
+	stream.send("session", newCreatedSessionView(r, session))
+}
+
+// createSession resuelve el pod de destino (pod, job, cronJob, selector, workload o
+// service), abre la
+// sesión y aplica las opciones pedidas. Con clientToken, si el usuario ya creó una
+// sesión con ese token y sigue activa, la devuelve con 200 sin volver a resolver el pod.
+func createSession(ctx context.Context, identity RequestIdentity, body createSessionRequest, clientset *kubernetes.Clientset, progress func(string)) (*PortForwardSession, int, error) {
+	if len(body.ClientToken) > maxClientTokenLength {
+		return nil, http.StatusBadRequest, fmt.Errorf("clientToken admite hasta %d caracteres", maxClientTokenLength)
+	}
+	fingerprint := requestFingerprint(body)
+	if body.ClientToken != "" {
+		release := acquireClientToken(identity, body.ClientToken)
+		defer release()
+		session, status, err := findTokenSession(identity, body.ClientToken, fingerprint)
+		if err != nil {
+			return nil, status, err
+		}
+		if session != nil {
+			slog.Info("Sesión devuelta por clientToken", "component", "createSession", "session", session.ID, "user", identity.User)
+			return session, http.StatusOK, nil
+		}
+	}
+
+	requested := body
+	requested.ClientToken = ""
+	spec := targetSpec{
+		Cluster:     body.Cluster,
+		Namespace:   body.Namespace,
+		Pod:         body.Pod,
+		Port:        body.Port,
+		PortName:    body.PortName,
+		Job:         body.Job,
+		CronJob:     body.CronJob,
+		Selector:    body.Selector,
+		Workload:    body.Workload,
+		Service:     body.Service,
+		Endpoint:    endpointOptions{Strategy: body.Strategy, Zone: body.Zone, IP: body.Endpoint},
+		Wait:        body.Wait,
+		WaitTimeout: waitTimeout(body.WaitTimeout),
+		Progress:    progress,
+	}
+	if status, err := resolveTarget(ctx, &spec); err != nil {
+		return nil, status, err
+	}
+	kube := spec.Kube
+	requested.Cluster = kube.Name
+	body.Pod, body.Port = spec.Pod, spec.Port
+	if body.Namespace == "" || body.Pod == "" || body.Port <= 0 || body.Port > 65535 {
+		return nil, http.StatusBadRequest, fmt.Errorf("faltan parámetros requeridos: namespace, pod (o job/cronJob/selector/service/workload), port (o portName)")
+	}
+	tlsOpts, status, err := loadUpstreamTLS(ctx, kube.Clientset, body.Namespace, body.Scheme, body.InsecureSkipVerify, body.CASecret)
+	if err != nil {
+		return nil, status, err
+	}
+	http2Enabled, err := parseProtocol(body.Protocol)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	session, status, err := openSession(ctx, identity, kube, body.Namespace, body.Pod, body.Port)
+	if err != nil {
+		return nil, status, err
+	}
+	configureSession(ctx, kube.Clientset, session, body.Profile, body.Raw)
+	session.setUpstreamTLS(tlsOpts)
+	session.setHTTP2(http2Enabled)
+	session.ensureTunnels(ctx, body.Tunnels, kube.Clientset, kube.Config)
+	if body.ClientToken != "" {
+		rememberClientToken(session, body.ClientToken, fingerprint)
+	}
+	recordRecentTarget(clientset, identity.User, requested)
+	slog.Info("Sesión lista", "component", "createSession", "session", session.ID, "user", identity.User,
+		"namespace", body.Namespace, "pod", body.Pod, "port", body.Port)
+	return session, http.StatusCreated, nil
+}
+
+// createdSessionView es la respuesta de la creación de una sesión
+type createdSessionView struct {
+	sessionView
+	URL string `json:"url"`
+}
+
+func newCreatedSessionView(r *http.Request, session *PortForwardSession) createdSessionView {
+	return createdSessionView{newSessionView(session), sessionExternalURL(r, session)}
+}
+
+// writeCreatedSession responde con la sesión y la URL para abrirla en el navegador:
+// 201 si se creó, 200 si se devolvió la creada antes con el mismo clientToken
+func writeCreatedSession(w http.ResponseWriter, r *http.Request, session *PortForwardSession, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiV2Prefix+"/sessions/"+session.ID)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newCreatedSessionView(r, session))
+}
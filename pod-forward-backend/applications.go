@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// Anotación con el target que la UI abre por defecto (ej: svc/grafana:3000)
+	defaultTargetAnnotation = "pod-forward.argocd/default-target"
+	// Prefijo de las anotaciones con targets con nombre (ej: pod-forward.argocd/target.prometheus)
+	namedTargetAnnotationPrefix = "pod-forward.argocd/target."
+	defaultTargetName           = "default"
+)
+
+var applicationGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+
+// ForwardTarget es un destino de port-forward declarado en una Application
+type ForwardTarget struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace"`
+	Port      int    `json:"port"`
+}
+
+// ApplicationTargets es la respuesta de GET /targets
+type ApplicationTargets struct {
+	Application string          `json:"application"`
+	Project     string          `json:"project,omitempty"`
+	Default     string          `json:"default,omitempty"`
+	Targets     []ForwardTarget `json:"targets"`
+}
+
+// targetKinds normaliza los tipos de recurso aceptados en las anotaciones
+var targetKinds = map[string]string{
+	"pod":         "pod",
+	"pods":        "pod",
+	"po":          "pod",
+	"svc":         "service",
+	"service":     "service",
+	"services":    "service",
+	"deploy":      "deployment",
+	"deployment":  "deployment",
+	"deployments": "deployment",
+	"sts":         "statefulset",
+	"statefulset": "statefulset",
+	"job":         "job",
+	"jobs":        "job",
+	"cj":          "cronjob",
+	"cronjob":     "cronjob",
+	"cronjobs":    "cronjob",
+}
+
+// parseTargetSpec interpreta una especificación con formato <tipo>/<nombre>:<puerto>
+func parseTargetSpec(spec string) (kind, name string, port int, err error) {
+	spec = strings.TrimSpace(spec)
+	slash := strings.Index(spec, "/")
+	colon := strings.LastIndex(spec, ":")
+	if slash <= 0 || colon < slash+2 || colon == len(spec)-1 {
+		return "", "", 0, fmt.Errorf("formato inválido %q, se espera <tipo>/<nombre>:<puerto>", spec)
+	}
+	kind, ok := targetKinds[strings.ToLower(spec[:slash])]
+	if !ok {
+		return "", "", 0, fmt.Errorf("tipo de recurso no soportado en %q", spec)
+	}
+	port, err = strconv.Atoi(spec[colon+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", "", 0, fmt.Errorf("puerto inválido en %q", spec)
+	}
+	return kind, spec[slash+1 : colon], port, nil
+}
+
+// applicationTargets construye la lista de targets a partir de las anotaciones de la Application
+func applicationTargets(app *unstructured.Unstructured) ApplicationTargets {
+	result := ApplicationTargets{
+		Application: app.GetNamespace() + "/" + app.GetName(),
+		Targets:     []ForwardTarget{},
+	}
+	namespace, _, _ := unstructured.NestedString(app.Object, "spec", "destination", "namespace")
+	result.Project, _, _ = unstructured.NestedString(app.Object, "spec", "project")
+
+	annotations := app.GetAnnotations()
+	addTarget := func(name, spec string) {
+		kind, resource, port, err := parseTargetSpec(spec)
+		if err != nil {
+			slog.Warn("Anotación ignorada", "component", "applicationTargets", "application", result.Application, "annotation", name, "error", err)
+			return
+		}
+		result.Targets = append(result.Targets, ForwardTarget{
+			Name:      name,
+			Kind:      kind,
+			Resource:  resource,
+			Namespace: namespace,
+			Port:      port,
+		})
+	}
+
+	for key, value := range annotations {
+		if name := strings.TrimPrefix(key, namedTargetAnnotationPrefix); name != key && name != "" {
+			addTarget(name, value)
+		}
+	}
+	sort.Slice(result.Targets, func(i, j int) bool { return result.Targets[i].Name < result.Targets[j].Name })
+
+	// La anotación por defecto puede ser una especificación o el nombre de un target declarado
+	if def := strings.TrimSpace(annotations[defaultTargetAnnotation]); def != "" {
+		if strings.Contains(def, "/") {
+			addTarget(defaultTargetName, def)
+			result.Default = defaultTargetName
+		} else {
+			for _, target := range result.Targets {
+				if target.Name == def {
+					result.Default = def
+				}
+			}
+			if result.Default == "" {
+				slog.Warn("Target por defecto no declarado", "component", "applicationTargets", "application", result.Application, "target", def)
+			}
+		}
+	}
+	return result
+}
+
+// applicationRef obtiene namespace y nombre de la Application desde el header que agrega
+// el proxy de extensiones de Argo CD (<namespace>:<nombre>) o desde el parámetro
+// application. Detrás del proxy solo vale el header: es la Application que el
+// usuario está viendo en Argo CD.
+func applicationRef(r *http.Request) (namespace, name string) {
+	ref := r.Header.Get("Argocd-Application-Name")
+	if ref == "" && authenticatedBy(r) != "argocd" {
+		ref = r.URL.Query().Get("application")
+	}
+	if i := strings.IndexAny(ref, ":/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return appConfig.ArgoCDNamespace, ref
+}
+
+// handleApplicationTargets devuelve los targets declarados en la Application
+func handleApplicationTargets(w http.ResponseWriter, r *http.Request, dynamicClient dynamic.Interface) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("applicationSet") != "" || query.Get("selector") != "" {
+		handleDiscoverTargets(w, r, dynamicClient)
+		return
+	}
+
+	namespace, name := applicationRef(r)
+	if name == "" {
+		http.Error(w, "Falta la Application: header Argocd-Application-Name o parámetro application", http.StatusBadRequest)
+		return
+	}
+
+	// Una Application de otro proyecto responde igual que una inexistente
+	app, err := dynamicClient.Resource(applicationGVR).Namespace(namespace).Get(r.Context(), name, metav1.GetOptions{})
+	if err != nil {
+		slog.Warn("No se pudo obtener la Application", "component", "applicationTargets", "application", namespace+"/"+name, "error", err)
+		http.Error(w, fmt.Sprintf("Application %s/%s no encontrada", namespace, name), http.StatusNotFound)
+		return
+	}
+	result := applicationTargets(app)
+	if !canReadApplication(identityFromRequest(r), result.Project) {
+		http.Error(w, fmt.Sprintf("Application %s/%s no encontrada", namespace, name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// canReadApplication indica si el usuario puede ver los targets de una Application
+// del proyecto: los admin ven todas y el resto solo las de su proyecto. Sin
+// proyecto no se ve ninguna.
+func canReadApplication(identity RequestIdentity, project string) bool {
+	return identity.IsAdmin() || (identity.Project != "" && project == identity.Project)
+}
+
+// handleDiscoverTargets devuelve los targets de todas las Applications generadas por
+// un ApplicationSet (parámetro applicationSet) o que coinciden con un label selector
+// (parámetro selector), para abrir port-forwards a cualquier aplicación desde un
+// dashboard. Los usuarios que no son admin solo ven las Applications de su proyecto
+// (ninguna si no tienen proyecto) y solo en el namespace de Argo CD; solo se
+// devuelven los targets que la PodForwardPolicy permite.
+func handleDiscoverTargets(w http.ResponseWriter, r *http.Request, dynamicClient dynamic.Interface) {
+	query := r.URL.Query()
+	identity := identityFromRequest(r)
+	appSet := query.Get("applicationSet")
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		namespace = appConfig.ArgoCDNamespace
+	}
+	if namespace != appConfig.ArgoCDNamespace && !identity.IsAdmin() {
+		http.Error(w, "Solo un admin puede listar las Applications de otro namespace", http.StatusForbidden)
+		return
+	}
+
+	apps, err := dynamicClient.Resource(applicationGVR).Namespace(namespace).List(r.Context(), metav1.ListOptions{
+		LabelSelector: query.Get("selector"),
+	})
+	if err != nil {
+		slog.Warn("No se pudieron listar las Applications", "component", "discoverTargets", "namespace", namespace, "error", err)
+		http.Error(w, "Error al listar las Applications", http.StatusBadRequest)
+		return
+	}
+
+	policy := getPolicy()
+	results := []ApplicationTargets{}
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		if appSet != "" && !generatedBy(app, appSet) {
+			continue
+		}
+		result := applicationTargets(app)
+		if !canReadApplication(identity, result.Project) {
+			continue
+		}
+		allowed, hasDefault := result.Targets[:0], false
+		for _, target := range result.Targets {
+			if policy.checkTarget(target.Namespace, target.Port) == nil {
+				allowed = append(allowed, target)
+				hasDefault = hasDefault || target.Name == result.Default
+			}
+		}
+		result.Targets = allowed
+		if !hasDefault {
+			result.Default = ""
+		}
+		if len(result.Targets) == 0 {
+			continue
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Application < results[j].Application })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// generatedBy indica si la Application pertenece al ApplicationSet con ese nombre
+func generatedBy(app *unstructured.Unstructured, appSet string) bool {
+	for _, owner := range app.GetOwnerReferences() {
+		if owner.Kind == "ApplicationSet" && owner.Name == appSet {
+			return true
+		}
+	}
+	return false
+}
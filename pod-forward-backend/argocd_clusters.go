@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// argocdInClusterServer es el server con que Argo CD identifica al cluster local
+const argocdInClusterServer = "https://kubernetes.default.svc"
+
+// argocdClusterSecretLabel marca los Secrets de Argo CD con credenciales de clusters
+const argocdClusterSecretLabel = "argocd.argoproj.io/secret-type=cluster"
+
+// kubeTarget son el cliente y la configuración de un cluster. Name es "" para el
+// cluster donde corre el backend.
+type kubeTarget struct {
+	Name      string
+	Server    string
+	Clientset *kubernetes.Clientset
+	Config    *rest.Config
+}
+
+// healthName es el nombre del cluster en el chequeo de conectividad y las métricas
+func (k *kubeTarget) healthName() string {
+	if k.Name == "" {
+		return localCluster
+	}
+	return k.Name
+}
+
+// localKube es el cluster donde corre el backend
+var localKube *kubeTarget
+
+// cachedCluster es un cluster remoto con la versión del Secret con que se armó
+type cachedCluster struct {
+	target          *kubeTarget
+	resourceVersion string
+	checked         time.Time
+}
+
+var (
+	// remoteClusters son los clientsets de los clusters remotos por nombre de Secret
+	remoteClusters   = make(map[string]*cachedCluster)
+	remoteClustersMu sync.Mutex
+)
+
+// argocdClusterConfig es el campo config de un Secret de cluster de Argo CD
+type argocdClusterConfig struct {
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	BearerToken     string `json:"bearerToken,omitempty"`
+	TLSClientConfig struct {
+		Insecure   bool   `json:"insecure,omitempty"`
+		ServerName string `json:"serverName,omitempty"`
+		CAData     []byte `json:"caData,omitempty"`
+		CertData   []byte `json:"certData,omitempty"`
+		KeyData    []byte `json:"keyData,omitempty"`
+	} `json:"tlsClientConfig"`
+	AWSAuthConfig *struct {
+		ClusterName string `json:"clusterName"`
+		RoleARN     string `json:"roleARN,omitempty"`
+		Profile     string `json:"profile,omitempty"`
+	} `json:"awsAuthConfig,omitempty"`
+	ExecProviderConfig *struct {
+		Command     string            `json:"command"`
+		Args        []string          `json:"args,omitempty"`
+		Env         map[string]string `json:"env,omitempty"`
+		APIVersion  string            `json:"apiVersion,omitempty"`
+		InstallHint string            `json:"installHint,omitempty"`
+	} `json:"execProviderConfig,omitempty"`
+}
+
+// isLocalClusterRef indica si cluster= apunta al cluster donde corre el backend
+func isLocalClusterRef(ref string) bool {
+	return ref == "" || ref == localCluster || strings.TrimSuffix(ref, "/") == argocdInClusterServer
+}
+
+// resolveCluster devuelve el cluster de cluster=<nombre|server>: el local, o uno
+// de los Secrets de cluster de Argo CD en ARGOCD_NAMESPACE. Los clientsets se
+// reutilizan mientras el Secret no cambie; el Secret se vuelve a leer cada
+// CLUSTER_CACHE_TTL para detectar credenciales rotadas.
+func resolveCluster(ctx context.Context, ref string) (*kubeTarget, int, error) {
+	if isLocalClusterRef(ref) {
+		return localKube, http.StatusOK, nil
+	}
+	if !appConfig.MultiClusterEnabled {
+		return nil, http.StatusBadRequest, fmt.Errorf("el acceso a otros clusters está deshabilitado (MULTI_CLUSTER_ENABLED)")
+	}
+
+	remoteClustersMu.Lock()
+	defer remoteClustersMu.Unlock()
+	for _, cached := range remoteClusters {
+		if clusterMatches(cached.target.Name, cached.target.Server, ref) && time.Since(cached.checked) < appConfig.ClusterCacheTTL {
+			return cached.target, http.StatusOK, nil
+		}
+	}
+
+	secrets, err := localKube.Clientset.CoreV1().Secrets(appConfig.ArgoCDNamespace).List(ctx, metav1.ListOptions{LabelSelector: argocdClusterSecretLabel})
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("error al listar los clusters de Argo CD: %v", err)
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		name, server := string(secret.Data["name"]), strings.TrimSuffix(string(secret.Data["server"]), "/")
+		if !clusterMatches(name, server, ref) {
+			continue
+		}
+		if server == argocdInClusterServer {
+			return localKube, http.StatusOK, nil
+		}
+		if cached, ok := remoteClusters[secret.Name]; ok && cached.resourceVersion == secret.ResourceVersion {
+			cached.checked = time.Now()
+			return cached.target, http.StatusOK, nil
+		}
+		target, err := clusterFromSecret(secret)
+		if err != nil {
+			return nil, http.StatusBadGateway, fmt.Errorf("credenciales inválidas para el cluster %s: %v", ref, err)
+		}
+		remoteClusters[secret.Name] = &cachedCluster{target: target, resourceVersion: secret.ResourceVersion, checked: time.Now()}
+		registerCluster(target.Name, target.Clientset)
+		slog.Info("Cluster cargado", "component", "clusters", "cluster", target.Name, "server", target.Server, "secret", secret.Name)
+		return target, http.StatusOK, nil
+	}
+	return nil, http.StatusNotFound, fmt.Errorf("cluster no encontrado en Argo CD: %s", ref)
+}
+
+// clusterMatches compara cluster= con el nombre o el server de un cluster
+func clusterMatches(name, server, ref string) bool {
+	return ref == name || strings.TrimSuffix(ref, "/") == server
+}
+
+// clusterFromSecret arma el rest.Config de un Secret de cluster de Argo CD con las
+// mismas formas de autenticación que Argo CD: token, usuario y contraseña,
+// certificado de cliente, exec provider y awsAuthConfig (con argocd-k8s-auth)
+func clusterFromSecret(secret *corev1.Secret) (*kubeTarget, error) {
+	server := strings.TrimSuffix(string(secret.Data["server"]), "/")
+	name := string(secret.Data["name"])
+	if server == "" {
+		return nil, fmt.Errorf("el Secret %s no tiene server", secret.Name)
+	}
+	if name == "" {
+		name = server
+	}
+	var clusterConfig argocdClusterConfig
+	if raw := secret.Data["config"]; len(raw) > 0 {
+		if err := json.Unmarshal(raw, &clusterConfig); err != nil {
+			return nil, fmt.Errorf("config inválido en el Secret %s: %v", secret.Name, err)
+		}
+	}
+
+	config := &rest.Config{
+		Host:        server,
+		Username:    clusterConfig.Username,
+		Password:    clusterConfig.Password,
+		BearerToken: clusterConfig.BearerToken,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure:   clusterConfig.TLSClientConfig.Insecure,
+			ServerName: clusterConfig.TLSClientConfig.ServerName,
+			CAData:     clusterConfig.TLSClientConfig.CAData,
+			CertData:   clusterConfig.TLSClientConfig.CertData,
+			KeyData:    clusterConfig.TLSClientConfig.KeyData,
+		},
+	}
+	switch {
+	case clusterConfig.AWSAuthConfig != nil:
+		args := []string{"aws", "--cluster-name", clusterConfig.AWSAuthConfig.ClusterName}
+		if clusterConfig.AWSAuthConfig.RoleARN != "" {
+			args = append(args, "--role-arn", clusterConfig.AWSAuthConfig.RoleARN)
+		}
+		if clusterConfig.AWSAuthConfig.Profile != "" {
+			args = append(args, "--profile", clusterConfig.AWSAuthConfig.Profile)
+		}
+		config.ExecProvider = &clientcmdapi.ExecConfig{
+			Command:         "argocd-k8s-auth",
+			Args:            args,
+			APIVersion:      "client.authentication.k8s.io/v1beta1",
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+	case clusterConfig.ExecProviderConfig != nil:
+		exec := clusterConfig.ExecProviderConfig
+		config.ExecProvider = &clientcmdapi.ExecConfig{
+			Command:         exec.Command,
+			Args:            exec.Args,
+			APIVersion:      exec.APIVersion,
+			InstallHint:     exec.InstallHint,
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+		for key, value := range exec.Env {
+			config.ExecProvider.Env = append(config.ExecProvider.Env, clientcmdapi.ExecEnvVar{Name: key, Value: value})
+		}
+	}
+
+	traceKubeClient(config)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeTarget{Name: name, Server: server, Clientset: clientset, Config: config}, nil
+}
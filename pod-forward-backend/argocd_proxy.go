@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Convenciones del proxy de extensiones de Argo CD según la versión:
+//   - 2.7 y posteriores quitan /extensions/<nombre> antes de reenviar la petición,
+//     pero algunas instalaciones detrás de otro proxy reenvían la ruta completa.
+//   - Argocd-Application-Name llega como <nombre> en versiones antiguas y como
+//     <namespace>:<nombre> desde que existen Applications en cualquier namespace.
+//   - Argocd-Username y Argocd-User-Groups solo se agregan desde 2.10; en versiones
+//     anteriores el usuario queda vacío y los grupos que mande el cliente se ignoran.
+//   - Argocd-User-Groups puede llegar como un único valor separado por comas o
+//     como varios headers repetidos.
+
+// argocdExtensionPrefix es la ruta bajo la que Argo CD expone la extensión
+func argocdExtensionPrefix() string {
+	return "/extensions/" + appConfig.ExtensionName
+}
+
+// argocdProxyCompat normaliza las peticiones que llegan por el proxy de extensiones
+// de Argo CD para que el resto del backend vea siempre la misma forma
+func argocdProxyCompat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := argocdExtensionPrefix()
+		if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+			// La ruta llegó sin quitar el prefijo de Argo CD: quitarlo aquí
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+			}
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+			slog.Debug("Prefijo removido", "component", "argocdProxyCompat", "prefix", prefix, "path", r.URL.Path)
+		}
+
+		// Unificar Argocd-User-Groups en un único valor separado por comas
+		if groups := r.Header.Values("Argocd-User-Groups"); len(groups) > 1 {
+			r.Header.Set("Argocd-User-Groups", strings.Join(groups, ","))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyArgoCDProxySecret comprueba el secreto que agrega el proxy de extensiones de
+// Argo CD (headers de la configuración de la extensión) y lo quita de la petición
+// para que no llegue al pod
+func verifyArgoCDProxySecret(r *http.Request) error {
+	if appConfig.ArgoCDProxySecret == "" {
+		return nil
+	}
+	value := r.Header.Get(appConfig.ArgoCDProxySecretHeader)
+	r.Header.Del(appConfig.ArgoCDProxySecretHeader)
+	if subtle.ConstantTimeCompare([]byte(value), []byte(appConfig.ArgoCDProxySecret)) != 1 {
+		return fmt.Errorf("la petición no trae el secreto del proxy de Argo CD")
+	}
+	return nil
+}
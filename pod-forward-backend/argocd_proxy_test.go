@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestArgoCDProxyConventions reproduce cómo reenvía las peticiones el proxy de
+// extensiones de cada versión de Argo CD y verifica que, después de argocdProxyCompat
+// y authenticate, el backend ve la misma ruta, usuario, grupos y Application
+func TestArgoCDProxyConventions(t *testing.T) {
+	const secretHeader = "Argocd-Extension-Secret"
+
+	tests := []struct {
+		name    string
+		version string
+		target  string
+		header  http.Header
+		secret  string // ARGOCD_PROXY_SECRET configurado en el backend
+
+		wantStatus  int
+		wantPath    string
+		wantRawPath string
+		wantUser    string
+		wantGroups  []string
+		wantAppNS   string
+		wantApp     string
+	}{
+		{
+			name:    "ruta completa y Application sin namespace",
+			version: "2.6",
+			target:  "/extensions/pod-forward/forward?namespace=demo&pod=web-0&port=8080",
+			header: http.Header{
+				"Argocd-Application-Name": {"guestbook"},
+				"Argocd-Project-Name":     {"default"},
+			},
+			wantStatus: http.StatusOK,
+			wantPath:   "/forward",
+			wantAppNS:  "argocd",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "prefijo quitado y Application con namespace",
+			version: "2.7",
+			target:  "/forward?namespace=demo&pod=web-0&port=8080",
+			header: http.Header{
+				"Argocd-Application-Name": {"apps:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+			},
+			wantStatus: http.StatusOK,
+			wantPath:   "/forward",
+			wantAppNS:  "apps",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "ruta completa detrás de otro proxy",
+			version: "2.8",
+			target:  "/extensions/pod-forward",
+			header: http.Header{
+				"Argocd-Application-Name": {"argocd:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+			},
+			wantStatus: http.StatusOK,
+			wantPath:   "/",
+			wantAppNS:  "argocd",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "ruta escapada de una sesión",
+			version: "2.9",
+			target:  "/extensions/pod-forward/api/v1/extensions/pod-forward/s/abc/files/a%2Fb",
+			header: http.Header{
+				"Argocd-Application-Name": {"argocd:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+			},
+			wantStatus:  http.StatusOK,
+			wantPath:    "/api/v1/extensions/pod-forward/s/abc/files/a/b",
+			wantRawPath: "/api/v1/extensions/pod-forward/s/abc/files/a%2Fb",
+			wantAppNS:   "argocd",
+			wantApp:     "guestbook",
+		},
+		{
+			name:    "grupos sin usuario agregados por el cliente",
+			version: "2.9",
+			target:  "/sessions",
+			header: http.Header{
+				"Argocd-Application-Name": {"argocd:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+				"Argocd-User-Groups":      {"admins"},
+			},
+			wantStatus: http.StatusOK,
+			wantPath:   "/sessions",
+			wantAppNS:  "argocd",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "usuario y grupos separados por comas",
+			version: "2.10",
+			target:  "/sessions",
+			header: http.Header{
+				"Argocd-Application-Name": {"argocd:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+				"Argocd-Username":         {"alice"},
+				"Argocd-User-Groups":      {"devs, ops"},
+			},
+			wantStatus: http.StatusOK,
+			wantPath:   "/sessions",
+			wantUser:   "alice",
+			wantGroups: []string{"devs", "ops"},
+			wantAppNS:  "argocd",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "grupos en headers repetidos",
+			version: "2.11",
+			target:  "/sessions",
+			header: http.Header{
+				"Argocd-Application-Name": {"argocd:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+				"Argocd-Username":         {"alice"},
+				"Argocd-User-Groups":      {"devs", "ops"},
+			},
+			wantStatus: http.StatusOK,
+			wantPath:   "/sessions",
+			wantUser:   "alice",
+			wantGroups: []string{"devs", "ops"},
+			wantAppNS:  "argocd",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "secreto del proxy correcto",
+			version: "2.12",
+			target:  "/extensions/pod-forward/sessions",
+			header: http.Header{
+				"Argocd-Application-Name": {"argocd:guestbook"},
+				"Argocd-Project-Name":     {"default"},
+				"Argocd-Username":         {"alice"},
+				"Argocd-User-Groups":      {"devs"},
+				secretHeader:              {"s3cret"},
+			},
+			secret:     "s3cret",
+			wantStatus: http.StatusOK,
+			wantPath:   "/sessions",
+			wantUser:   "alice",
+			wantGroups: []string{"devs"},
+			wantAppNS:  "argocd",
+			wantApp:    "guestbook",
+		},
+		{
+			name:    "sin el secreto del proxy",
+			version: "2.12",
+			target:  "/sessions",
+			header: http.Header{
+				"Argocd-Project-Name": {"default"},
+				"Argocd-Username":     {"alice"},
+			},
+			secret:     "s3cret",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	saved := *appConfig
+	t.Cleanup(func() { *appConfig = saved })
+	appConfig.ExtensionName = "pod-forward"
+	appConfig.ArgoCDNamespace = "argocd"
+	appConfig.ArgoCDProxySecretHeader = secretHeader
+
+	for _, tt := range tests {
+		t.Run(tt.version+"/"+tt.name, func(t *testing.T) {
+			appConfig.ArgoCDProxySecret = tt.secret
+
+			var seen *http.Request
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r })
+			handler := argocdProxyCompat(authenticate([]Authenticator{argocdAuthenticator{}}, next))
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for key, values := range tt.header {
+				for _, value := range values {
+					req.Header.Add(key, value)
+				}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, se esperaba %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if seen != nil {
+					t.Fatal("la petición rechazada llegó al handler")
+				}
+				return
+			}
+			if seen.URL.Path != tt.wantPath || seen.URL.RawPath != tt.wantRawPath {
+				t.Errorf("ruta = %q (raw %q), se esperaba %q (raw %q)", seen.URL.Path, seen.URL.RawPath, tt.wantPath, tt.wantRawPath)
+			}
+			identity := identityFromRequest(seen)
+			if identity.User != tt.wantUser || !reflect.DeepEqual(identity.Groups, tt.wantGroups) {
+				t.Errorf("identidad = %q %v, se esperaba %q %v", identity.User, identity.Groups, tt.wantUser, tt.wantGroups)
+			}
+			if identity.Project != "default" {
+				t.Errorf("proyecto = %q", identity.Project)
+			}
+			if namespace, app := applicationRef(seen); namespace != tt.wantAppNS || app != tt.wantApp {
+				t.Errorf("Application = %s/%s, se esperaba %s/%s", namespace, app, tt.wantAppNS, tt.wantApp)
+			}
+			if seen.Header.Get(secretHeader) != "" {
+				t.Error("el secreto del proxy sigue en la petición")
+			}
+		})
+	}
+}
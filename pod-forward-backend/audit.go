@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Destinos de AUDIT_LOG
+const (
+	auditSinkStdout = "stdout"
+	auditSinkEvents = "events"
+)
+
+// auditEventReason es el reason de los Events de auditoría, para filtrarlos con
+// kubectl get events --field-selector reason=PodForwardRequest
+const auditEventReason = "PodForwardRequest"
+
+// auditQueueSize es cuántos Events de auditoría pueden esperar a crearse; con la
+// cola llena se descartan y se cuentan en pod_forward_audit_dropped_total
+const auditQueueSize = 1024
+
+// auditLogger escribe los registros de auditoría en stdout, uno por línea y sin el
+// prefijo de log, igual que el access log
+var auditLogger = log.New(os.Stdout, "", 0)
+
+// auditRecord es el registro de auditoría de una petición que pasó por el proxy o,
+// con Type "action", de una acción del backend (transferencias, links, cuotas)
+type auditRecord struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId"`
+	User       string    `json:"user"`
+	Project    string    `json:"project,omitempty"`
+	Cluster    string    `json:"cluster,omitempty"`
+	Namespace  string    `json:"namespace"`
+	Pod        string    `json:"pod"`
+	Port       int       `json:"port"`
+	Session    string    `json:"session"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Remote     string    `json:"remote"`
+	DurationMs int64     `json:"durationMs"`
+	Action     string    `json:"action,omitempty"`
+	Container  string    `json:"container,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	Result     string    `json:"result,omitempty"`
+
+	kube *kubeTarget
+}
+
+// auditSinks son los destinos de AUDIT_LOG activos
+var auditSinks struct {
+	stdout bool
+	events chan auditRecord
+}
+
+// startAuditLog valida AUDIT_LOG y, con el destino events, arranca el worker que
+// crea los Events. Los destinos desconocidos se ignoran con un aviso, como
+// ACCESS_LOG_FORMAT.
+func startAuditLog() {
+	for _, sink := range appConfig.AuditLog {
+		switch sink {
+		case auditSinkStdout:
+			auditSinks.stdout = true
+		case auditSinkEvents:
+			if auditSinks.events == nil {
+				events := make(chan auditRecord, auditQueueSize)
+				auditSinks.events = events
+				goTask("audit-events", func(ctx context.Context) error {
+					runAuditEvents(ctx, events)
+					return nil
+				})
+			}
+		default:
+			slog.Warn("Destino desconocido en AUDIT_LOG, ignorado", "component", "audit", "sink", sink)
+		}
+	}
+	if auditSinks.stdout || auditSinks.events != nil {
+		slog.Info("Auditoría activa", "component", "audit", "sinks", appConfig.AuditLog)
+	}
+}
+
+// auditRequest registra una petición que pasó por la sesión, al terminar de
+// responderla. Se llama desde accessLog, que conoce el status y los bytes enviados.
+func auditRequest(r *http.Request, session *PortForwardSession, status int, bytes int64, started time.Time) {
+	if !auditSinks.stdout && auditSinks.events == nil {
+		return
+	}
+	record := auditRecord{
+		Type:       "audit",
+		Time:       started.UTC(),
+		RequestID:  requestID(r.Context()),
+		User:       identityFromRequest(r).User,
+		Project:    session.Project,
+		Cluster:    session.Cluster,
+		Namespace:  session.Namespace,
+		Pod:        session.Pod,
+		Port:       session.Port,
+		Session:    session.ID,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     status,
+		Bytes:      bytes,
+		Remote:     remoteHost(r),
+		DurationMs: time.Since(started).Milliseconds(),
+		kube:       session.kube,
+	}
+	emitAudit(record)
+}
+
+// auditAction registra una acción del backend que no es una petición proxeada. Con
+// r se completan el request ID, el usuario y el origen; el Event solo se crea si
+// record.kube indica el cluster del pod.
+func auditAction(r *http.Request, record auditRecord) {
+	if !auditSinks.stdout && auditSinks.events == nil {
+		return
+	}
+	record.Type = "action"
+	record.Time = time.Now().UTC()
+	if r != nil {
+		record.RequestID = requestID(r.Context())
+		record.Remote = remoteHost(r)
+		if record.User == "" {
+			record.User = identityFromRequest(r).User
+		}
+		if record.Method == "" {
+			record.Method = r.Method
+		}
+		if record.Path == "" {
+			record.Path = r.URL.Path
+		}
+	}
+	if record.Result == "" {
+		record.Result = "ok"
+	}
+	emitAudit(record)
+}
+
+// sessionAuditRecord arma el registro de una acción sobre la sesión, con su destino
+// y el cluster donde crear el Event
+func sessionAuditRecord(session *PortForwardSession, action string) auditRecord {
+	return auditRecord{
+		User:      session.User,
+		Project:   session.Project,
+		Cluster:   session.Cluster,
+		Namespace: session.Namespace,
+		Pod:       session.Pod,
+		Port:      session.Port,
+		Session:   session.ID,
+		Action:    action,
+		Detail:    fmt.Sprintf("%s/%s:%d", session.Namespace, session.Pod, session.Port),
+		kube:      session.kube,
+	}
+}
+
+// emitAudit escribe el registro en stdout y lo encola para crear el Event
+func emitAudit(record auditRecord) {
+	if auditSinks.stdout {
+		line, _ := json.Marshal(record)
+		auditLogger.Print(string(line))
+	}
+	if auditSinks.events != nil && record.kube != nil {
+		select {
+		case auditSinks.events <- record:
+		default:
+			addCounter("pod_forward_audit_dropped_total", map[string]string{"sink": auditSinkEvents}, 1)
+		}
+	}
+}
+
+// runAuditEvents crea los Events de auditoría de a uno, para no bloquear las
+// peticiones con llamadas al API server
+func runAuditEvents(ctx context.Context, records <-chan auditRecord) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-records:
+			if err := createAuditEvent(ctx, record); err != nil {
+				slog.Error("Error al crear el Event de auditoría", "component", "audit", "method", record.Method, "path", record.Path,
+					"namespace", record.Namespace, "pod", record.Pod, "error", err)
+				addCounter("pod_forward_audit_dropped_total", map[string]string{"sink": auditSinkEvents}, 1)
+			}
+		}
+	}
+}
+
+// createAuditEvent crea un Event asociado al pod con el registro de la petición,
+// en el cluster del pod
+func createAuditEvent(ctx context.Context, record auditRecord) error {
+	user := record.User
+	if user == "" {
+		user = "anónimo"
+	}
+	message := fmt.Sprintf("%s %s %s puerto %d -> %d (%d bytes)", user, record.Method, record.Path, record.Port, record.Status, record.Bytes)
+	action := record.Method
+	if record.Action != "" {
+		message = fmt.Sprintf("%s %s %s (%d bytes): %s", user, record.Action, record.Detail, record.Bytes, record.Result)
+		action = record.Action
+	}
+	timestamp := metav1.NewTime(record.Time)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Mismo formato que los Events de kubelet: <objeto>.<sufijo único>
+			Name:      fmt.Sprintf("%s.%x", record.Pod, record.Time.UnixNano()),
+			Namespace: record.Namespace,
+			Annotations: map[string]string{
+				"pod-forward.argocd/user":       record.User,
+				"pod-forward.argocd/project":    record.Project,
+				"pod-forward.argocd/session":    record.Session,
+				"pod-forward.argocd/request-id": record.RequestID,
+			},
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  record.Namespace,
+			Name:       record.Pod,
+		},
+		Reason:              auditEventReason,
+		Message:             message,
+		Type:                corev1.EventTypeNormal,
+		Source:              corev1.EventSource{Component: "pod-forward-backend"},
+		ReportingController: "pod-forward.argocd/backend",
+		ReportingInstance:   backendInstance,
+		Action:              action,
+		FirstTimestamp:      timestamp,
+		LastTimestamp:       timestamp,
+		Count:               1,
+	}
+	createCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := record.kube.Clientset.CoreV1().Events(record.Namespace).Create(createCtx, event, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// errNoCredentials indica que la petición no trae credenciales para ese
+// authenticator y hay que probar con el siguiente de la cadena
+var errNoCredentials = errors.New("sin credenciales")
+
+// Authenticator obtiene la identidad del usuario a partir de la petición.
+// Devuelve errNoCredentials si la petición no trae sus credenciales y cualquier
+// otro error si las trae pero son inválidas.
+type Authenticator interface {
+	Name() string
+	Authenticate(r *http.Request) (RequestIdentity, error)
+}
+
+// authRouteHandler lo implementan los authenticators que atienden rutas propias
+// (por ejemplo el callback de OIDC); devuelve true si la petición fue atendida
+type authRouteHandler interface {
+	ServeAuthRoute(w http.ResponseWriter, r *http.Request) bool
+}
+
+// authChallenger lo implementan los authenticators que pueden iniciar un login
+// cuando ningún authenticator reconoció la petición; devuelve true si respondió
+type authChallenger interface {
+	Challenge(w http.ResponseWriter, r *http.Request) bool
+}
+
+// buildAuthenticators arma la cadena de AUTHENTICATORS. Sin configuración explícita
+// se usa argocd en modo argocd, y oidc o header en modo standalone.
+func buildAuthenticators(mode string) ([]Authenticator, error) {
+	names := appConfig.Authenticators
+	if len(names) == 0 {
+		switch {
+		case mode != modeStandalone:
+			names = []string{"argocd"}
+		case appConfig.OIDCIssuerURL != "":
+			names = []string{"oidc"}
+		default:
+			names = []string{"header"}
+		}
+	}
+
+	var chain []Authenticator
+	for i, name := range names {
+		switch name {
+		case "argocd":
+			// argocd acepta cualquier petición, así que solo tiene sentido al final
+			if i != len(names)-1 {
+				return nil, fmt.Errorf("el authenticator argocd debe ser el último de la cadena")
+			}
+			chain = append(chain, argocdAuthenticator{})
+		case "header":
+			chain = append(chain, headerAuthenticator{})
+		case "shared-secret":
+			if appConfig.AuthSharedSecret == "" {
+				return nil, fmt.Errorf("shared-secret requiere AUTH_SHARED_SECRET")
+			}
+			chain = append(chain, sharedSecretAuthenticator{})
+		case "oidc":
+			if appConfig.OIDCIssuerURL == "" {
+				return nil, fmt.Errorf("oidc requiere OIDC_ISSUER_URL")
+			}
+			provider, err := newOIDCProvider(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, provider)
+		case "mtls":
+			if appConfig.TLSClientCAFile == "" {
+				return nil, fmt.Errorf("mtls requiere TLS_CLIENT_CA_FILE")
+			}
+			chain = append(chain, mtlsAuthenticator{})
+		default:
+			return nil, fmt.Errorf("authenticator desconocido: %s", name)
+		}
+	}
+	slog.Info("Authenticators configurados", "component", "auth", "authenticators", names)
+	return chain, nil
+}
+
+// authenticate recorre la cadena de authenticators y traduce la identidad obtenida
+// a los headers Argocd-* que usa el resto del backend
+func authenticate(chain []Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range chain {
+			if h, ok := a.(authRouteHandler); ok && h.ServeAuthRoute(w, r) {
+				return
+			}
+		}
+		identity, name, err := identify(chain, r)
+		if err == nil {
+			next.ServeHTTP(w, authenticated(r, identity, name))
+			return
+		}
+		// Los health checks no requieren autenticación (la identidad solo habilita
+		// el detalle de /readyz) y los links de un solo uso llevan su propia firma:
+		// sin credenciales válidas siguen sin identidad y el handler decide
+		if isHealthPath(r.URL.Path) || isLinkPath(r.URL.Path) {
+			setIdentityHeaders(r, RequestIdentity{})
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !errors.Is(err, errNoCredentials) {
+			http.Error(w, "Credenciales inválidas", http.StatusUnauthorized)
+			return
+		}
+
+		for _, a := range chain {
+			if c, ok := a.(authChallenger); ok && c.Challenge(w, r) {
+				return
+			}
+		}
+		http.Error(w, "Autenticación requerida", http.StatusUnauthorized)
+	})
+}
+
+// authenticated registra el authenticator en el contexto y deja la identidad en los
+// headers Argocd-*
+func authenticated(r *http.Request, identity RequestIdentity, name string) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), authenticatorKey{}, name))
+	if name != "argocd" {
+		// Los headers Argocd-* solo son confiables si vienen del proxy de Argo CD
+		setIdentityHeaders(r, identity)
+	} else if len(identity.Groups) == 0 {
+		// Grupos que el authenticator argocd descartó (ver Authenticate)
+		r.Header.Del("Argocd-User-Groups")
+	}
+	return r
+}
+
+// identify prueba la cadena de authenticators en orden y devuelve la identidad y el
+// nombre del primero que reconoce la petición. Si ninguno la reconoce devuelve
+// errNoCredentials.
+func identify(chain []Authenticator, r *http.Request) (RequestIdentity, string, error) {
+	for _, a := range chain {
+		identity, err := a.Authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		if err != nil {
+			slog.Info("Credenciales rechazadas", "component", "auth", "authenticator", a.Name(), "error", err)
+			return RequestIdentity{}, a.Name(), err
+		}
+		return identity, a.Name(), nil
+	}
+	return RequestIdentity{}, "", errNoCredentials
+}
+
+// authenticatorKey guarda en el contexto el nombre del authenticator que identificó
+// la petición
+type authenticatorKey struct{}
+
+// authenticatedBy devuelve el authenticator que identificó la petición, o vacío
+func authenticatedBy(r *http.Request) string {
+	name, _ := r.Context().Value(authenticatorKey{}).(string)
+	return name
+}
+
+// consumedAuthorization indica si el header Authorization fue la credencial con la
+// que la petición se autenticó ante el backend
+func consumedAuthorization(r *http.Request) bool {
+	return authenticatedBy(r) == "shared-secret"
+}
+
+// argocdTokenCookie es la cookie con el token de sesión de Argo CD
+const argocdTokenCookie = "argocd.token"
+
+// backendCookies son las cookies del backend y la sesión de Argo CD: no son de la
+// aplicación del pod
+var backendCookies = map[string]bool{
+	oidcSessionCookie: true,
+	oidcStateCookie:   true,
+	csrfCookie:        true,
+	argocdTokenCookie: true,
+}
+
+// stripBackendCredentials quita de la petición al pod las cookies del backend y el
+// Authorization con el que se autenticó la petición original; las credenciales de
+// la aplicación (sus cookies, un Authorization que el backend no usó) se conservan
+func stripBackendCredentials(header http.Header, r *http.Request) {
+	if consumedAuthorization(r) {
+		header.Del("Authorization")
+	}
+	values := header.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+	var kept []string
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ";") {
+			pair = strings.TrimSpace(pair)
+			name, _, _ := strings.Cut(pair, "=")
+			if pair != "" && !backendCookies[name] {
+				kept = append(kept, pair)
+			}
+		}
+	}
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// setIdentityHeaders reemplaza los headers Argocd-* por la identidad autenticada
+func setIdentityHeaders(r *http.Request, identity RequestIdentity) {
+	for key := range r.Header {
+		if strings.HasPrefix(key, "Argocd-") {
+			r.Header.Del(key)
+		}
+	}
+	r.Header.Set("Argocd-Username", identity.User)
+	if len(identity.Groups) > 0 {
+		r.Header.Set("Argocd-User-Groups", strings.Join(identity.Groups, ","))
+	}
+	if identity.Project != "" {
+		r.Header.Set("Argocd-Project-Name", identity.Project)
+	}
+}
+
+// isHealthPath indica si la ruta es un endpoint de health check
+func isHealthPath(path string) bool {
+	return path == "/health" || path == "/livez" || path == "/readyz"
+}
+
+// argocdAuthenticator confía en los headers que agrega el proxy de extensiones de
+// Argo CD, que ya autenticó al usuario. Con ARGOCD_PROXY_SECRET exige además el
+// secreto que agrega el proxy, así no basta con alcanzar el Service del backend.
+// El proxy quita Cookie y Authorization, así que el backend no puede validar el
+// token de sesión de Argo CD: el secreto es lo único que prueba el origen.
+type argocdAuthenticator struct{}
+
+func (argocdAuthenticator) Name() string { return "argocd" }
+
+func (argocdAuthenticator) Authenticate(r *http.Request) (RequestIdentity, error) {
+	if err := verifyArgoCDProxySecret(r); err != nil {
+		return RequestIdentity{}, err
+	}
+	identity := identityFromRequest(r)
+	if identity.User == "" {
+		// Argo CD agrega el usuario y los grupos juntos (2.10 y posteriores): grupos
+		// sin usuario los puso el cliente y un proxy anterior los dejó pasar
+		identity.Groups = nil
+	}
+	return identity, nil
+}
+
+// headerAuthenticator toma la identidad de los headers que agrega la autenticación
+// del Ingress (por ejemplo oauth2-proxy)
+type headerAuthenticator struct{}
+
+func (headerAuthenticator) Name() string { return "header" }
+
+func (headerAuthenticator) Authenticate(r *http.Request) (RequestIdentity, error) {
+	user := r.Header.Get(appConfig.StandaloneUserHeader)
+	if user == "" {
+		return RequestIdentity{}, errNoCredentials
+	}
+	identity := RequestIdentity{User: user}
+	for _, value := range r.Header.Values(appConfig.StandaloneGroupsHeader) {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				identity.Groups = append(identity.Groups, group)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// sharedSecretAuthenticator acepta Authorization: Bearer <AUTH_SHARED_SECRET>,
+// pensado para automatizaciones dentro del cluster
+type sharedSecretAuthenticator struct{}
+
+func (sharedSecretAuthenticator) Name() string { return "shared-secret" }
+
+func (sharedSecretAuthenticator) Authenticate(r *http.Request) (RequestIdentity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return RequestIdentity{}, errNoCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.AuthSharedSecret)) != 1 {
+		return RequestIdentity{}, fmt.Errorf("secreto compartido inválido")
+	}
+	return RequestIdentity{User: appConfig.AuthSharedSecretUser, Groups: appConfig.AuthSharedSecretGroups}, nil
+}
+
+// mtlsAuthenticator usa el certificado de cliente verificado contra TLS_CLIENT_CA_FILE:
+// el CN es el usuario y las OU son los grupos
+type mtlsAuthenticator struct{}
+
+func (mtlsAuthenticator) Name() string { return "mtls" }
+
+func (mtlsAuthenticator) Authenticate(r *http.Request) (RequestIdentity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return RequestIdentity{}, errNoCredentials
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return RequestIdentity{}, fmt.Errorf("certificado sin CommonName")
+	}
+	return RequestIdentity{User: subject.CommonName, Groups: subject.OrganizationalUnit}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Algunas aplicaciones autentican la conexión y no la petición (NTLM, Negotiate/
+// Kerberos): el handshake cuesta varias idas y vueltas y solo vale en la conexión
+// TCP donde se hizo. Con el pool compartido de la sesión cada petición puede salir
+// por otra conexión y el handshake se repite. Cuando el pod pide uno de estos
+// esquemas, la sesión pasa a afinidad: cada conexión del navegador usa siempre la
+// misma conexión hacia el pod, que se conserva autenticada entre peticiones.
+
+// connectionAuthSchemes son los esquemas de WWW-Authenticate ligados a la conexión
+var connectionAuthSchemes = []string{"ntlm", "negotiate", "kerberos"}
+
+// affineClient es el cliente dedicado a una conexión del navegador
+type affineClient struct {
+	client    *http.Client
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+// authAffinity guarda los clientes dedicados de una sesión por conexión del
+// navegador (RemoteAddr identifica la conexión TCP)
+type authAffinity struct {
+	enabled bool
+	mu      sync.Mutex
+	clients map[string]*affineClient
+}
+
+// isConnectionAuth indica si el header (WWW-Authenticate o Authorization) usa un
+// esquema ligado a la conexión
+func isConnectionAuth(values []string) bool {
+	for _, value := range values {
+		scheme, _, _ := strings.Cut(strings.TrimSpace(value), " ")
+		for _, candidate := range connectionAuthSchemes {
+			if strings.EqualFold(scheme, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// noteUpstreamAuth activa la afinidad si el pod pidió un handshake ligado a la conexión
+func (s *PortForwardSession) noteUpstreamAuth(resp *http.Response) {
+	if resp.StatusCode != http.StatusUnauthorized || !isConnectionAuth(resp.Header.Values("WWW-Authenticate")) {
+		return
+	}
+	addCounter("pod_forward_upstream_auth_challenges_total", map[string]string{"project": s.Project}, 1)
+	s.affinity.mu.Lock()
+	defer s.affinity.mu.Unlock()
+	s.enableAffinity()
+}
+
+// enableAffinity pasa la sesión a afinidad; se llama con affinity.mu tomado
+func (s *PortForwardSession) enableAffinity() {
+	if s.affinity.enabled {
+		return
+	}
+	s.affinity.enabled = true
+	s.affinity.clients = make(map[string]*affineClient)
+	slog.Info("El pod autentica por conexión, se reutilizan las conexiones autenticadas", "component", "authAffinity", "session", s.ID)
+}
+
+// usesAuthAffinity indica si la sesión mantiene conexiones dedicadas por
+// conexión del navegador
+func (s *PortForwardSession) usesAuthAffinity() bool {
+	s.affinity.mu.Lock()
+	defer s.affinity.mu.Unlock()
+	return s.affinity.enabled
+}
+
+// clientFor devuelve el cliente para la petición: el dedicado a la conexión del
+// navegador si la sesión usa afinidad, o el del pool de la sesión
+func (s *PortForwardSession) clientFor(r *http.Request, raw bool) *http.Client {
+	s.affinity.mu.Lock()
+	defer s.affinity.mu.Unlock()
+	if !s.affinity.enabled && !isConnectionAuth(r.Header.Values("Authorization")) {
+		return s.httpClient(raw)
+	}
+	s.enableAffinity()
+
+	now := time.Now()
+	s.affinity.evictIdle(now)
+	key := r.RemoteAddr
+	if raw {
+		key += "|raw"
+	}
+	if affine, ok := s.affinity.clients[key]; ok {
+		affine.lastUsed = now
+		addCounter("pod_forward_auth_affinity_reuses_total", map[string]string{"project": s.Project}, 1)
+		return affine.client
+	}
+	if len(s.affinity.clients) >= appConfig.AuthAffinityMaxConns {
+		// Sin lugar para otra conexión dedicada: el handshake se repite en el pool
+		return s.httpClient(raw)
+	}
+	// Una sola conexión por host: las peticiones de la misma conexión del
+	// navegador salen siempre por la misma conexión autenticada
+	transport := s.newSessionTransport(raw)
+	transport.MaxConnsPerHost = 1
+	transport.MaxIdleConnsPerHost = 1
+	transport.IdleConnTimeout = appConfig.AuthAffinityIdleTimeout
+	affine := &affineClient{
+		client:    &http.Client{Transport: transport, CheckRedirect: noRedirects},
+		transport: transport,
+		lastUsed:  now,
+	}
+	s.affinity.clients[key] = affine
+	return affine.client
+}
+
+// evictIdle descarta los clientes dedicados sin uso; se llama con mu tomado
+func (a *authAffinity) evictIdle(now time.Time) {
+	for key, affine := range a.clients {
+		if now.Sub(affine.lastUsed) > appConfig.AuthAffinityIdleTimeout {
+			affine.transport.CloseIdleConnections()
+			delete(a.clients, key)
+		}
+	}
+}
+
+// closeAffineConnections cierra las conexiones dedicadas, al cerrar o reconectar la sesión
+func (s *PortForwardSession) closeAffineConnections() {
+	s.affinity.mu.Lock()
+	defer s.affinity.mu.Unlock()
+	for key, affine := range s.affinity.clients {
+		affine.transport.CloseIdleConnections()
+		delete(s.affinity.clients, key)
+	}
+}
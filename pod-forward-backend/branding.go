@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// footerLink es un link del pie de las páginas del backend
+type footerLink struct {
+	Label string
+	URL   string
+}
+
+// brandFooterLinks interpreta BRAND_FOOTER_LINKS (etiqueta=url separadas por comas)
+// conservando el orden; solo se aceptan URLs http(s) o rutas absolutas
+func brandFooterLinks() []footerLink {
+	var links []footerLink
+	for _, entry := range getEnvList("BRAND_FOOTER_LINKS") {
+		label, target, ok := strings.Cut(entry, "=")
+		label, target = strings.TrimSpace(label), strings.TrimSpace(target)
+		if !ok || label == "" || !isSafePageURL(target) {
+			slog.Warn("Entrada inválida en BRAND_FOOTER_LINKS", "component", "config", "entry", entry)
+			continue
+		}
+		links = append(links, footerLink{Label: label, URL: target})
+	}
+	return links
+}
+
+// isSafePageURL acepta URLs http(s) y rutas absolutas del mismo origen, para que la
+// configuración no pueda inyectar javascript: en las páginas
+func isSafePageURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if parsed.Scheme == "" {
+		return parsed.Host == "" && strings.HasPrefix(parsed.Path, "/")
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// brandLogoURL devuelve BRAND_LOGO_URL si es una URL aceptable para la página
+func brandLogoURL() string {
+	logo := getEnv("BRAND_LOGO_URL", "")
+	if logo != "" && !isSafePageURL(logo) {
+		slog.Warn("BRAND_LOGO_URL inválida", "component", "config", "value", logo)
+		return ""
+	}
+	return logo
+}
+
+// brandLogoSource devuelve el origen del logo para agregarlo a img-src de la CSP,
+// o "" si el logo está en el mismo origen o no hay logo
+func brandLogoSource() string {
+	parsed, err := url.Parse(appConfig.BrandLogoURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return ""
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// writeBrandedPage escribe una página propia del backend (forward, errores) con el
+// nombre, el logo y los links de pie configurados en BRAND_*. body ya debe venir
+// escapado.
+func writeBrandedPage(w http.ResponseWriter, status int, heading, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	title := "Port Forward"
+	var header, footer strings.Builder
+	if appConfig.BrandName != "" || appConfig.BrandLogoURL != "" {
+		header.WriteString(`    <header>`)
+		if appConfig.BrandLogoURL != "" {
+			fmt.Fprintf(&header, `<img src="%s" alt="" style="max-height:32px;vertical-align:middle">`, html.EscapeString(appConfig.BrandLogoURL))
+		}
+		if appConfig.BrandName != "" {
+			title = appConfig.BrandName + " - " + title
+			fmt.Fprintf(&header, ` <strong>%s</strong>`, html.EscapeString(appConfig.BrandName))
+		}
+		header.WriteString("</header>\n")
+	}
+	if len(appConfig.BrandFooterLinks) > 0 {
+		footer.WriteString(`    <footer>`)
+		for i, link := range appConfig.BrandFooterLinks {
+			if i > 0 {
+				footer.WriteString(" · ")
+			}
+			fmt.Fprintf(&footer, `<a href="%s" target="_blank" rel="noopener noreferrer">%s</a>`, html.EscapeString(link.URL), html.EscapeString(link.Label))
+		}
+		footer.WriteString("</footer>\n")
+	}
+
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="utf-8">
+</head>
+<body>
+%s    <h1>%s</h1>
+%s%s</body>
+</html>`, html.EscapeString(title), header.String(), html.EscapeString(heading), body, footer.String())
+}
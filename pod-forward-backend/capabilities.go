@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Funcionalidades que dependen de lo que sirve el API server de cada cluster
+const (
+	capabilityPortForward    = "portForward"
+	capabilityExec           = "exec"
+	capabilityEndpointSlices = "endpointSlices"
+	capabilityCronJobs       = "cronJobs"
+	capabilityPolicyCRD      = "podForwardPolicy"
+)
+
+// capabilityResource es el recurso (o subrecurso) que habilita una funcionalidad
+type capabilityResource struct {
+	name         string
+	groupVersion string
+	resource     string
+	// uses es lo que se deshabilita sin el recurso, para el mensaje de error
+	uses string
+}
+
+var capabilityResources = []capabilityResource{
+	{capabilityPortForward, "v1", "pods/portforward", "el port-forward"},
+	{capabilityExec, "v1", "pods/exec", "la transferencia de archivos"},
+	{capabilityEndpointSlices, "discovery.k8s.io/v1", "endpointslices", "los targets service="},
+	{capabilityCronJobs, "batch/v1", "cronjobs", "los targets cronjob="},
+	{capabilityPolicyCRD, podForwardPolicyGVR.GroupVersion().String(), podForwardPolicyGVR.Resource, "las PodForwardPolicy"},
+}
+
+// clusterCapabilities es la versión del cluster y las funcionalidades que soporta
+type clusterCapabilities struct {
+	Version  string          `json:"version,omitempty"`
+	Features map[string]bool `json:"features,omitempty"`
+	// Error es el motivo por el que no se pudo detectar; mientras tanto las
+	// funcionalidades quedan habilitadas y fallan, si corresponde, al usarse
+	Error string `json:"error,omitempty"`
+	// Unsupported explica por qué el cluster no cumple el mínimo del backend
+	Unsupported string `json:"unsupported,omitempty"`
+}
+
+var (
+	// capabilities guarda la detección de cada cluster por su healthName
+	capabilities   = make(map[string]*clusterCapabilities)
+	capabilitiesMu sync.Mutex
+)
+
+// capabilitiesFor devuelve las capacidades del cluster, detectándolas la primera
+// vez. Una detección fallida se reintenta en el próximo uso.
+func capabilitiesFor(ctx context.Context, kube *kubeTarget) *clusterCapabilities {
+	name := kube.healthName()
+	capabilitiesMu.Lock()
+	caps, ok := capabilities[name]
+	capabilitiesMu.Unlock()
+	if ok && caps.Error == "" {
+		return caps
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, appConfig.ClusterHealthTimeout)
+	defer cancel()
+	caps = detectCapabilities(ctx, kube.Clientset)
+	capabilitiesMu.Lock()
+	capabilities[name] = caps
+	capabilitiesMu.Unlock()
+	if caps.Error != "" {
+		slog.Warn("No se pudieron detectar las capacidades del cluster", "component", "capabilities", "cluster", name, "error", caps.Error)
+		return caps
+	}
+	var missing []string
+	for _, res := range capabilityResources {
+		if !caps.Features[res.name] {
+			missing = append(missing, res.name)
+		}
+	}
+	if len(missing) == 0 {
+		slog.Info("Todas las funcionalidades disponibles", "component", "capabilities", "cluster", name, "version", caps.Version)
+	} else {
+		slog.Warn("Funcionalidades no soportadas por el cluster", "component", "capabilities", "cluster", name, "version", caps.Version, "missing", missing)
+	}
+	if caps.Unsupported != "" {
+		slog.Warn("Cluster no soportado", "component", "capabilities", "cluster", name, "reason", caps.Unsupported)
+	}
+	return caps
+}
+
+// detectCapabilities consulta /version y la discovery de cada grupo que usa el backend
+func detectCapabilities(ctx context.Context, clientset kubernetes.Interface) *clusterCapabilities {
+	caps := &clusterCapabilities{Features: make(map[string]bool)}
+	client := clientset.Discovery().RESTClient()
+	raw, err := client.Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		caps.Error = fmt.Sprintf("error al consultar /version: %v", err)
+		return caps
+	}
+	var info version.Info
+	if err := json.Unmarshal(raw, &info); err != nil {
+		caps.Error = fmt.Sprintf("respuesta inválida de /version: %v", err)
+		return caps
+	}
+	caps.Version = info.GitVersion
+
+	resources := make(map[string]map[string]bool)
+	for _, res := range capabilityResources {
+		if _, ok := resources[res.groupVersion]; !ok {
+			path := "/apis/" + res.groupVersion
+			if res.groupVersion == "v1" {
+				path = "/api/v1"
+			}
+			var list metav1.APIResourceList
+			err := client.Get().AbsPath(path).Do(ctx).Into(&list)
+			if err != nil && !apierrors.IsNotFound(err) {
+				caps.Error = fmt.Sprintf("error al consultar %s: %v", path, err)
+				return caps
+			}
+			names := make(map[string]bool, len(list.APIResources))
+			for _, resource := range list.APIResources {
+				names[resource.Name] = true
+			}
+			resources[res.groupVersion] = names
+		}
+		caps.Features[res.name] = resources[res.groupVersion][res.resource]
+	}
+
+	if minimum, err := utilversion.ParseGeneric(appConfig.MinKubernetesVersion); err != nil {
+		slog.Warn("MIN_KUBERNETES_VERSION inválida", "component", "capabilities", "value", appConfig.MinKubernetesVersion, "error", err)
+	} else if current, err := utilversion.ParseGeneric(info.GitVersion); err == nil && current.LessThan(minimum) {
+		caps.Unsupported = fmt.Sprintf("la versión %s es anterior a la mínima %s", info.GitVersion, appConfig.MinKubernetesVersion)
+	}
+	if !caps.Features[capabilityPortForward] {
+		caps.Unsupported = "el API server no expone pods/portforward"
+	}
+	return caps
+}
+
+// requireCapability responde 501 si el cluster no soporta la funcionalidad, en
+// lugar de dejar que la llamada a la API falle con un error poco claro
+func requireCapability(ctx context.Context, kube *kubeTarget, feature string) (int, error) {
+	caps := capabilitiesFor(ctx, kube)
+	if caps.Error != "" || caps.Features[feature] {
+		return http.StatusOK, nil
+	}
+	uses := feature
+	for _, res := range capabilityResources {
+		if res.name == feature {
+			uses = fmt.Sprintf("%s (%s %s)", res.uses, res.groupVersion, res.resource)
+		}
+	}
+	addCounter("pod_forward_unsupported_feature_requests_total", map[string]string{"feature": feature}, 1)
+	return http.StatusNotImplemented, fmt.Errorf("el cluster %s (%s) no soporta %s", kube.healthName(), caps.Version, uses)
+}
+
+// capabilityStatuses devuelve una copia de las capacidades detectadas para /readyz
+func capabilityStatuses() map[string]clusterCapabilities {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	statuses := make(map[string]clusterCapabilities, len(capabilities))
+	for name, caps := range capabilities {
+		statuses[name] = *caps
+	}
+	return statuses
+}
+
+// localUnsupported devuelve por qué el cluster local no cumple el mínimo, o ""
+func localUnsupported() string {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	if caps, ok := capabilities[localCluster]; ok {
+		return caps.Unsupported
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// normalizeCharset declara o convierte a UTF-8 el charset de las respuestas HTML del
+// pod que no lo declaran en Content-Type. charset es el del contenido que envía la
+// aplicación (ej: windows-1252); si no es UTF-8 el cuerpo se transcodifica a medida
+// que se copia. El charset del header tiene prioridad sobre un <meta charset> del
+// documento, así que declararlo alcanza para que el navegador lo interprete bien.
+func normalizeCharset(resp *http.Response, charset string) error {
+	if charset == "" {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" || params["charset"] != "" {
+		return nil
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return fmt.Errorf("charset desconocido %q: %v", charset, err)
+	}
+	params["charset"] = "utf-8"
+	resp.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	if name, _ := htmlindex.Name(enc); strings.EqualFold(name, "utf-8") {
+		return nil
+	}
+
+	// El tamaño cambia al transcodificar
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{transform.NewReader(resp.Body, enc.NewDecoder()), resp.Body}
+	slog.Debug("Transcodificando HTML a UTF-8", "component", "normalizeCharset", "charset", charset)
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// localCluster es el nombre del cluster donde corre el backend
+const localCluster = "in-cluster"
+
+// clusterHealth es el último resultado del chequeo de conectividad de un cluster
+type clusterHealth struct {
+	Reachable   bool      `json:"reachable"`
+	LastChecked time.Time `json:"lastChecked"`
+	Error       string    `json:"error,omitempty"`
+	// Failures es la cantidad de chequeos fallidos seguidos
+	Failures int `json:"failures,omitempty"`
+}
+
+var (
+	// clusterClients son los clusters a los que el backend puede abrir port-forwards
+	clusterClients = make(map[string]kubernetes.Interface)
+	// clusterStatus guarda el estado de cada cluster de clusterClients
+	clusterStatus = make(map[string]clusterHealth)
+	clustersMu    sync.RWMutex
+)
+
+// registerCluster agrega un cluster al chequeo periódico; hasta el primer chequeo
+// se lo considera alcanzable
+func registerCluster(name string, clientset kubernetes.Interface) {
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	clusterClients[name] = clientset
+	if _, ok := clusterStatus[name]; !ok {
+		clusterStatus[name] = clusterHealth{Reachable: true}
+	}
+}
+
+// startClusterHealthChecks valida cada CLUSTER_HEALTH_INTERVAL las credenciales y
+// la conectividad de cada cluster registrado con una llamada liviana al API server
+func startClusterHealthChecks() {
+	goTask("cluster-health", func(ctx context.Context) error {
+		for {
+			checkClusters()
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(appConfig.ClusterHealthInterval):
+			}
+		}
+	})
+}
+
+func checkClusters() {
+	clustersMu.RLock()
+	clients := make(map[string]kubernetes.Interface, len(clusterClients))
+	for name, clientset := range clusterClients {
+		clients[name] = clientset
+	}
+	clustersMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, clientset := range clients {
+		wg.Add(1)
+		go func(name string, clientset kubernetes.Interface) {
+			defer wg.Done()
+			err := pingCluster(clientset)
+
+			clustersMu.Lock()
+			health := clusterStatus[name]
+			wasReachable := health.Reachable
+			health.LastChecked = time.Now()
+			health.Reachable = err == nil
+			if err != nil {
+				health.Error = err.Error()
+				health.Failures++
+			} else {
+				health.Error, health.Failures = "", 0
+			}
+			clusterStatus[name] = health
+			clustersMu.Unlock()
+
+			if wasReachable && err != nil {
+				slog.Warn("Cluster inalcanzable", "component", "clusterHealth", "cluster", name, "error", err)
+			} else if !wasReachable && err == nil {
+				slog.Info("Cluster alcanzable nuevamente", "component", "clusterHealth", "cluster", name)
+			}
+		}(name, clientset)
+	}
+	wg.Wait()
+}
+
+// pingCluster consulta /version con el plazo de CLUSTER_HEALTH_TIMEOUT; falla si las
+// credenciales fueron rechazadas o el API server no responde
+func pingCluster(clientset kubernetes.Interface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), appConfig.ClusterHealthTimeout)
+	defer cancel()
+	return clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// checkClusterReachable devuelve un error inmediato si el último chequeo del cluster
+// falló, para no esperar el timeout de la conexión al crear una sesión
+func checkClusterReachable(name string) (int, error) {
+	clustersMu.RLock()
+	health, ok := clusterStatus[name]
+	clustersMu.RUnlock()
+	if ok && !health.Reachable {
+		return http.StatusServiceUnavailable, fmt.Errorf("cluster %s inalcanzable: %s", name, health.Error)
+	}
+	return http.StatusOK, nil
+}
+
+// clusterStatuses devuelve una copia del estado de los clusters para /readyz
+func clusterStatuses() map[string]clusterHealth {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+	statuses := make(map[string]clusterHealth, len(clusterStatus))
+	for name, health := range clusterStatus {
+		statuses[name] = health
+	}
+	return statuses
+}
+
+// writeClusterMetrics expone pod_forward_cluster_up por cluster
+func writeClusterMetrics(w http.ResponseWriter) {
+	statuses := clusterStatuses()
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# TYPE pod_forward_cluster_up gauge\n")
+	for _, name := range names {
+		up := 0
+		if statuses[name].Reachable {
+			up = 1
+		}
+		fmt.Fprintf(w, "pod_forward_cluster_up%s %d\n", metricLabels(map[string]string{"cluster": name}), up)
+	}
+}
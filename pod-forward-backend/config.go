@@ -0,0 +1,544 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config agrupa las opciones del backend que se leen de variables de entorno
+type Config struct {
+	Port string
+	// StripFrameHeaders elimina X-Frame-Options y frame-ancestors de las respuestas
+	// del pod para que la aplicación pueda embeberse en el panel de Argo CD
+	StripFrameHeaders bool
+	// SniffContentType completa el Content-Type de las respuestas del pod que no lo
+	// traen con el tipo detectado a partir del cuerpo
+	SniffContentType bool
+	// DefaultCharset es el charset que se asume para el HTML del pod que no lo
+	// declara (ej: windows-1252); se convierte a UTF-8. Vacío no cambia la respuesta.
+	DefaultCharset string
+	// BlockServiceWorkers impide que las aplicaciones registren service workers a
+	// través del proxy
+	BlockServiceWorkers bool
+	// RewriteOrigin y RewriteReferer aplican a todas las sesiones las opciones
+	// rewriteOrigin y rewriteReferer de los perfiles
+	RewriteOrigin  bool
+	RewriteReferer bool
+	// UpstreamOrigin es el origen que se envía en Origin y Referer en lugar del del
+	// pod (ej: https://grafana.example.com); un perfil puede reemplazarlo
+	UpstreamOrigin string
+	// RewriteCookiePaths agrega el prefijo del proxy al Path de los Set-Cookie del
+	// pod y quita su Domain
+	RewriteCookiePaths bool
+	// RewriteAbsoluteURLs agrega el prefijo del proxy a los href/src/action absolutos
+	// del HTML de todas las sesiones, como la opción rewriteAbsoluteURLs de los perfiles
+	RewriteAbsoluteURLs bool
+	// ForwardedHeaders envía X-Forwarded-For/Proto/Host/Prefix al pod para que las
+	// aplicaciones que soportan un sub-path generen sus URLs con el prefijo del proxy
+	ForwardedHeaders bool
+	// ExternalURL es la URL pública de Argo CD (ej: https://argocd.example.com),
+	// usada para construir enlaces absolutos a las sesiones
+	ExternalURL string
+	// TrustedProxies son las IPs o rangos CIDR de los proxies (ingress, Argo CD) cuyos
+	// X-Forwarded-Proto/Host se aceptan para armar URLs absolutas sin EXTERNAL_URL
+	TrustedProxies []string
+	// ArgoCDNamespace es el namespace donde viven las Applications de Argo CD
+	ArgoCDNamespace string
+	// PolicyCRDEnabled activa la lectura de políticas desde objetos PodForwardPolicy
+	PolicyCRDEnabled bool
+	// AdminGroups son los grupos de Argo CD con rol admin (todas las sesiones, drain y reportes)
+	AdminGroups []string
+	// OperatorGroups son los grupos de Argo CD con rol operator (cierran sesiones de su proyecto)
+	OperatorGroups []string
+	// PodNamespace es el namespace donde corre el backend
+	PodNamespace string
+	// PersistenceConfigMap es el ConfigMap donde se guardan las sesiones; vacío deshabilita la persistencia
+	PersistenceConfigMap string
+	// RestoreConcurrency limita cuántas sesiones se restauran a la vez al iniciar
+	RestoreConcurrency int
+	// RestoreTimeout es el plazo para restaurar cada sesión
+	RestoreTimeout time.Duration
+	// ExtensionName es el nombre de la extensión en Argo CD (extension.proxy.<nombre>)
+	ExtensionName string
+	// DeploymentMode es argocd (detrás del proxy de extensiones), standalone
+	// (detrás de un Ingress con autenticación propia) o auto
+	DeploymentMode string
+	// ArgoCDServerService es el Service del API server usado para detectar el modo
+	ArgoCDServerService string
+	// ArgoCDProxySecret es el secreto que el proxy de extensiones de Argo CD agrega en
+	// el header ArgoCDProxySecretHeader (headers de extension.config); sin él el
+	// authenticator argocd rechaza la petición. Vacío no lo exige.
+	ArgoCDProxySecret       string
+	ArgoCDProxySecretHeader string
+	// StandaloneUserHeader y StandaloneGroupsHeader son los headers con la identidad
+	// que agrega la autenticación del Ingress en modo standalone
+	StandaloneUserHeader   string
+	StandaloneGroupsHeader string
+	// OIDCIssuerURL activa el login OIDC propio en modo standalone
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCRedirectURL es la URL de callback registrada en el IdP; por defecto
+	// <EXTERNAL_URL>/api/v1/extensions/pod-forward/auth/callback
+	OIDCRedirectURL string
+	OIDCScopes      []string
+	// OIDCUserClaim y OIDCGroupsClaim son los claims del ID token con el usuario y sus grupos
+	OIDCUserClaim   string
+	OIDCGroupsClaim string
+	// OIDCCookieSecret firma la cookie de sesión; debe ser igual en todas las réplicas
+	OIDCCookieSecret string
+	// OIDCSessionTTL es la duración de la sesión de login
+	OIDCSessionTTL time.Duration
+	// Authenticators es la cadena de authenticators que se prueban en orden
+	// (argocd, header, shared-secret, oidc, mtls); vacío usa el default del modo
+	Authenticators []string
+	// AuthSharedSecret es el token aceptado por el authenticator shared-secret y
+	// AuthSharedSecretUser/AuthSharedSecretGroups la identidad que se le asigna
+	AuthSharedSecret       string
+	AuthSharedSecretUser   string
+	AuthSharedSecretGroups []string
+	// TLSCertFile y TLSKeyFile activan HTTPS; TLSClientCAFile valida certificados
+	// de cliente para el authenticator mtls
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	// H2CEnabled acepta HTTP/2 sin TLS (h2c) en el listener principal, para clientes
+	// gRPC que llegan directo al backend
+	H2CEnabled bool
+	// AdminAddr es la dirección del listener de /admin/*; fuera de loopback requiere
+	// TLS y una autenticación distinta de argocd
+	AdminAddr string
+	// FrameAncestors son los orígenes que pueden embeber las páginas propias del
+	// backend; por defecto 'self' y el origen de EXTERNAL_URL
+	FrameAncestors []string
+	// CSRFTrustedOrigins son orígenes (ej: https://admin.example.com) desde los que
+	// se aceptan peticiones mutantes además del host de la petición y EXTERNAL_URL
+	CSRFTrustedOrigins []string
+	// CSRFSecret firma los tokens CSRF de las sesiones con cookie (oidc, header);
+	// debe ser igual en todas las réplicas
+	CSRFSecret string
+	// HelpersEnabled permite crear pods auxiliares (ej: pgweb, adminer) en los
+	// namespaces de las aplicaciones; requiere permiso de create/delete sobre pods
+	HelpersEnabled bool
+	// HelperNamespaces son los namespaces (admite patrones como team-*) en los que se
+	// pueden crear pods auxiliares cuando no hay política; vacío no permite ninguno
+	HelperNamespaces []string
+	// HelperImages reemplaza las imágenes de los pods auxiliares (ej: pgweb=registry/pgweb:0.15.0)
+	HelperImages map[string]string
+	// HelperStartTimeout es el plazo para que el pod auxiliar quede listo
+	HelperStartTimeout time.Duration
+	// HelperMaxLifetime es el activeDeadlineSeconds del pod auxiliar, para que
+	// Kubernetes lo termine aunque el backend no llegue a borrarlo
+	HelperMaxLifetime time.Duration
+	// HelperGCEnabled borra al iniciar los pods auxiliares que quedaron sin sesión
+	// (por ejemplo tras un reinicio a mitad de la creación)
+	HelperGCEnabled bool
+	// FileTransferEnabled habilita /api/v2/files cuando no hay PodForwardPolicy;
+	// con políticas se usa fileTransferNamespaces
+	FileTransferEnabled bool
+	// FileTransferMaxBytes es el tamaño máximo de un archivo subido
+	FileTransferMaxBytes int64
+	// WaitMaxTimeout es el máximo que puede esperar la creación de una sesión con
+	// wait=true a que haya un pod listo
+	WaitMaxTimeout time.Duration
+	// TemplatesConfigMap es el ConfigMap donde se guardan las plantillas de sesión de cada usuario
+	TemplatesConfigMap string
+	// RecentConfigMap es el ConfigMap con el historial de targets recientes de cada usuario
+	RecentConfigMap string
+	// GRPCAddr es la dirección de la API gRPC de sesiones; vacío la deshabilita.
+	// Requiere shared-secret, mtls o argocd con ARGOCD_PROXY_SECRET, y fuera de
+	// loopback además TLS y una autenticación distinta de argocd.
+	GRPCAddr string
+	// LinkSecret firma los links de un solo uso; debe ser igual en todas las réplicas
+	LinkSecret string
+	// LinkMaxTTL es la vigencia máxima de un link de un solo uso
+	LinkMaxTTL time.Duration
+	// LinksConfigMap registra los links ya usados hasta que expiran
+	LinksConfigMap string
+	// ClusterHealthInterval es cada cuánto se valida la conectividad con cada cluster
+	ClusterHealthInterval time.Duration
+	// ClusterHealthTimeout es el plazo de cada chequeo de conectividad
+	ClusterHealthTimeout time.Duration
+	// MinKubernetesVersion es la versión mínima del cluster local; con una anterior
+	// /readyz responde 503 en lugar de fallar en cada petición
+	MinKubernetesVersion string
+	// MultiClusterEnabled permite abrir sesiones en los clusters registrados en Argo
+	// CD (cluster=<nombre|server>) con las credenciales de sus Secrets
+	MultiClusterEnabled bool
+	// ClusterCacheTTL es cada cuánto se vuelve a leer el Secret de un cluster remoto
+	ClusterCacheTTL time.Duration
+	// SessionReconnectTimeout es cuánto se reintenta reconectar una sesión que perdió
+	// la conexión con el pod antes de cerrarla; 0 la cierra en el momento
+	SessionReconnectTimeout time.Duration
+	// SessionReconnectWait es cuánto espera una petición a que su sesión reconecte
+	// antes de responder 503; 0 responde 503 en el momento
+	SessionReconnectWait time.Duration
+	// SessionIdleTTL cierra las sesiones sin actividad durante ese plazo; 0 lo deshabilita
+	SessionIdleTTL time.Duration
+	// NodeName es el nodo donde corre el backend (downward API), para elegir
+	// endpoints de la misma zona
+	NodeName string
+	// UpstreamDialTimeout es el plazo para conectar con el puerto local del port-forward
+	UpstreamDialTimeout time.Duration
+	// UpstreamTLSTimeout es el plazo del handshake TLS con el pod
+	UpstreamTLSTimeout time.Duration
+	// UpstreamHeaderTimeout es el plazo para que el pod envíe los headers de
+	// la respuesta; el cuerpo no tiene plazo para que las descargas no se corten
+	UpstreamHeaderTimeout time.Duration
+	// UpstreamTimeout es el plazo total de cada petición al pod; 0 no limita. No
+	// aplica a Server-Sent Events ni a las rutas de UpstreamStreamingPaths.
+	UpstreamTimeout time.Duration
+	// UpstreamReadIdleTimeout corta la respuesta si el pod pasa ese plazo sin
+	// enviar datos del cuerpo; 0 no limita
+	UpstreamReadIdleTimeout time.Duration
+	// UpstreamWriteTimeout es el plazo de cada escritura hacia el cliente (también
+	// en los WebSockets); si vence se corta la petición al pod. 0 no limita
+	UpstreamWriteTimeout time.Duration
+	// UpstreamMaxIdleConns es cuántas conexiones inactivas guarda el pool de una
+	// sesión por túnel para reutilizarlas en las peticiones siguientes
+	UpstreamMaxIdleConns int
+	// LatencySLO es el tiempo hasta los headers de la respuesta del pod que se
+	// considera aceptable; LatencySLOObjective es la fracción de peticiones que
+	// debe cumplirlo (ej: 0.99). Con ambos se calcula el burn rate por perfil.
+	LatencySLO          time.Duration
+	LatencySLOObjective float64
+	// AuthAffinityIdleTimeout es cuánto se conserva sin uso la conexión autenticada
+	// de una conexión del navegador cuando el pod autentica por conexión (NTLM,
+	// Negotiate); AuthAffinityMaxConns limita cuántas guarda cada sesión
+	AuthAffinityIdleTimeout time.Duration
+	AuthAffinityMaxConns    int
+	// UpstreamMaxTimeout limita los plazos que una petición pide por header o query
+	UpstreamMaxTimeout time.Duration
+	// UpstreamStreamingPaths son los prefijos de ruta del pod sin plazo total (ej:
+	// long polling, descargas grandes)
+	UpstreamStreamingPaths []string
+	// UpstreamIdleTimeout es cuánto se conserva una conexión inactiva hacia el pod
+	UpstreamIdleTimeout time.Duration
+	// WarmConnections es la cantidad de conexiones keep-alive que se mantienen
+	// abiertas hacia cada sesión; 0 lo deshabilita
+	WarmConnections int
+	// WarmInterval es cada cuánto se hace el ping que mantiene las conexiones abiertas
+	WarmInterval time.Duration
+	// WarmPath es la ruta del pod que recibe el ping (HEAD)
+	WarmPath string
+	// SPDYMaxStreams limita las conexiones simultáneas hacia cada sesión; las demás
+	// esperan un stream libre. 0 no limita hasta que el túnel rechace un stream.
+	SPDYMaxStreams int
+	// MaxSessionTunnels es la cantidad máxima de túneles que puede pedir una sesión
+	MaxSessionTunnels int
+	// MaxSessionsPerUser limita las sesiones simultáneas de cada usuario sin
+	// PodForwardPolicy (POLICY_CRD_ENABLED deshabilitado); 0 no limita
+	MaxSessionsPerUser int
+	// SubjectAccessReview verifica con un SubjectAccessReview que el usuario pueda
+	// crear pods/portforward antes de abrir cada sesión
+	SubjectAccessReview bool
+	// SARUserPrefix y SARGroupPrefix se anteponen al usuario y los grupos de Argo CD
+	// para obtener los de Kubernetes (ej: oidc:)
+	SARUserPrefix  string
+	SARGroupPrefix string
+	// SelfTestEnabled verifica al iniciar los permisos de la ServiceAccount en
+	// SelfTestNamespace; con SelfTestStrict el backend no arranca si falta alguno
+	SelfTestEnabled   bool
+	SelfTestNamespace string
+	SelfTestStrict    bool
+	// LogFormat es el formato del log de la aplicación: text o json
+	LogFormat string
+	// LogLevel es el nivel mínimo del log: debug, info, warn o error
+	LogLevel string
+	// AccessLogFormat es el formato del access log en stdout: json o combined
+	// (Apache/NCSA); vacío lo deshabilita
+	AccessLogFormat string
+	// CatchAllForward maneja como port-forward cualquier ruta no declarada que
+	// contenga /forward; deshabilitado, el handler raíz solo sirve "/"
+	CatchAllForward bool
+	// MaxRedirectDepth es la cantidad de redirects seguidos que puede seguir un
+	// navegador en una sesión antes de cortar la cadena como bucle
+	MaxRedirectDepth int
+	// RateLimitUserRPS y RateLimitUserBurst limitan las peticiones de cada usuario (o
+	// IP sin usuario); RateLimitSessionRPS y RateLimitSessionBurst las que van al pod
+	// por cada sesión. Un RPS de 0 deshabilita el límite.
+	RateLimitUserRPS      float64
+	RateLimitUserBurst    int
+	RateLimitSessionRPS   float64
+	RateLimitSessionBurst int
+	// AuditLog son los destinos del registro de auditoría de cada petición que pasa
+	// por el proxy y de las sesiones, links, transferencias y cuotas: stdout (JSON)
+	// y/o events (Events de Kubernetes en el pod); vacío lo deshabilita
+	AuditLog []string
+	// TracingEnabled envía spans OpenTelemetry por OTLP/HTTP; el destino y el
+	// muestreo se configuran con las variables OTEL_* estándar
+	TracingEnabled bool
+	// ShutdownTimeout es cuánto se espera al recibir SIGTERM a que terminen las
+	// peticiones en curso antes de cerrar las sesiones
+	ShutdownTimeout time.Duration
+	// BrandName, BrandLogoURL y BrandFooterLinks personalizan las páginas que
+	// genera el backend (forward, errores) con el nombre y el logo de la organización
+	BrandName        string
+	BrandLogoURL     string
+	BrandFooterLinks []footerLink
+	// MockMode simula un cluster con pods de ejemplo en lugar de conectarse a
+	// Kubernetes, para desarrollar la extensión de la UI sin acceso a un cluster
+	MockMode bool
+	// Kubeconfig y KubeContext permiten ejecutar el backend fuera del cluster
+	// (kind, minikube); también se pueden indicar con --kubeconfig y --context
+	Kubeconfig  string
+	KubeContext string
+	// RewriterPlugins son ejecutables que reciben cada petición y respuesta en
+	// JSON por stdin y devuelven los headers a cambiar por stdout (ver plugins.go)
+	RewriterPlugins []string
+	// RewriterPluginTimeout es cuánto se espera la respuesta de un rewriter antes
+	// de reiniciarlo
+	RewriterPluginTimeout time.Duration
+}
+
+// appConfig es la configuración cargada al iniciar el servidor
+var appConfig = loadConfig()
+
+func loadConfig() *Config {
+	return &Config{
+		Port:                    getEnv("PORT", defaultPort),
+		StripFrameHeaders:       getEnvBool("STRIP_FRAME_HEADERS", false),
+		SniffContentType:        getEnvBool("SNIFF_CONTENT_TYPE", false),
+		DefaultCharset:          getEnv("DEFAULT_CHARSET", ""),
+		BlockServiceWorkers:     getEnvBool("BLOCK_SERVICE_WORKERS", false),
+		RewriteOrigin:           getEnvBool("REWRITE_ORIGIN", false),
+		RewriteReferer:          getEnvBool("REWRITE_REFERER", false),
+		UpstreamOrigin:          strings.TrimSuffix(getEnv("UPSTREAM_ORIGIN", ""), "/"),
+		RewriteCookiePaths:      getEnvBool("REWRITE_COOKIE_PATHS", true),
+		RewriteAbsoluteURLs:     getEnvBool("REWRITE_ABSOLUTE_URLS", false),
+		ForwardedHeaders:        getEnvBool("FORWARDED_HEADERS", true),
+		ExternalURL:             strings.TrimSuffix(getEnv("EXTERNAL_URL", ""), "/"),
+		TrustedProxies:          getEnvList("TRUSTED_PROXIES"),
+		ArgoCDNamespace:         getEnv("ARGOCD_NAMESPACE", "argocd"),
+		PolicyCRDEnabled:        getEnvBool("POLICY_CRD_ENABLED", false),
+		AdminGroups:             getEnvList("ADMIN_GROUPS"),
+		OperatorGroups:          getEnvList("OPERATOR_GROUPS"),
+		PodNamespace:            getEnv("POD_NAMESPACE", "argocd"),
+		PersistenceConfigMap:    getEnv("PERSISTENCE_CONFIGMAP", ""),
+		RestoreConcurrency:      getEnvInt("RESTORE_CONCURRENCY", 4),
+		RestoreTimeout:          getEnvDuration("RESTORE_TIMEOUT", 15*time.Second),
+		ExtensionName:           getEnv("EXTENSION_NAME", "pod-forward"),
+		DeploymentMode:          getEnv("DEPLOYMENT_MODE", modeArgoCD),
+		ArgoCDServerService:     getEnv("ARGOCD_SERVER_SERVICE", "argocd-server"),
+		ArgoCDProxySecret:       getEnv("ARGOCD_PROXY_SECRET", ""),
+		ArgoCDProxySecretHeader: getEnv("ARGOCD_PROXY_SECRET_HEADER", "X-Pod-Forward-Secret"),
+		StandaloneUserHeader:    getEnv("STANDALONE_USER_HEADER", "X-Auth-Request-User"),
+		StandaloneGroupsHeader:  getEnv("STANDALONE_GROUPS_HEADER", "X-Auth-Request-Groups"),
+		OIDCIssuerURL:           getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:            getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:        getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:         getEnv("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:              getEnvListDefault("OIDC_SCOPES", []string{"openid", "profile", "email", "groups"}),
+		OIDCUserClaim:           getEnv("OIDC_USER_CLAIM", "email"),
+		OIDCGroupsClaim:         getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCCookieSecret:        getEnv("OIDC_COOKIE_SECRET", ""),
+		OIDCSessionTTL:          getEnvDuration("OIDC_SESSION_TTL", 12*time.Hour),
+		Authenticators:          getEnvList("AUTHENTICATORS"),
+		AuthSharedSecret:        getEnv("AUTH_SHARED_SECRET", ""),
+		AuthSharedSecretUser:    getEnv("AUTH_SHARED_SECRET_USER", "automation"),
+		AuthSharedSecretGroups:  getEnvList("AUTH_SHARED_SECRET_GROUPS"),
+		TLSCertFile:             getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:              getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:         getEnv("TLS_CLIENT_CA_FILE", ""),
+		H2CEnabled:              getEnvBool("H2C_ENABLED", false),
+		AdminAddr:               getEnv("ADMIN_ADDR", "127.0.0.1:9091"),
+		FrameAncestors:          getEnvList("FRAME_ANCESTORS"),
+		CSRFTrustedOrigins:      getEnvList("CSRF_TRUSTED_ORIGINS"),
+		CSRFSecret:              getEnv("CSRF_SECRET", ""),
+		HelpersEnabled:          getEnvBool("HELPERS_ENABLED", false),
+		HelperNamespaces:        getEnvList("HELPER_NAMESPACES"),
+		HelperImages:            getEnvMap("HELPER_IMAGES"),
+		HelperStartTimeout:      getEnvDuration("HELPER_START_TIMEOUT", 90*time.Second),
+		HelperMaxLifetime:       getEnvDuration("HELPER_MAX_LIFETIME", 8*time.Hour),
+		HelperGCEnabled:         getEnvBool("HELPER_GC_ENABLED", true),
+		FileTransferEnabled:     getEnvBool("FILE_TRANSFER_ENABLED", false),
+		FileTransferMaxBytes:    int64(getEnvInt("FILE_TRANSFER_MAX_BYTES", 512<<20)),
+		WaitMaxTimeout:          getEnvDuration("WAIT_MAX_TIMEOUT", 5*time.Minute),
+		TemplatesConfigMap:      getEnv("TEMPLATES_CONFIGMAP", "pod-forward-templates"),
+		RecentConfigMap:         getEnv("RECENT_CONFIGMAP", "pod-forward-recent"),
+		GRPCAddr:                getEnv("GRPC_ADDR", ""),
+		LinkSecret:              getEnv("LINK_SECRET", ""),
+		LinkMaxTTL:              getEnvDuration("LINK_MAX_TTL", time.Hour),
+		LinksConfigMap:          getEnv("LINKS_CONFIGMAP", "pod-forward-links"),
+		ClusterHealthInterval:   getEnvDuration("CLUSTER_HEALTH_INTERVAL", 30*time.Second),
+		ClusterHealthTimeout:    getEnvDuration("CLUSTER_HEALTH_TIMEOUT", 5*time.Second),
+		MinKubernetesVersion:    getEnv("MIN_KUBERNETES_VERSION", "1.21"),
+		MultiClusterEnabled:     getEnvBool("MULTI_CLUSTER_ENABLED", false),
+		ClusterCacheTTL:         getEnvDuration("CLUSTER_CACHE_TTL", 5*time.Minute),
+		SessionReconnectTimeout: getEnvDurationOrZero("SESSION_RECONNECT_TIMEOUT", 2*time.Minute),
+		SessionReconnectWait:    getEnvDurationOrZero("SESSION_RECONNECT_WAIT", 15*time.Second),
+		SessionIdleTTL:          getEnvDurationOrZero("SESSION_IDLE_TTL", 30*time.Minute),
+		NodeName:                getEnv("NODE_NAME", ""),
+		UpstreamDialTimeout:     getEnvDuration("UPSTREAM_DIAL_TIMEOUT", 5*time.Second),
+		UpstreamTLSTimeout:      getEnvDuration("UPSTREAM_TLS_TIMEOUT", 10*time.Second),
+		UpstreamHeaderTimeout:   getEnvDuration("UPSTREAM_HEADER_TIMEOUT", 30*time.Second),
+		UpstreamTimeout:         getEnvDurationOrZero("UPSTREAM_TIMEOUT", 0),
+		UpstreamReadIdleTimeout: getEnvDurationOrZero("UPSTREAM_READ_IDLE_TIMEOUT", 0),
+		AuthAffinityIdleTimeout: getEnvDuration("AUTH_AFFINITY_IDLE_TIMEOUT", 10*time.Minute),
+		AuthAffinityMaxConns:    getEnvInt("AUTH_AFFINITY_MAX_CONNS", 32),
+		UpstreamMaxIdleConns:    getEnvInt("UPSTREAM_MAX_IDLE_CONNS", 16),
+		UpstreamWriteTimeout:    getEnvDurationOrZero("UPSTREAM_WRITE_TIMEOUT", time.Minute),
+		LatencySLO:              getEnvDuration("LATENCY_SLO", time.Second),
+		LatencySLOObjective:     getEnvFraction("LATENCY_SLO_OBJECTIVE", 0.99),
+		UpstreamMaxTimeout:      getEnvDurationOrZero("UPSTREAM_MAX_TIMEOUT", time.Hour),
+		UpstreamStreamingPaths:  getEnvList("UPSTREAM_STREAMING_PATHS"),
+		UpstreamIdleTimeout:     getEnvDuration("UPSTREAM_IDLE_TIMEOUT", 90*time.Second),
+		WarmConnections:         getEnvInt("WARM_CONNECTIONS", 0),
+		WarmInterval:            getEnvDuration("WARM_INTERVAL", 30*time.Second),
+		WarmPath:                getEnv("WARM_PATH", "/"),
+		SPDYMaxStreams:          getEnvInt("SPDY_MAX_STREAMS", 0),
+		MaxSessionTunnels:       getEnvInt("MAX_SESSION_TUNNELS", 4),
+		MaxSessionsPerUser:      getEnvInt("MAX_SESSIONS_PER_USER", 20),
+		AccessLogFormat:         getEnv("ACCESS_LOG_FORMAT", ""),
+		CatchAllForward:         getEnvBool("CATCH_ALL_FORWARD", false),
+		MaxRedirectDepth:        getEnvInt("MAX_REDIRECT_DEPTH", 10),
+		RateLimitUserRPS:        getEnvFloat("RATE_LIMIT_USER_RPS", 0),
+		RateLimitUserBurst:      getEnvInt("RATE_LIMIT_USER_BURST", 100),
+		RateLimitSessionRPS:     getEnvFloat("RATE_LIMIT_SESSION_RPS", 0),
+		RateLimitSessionBurst:   getEnvInt("RATE_LIMIT_SESSION_BURST", 50),
+		AuditLog:                getEnvList("AUDIT_LOG"),
+		TracingEnabled:          getEnvBool("TRACING_ENABLED", false),
+		ShutdownTimeout:         getEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second),
+		BrandName:               getEnv("BRAND_NAME", ""),
+		BrandLogoURL:            brandLogoURL(),
+		BrandFooterLinks:        brandFooterLinks(),
+		MockMode:                getEnvBool("MOCK_MODE", false),
+		Kubeconfig:              getEnv("KUBECONFIG", ""),
+		KubeContext:             getEnv("KUBE_CONTEXT", ""),
+		RewriterPlugins:         getEnvList("REWRITER_PLUGINS"),
+		RewriterPluginTimeout:   getEnvDuration("REWRITER_PLUGIN_TIMEOUT", 2*time.Second),
+		LogFormat:               getEnv("LOG_FORMAT", "json"),
+		LogLevel:                getEnv("LOG_LEVEL", "info"),
+		SelfTestEnabled:         getEnvBool("SELFTEST_ENABLED", true),
+		SelfTestNamespace:       getEnv("SELFTEST_NAMESPACE", "default"),
+		SelfTestStrict:          getEnvBool("SELFTEST_STRICT", false),
+		SubjectAccessReview:     getEnvBool("SUBJECT_ACCESS_REVIEW", false),
+		SARUserPrefix:           getEnv("SAR_USER_PREFIX", ""),
+		SARGroupPrefix:          getEnv("SAR_GROUP_PREFIX", ""),
+	}
+}
+
+// getEnv devuelve el valor de la variable de entorno o el valor por defecto
+func getEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvList interpreta la variable de entorno como una lista separada por comas
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvListDefault es getEnvList con un valor por defecto si la variable está vacía
+func getEnvListDefault(key string, def []string) []string {
+	if values := getEnvList(key); len(values) > 0 {
+		return values
+	}
+	return def
+}
+
+// getEnvMap interpreta la variable de entorno como una lista de clave=valor separada por comas
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range getEnvList(key) {
+		k, v, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			slog.Warn("Entrada inválida", "component", "config", "key", key, "entry", entry)
+			continue
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return values
+}
+
+// getEnvInt interpreta la variable de entorno como un entero positivo
+func getEnvInt(key string, def int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		slog.Warn("Valor inválido, usando el valor por defecto", "component", "config", "key", key, "value", value, "default", def)
+		return def
+	}
+	return n
+}
+
+// getEnvFloat interpreta la variable de entorno como un número no negativo
+func getEnvFloat(key string, def float64) float64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		slog.Warn("Valor inválido, usando el valor por defecto", "component", "config", "key", key, "value", value, "default", def)
+		return def
+	}
+	return f
+}
+
+// getEnvFraction interpreta la variable de entorno como una fracción entre 0 y 1 (exclusivo)
+func getEnvFraction(key string, def float64) float64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 || f >= 1 {
+		slog.Warn("Valor inválido, usando el valor por defecto", "component", "config", "key", key, "value", value, "default", def)
+		return def
+	}
+	return f
+}
+
+// getEnvDuration interpreta la variable de entorno como una duración (ej: 30s, 5m)
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Valor inválido, usando el valor por defecto", "component", "config", "key", key, "value", value, "default", def)
+		return def
+	}
+	return d
+}
+
+// getEnvDurationOrZero es getEnvDuration pero acepta 0, para los plazos en los que
+// 0 deshabilita la función
+func getEnvDurationOrZero(key string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if d, err := time.ParseDuration(value); err == nil && d == 0 {
+		return 0
+	}
+	return getEnvDuration(key, def)
+}
+
+// getEnvBool interpreta la variable de entorno como booleano
+func getEnvBool(key string, def bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Valor inválido, usando el valor por defecto", "component", "config", "key", key, "value", value, "default", def)
+		return def
+	}
+	return b
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// La protección CSRF tiene dos capas. Toda petición mutante debe traer los headers
+// que el navegador agrega y que una página de otro sitio no puede falsificar:
+// Sec-Fetch-Site y, en navegadores que no lo envían, Origin. Las sesiones
+// autenticadas con una cookie (oidc, o header detrás de oauth2-proxy) exigen además
+// un token de doble envío: cookie y header X-CSRF-Token iguales. Detrás del proxy
+// de Argo CD no hay token porque el proxy no reenvía las cookies del navegador.
+
+const (
+	csrfCookie = "pod_forward_csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfSecret firma los tokens; sin CSRF_SECRET se genera uno al iniciar y los
+// tokens dejan de ser válidos tras un reinicio o entre réplicas
+var csrfSecret = loadCSRFSecret()
+
+func loadCSRFSecret() []byte {
+	if appConfig.CSRFSecret != "" {
+		return []byte(appConfig.CSRFSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error("Error al generar el secreto", "component", "csrf", "error", err)
+	}
+	return secret
+}
+
+// newCSRFToken genera un token <aleatorio>.<hmac> atado al usuario, de modo que un
+// token obtenido con otra sesión de login no sirve
+func newCSRFToken(user string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + csrfSignature(encoded, user), nil
+}
+
+func csrfSignature(nonce, user string) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte(nonce + "|" + user))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken verifica el doble envío (cookie y header iguales) y la firma
+func validCSRFToken(r *http.Request, user string) bool {
+	header := r.Header.Get(csrfHeader)
+	cookie, err := r.Cookie(csrfCookie)
+	if header == "" || err != nil || !hmac.Equal([]byte(header), []byte(cookie.Value)) {
+		return false
+	}
+	nonce, sig, ok := strings.Cut(header, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(csrfSignature(nonce, user)))
+}
+
+// setCSRFCookie entrega un token nuevo en la cookie de doble envío. La cookie no es
+// HttpOnly: el cliente la lee para repetirla en X-CSRF-Token.
+func setCSRFCookie(w http.ResponseWriter, r *http.Request, user string) (string, error) {
+	token, err := newCSRFToken(user)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// cookieAuthenticated indica si la petición se autenticó con una credencial que el
+// navegador agrega por su cuenta: la cookie de sesión OIDC o la del proxy de
+// autenticación que inyecta los headers de identidad
+func cookieAuthenticated(r *http.Request) bool {
+	switch authenticatedBy(r) {
+	case "oidc", "header":
+		return true
+	}
+	return false
+}
+
+// isProxiedPath indica si la ruta la atiende handlePortForward y va al pod: la
+// aplicación proxificada tiene su propia lógica contra CSRF. El resto (API de
+// gestión, links, admin, login) lo protege csrfProtect.
+func isProxiedPath(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case path == "/forward":
+		return true
+	case path == extensionBasePath+"/discover", strings.HasPrefix(path, extensionBasePath+"/auth/"), isLinkPath(path):
+		return false
+	case hasProxyPrefix(path):
+		return true
+	}
+	// Con CATCH_ALL_FORWARD las rutas no declaradas con /forward van al pod
+	if appConfig.CatchAllForward && strings.Contains(path, "/forward") {
+		_, pattern := http.DefaultServeMux.Handler(r)
+		return pattern == "/"
+	}
+	return false
+}
+
+// isSafeMethod indica si el método no modifica estado
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sameOriginRequest indica si la petición no viene de una página de otro sitio.
+// Sec-Fetch-Site same-origin o none (barra de direcciones, marcadores) se aceptan;
+// sin Sec-Fetch-Site se compara Origin con el host de la petición, EXTERNAL_URL y
+// CSRF_TRUSTED_ORIGINS. Sin ninguno de los dos se rechaza: los navegadores envían
+// alguno en toda petición mutante y los clientes programáticos usan un bearer token.
+func sameOriginRequest(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		// Origin: null (sandbox, data:, redirects entre sitios)
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	if base := externalBaseURL(r); base != "" && strings.EqualFold(strings.TrimSuffix(origin, "/"), base) {
+		return true
+	}
+	for _, trusted := range appConfig.CSRFTrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(origin, "/"), strings.TrimSuffix(trusted, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfProtect rechaza las peticiones que modifican estado desde otro sitio en todas
+// las rutas que no van al pod, y sin el token de doble envío en las sesiones con
+// cookie. Solo quedan exentas las autenticadas con un bearer token (shared-secret):
+// el navegador no agrega ese header por su cuenta, a diferencia de los headers de
+// identidad que inyecta el proxy de Argo CD.
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) || isProxiedPath(r) || consumedAuthorization(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity := identityFromRequest(r)
+		if !sameOriginRequest(r) {
+			slog.Info("Petición de otro origen rechazada", "component", "csrfProtect", "method", r.Method, "path", r.URL.Path,
+				"user", identity.User, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+			addCounter("pod_forward_csrf_rejected_total", map[string]string{"project": identity.Project}, 1)
+			http.Error(w, "Petición de otro origen rechazada", http.StatusForbidden)
+			return
+		}
+		if cookieAuthenticated(r) && !validCSRFToken(r, identity.User) {
+			slog.Info("Token CSRF ausente o inválido", "component", "csrfProtect", "method", r.Method, "path", r.URL.Path, "user", identity.User)
+			addCounter("pod_forward_csrf_rejected_total", map[string]string{"project": identity.Project}, 1)
+			http.Error(w, "Token CSRF inválido", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleCSRFToken entrega un token nuevo en el cuerpo y en la cookie de doble envío.
+// El cliente debe repetirlo en el header X-CSRF-Token en cada petición mutante.
+func handleCSRFToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	token, err := setCSRFCookie(w, r, identityFromRequest(r).User)
+	if err != nil {
+		slog.Error("Error al generar el token CSRF", "component", "csrf", "error", err)
+		http.Error(w, "Error al generar el token CSRF", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"token": token, "header": csrfHeader})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// modeArgoCD: el backend recibe las peticiones del proxy de extensiones de Argo CD,
+	// que ya autenticó al usuario y agrega los headers Argocd-*
+	modeArgoCD = "argocd"
+	// modeStandalone: el backend se sirve directamente detrás de un Ingress (Argo CD
+	// core / headless) y autentica por su cuenta
+	modeStandalone = "standalone"
+	// modeAuto detecta el modo según exista o no el Service del API server de Argo CD
+	modeAuto = "auto"
+)
+
+// resolveDeploymentMode devuelve el modo efectivo, detectándolo si es auto
+func resolveDeploymentMode(clientset *kubernetes.Clientset) string {
+	mode := strings.ToLower(appConfig.DeploymentMode)
+	switch mode {
+	case modeArgoCD, modeStandalone:
+		return mode
+	case modeAuto:
+	default:
+		slog.Warn("Modo desconocido, usando detección automática", "component", "deploymentMode", "mode", appConfig.DeploymentMode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := clientset.CoreV1().Services(appConfig.ArgoCDNamespace).Get(ctx, appConfig.ArgoCDServerService, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		slog.Info("Service de Argo CD encontrado", "component", "deploymentMode", "namespace", appConfig.ArgoCDNamespace, "service", appConfig.ArgoCDServerService, "mode", modeArgoCD)
+		return modeArgoCD
+	case apierrors.IsNotFound(err):
+		slog.Info("Sin API server de Argo CD (core mode)", "component", "deploymentMode", "mode", modeStandalone)
+		return modeStandalone
+	default:
+		slog.Warn("No se pudo detectar el modo", "component", "deploymentMode", "mode", modeArgoCD, "error", err)
+		return modeArgoCD
+	}
+}
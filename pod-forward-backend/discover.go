@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// maxDiscoverPods limita la cantidad de pods por respuesta de /discover
+const maxDiscoverPods = 500
+
+// discoverPort es un containerPort declarado; Allowed indica si la política
+// permite abrir un port-forward a ese puerto
+type discoverPort struct {
+	Name          string `json:"name,omitempty"`
+	ContainerPort int32  `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	Allowed       bool   `json:"allowed"`
+}
+
+// discoverContainer es un contenedor del pod con sus puertos TCP
+type discoverContainer struct {
+	Name  string         `json:"name"`
+	Ready bool           `json:"ready"`
+	Ports []discoverPort `json:"ports"`
+}
+
+// discoverPod es un pod candidato para el selector de la UI
+type discoverPod struct {
+	Name       string              `json:"name"`
+	Phase      string              `json:"phase"`
+	Ready      bool                `json:"ready"`
+	Reason     string              `json:"reason,omitempty"`
+	Containers []discoverContainer `json:"containers"`
+}
+
+// handleDiscover lista los pods del namespace (filtrados por selector) con sus
+// contenedores, puertos declarados y readiness, para que la extensión de la UI
+// muestre un selector en lugar de pedir los parámetros a mano
+func handleDiscover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	namespace, selector := query.Get("namespace"), query.Get("selector")
+	if namespace == "" {
+		http.Error(w, "Falta el parámetro namespace", http.StatusBadRequest)
+		return
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		http.Error(w, fmt.Sprintf("selector inválido %q: %v", selector, err), http.StatusBadRequest)
+		return
+	}
+
+	policy := getPolicy()
+	if policy != nil {
+		if err := policy.checkNamespace(namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	kube, status, err := resolveCluster(r.Context(), query.Get("cluster"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	// Sin nombre de pod el SubjectAccessReview vale para todo el namespace
+	if status, err := checkPortForwardAccess(r.Context(), kube.Clientset, identityFromRequest(r), namespace, ""); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	list, err := kube.Clientset.CoreV1().Pods(namespace).List(r.Context(), metav1.ListOptions{
+		LabelSelector: selector,
+		Limit:         maxDiscoverPods,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al listar pods: %v", err), lookupStatus(err))
+		return
+	}
+
+	pods := make([]discoverPod, 0, len(list.Items))
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		entry := discoverPod{
+			Name:       pod.Name,
+			Phase:      string(pod.Status.Phase),
+			Ready:      isPodReady(pod),
+			Containers: []discoverContainer{},
+		}
+		if !entry.Ready {
+			entry.Reason = podWaitingReason(pod)
+		}
+		ready := make(map[string]bool)
+		for _, status := range pod.Status.ContainerStatuses {
+			ready[status.Name] = status.Ready
+		}
+		for _, container := range pod.Spec.Containers {
+			item := discoverContainer{Name: container.Name, Ready: ready[container.Name], Ports: []discoverPort{}}
+			for _, port := range container.Ports {
+				// El port-forward de Kubernetes solo transporta TCP
+				if port.Protocol != "" && port.Protocol != corev1.ProtocolTCP {
+					continue
+				}
+				item.Ports = append(item.Ports, discoverPort{
+					Name:          port.Name,
+					ContainerPort: port.ContainerPort,
+					Protocol:      string(corev1.ProtocolTCP),
+					Allowed:       policy.checkTarget(namespace, int(port.ContainerPort)) == nil,
+				})
+			}
+			entry.Containers = append(entry.Containers, item)
+		}
+		pods = append(pods, entry)
+	}
+	// Los pods listos primero, después por nombre
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Ready != pods[j].Ready {
+			return pods[i].Ready
+		}
+		return pods[i].Name < pods[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cluster":   kube.Name,
+		"namespace": namespace,
+		"selector":  selector,
+		"pods":      pods,
+		"truncated": list.Continue != "",
+	})
+}
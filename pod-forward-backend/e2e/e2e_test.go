@@ -0,0 +1,362 @@
+//go:build e2e
+
+// Suite end-to-end contra el backend desplegado en kind. run.sh levanta el cluster,
+// despliega las aplicaciones de prueba y el backend, y ejecuta estos tests con:
+//
+//	E2E_BASE_URL     URL del backend (port-forward al Service)
+//	E2E_GRAFANA_POD  pod de Grafana (puerto 3000)
+//	E2E_WS_POD       pod del servidor WebSocket (puerto 8080)
+//	E2E_FILES_POD    pod del servidor de archivos (puerto 8080)
+//	E2E_IDLE_TTL     SESSION_IDLE_TTL del backend; solo con ella corre TestIdleExpiry
+package e2e
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// prefix es la ruta bajo la que Argo CD expone la extensión
+const prefix = "/api/v1/extensions/pod-forward"
+
+// client no sigue redirects: los tests verifican cada Location
+var client = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// env devuelve la variable de entorno o corta el test si no está definida
+func env(t *testing.T, key string) string {
+	t.Helper()
+	value := os.Getenv(key)
+	if value == "" {
+		t.Fatalf("%s no está definida: ejecutar la suite con make e2e", key)
+	}
+	return value
+}
+
+// get hace la petición y devuelve la respuesta con el cuerpo leído
+func get(t *testing.T, target string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: error al leer el cuerpo: %v", target, err)
+	}
+	return resp, string(body)
+}
+
+// openSession abre una sesión y devuelve el prefijo al que redirige (<prefix>/s/<id>),
+// bajo el que se sirve la aplicación del pod
+func openSession(t *testing.T, pod string, port int, extra string) string {
+	t.Helper()
+	query := url.Values{"namespace": {"e2e"}, "pod": {pod}, "port": {fmt.Sprint(port)}}.Encode()
+	if extra != "" {
+		query += "&" + extra
+	}
+	resp, _ := get(t, env(t, "E2E_BASE_URL")+prefix+"/forward?"+query, nil)
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location, prefix+"/s/") {
+		t.Fatalf("la creación de la sesión respondió %d con Location %q", resp.StatusCode, location)
+	}
+	return strings.TrimSuffix(location, "/")
+}
+
+// sessionID es el último segmento del prefijo de la sesión
+func sessionID(sessionPrefix string) string {
+	return sessionPrefix[strings.LastIndex(sessionPrefix, "/")+1:]
+}
+
+// listSessions devuelve las sesiones activas por ID
+func listSessions(t *testing.T) map[string]map[string]interface{} {
+	t.Helper()
+	resp, body := get(t, env(t, "E2E_BASE_URL")+"/sessions", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /sessions respondió %d: %s", resp.StatusCode, body)
+	}
+	var sessions []map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &sessions); err != nil {
+		t.Fatalf("respuesta de /sessions inválida: %v", err)
+	}
+	byID := make(map[string]map[string]interface{}, len(sessions))
+	for _, session := range sessions {
+		byID[fmt.Sprint(session["id"])] = session
+	}
+	return byID
+}
+
+// TestGrafanaLogin abre una sesión hacia Grafana: la aplicación redirige al login y
+// el Location queda bajo el prefijo de la sesión, donde la página se sirve sin
+// parámetros ni cookies
+func TestGrafanaLogin(t *testing.T) {
+	base := env(t, "E2E_BASE_URL")
+	grafana := openSession(t, env(t, "E2E_GRAFANA_POD"), 3000, "")
+
+	resp, _ := get(t, base+grafana+"/", nil)
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("se esperaba 302 de Grafana, se obtuvo %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(location, grafana+"/login") {
+		t.Fatalf("Location no reescrito: %q", location)
+	}
+
+	resp, body := get(t, base+location, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<html") {
+		t.Fatalf("la página de login respondió %d", resp.StatusCode)
+	}
+}
+
+// TestAssets carga un asset bajo el prefijo de la sesión y, sin el prefijo, por el
+// Referer de la página que lo pide
+func TestAssets(t *testing.T) {
+	base := env(t, "E2E_BASE_URL")
+	grafana := openSession(t, env(t, "E2E_GRAFANA_POD"), 3000, "")
+	const asset = "/public/img/grafana_icon.svg"
+
+	for name, request := range map[string]struct {
+		path   string
+		header http.Header
+	}{
+		"prefijo de la sesión": {grafana + asset, nil},
+		"Referer":              {prefix + asset, http.Header{"Referer": {base + grafana + "/login"}}},
+	} {
+		resp, _ := get(t, base+request.path, request.header)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/svg+xml") {
+			t.Errorf("%s: asset respondió %d (%s)", name, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+// TestArgoCDPathConventions verifica las rutas con y sin /extensions/pod-forward,
+// como las reenvían las distintas versiones del proxy de extensiones de Argo CD
+func TestArgoCDPathConventions(t *testing.T) {
+	base := env(t, "E2E_BASE_URL")
+	header := http.Header{"Argocd-Application-Name": {"argocd:guestbook"}, "Argocd-Project-Name": {"default"}}
+	for _, path := range []string{"/metrics", "/extensions/pod-forward/metrics"} {
+		if resp, _ := get(t, base+path, header); resp.StatusCode != http.StatusOK {
+			t.Errorf("ruta %s respondió %d", path, resp.StatusCode)
+		}
+	}
+}
+
+// TestAmbiguousFraming envía peticiones con framing ambiguo por una conexión TCP
+// propia: el backend las rechaza antes de llegar al pod
+func TestAmbiguousFraming(t *testing.T) {
+	base, err := url.Parse(env(t, "E2E_BASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, headers := range map[string]string{
+		"Connection con Content-Length": "Content-Length: 3\r\nConnection: keep-alive, Content-Length\r\n",
+		"TE no soportado":               "Transfer-Encoding: gzip, chunked\r\n",
+		"CL y TE":                       "Content-Length: 3\r\nTransfer-Encoding: chunked\r\n",
+		"CL duplicado":                  "Content-Length: 3\r\nContent-Length: 3\r\n",
+	} {
+		conn, err := net.DialTimeout("tcp", base.Host, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		fmt.Fprintf(conn, "POST %s/api/health HTTP/1.1\r\nHost: %s\r\n%s\r\nabc", prefix, base.Host, headers)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusNotImplemented {
+			t.Errorf("%s: se esperaba 400 o 501, se obtuvo %d", name, resp.StatusCode)
+		}
+	}
+}
+
+// TestWebSocketHandshake verifica que el subprotocolo y permessage-deflate se
+// negocian de punta a punta
+func TestWebSocketHandshake(t *testing.T) {
+	base, err := url.Parse(env(t, "E2E_BASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := openSession(t, env(t, "E2E_WS_POD"), 8080, "")
+
+	conn, err := net.DialTimeout("tcp", base.Host, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "GET %s/ws HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Protocol: vscode-remote, fallback\r\n"+
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n\r\n", ws, base.Host)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("el upgrade respondió %d", resp.StatusCode)
+	}
+	for header, want := range map[string]string{
+		"Sec-WebSocket-Protocol":   "vscode-remote",
+		"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits",
+		"Sec-WebSocket-Accept":     "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("%s = %q, se esperaba %q", header, got, want)
+		}
+	}
+}
+
+// TestWebSocketLiveUpdates recibe los mensajes que el pod envía por su cuenta
+// mientras la conexión sigue abierta, y verifica que la sesión cuenta el WebSocket
+func TestWebSocketLiveUpdates(t *testing.T) {
+	base, err := url.Parse(env(t, "E2E_BASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := openSession(t, env(t, "E2E_WS_POD"), 8080, "")
+
+	config, err := websocket.NewConfig("ws://"+base.Host+ws+"/live", base.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("error al abrir el WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 1; i <= 3; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var message string
+		if err := websocket.Message.Receive(conn, &message); err != nil {
+			t.Fatalf("mensaje %d no recibido: %v", i, err)
+		}
+		if want := fmt.Sprintf("update %d", i); message != want {
+			t.Fatalf("mensaje %d = %q, se esperaba %q", i, message, want)
+		}
+		if i == 1 {
+			session := listSessions(t)[sessionID(ws)]
+			if session == nil || session["webSockets"] != float64(1) {
+				t.Errorf("la sesión no cuenta el WebSocket abierto: %v", session)
+			}
+		}
+	}
+}
+
+// TestLargeDownload descarga 1GiB sin el límite de 30s y sin alterar el cuerpo
+func TestLargeDownload(t *testing.T) {
+	base := env(t, "E2E_BASE_URL")
+	files := openSession(t, env(t, "E2E_FILES_POD"), 8080, "raw=true")
+
+	download := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := download.Get(base + files + "/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("la descarga respondió %d", resp.StatusCode)
+	}
+	got := sha256.New()
+	n, err := io.Copy(got, resp.Body)
+	if err != nil {
+		t.Fatalf("descarga cortada después de %d bytes: %v", n, err)
+	}
+	const size = 1 << 30
+	if n != size {
+		t.Fatalf("se descargaron %d bytes, se esperaban %d", n, size)
+	}
+	want := sha256.New()
+	io.CopyN(want, zeros{}, size)
+	if hex.EncodeToString(got.Sum(nil)) != hex.EncodeToString(want.Sum(nil)) {
+		t.Fatal("el contenido descargado no coincide")
+	}
+}
+
+// zeros es un io.Reader de ceros, el contenido de big.bin
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// TestRangeRequests verifica 206 con Content-Range intacto, rangos por sufijo y 416
+func TestRangeRequests(t *testing.T) {
+	base := env(t, "E2E_BASE_URL")
+	files := openSession(t, env(t, "E2E_FILES_POD"), 8080, "raw=true")
+
+	cases := []struct {
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=10-14", http.StatusPartialContent, "01234", "bytes 10-14/1000"},
+		{"bytes=-3", http.StatusPartialContent, "789", "bytes 997-999/1000"},
+		{"bytes=5000-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */1000"},
+	}
+	for _, c := range cases {
+		resp, body := get(t, base+files+"/digits.txt", http.Header{"Range": {c.rangeHeader}})
+		if resp.StatusCode != c.status || body != c.body || resp.Header.Get("Content-Range") != c.contentRange {
+			t.Errorf("Range %s: %d %q (%s), se esperaba %d %q (%s)", c.rangeHeader,
+				resp.StatusCode, body, resp.Header.Get("Content-Range"), c.status, c.body, c.contentRange)
+		}
+	}
+}
+
+// TestIdleExpiry espera a que el backend cierre una sesión sin actividad: desaparece
+// de /sessions y su prefijo deja de responder
+func TestIdleExpiry(t *testing.T) {
+	if os.Getenv("E2E_IDLE_TTL") == "" {
+		t.Skip("E2E_IDLE_TTL no definida: el backend corre con el SESSION_IDLE_TTL por defecto")
+	}
+	ttl, err := time.ParseDuration(os.Getenv("E2E_IDLE_TTL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := env(t, "E2E_BASE_URL")
+	files := openSession(t, env(t, "E2E_FILES_POD"), 8080, "")
+	if resp, _ := get(t, base+files+"/digits.txt", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("la sesión recién abierta respondió %d", resp.StatusCode)
+	}
+
+	// El reaper revisa cada ttl/4: la sesión se cierra antes de ttl + ttl/4
+	deadline := time.Now().Add(2*ttl + 10*time.Second)
+	for listSessions(t)[sessionID(files)] != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("la sesión sigue activa %s después de su último uso", 2*ttl+10*time.Second)
+		}
+		time.Sleep(time.Second)
+	}
+	if resp, _ := get(t, base+files+"/digits.txt", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("el prefijo de la sesión cerrada respondió %d, se esperaba 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,101 @@
+#!/usr/bin/env bash
+# Suite end-to-end: levanta un cluster kind, despliega Grafana, un eco WebSocket,
+# un servidor de archivos y el backend, y ejecuta los tests Go de e2e/ (build tag
+# e2e) que validan los flujos completos a través del proxy.
+#
+# Variables:
+#   KEEP_CLUSTER=true  no borra el cluster al terminar (útil para depurar)
+#   KIND_CLUSTER       nombre del cluster (por defecto pod-forward-e2e)
+#   IDLE_TTL           SESSION_IDLE_TTL para el test de expiración (por defecto 15s)
+set -euo pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BACKEND_DIR="$(cd "${SCRIPT_DIR}/.." && pwd)"
+CHART_DIR="$(cd "${BACKEND_DIR}/../argocd/templates" && pwd)"
+KIND_CLUSTER="${KIND_CLUSTER:-pod-forward-e2e}"
+IMAGE="pod-forward-backend:e2e"
+LOCAL_PORT="${LOCAL_PORT:-18080}"
+BASE="http://localhost:${LOCAL_PORT}"
+IDLE_TTL="${IDLE_TTL:-15s}"
+
+PF_PID=""
+cleanup() {
+  [[ -n "${PF_PID}" ]] && kill "${PF_PID}" 2>/dev/null || true
+  if [[ "${KEEP_CLUSTER:-false}" != "true" ]]; then
+    kind delete cluster --name "${KIND_CLUSTER}" >/dev/null 2>&1 || true
+  fi
+}
+trap cleanup EXIT
+
+fail() {
+  echo "❌ $*" >&2
+  kubectl -n argocd logs deploy/pod-forward-backend --tail=50 >&2 || true
+  exit 1
+}
+
+pass() {
+  echo "✅ $*"
+}
+
+echo "==> Creando cluster kind ${KIND_CLUSTER}"
+kind create cluster --config "${SCRIPT_DIR}/kind-config.yaml" --name "${KIND_CLUSTER}" --wait 120s
+
+echo "==> Construyendo y cargando la imagen del backend"
+docker build -t "${IMAGE}" "${BACKEND_DIR}"
+kind load docker-image "${IMAGE}" --name "${KIND_CLUSTER}"
+
+echo "==> Desplegando las aplicaciones de prueba"
+kubectl apply -f "${SCRIPT_DIR}/grafana.yaml"
+kubectl apply -f "${SCRIPT_DIR}/ws-echo.yaml"
+kubectl apply -f "${SCRIPT_DIR}/files.yaml"
+
+echo "==> Desplegando el backend"
+kubectl create namespace argocd --dry-run=client -o yaml | kubectl apply -f -
+kubectl apply -f "${CHART_DIR}/podforwardpolicy-crd.yaml"
+# El manifiesto del backend es una plantilla del chart: los permisos opcionales
+# (podForwardBackend.rbac) se renderizan con sus valores por defecto
+helm template pod-forward "${CHART_DIR}/.." --show-only templates/pod-forward-backend.yaml | kubectl apply -f -
+kubectl -n argocd set image deploy/pod-forward-backend pod-forward-backend="${IMAGE}"
+kubectl -n argocd patch deploy/pod-forward-backend --type=json \
+  -p '[{"op":"replace","path":"/spec/template/spec/containers/0/imagePullPolicy","value":"IfNotPresent"}]'
+
+kubectl -n e2e rollout status deploy/grafana --timeout=180s
+kubectl -n e2e rollout status deploy/ws-echo --timeout=180s
+kubectl -n e2e rollout status deploy/files --timeout=180s
+kubectl -n argocd rollout status deploy/pod-forward-backend --timeout=180s
+
+GRAFANA_POD="$(kubectl -n e2e get pods -l app=grafana -o jsonpath='{.items[0].metadata.name}')"
+WS_POD="$(kubectl -n e2e get pods -l app=ws-echo -o jsonpath='{.items[0].metadata.name}')"
+FILES_POD="$(kubectl -n e2e get pods -l app=files -o jsonpath='{.items[0].metadata.name}')"
+
+# start_port_forward expone el Service del backend en LOCAL_PORT y espera /readyz
+start_port_forward() {
+  [[ -n "${PF_PID}" ]] && kill "${PF_PID}" 2>/dev/null || true
+  kubectl -n argocd port-forward svc/pod-forward-backend "${LOCAL_PORT}:8080" >/dev/null 2>&1 &
+  PF_PID=$!
+  for _ in $(seq 1 30); do
+    curl -fsS "${BASE}/readyz" >/dev/null 2>&1 && break
+    sleep 1
+  done
+  curl -fsS "${BASE}/readyz" >/dev/null || fail "el backend no está listo"
+}
+
+start_port_forward
+pass "backend listo"
+
+export E2E_BASE_URL="${BASE}"
+export E2E_GRAFANA_POD="${GRAFANA_POD}" E2E_WS_POD="${WS_POD}" E2E_FILES_POD="${FILES_POD}"
+
+echo "==> Ejecutando los tests e2e"
+(cd "${BACKEND_DIR}" && go test -tags e2e -count=1 -v ./e2e/) || fail "tests e2e fallidos"
+
+# La expiración por inactividad necesita un SESSION_IDLE_TTL corto, que cortaría la
+# descarga de 1GiB: se prueba aparte, con el backend reiniciado
+echo "==> Expiración de sesiones inactivas (SESSION_IDLE_TTL=${IDLE_TTL})"
+kubectl -n argocd set env deploy/pod-forward-backend SESSION_IDLE_TTL="${IDLE_TTL}"
+kubectl -n argocd rollout status deploy/pod-forward-backend --timeout=180s
+start_port_forward
+(cd "${BACKEND_DIR}" && E2E_IDLE_TTL="${IDLE_TTL}" go test -tags e2e -count=1 -v -run TestIdleExpiry ./e2e/) \
+  || fail "test de expiración fallido"
+
+echo "==> Suite e2e completada"
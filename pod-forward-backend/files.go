@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execInPod ejecuta un comando en el contenedor conectando stdin y stdout. Si el
+// comando falla se devuelve el stderr como parte del error.
+func execInPod(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, req.URL())
+	if err != nil {
+		return fmt.Errorf("error al crear el exec: %v", err)
+	}
+	var stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: &stderr})
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// countingReader cuenta los bytes leídos, para el registro de auditoría
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// fileTransferTarget son los parámetros comunes de /api/v2/files
+type fileTransferTarget struct {
+	Namespace string
+	Pod       string
+	Container string
+	Path      string
+}
+
+// parseFileTransferTarget lee y valida namespace, pod, container y path de la query
+func parseFileTransferTarget(r *http.Request) (fileTransferTarget, error) {
+	query := r.URL.Query()
+	target := fileTransferTarget{
+		Namespace: query.Get("namespace"),
+		Pod:       query.Get("pod"),
+		Container: query.Get("container"),
+		Path:      query.Get("path"),
+	}
+	if target.Namespace == "" || target.Pod == "" || target.Path == "" {
+		return target, fmt.Errorf("faltan parámetros requeridos: namespace, pod, path")
+	}
+	if !strings.HasPrefix(target.Path, "/") {
+		return target, fmt.Errorf("path debe ser absoluto")
+	}
+	target.Path = path.Clean(target.Path)
+	if target.Path == "/" {
+		return target, fmt.Errorf("path debe ser un archivo")
+	}
+	return target, nil
+}
+
+// auditFileTransfer registra cada transferencia en AUDIT_LOG con el usuario, el
+// destino y el resultado
+func auditFileTransfer(r *http.Request, identity RequestIdentity, kube *kubeTarget, action string, target fileTransferTarget, size int64, err error) {
+	record := auditRecord{
+		User:      identity.User,
+		Project:   identity.Project,
+		Cluster:   r.URL.Query().Get("cluster"),
+		Namespace: target.Namespace,
+		Pod:       target.Pod,
+		Container: target.Container,
+		Action:    "file-" + action,
+		Detail:    target.Path,
+		Bytes:     size,
+		kube:      kube,
+	}
+	if err != nil {
+		record.Result = err.Error()
+	}
+	auditAction(r, record)
+	addCounter("pod_forward_file_transfers_total", map[string]string{"project": identity.Project, "action": action}, 1)
+}
+
+// handleFiles descarga (GET) o sube (PUT) un archivo de un contenedor usando tar
+// por exec, igual que kubectl cp. Con archive=true la descarga devuelve el tar
+// completo, lo que permite bajar directorios. Con cluster= el pod está en otro
+// cluster de Argo CD.
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	target, err := parseFileTransferTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := getPolicy().checkFileTransfer(target.Namespace); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	kube, status, err := resolveCluster(r.Context(), r.URL.Query().Get("cluster"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if status, err := requireCapability(r.Context(), kube, capabilityExec); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// tar por exec corre con la identidad del backend: se exige el rol operator en
+	// el proyecto y que el usuario pueda hacer exec en el pod en Kubernetes
+	identity := identityFromRequest(r)
+	if identity.Role() < RoleOperator {
+		http.Error(w, "la transferencia de archivos requiere el rol operator o admin", http.StatusForbidden)
+		return
+	}
+	if status, err := checkResourceAccess(r.Context(), kube.Clientset, identity, "transferencia de archivos", authorizationv1.ResourceAttributes{
+		Namespace:   target.Namespace,
+		Verb:        "create",
+		Resource:    "pods",
+		Subresource: "exec",
+		Name:        target.Pod,
+	}); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		size, err := downloadFile(w, r, kube.Clientset, kube.Config, target)
+		auditFileTransfer(r, identity, kube, "download", target, size, err)
+		return
+	}
+	size, err := uploadFile(w, r, kube.Clientset, kube.Config, target)
+	auditFileTransfer(r, identity, kube, "upload", target, size, err)
+}
+
+// downloadFile copia el archivo del contenedor al cliente a medida que llega
+func downloadFile(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, config *rest.Config, target fileTransferTarget) (int64, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		command := []string{"tar", "cf", "-", "-C", path.Dir(target.Path), path.Base(target.Path)}
+		writer.CloseWithError(execInPod(ctx, clientset, config, target.Namespace, target.Pod, target.Container, command, nil, writer))
+	}()
+
+	if r.URL.Query().Get("archive") == "true" {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(target.Path) + ".tar"}))
+		body := &countingReader{reader: reader}
+		err := copyResponseBody(w, body, true)
+		return body.n, err
+	}
+
+	archive := tar.NewReader(reader)
+	header, err := archive.Next()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al leer el archivo del contenedor: %v", err), http.StatusBadGateway)
+		return 0, err
+	}
+	if header.Typeflag != tar.TypeReg {
+		err := fmt.Errorf("%s no es un archivo regular, usar archive=true para directorios", target.Path)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(header.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(target.Path)}))
+	body := &countingReader{reader: archive}
+	err = copyResponseBody(w, body, true)
+	return body.n, err
+}
+
+// uploadFile arma un tar con el cuerpo de la petición y lo extrae en el contenedor.
+// El tamaño tiene que conocerse de antemano porque va en el header del tar.
+func uploadFile(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, config *rest.Config, target fileTransferTarget) (int64, error) {
+	size := r.ContentLength
+	if size < 0 {
+		err := fmt.Errorf("se requiere Content-Length")
+		http.Error(w, err.Error(), http.StatusLengthRequired)
+		return 0, err
+	}
+	if size > appConfig.FileTransferMaxBytes {
+		err := fmt.Errorf("el archivo supera el máximo de %d bytes", appConfig.FileTransferMaxBytes)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return 0, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		archive := tar.NewWriter(writer)
+		err := archive.WriteHeader(&tar.Header{
+			Name:    path.Base(target.Path),
+			Mode:    0644,
+			Size:    size,
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = io.CopyN(archive, r.Body, size)
+		}
+		if err == nil {
+			err = archive.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	command := []string{"tar", "xf", "-", "-C", path.Dir(target.Path)}
+	if err := execInPod(r.Context(), clientset, config, target.Namespace, target.Pod, target.Container, command, reader, io.Discard); err != nil {
+		reader.CloseWithError(err)
+		http.Error(w, fmt.Sprintf("Error al escribir el archivo en el contenedor: %v", err), http.StatusBadGateway)
+		return 0, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return size, nil
+}
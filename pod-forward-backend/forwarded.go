@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// setForwardedHeaders indica al pod cómo lo ve el navegador: la IP del cliente, el
+// esquema y host públicos y el prefijo de la sesión. Aplicaciones como
+// Grafana o Keycloak arman con esto sus URLs absolutas y redirecciones sin que el
+// proxy tenga que reescribir el cuerpo.
+func setForwardedHeaders(header http.Header, r *http.Request, prefix string) {
+	if !appConfig.ForwardedHeaders {
+		return
+	}
+	// Argo CD y el ingress ya agregaron sus saltos; el cliente de este backend es
+	// el último
+	forwardedFor := remoteHost(r)
+	if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		forwardedFor = strings.Join(prior, ", ") + ", " + forwardedFor
+	}
+	header.Set("X-Forwarded-For", forwardedFor)
+
+	// El prefijo es solo una ruta y se envía siempre; el esquema y el host, solo si
+	// se conoce la URL pública (EXTERNAL_URL o un proxy de TRUSTED_PROXIES)
+	base, err := url.Parse(externalBaseURL(r))
+	if err != nil || base.Host == "" {
+		header.Set("X-Forwarded-Prefix", prefix)
+		return
+	}
+	header.Set("X-Forwarded-Proto", base.Scheme)
+	header.Set("X-Forwarded-Host", base.Host)
+	header.Set("X-Forwarded-Prefix", strings.TrimSuffix(base.Path, "/")+prefix)
+}
+
+// fromTrustedProxy indica si la petición llegó directamente de uno de los proxies de
+// TRUSTED_PROXIES; las entradas pueden ser IPs o rangos CIDR
+func fromTrustedProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddr(remoteHost(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range appConfig.TrustedProxies {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+		} else if trusted, err := netip.ParseAddr(entry); err == nil && trusted.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// secureRequest indica si el navegador llegó por HTTPS, para marcar las cookies del
+// backend como Secure: lo decide el esquema de EXTERNAL_URL y, sin él, TLS en la
+// conexión o el X-Forwarded-Proto de un proxy de TRUSTED_PROXIES. El header de un
+// cliente cualquiera no cuenta.
+func secureRequest(r *http.Request) bool {
+	if appConfig.ExternalURL != "" {
+		return strings.HasPrefix(strings.ToLower(appConfig.ExternalURL), "https://")
+	}
+	return r.TLS != nil || (fromTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// embeddingBlockReason devuelve un motivo legible si los headers de la respuesta
+// impiden que el navegador muestre la aplicación dentro de un iframe.
+// Como el proxy sirve la aplicación desde el mismo origen que Argo CD,
+// SAMEORIGIN y 'self' no bloquean el embebido.
+func embeddingBlockReason(header http.Header) string {
+	xfo := strings.ToUpper(strings.TrimSpace(header.Get("X-Frame-Options")))
+	if xfo == "DENY" || strings.HasPrefix(xfo, "ALLOW-FROM") {
+		return fmt.Sprintf("la aplicación envía el header X-Frame-Options: %s", xfo)
+	}
+
+	for _, csp := range header.Values("Content-Security-Policy") {
+		sources, ok := frameAncestors(csp)
+		if !ok {
+			continue
+		}
+		allowed := false
+		for _, source := range sources {
+			if source == "'self'" || source == "*" {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("la aplicación envía la directiva CSP frame-ancestors %s", strings.Join(sources, " "))
+		}
+	}
+	return ""
+}
+
+// frameAncestors extrae las fuentes de la directiva frame-ancestors de una política CSP
+func frameAncestors(policy string) ([]string, bool) {
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "frame-ancestors") {
+			continue
+		}
+		sources := make([]string, 0, len(fields)-1)
+		for _, source := range fields[1:] {
+			sources = append(sources, strings.ToLower(source))
+		}
+		return sources, true
+	}
+	return nil, false
+}
+
+// stripFrameHeaders elimina X-Frame-Options y la directiva frame-ancestors
+// para permitir que la aplicación se muestre dentro del panel de Argo CD
+func stripFrameHeaders(header http.Header) {
+	header.Del("X-Frame-Options")
+
+	policies := header.Values("Content-Security-Policy")
+	if len(policies) == 0 {
+		return
+	}
+	header.Del("Content-Security-Policy")
+	for _, policy := range policies {
+		var kept []string
+		for _, directive := range strings.Split(policy, ";") {
+			fields := strings.Fields(directive)
+			if len(fields) == 0 || strings.EqualFold(fields[0], "frame-ancestors") {
+				continue
+			}
+			kept = append(kept, strings.TrimSpace(directive))
+		}
+		if len(kept) > 0 {
+			header.Add("Content-Security-Policy", strings.Join(kept, "; "))
+		}
+	}
+}
+
+// isFramedNavigation indica si el navegador está cargando la petición dentro de un iframe
+func isFramedNavigation(r *http.Request) bool {
+	dest := r.Header.Get("Sec-Fetch-Dest")
+	return dest == "iframe" || dest == "frame"
+}
+
+// serveFrameBlockedPage explica por qué la aplicación no se puede mostrar en el panel
+// de Argo CD y ofrece abrirla en una pestaña nueva, donde los headers no aplican
+func serveFrameBlockedPage(w http.ResponseWriter, r *http.Request, reason string) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeBrandedPage(w, http.StatusOK, "La aplicación no se puede mostrar en el panel", fmt.Sprintf(`    <p>El navegador bloquea el embebido porque %s.</p>
+    <p>El port-forward está activo: puedes abrir la aplicación en una pestaña nueva.</p>
+    <p><a href="%s" target="_blank" rel="noopener noreferrer">Abrir en una pestaña nueva</a></p>
+    <p>Un administrador puede habilitar STRIP_FRAME_HEADERS en el backend para eliminar estos headers.</p>
+`, html.EscapeString(reason), html.EscapeString(r.URL.RequestURI())))
+}
+
+// ownPageHeaders son los headers de seguridad de las páginas que genera el propio
+// backend (página de forward, errores, página de embebido bloqueado)
+var ownPageHeaders = []string{"Content-Security-Policy", "X-Content-Type-Options", "X-Frame-Options", "Referrer-Policy"}
+
+// pageSecurityHeaders agrega CSP estricta, nosniff y frame-ancestors limitado al
+// origen de Argo CD a todas las respuestas. proxyHTTP los quita antes de copiar los
+// headers del pod, así que las respuestas de la aplicación conservan los suyos.
+func pageSecurityHeaders(next http.Handler) http.Handler {
+	ancestors := frameAncestorSources()
+	imgSrc := "'self'"
+	if logo := brandLogoSource(); logo != "" {
+		imgSrc += " " + logo
+	}
+	csp := "default-src 'none'; img-src " + imgSrc + "; style-src 'self' 'unsafe-inline'; base-uri 'none'; form-action 'self'; frame-ancestors " +
+		strings.Join(ancestors, " ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", csp)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "same-origin")
+		// X-Frame-Options solo expresa "mismo origen"; con más orígenes decide la CSP
+		if len(ancestors) == 1 && ancestors[0] == "'self'" {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// frameAncestorSources devuelve FRAME_ANCESTORS o, por defecto, 'self' más el
+// origen de EXTERNAL_URL
+func frameAncestorSources() []string {
+	if len(appConfig.FrameAncestors) > 0 {
+		return appConfig.FrameAncestors
+	}
+	sources := []string{"'self'"}
+	if parsed, err := url.Parse(appConfig.ExternalURL); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		sources = append(sources, parsed.Scheme+"://"+parsed.Host)
+	}
+	return sources
+}
+
+// clearOwnPageHeaders quita los headers de pageSecurityHeaders de una respuesta
+// que viene del pod
+func clearOwnPageHeaders(header http.Header) {
+	for _, name := range ownPageHeaders {
+		header.Del(name)
+	}
+}
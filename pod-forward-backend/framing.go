@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// maxHeaderBytes limita el tamaño de la línea de petición y los headers entrantes
+const maxHeaderBytes = 64 << 10
+
+// hopByHopHeaders son los headers que describen la conexión con el salto anterior
+// y nunca se reenvían (RFC 9110, sección 7.6.1). Range, If-Range, Accept-Ranges y
+// Content-Range son end-to-end: se copian sin cambios para que el pod responda 206.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// framingHeaders determinan dónde termina el cuerpo; que el cliente pida quitarlos
+// con Connection es un intento de que dos servidores interpreten distinto el mensaje
+var framingHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Host":              true,
+}
+
+// Content-Length, Transfer-Encoding y las extensiones de chunk se validan en la
+// conexión (framingListener), antes de que net/http normalice la petición.
+// rejectAmbiguousFraming agrega lo que queda a nivel de handler, y proxyHTTP
+// nunca reenvía los headers de framing: el cliente HTTP los regenera a partir del
+// cuerpo, así que el pod ve siempre un único framing consistente.
+
+// rejectAmbiguousFraming responde 400 a las peticiones cuyo framing puede
+// interpretarse de dos formas distintas entre el proxy de delante, el backend y el pod
+func rejectAmbiguousFraming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := ambiguousFraming(r); reason != "" {
+			slog.Info("Petición rechazada", "component", "rejectAmbiguousFraming", "reason", reason, "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Framing de la petición ambiguo", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ambiguousFraming devuelve el motivo por el que el framing es ambiguo o vacío
+func ambiguousFraming(r *http.Request) string {
+	if len(r.TransferEncoding) > 0 {
+		if len(r.TransferEncoding) != 1 || r.TransferEncoding[0] != "chunked" {
+			return "Transfer-Encoding no soportado"
+		}
+		if len(r.Header.Values("Content-Length")) > 0 {
+			return "Content-Length y Transfer-Encoding a la vez"
+		}
+	}
+	if len(r.Header.Values("Content-Length")) > 1 {
+		return "varios Content-Length"
+	}
+	for _, name := range connectionTokens(r.Header) {
+		if framingHeaders[name] {
+			return "Connection nombra el header " + name
+		}
+	}
+	for _, value := range r.Header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if framingHeaders[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] {
+				return "Trailer declara un header de framing"
+			}
+		}
+	}
+	return ""
+}
+
+// connectionTokens devuelve los headers nombrados en Connection, en forma canónica
+func connectionTokens(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, textproto.CanonicalMIMEHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// removeHopByHopHeaders quita los headers de conexión, incluidos los que nombra
+// Connection, antes de reenviar una petición o una respuesta
+func removeHopByHopHeaders(header http.Header) {
+	for _, name := range connectionTokens(header) {
+		header.Del(name)
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// net/http normaliza el framing antes de que la petición llegue a un handler: con
+// Transfer-Encoding: chunked descarta Content-Length, unifica los Content-Length
+// repetidos con el mismo valor, acepta "Chunked" y descarta las extensiones de chunk.
+// Un proxy de delante puede haber interpretado esos mensajes de otra forma, así que
+// framingListener lee los headers y los tamaños de chunk tal como llegaron por la
+// conexión y responde 400 antes de que net/http los vea.
+//
+// Solo se aplica a los listeners en texto plano: con TLS net/http necesita el
+// *tls.Conn para negociar HTTP/2 y leer los certificados de cliente.
+
+// framingError es el motivo por el que framingConn rechaza la petición
+type framingError struct {
+	reason string
+}
+
+func (e *framingError) Error() string { return "framing ambiguo: " + e.reason }
+
+// rejectedRequestLine reemplaza a la petición rechazada. Devolver el error en la
+// lectura no alcanza: entre peticiones de una conexión keep-alive net/http cierra
+// sin responder ante cualquier error de lectura.
+const rejectedRequestLine = "FRAMING-AMBIGUO\r\n\r\n"
+
+// framingListener envuelve las conexiones aceptadas en un framingConn
+type framingListener struct {
+	net.Listener
+}
+
+func (l framingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &framingConn{Conn: conn}, nil
+}
+
+// Estados de framingConn: qué parte del mensaje HTTP/1.1 viene a continuación
+const (
+	stateHeaders = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateTrailer
+	statePassthrough
+)
+
+// framingConn valida el framing de cada petición HTTP/1.1 de la conexión a medida
+// que net/http la lee. Los bytes se entregan sin cambios; solo se retienen hasta
+// completar la línea o el bloque de headers que hay que validar.
+type framingConn struct {
+	net.Conn
+
+	state     int
+	remaining int64  // Bytes del cuerpo o del chunk que faltan
+	upgrade   bool   // La petición actual pide Upgrade: después del cuerpo deja de ser HTTP/1.1
+	expect    bool   // La petición actual espera 100 Continue antes de enviar el cuerpo
+	held      []byte // Headers retenidos hasta validar el primer chunk
+	in        []byte // Leído de la conexión y todavía no procesado
+	block     []byte // Bloque de headers en curso
+	out       []byte // Validado y pendiente de entregar a net/http
+	err       error  // Framing rechazado: se devuelve en todas las lecturas siguientes
+}
+
+func (c *framingConn) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if err := c.advance(); err != nil {
+			if fe, ok := err.(*framingError); ok {
+				slog.Info("Petición rechazada", "component", "framingConn", "reason", fe.reason, "remote", c.RemoteAddr().String())
+				c.err = fe
+				if c.state == stateHeaders || c.held != nil {
+					// net/http todavía no vio la petición: en su lugar recibe una línea
+					// inválida, a la que responde 400 y cierra la conexión
+					c.out, c.held = []byte(rejectedRequestLine), nil
+				}
+				continue
+			}
+			// Errores de la conexión (timeouts que usa net/http, EOF): el estado se
+			// conserva para la próxima lectura
+			return 0, err
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// advance procesa la siguiente línea o porción del cuerpo y la deja en out si es válida
+func (c *framingConn) advance() error {
+	switch c.state {
+	case stateHeaders:
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			// Línea más larga que maxHeaderBytes: net/http responde 431
+			c.out, c.in, c.state = c.in, nil, statePassthrough
+			return nil
+		}
+		c.block = append(c.block, line...)
+		if !isBlankLine(line) {
+			if len(c.block) > maxHeaderBytes {
+				c.out, c.block, c.state = c.block, nil, statePassthrough
+			}
+			return nil
+		}
+		block := c.block
+		c.block = nil
+		if len(block) == len(line) {
+			// Líneas vacías antes de la petición (RFC 9112, sección 2.2)
+			c.out = block
+			return nil
+		}
+		if err := c.startMessage(block); err != nil {
+			return err
+		}
+		if c.state == stateChunkSize && !c.expect {
+			// Validar el primer chunk antes de entregar los headers, así una extensión
+			// de chunk se rechaza con 400 antes de que la petición llegue al handler.
+			// Las de los chunks siguientes cortan la conexión a mitad del cuerpo.
+			c.held = block
+			return nil
+		}
+		c.out = block
+		return nil
+
+	case stateBody, stateChunkData:
+		if len(c.in) == 0 {
+			if err := c.fill(); err != nil {
+				return err
+			}
+		}
+		n := int64(len(c.in))
+		if n > c.remaining {
+			n = c.remaining
+		}
+		c.out, c.in = c.in[:n], c.in[n:]
+		c.remaining -= n
+		if c.remaining == 0 {
+			if c.state == stateChunkData {
+				c.state = stateChunkSize
+			} else {
+				c.endMessage()
+			}
+		}
+		return nil
+
+	case stateChunkSize:
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		size, err := parseChunkSize(line)
+		if err != nil {
+			return err
+		}
+		c.out = append(c.held, line...)
+		c.held = nil
+		if size == 0 {
+			c.state = stateTrailer
+		} else {
+			// El CRLF del final del chunk se entrega con los datos
+			c.state, c.remaining = stateChunkData, size+2
+		}
+		return nil
+
+	case stateTrailer:
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			c.out, c.in, c.state = c.in, nil, statePassthrough
+			return nil
+		}
+		c.out = line
+		if isBlankLine(line) {
+			c.endMessage()
+		}
+		return nil
+	}
+
+	// statePassthrough
+	if len(c.in) == 0 {
+		if err := c.fill(); err != nil {
+			return err
+		}
+	}
+	c.out, c.in = c.in, nil
+	return nil
+}
+
+// startMessage valida el bloque de headers de una petición y decide cómo sigue la
+// conexión
+func (c *framingConn) startMessage(block []byte) error {
+	lines := strings.Split(strings.TrimRight(string(block), "\r\n"), "\n")
+	requestLine := strings.TrimSuffix(lines[0], "\r")
+	if requestLine == "PRI * HTTP/2.0" {
+		// Preámbulo de h2c con conocimiento previo: el resto es HTTP/2
+		c.state = statePassthrough
+		return nil
+	}
+
+	var contentLengths, transferEncodings []string
+	c.upgrade, c.expect = false, false
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" && (line[0] == ' ' || line[0] == '\t') {
+			return &framingError{"header plegado en varias líneas"}
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			// Header mal formado: net/http lo rechaza
+			continue
+		}
+		if name != strings.TrimRight(name, " \t") {
+			return &framingError{"espacio antes de los dos puntos en " + strings.TrimSpace(name)}
+		}
+		switch strings.ToLower(name) {
+		case "content-length":
+			contentLengths = append(contentLengths, value)
+		case "transfer-encoding":
+			transferEncodings = append(transferEncodings, value)
+		case "upgrade":
+			c.upgrade = true
+		case "expect":
+			c.expect = strings.EqualFold(strings.TrimSpace(value), "100-continue")
+		}
+	}
+
+	switch {
+	case len(transferEncodings) > 0 && len(contentLengths) > 0:
+		return &framingError{"Content-Length y Transfer-Encoding a la vez"}
+	case len(contentLengths) > 1:
+		return &framingError{"varios Content-Length"}
+	case len(transferEncodings) > 1:
+		return &framingError{"varios Transfer-Encoding"}
+	case len(transferEncodings) == 1:
+		if strings.Trim(transferEncodings[0], " \t") != "chunked" {
+			return &framingError{"Transfer-Encoding no soportado"}
+		}
+		if strings.HasSuffix(requestLine, " HTTP/1.0") {
+			return &framingError{"Transfer-Encoding en HTTP/1.0"}
+		}
+		c.state = stateChunkSize
+		return nil
+	case len(contentLengths) == 1:
+		value := strings.Trim(contentLengths[0], " \t")
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 || strings.TrimLeft(value, "0123456789") != "" {
+			return &framingError{"Content-Length inválido"}
+		}
+		if length > 0 {
+			c.state, c.remaining = stateBody, length
+			return nil
+		}
+	}
+	c.endMessage()
+	return nil
+}
+
+// endMessage pasa a la siguiente petición, o deja de validar si la conexión cambia
+// de protocolo (WebSocket, h2c). Si el pod rechaza el Upgrade la conexión queda sin
+// validar, pero net/http sigue rechazando lo que no puede interpretar.
+func (c *framingConn) endMessage() {
+	if c.upgrade {
+		c.state = statePassthrough
+		return
+	}
+	c.state = stateHeaders
+}
+
+// readLine devuelve la siguiente línea completa, con su fin de línea, o nil si supera
+// maxHeaderBytes sin terminar
+func (c *framingConn) readLine() ([]byte, error) {
+	for {
+		if i := bytes.IndexByte(c.in, '\n'); i >= 0 {
+			line := c.in[:i+1]
+			c.in = c.in[i+1:]
+			return line, nil
+		}
+		if len(c.in) > maxHeaderBytes {
+			return nil, nil
+		}
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fill agrega a in lo siguiente que llega por la conexión
+func (c *framingConn) fill() error {
+	buf := make([]byte, 32<<10)
+	n, err := c.Conn.Read(buf)
+	c.in = append(c.in, buf[:n]...)
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+// parseChunkSize valida la línea con el tamaño de un chunk. Las extensiones de chunk
+// (3;ext=valor) no las usa ningún cliente real y cada servidor las interpreta a su
+// manera, así que se rechazan.
+func parseChunkSize(line []byte) (int64, error) {
+	value := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+	if strings.ContainsRune(value, ';') {
+		return 0, &framingError{"extensiones de chunk"}
+	}
+	size, err := strconv.ParseInt(value, 16, 64)
+	if err != nil || size < 0 || size > 1<<40 || strings.ContainsAny(value, "+-") {
+		return 0, &framingError{"tamaño de chunk inválido"}
+	}
+	return size, nil
+}
+
+// isBlankLine indica si la línea es el fin de un bloque de headers
+func isBlankLine(line []byte) bool {
+	return len(line) == 1 || (len(line) == 2 && line[0] == '\r')
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFramingServer sirve un handler que devuelve el cuerpo recibido, detrás de
+// framingListener y rejectAmbiguousFraming como en listenAndServe
+func startFramingServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: rejectAmbiguousFraming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(w, r.Body)
+		})),
+		MaxHeaderBytes: maxHeaderBytes,
+	}
+	go server.Serve(framingListener{listener})
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// sendRaw envía la petición tal cual por una conexión TCP y devuelve las respuestas
+func sendRaw(t *testing.T, addr, raw string, responses int) []*http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	var result []*http.Response
+	for i := 0; i < responses; i++ {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("respuesta %d: %v", i+1, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		result = append(result, resp)
+	}
+	return result
+}
+
+func TestFramingRejected(t *testing.T) {
+	addr := startFramingServer(t)
+	const start = "POST /echo HTTP/1.1\r\nHost: backend\r\n"
+
+	tests := map[string]string{
+		"Content-Length y Transfer-Encoding": start + "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding y Content-Length": start + "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		"Content-Length duplicado":           start + "Content-Length: 3\r\nContent-Length: 3\r\n\r\nabc",
+		"Content-Length en conflicto":        start + "Content-Length: 3\r\nContent-Length: 4\r\n\r\nabcd",
+		"Content-Length con lista":           start + "Content-Length: 3, 3\r\n\r\nabc",
+		"Content-Length con signo":           start + "Content-Length: +3\r\n\r\nabc",
+		"Transfer-Encoding en mayúsculas":    start + "Transfer-Encoding: Chunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding con prefijo":      start + "Transfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding con lista":        start + "Transfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding duplicado":        start + "Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding con tab vertical": start + "Transfer-Encoding: \x0bchunked\r\n\r\n0\r\n\r\n",
+		"espacio antes de los dos puntos":    start + "Transfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding plegado":          start + "Transfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n",
+		"Transfer-Encoding en HTTP/1.0":      "POST /echo HTTP/1.0\r\nHost: backend\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"extensión de chunk":                 start + "Transfer-Encoding: chunked\r\n\r\n3;ext=1\r\nabc\r\n0\r\n\r\n",
+		"extensión de chunk sin valor":       start + "Transfer-Encoding: chunked\r\n\r\n3;\r\nabc\r\n0\r\n\r\n",
+		"extensión en el último chunk":       start + "Transfer-Encoding: chunked\r\n\r\n0;ext\r\n\r\n",
+		"tamaño de chunk con espacios":       start + "Transfer-Encoding: chunked\r\n\r\n3 \r\nabc\r\n0\r\n\r\n",
+		"Connection nombra Content-Length":   start + "Content-Length: 3\r\nConnection: keep-alive, Content-Length\r\n\r\nabc",
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := sendRaw(t, addr, raw, 1)[0]
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("status = %d, se esperaba 400", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestFramingAccepted(t *testing.T) {
+	addr := startFramingServer(t)
+
+	t.Run("chunked y Content-Length en la misma conexión", func(t *testing.T) {
+		raw := "POST /echo HTTP/1.1\r\nHost: backend\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+			"POST /echo HTTP/1.1\r\nHost: backend\r\nContent-Length: 3\r\n\r\nxyz" +
+			"GET /echo HTTP/1.1\r\nHost: backend\r\n\r\n"
+		responses := sendRaw(t, addr, raw, 3)
+		for i, want := range []string{"abcde", "xyz", ""} {
+			body, _ := io.ReadAll(responses[i].Body)
+			if responses[i].StatusCode != http.StatusOK || string(body) != want {
+				t.Errorf("respuesta %d = %d %q, se esperaba 200 %q", i+1, responses[i].StatusCode, body, want)
+			}
+		}
+	})
+
+	t.Run("petición válida seguida de una ambigua", func(t *testing.T) {
+		raw := "POST /echo HTTP/1.1\r\nHost: backend\r\nContent-Length: 2\r\n\r\nok" +
+			"POST /echo HTTP/1.1\r\nHost: backend\r\nContent-Length: 1\r\nContent-Length: 1\r\n\r\nx"
+		responses := sendRaw(t, addr, raw, 2)
+		if responses[0].StatusCode != http.StatusOK || responses[1].StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, %d, se esperaba 200, 400", responses[0].StatusCode, responses[1].StatusCode)
+		}
+	})
+
+	t.Run("Transfer-Encoding con espacios alrededor", func(t *testing.T) {
+		raw := "POST /echo HTTP/1.1\r\nHost: backend\r\nTransfer-Encoding:  chunked \r\n\r\n1\r\na\r\n0\r\n\r\n"
+		if resp := sendRaw(t, addr, raw, 1)[0]; resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, se esperaba 200", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// helperOwnerAnnotation guarda el pod del backend que creó el pod auxiliar, para que
+// la recolección de una réplica no borre los de otra que sigue viva
+const helperOwnerAnnotation = "pod-forward.argocd/owner"
+
+// backendInstance es el nombre del pod del backend (su hostname)
+var backendInstance, _ = os.Hostname()
+
+// backendStarted es el inicio del proceso; los pods auxiliares posteriores son de
+// sesiones que se están creando
+var backendStarted = time.Now()
+
+// Si el backend termina a mitad de una operación (OOM, kill, nodo caído), los pods
+// auxiliares que creó quedan corriendo hasta su ActiveDeadlineSeconds, y los que
+// vencieron quedan en fase Failed. Al iniciar, y una vez restauradas las sesiones
+// guardadas, se buscan los pods con la label de helper y se borran los que no
+// tienen una sesión viva que los use.
+
+// startHelperGC programa la recolección de pods auxiliares huérfanos
+func startHelperGC(clientset *kubernetes.Clientset) {
+	if !appConfig.HelpersEnabled || !appConfig.HelperGCEnabled {
+		return
+	}
+	goTask("helper-gc", func(ctx context.Context) error {
+		// Las sesiones guardadas todavía no restauradas también son dueñas de sus pods
+		if persistence != nil {
+			err := wait.PollUntilContextCancel(ctx, time.Second, true, func(context.Context) (bool, error) {
+				return restoreProgress.finished.Load(), nil
+			})
+			if err != nil {
+				return nil
+			}
+		}
+		collected, err := collectOrphanHelpers(ctx, clientset)
+		if err != nil {
+			return fmt.Errorf("error al buscar pods auxiliares huérfanos: %v", err)
+		}
+		slog.Info("Recolección inicial finalizada", "component", "helperGC", "deletedPods", collected)
+		return nil
+	})
+}
+
+// collectOrphanHelpers borra los pods auxiliares sin una sesión viva y devuelve
+// cuántos borró
+func collectOrphanHelpers(ctx context.Context, clientset *kubernetes.Clientset) (int, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: helperLabel})
+	if err != nil {
+		return 0, err
+	}
+	live := liveHelperPods()
+	collected := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if reason := orphanReason(ctx, clientset, pod, live); reason != "" {
+			slog.Info("Borrando pod auxiliar", "component", "helperGC", "namespace", pod.Namespace, "pod", pod.Name, "user", pod.Annotations[helperUserAnnotation], "reason", reason)
+			err := clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				slog.Error("Error al borrar el pod auxiliar", "component", "helperGC", "namespace", pod.Namespace, "pod", pod.Name, "error", err)
+				continue
+			}
+			addCounter("pod_forward_orphans_collected_total", map[string]string{"kind": "helper-pod"}, 1)
+			collected++
+		}
+	}
+	return collected, nil
+}
+
+// liveHelperPods devuelve los pods auxiliares (<namespace>/<pod>) de las sesiones abiertas
+func liveHelperPods() map[string]bool {
+	live := make(map[string]bool)
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, session := range activeSessions {
+		session.mu.Lock()
+		if session.Helper != "" {
+			live[session.Namespace+"/"+session.Pod] = true
+		}
+		session.mu.Unlock()
+	}
+	return live
+}
+
+// orphanReason indica por qué el pod auxiliar es huérfano, o vacío si hay que
+// conservarlo: lo usa una sesión de este backend, o lo creó otra réplica que sigue
+// corriendo
+func orphanReason(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod, live map[string]bool) string {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return fmt.Sprintf("terminó en fase %s", pod.Status.Phase)
+	}
+	if live[pod.Namespace+"/"+pod.Name] || pod.CreationTimestamp.Time.After(backendStarted) {
+		return ""
+	}
+	owner := pod.Annotations[helperOwnerAnnotation]
+	if owner == "" {
+		return "sin sesión y sin réplica dueña"
+	}
+	if owner == backendInstance {
+		return "sin sesión"
+	}
+	ownerPod, err := clientset.CoreV1().Pods(appConfig.PodNamespace).Get(ctx, owner, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && (ownerPod.Status.Phase == corev1.PodFailed || ownerPod.Status.Phase == corev1.PodSucceeded)) {
+		return fmt.Sprintf("la réplica %s que lo creó ya no corre", owner)
+	}
+	if err != nil {
+		// Ante la duda se conserva: ActiveDeadlineSeconds lo termina igual
+		slog.Warn("No se pudo consultar la réplica dueña del pod auxiliar", "component", "helperGC", "owner", owner, "namespace", pod.Namespace, "pod", pod.Name, "error", err)
+	}
+	return ""
+}
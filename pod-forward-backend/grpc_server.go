@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	podforwardv1 "pod-forward-backend/gen/podforward/v1"
+)
+
+// identityKey guarda en el contexto de la llamada gRPC la identidad autenticada
+type identityKey struct{}
+
+// grpcSessionServer implementa SessionService sobre las mismas funciones que la
+// API REST de sesiones
+type grpcSessionServer struct {
+	podforwardv1.UnimplementedSessionServiceServer
+	clientset *kubernetes.Clientset
+	config    *rest.Config
+}
+
+// grpcMetadataHeaders es la metadata que se presenta a los authenticators y a
+// externalBaseURL como headers HTTP; el resto de la metadata se ignora
+var grpcMetadataHeaders = []string{
+	"Authorization",
+	"Argocd-Username",
+	"Argocd-User-Groups",
+	"Argocd-Project-Name",
+	"Argocd-Application-Name",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+}
+
+// grpcAuthenticators deja de la cadena de AUTHENTICATORS los que prueban el origen
+// de la llamada: shared-secret, mtls con TLS en el listener y argocd con
+// ARGOCD_PROXY_SECRET. header y argocd sin secreto confían en metadata que puede
+// poner cualquier cliente que alcance el listener, incluso en loopback.
+func grpcAuthenticators(chain []Authenticator) ([]Authenticator, error) {
+	var trusted []Authenticator
+	for _, a := range chain {
+		switch {
+		case a.Name() == "shared-secret",
+			a.Name() == "mtls" && appConfig.TLSCertFile != "" && appConfig.TLSKeyFile != "",
+			a.Name() == "argocd" && appConfig.ArgoCDProxySecret != "":
+			trusted = append(trusted, a)
+		default:
+			slog.Warn("Authenticator no admitido en la API gRPC", "component", "grpc", "authenticator", a.Name())
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("GRPC_ADDR requiere shared-secret, mtls con TLS o argocd con ARGOCD_PROXY_SECRET en AUTHENTICATORS")
+	}
+	return trusted, nil
+}
+
+// startGRPCServer sirve la API gRPC en GRPC_ADDR. Igual que el listener de
+// administración, fuera de loopback requiere TLS y una autenticación distinta de
+// argocd; en cualquier dirección requiere un authenticator de grpcAuthenticators.
+func startGRPCServer(chain []Authenticator, clientset *kubernetes.Clientset, config *rest.Config) error {
+	if err := checkAdminListener("GRPC_ADDR", appConfig.GRPCAddr, chain); err != nil {
+		return err
+	}
+	chain, err := grpcAuthenticators(chain)
+	if err != nil {
+		return err
+	}
+	options := []grpc.ServerOption{grpc.UnaryInterceptor(grpcAuthInterceptor(chain))}
+	if appConfig.TLSCertFile != "" && appConfig.TLSKeyFile != "" {
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(appConfig.TLSCertFile, appConfig.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	listener, err := net.Listen("tcp", appConfig.GRPCAddr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(options...)
+	podforwardv1.RegisterSessionServiceServer(server, &grpcSessionServer{clientset: clientset, config: config})
+	serversMu.Lock()
+	grpcServer = server
+	serversMu.Unlock()
+	go func() {
+		slog.Info("API gRPC iniciada", "component", "grpc", "addr", appConfig.GRPCAddr)
+		// Después de GracefulStop, Serve vuelve sin error
+		if err := server.Serve(listener); err != nil {
+			fatal("Error en la API gRPC", "component", "grpc", "error", err)
+		}
+	}()
+	return nil
+}
+
+// grpcAuthInterceptor autentica cada llamada con los authenticators admitidos. La
+// metadata de grpcMetadataHeaders se presenta como headers HTTP y el certificado de
+// cliente TLS, si lo hay, como el de la petición.
+func grpcAuthInterceptor(chain []Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r := grpcHTTPRequest(ctx, info.FullMethod)
+		identity, _, err := identify(chain, r)
+		if errors.Is(err, errNoCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "autenticación requerida")
+		}
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "credenciales inválidas")
+		}
+		slog.Debug("Petición gRPC", "component", "grpc", "requestId", requestID(ctx), "method", info.FullMethod, "user", identity.User)
+		return handler(context.WithValue(ctx, identityKey{}, identity), req)
+	}
+}
+
+// grpcHTTPRequest arma una petición HTTP equivalente a la llamada gRPC para
+// reutilizar los authenticators y la construcción de URLs
+func grpcHTTPRequest(ctx context.Context, method string) *http.Request {
+	r := (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: method},
+		Header: http.Header{},
+	}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	if authority := md.Get(":authority"); len(authority) > 0 {
+		r.Host = authority[0]
+	}
+	headers := grpcMetadataHeaders
+	if appConfig.ArgoCDProxySecret != "" {
+		headers = append(headers[:len(headers):len(headers)], appConfig.ArgoCDProxySecretHeader)
+	}
+	for _, key := range headers {
+		for _, value := range md.Get(key) {
+			r.Header.Add(key, value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	return r
+}
+
+// grpcIdentity devuelve la identidad autenticada por el interceptor
+func grpcIdentity(ctx context.Context) RequestIdentity {
+	identity, _ := ctx.Value(identityKey{}).(RequestIdentity)
+	return identity
+}
+
+// grpcStatusCode traduce los códigos HTTP del servicio de sesiones a códigos gRPC
+func grpcStatusCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+// createSessionRequestFromProto convierte el mensaje gRPC al cuerpo de
+// POST /api/v2/sessions, para que las dos APIs creen sesiones con los mismos datos
+func createSessionRequestFromProto(req *podforwardv1.CreateSessionRequest) createSessionRequest {
+	return createSessionRequest{
+		Cluster:            req.Cluster,
+		Namespace:          req.Namespace,
+		Pod:                req.Pod,
+		Job:                req.Job,
+		CronJob:            req.CronJob,
+		Port:               int(req.Port),
+		PortName:           req.PortName,
+		Profile:            req.Profile,
+		Raw:                req.Raw,
+		Selector:           req.Selector,
+		Wait:               req.Wait,
+		WaitTimeout:        req.WaitTimeout,
+		ClientToken:        req.ClientToken,
+		Service:            req.Service,
+		Strategy:           req.Strategy,
+		Zone:               req.Zone,
+		Endpoint:           req.Endpoint,
+		Tunnels:            int(req.Tunnels),
+		Workload:           req.Workload,
+		Scheme:             req.Scheme,
+		InsecureSkipVerify: req.InsecureSkipVerify,
+		CASecret:           req.CaSecret,
+		Protocol:           req.Protocol,
+	}
+}
+
+func (s *grpcSessionServer) CreateSession(ctx context.Context, req *podforwardv1.CreateSessionRequest) (*podforwardv1.Session, error) {
+	body := createSessionRequestFromProto(req)
+	session, code, err := createSession(ctx, grpcIdentity(ctx), body, s.clientset, nil)
+	if err != nil {
+		return nil, status.Error(grpcStatusCode(code), err.Error())
+	}
+	return newSessionMessage(grpcHTTPRequest(ctx, ""), session), nil
+}
+
+func (s *grpcSessionServer) GetSession(ctx context.Context, req *podforwardv1.GetSessionRequest) (*podforwardv1.Session, error) {
+	session := visibleSession(grpcIdentity(ctx), req.Id)
+	if session == nil {
+		return nil, status.Errorf(codes.NotFound, "sesión no encontrada: %s", req.Id)
+	}
+	return newSessionMessage(grpcHTTPRequest(ctx, ""), session), nil
+}
+
+func (s *grpcSessionServer) ListSessions(ctx context.Context, req *podforwardv1.ListSessionsRequest) (*podforwardv1.ListSessionsResponse, error) {
+	r := grpcHTTPRequest(ctx, "")
+	resp := &podforwardv1.ListSessionsResponse{}
+	for _, session := range visibleSessions(grpcIdentity(ctx)) {
+		resp.Sessions = append(resp.Sessions, newSessionMessage(r, session))
+	}
+	return resp, nil
+}
+
+func (s *grpcSessionServer) DeleteSession(ctx context.Context, req *podforwardv1.DeleteSessionRequest) (*podforwardv1.DeleteSessionResponse, error) {
+	identity := grpcIdentity(ctx)
+	session := visibleSession(identity, req.Id)
+	if session == nil {
+		return nil, status.Errorf(codes.NotFound, "sesión no encontrada: %s", req.Id)
+	}
+	if !closeSessionAs(identity, session) {
+		return nil, status.Error(codes.PermissionDenied, "permisos insuficientes para cerrar la sesión")
+	}
+	return &podforwardv1.DeleteSessionResponse{}, nil
+}
+
+// newSessionMessage convierte la vista de la sesión al mensaje gRPC
+func newSessionMessage(r *http.Request, session *PortForwardSession) *podforwardv1.Session {
+	view := newCreatedSessionView(r, session)
+	return &podforwardv1.Session{
+		Id:         view.ID,
+		Project:    view.Project,
+		User:       view.User,
+		Namespace:  view.Namespace,
+		Pod:        view.Pod,
+		Port:       int32(view.Port),
+		LocalPort:  int32(view.LocalPort),
+		Profile:    view.Profile,
+		LastUsed:   view.LastUsed.Format(time.RFC3339),
+		WebSockets: int32(view.WebSockets),
+		Raw:        view.Raw,
+		Helper:     view.Helper,
+		Url:        view.URL,
+		State:      view.State,
+		Key:        view.Key,
+		BytesIn:    view.BytesIn,
+		BytesOut:   view.BytesOut,
+		Tunnels:    int32(view.Tunnels),
+	}
+}
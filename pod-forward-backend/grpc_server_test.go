@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	podforwardv1 "pod-forward-backend/gen/podforward/v1"
+)
+
+// TestCreateSessionRequestParity arma el mismo pedido por la API REST y por la gRPC,
+// con todos los campos de createSessionRequest con valor: las dos deben crear la
+// sesión con el mismo createSessionRequest. Un campo que falte en el proto hace
+// fallar protojson; uno que falte en el mapeo, la comparación
+func TestCreateSessionRequestParity(t *testing.T) {
+	var want createSessionRequest
+	value := reflect.ValueOf(&want).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString("valor-" + value.Type().Field(i).Name)
+		case reflect.Int:
+			field.SetInt(int64(i + 1))
+		case reflect.Bool:
+			field.SetBool(true)
+		default:
+			t.Fatalf("campo %s de tipo %s sin valor de prueba", value.Type().Field(i).Name, field.Kind())
+		}
+	}
+	payload, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, apiV2Prefix+"/sessions", bytes.NewReader(payload))
+	rest, err := decodeCreateSessionRequest(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var message podforwardv1.CreateSessionRequest
+	if err := protojson.Unmarshal(payload, &message); err != nil {
+		t.Fatalf("el proto no acepta el cuerpo REST: %v", err)
+	}
+	fromGRPC := createSessionRequestFromProto(&message)
+
+	if !reflect.DeepEqual(rest, want) {
+		t.Errorf("REST = %+v, se esperaba %+v", rest, want)
+	}
+	if !reflect.DeepEqual(fromGRPC, rest) {
+		t.Errorf("gRPC = %+v, REST = %+v", fromGRPC, rest)
+	}
+}
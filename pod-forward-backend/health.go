@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// /livez indica si el proceso responde y /readyz si puede atender sesiones. La
+// liveness no depende del API server: reiniciar el pod no arregla un API server
+// caído ni credenciales vencidas, y solo agregaría sesiones perdidas. La readiness
+// hace una llamada liviana al API server local, así una réplica sin acceso al
+// cluster sale del Service en lugar de responder errores al abrir sesiones.
+// /health se mantiene como alias de /livez. Los probes no se autentican y reciben
+// solo el código; el detalle de /readyz es para quien presenta credenciales.
+
+// livezLockTimeout es cuánto puede tardar en liberarse la tabla de sesiones antes
+// de considerar el proceso trabado
+const livezLockTimeout = 2 * time.Second
+
+// livezLockPoll es cada cuánto /livez y /readyz reintentan tomar la tabla de sesiones
+const livezLockPoll = 10 * time.Millisecond
+
+// readyzLockTimeout es cuánto espera /readyz la tabla de sesiones para el detalle;
+// si no la obtiene informa la tabla como bloqueada sin demorar el probe
+const readyzLockTimeout = 500 * time.Millisecond
+
+// readyzCheckTTL es cuánto se reutiliza el resultado del chequeo del API server,
+// para que varios probes seguidos no multipliquen las llamadas
+const readyzCheckTTL = 5 * time.Second
+
+// readyzLastStatus es el último estado de /readyz, para registrar el detalle solo
+// cuando cambia
+var readyzLastStatus struct {
+	mu     sync.Mutex
+	status string
+}
+
+// apiServerCheck es el último chequeo del API server local hecho por /readyz
+var apiServerCheck struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// handleLivez responde 503 si la tabla de sesiones quedó bloqueada; cualquier otra
+// falla se informa en /readyz. El lock se intenta tomar sin bloquear, así un probe
+// no deja una goroutine esperando si la tabla quedó trabada.
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	if !tryRLockSessions(livezLockTimeout) {
+		slog.Error("La tabla de sesiones está bloqueada", "component", "livez", "timeout", livezLockTimeout.String())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	sessionsMu.RUnlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// tryRLockSessions toma sessionsMu para lectura sin quedar bloqueado: reintenta
+// hasta timeout y devuelve false si no lo obtuvo
+func tryRLockSessions(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !sessionsMu.TryRLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(livezLockPoll)
+	}
+	return true
+}
+
+// checkAPIServer consulta /version en el API server local, o devuelve el resultado
+// del último chequeo si tiene menos de readyzCheckTTL. Falla tanto si el API
+// server no responde como si rechaza las credenciales del backend.
+func checkAPIServer() (time.Time, error) {
+	apiServerCheck.mu.Lock()
+	defer apiServerCheck.mu.Unlock()
+	if time.Since(apiServerCheck.checked) < readyzCheckTTL {
+		return apiServerCheck.checked, apiServerCheck.err
+	}
+	clustersMu.RLock()
+	clientset := clusterClients[localCluster]
+	clustersMu.RUnlock()
+	if clientset == nil {
+		// Todavía no se registró el cluster local: main sigue iniciando
+		return time.Time{}, nil
+	}
+	apiServerCheck.err = pingCluster(clientset)
+	apiServerCheck.checked = time.Now()
+	return apiServerCheck.checked, apiServerCheck.err
+}
+
+// sessionsReadiness resume el estado de las sesiones para /readyz
+func sessionsReadiness() map[string]interface{} {
+	state := map[string]interface{}{
+		"draining": draining.Load(),
+	}
+	if tryRLockSessions(readyzLockTimeout) {
+		active := len(activeSessions)
+		degraded := 0
+		for _, session := range activeSessions {
+			session.mu.Lock()
+			if session.Degraded {
+				degraded++
+			}
+			session.mu.Unlock()
+		}
+		sessionsMu.RUnlock()
+		state["active"], state["degraded"] = active, degraded
+	} else {
+		state["locked"] = true
+	}
+	if persistence != nil {
+		state["restore"] = map[string]interface{}{
+			"total":    restoreProgress.total.Load(),
+			"restored": restoreProgress.restored.Load(),
+			"failed":   restoreProgress.failed.Load(),
+			"finished": restoreProgress.finished.Load(),
+		}
+	}
+	return state
+}
+
+// handleReadyz responde 503 si el API server local no responde o rechaza las
+// credenciales, mientras se restauran las sesiones guardadas y durante el apagado.
+// A los probes sin credenciales responde solo el código; a un usuario autenticado
+// le devuelve además el detalle en JSON (clusters, capacidades, sesiones y
+// restauración, errores). ?verbose=1 sin credenciales responde 401. El detalle va
+// también al log cuando cambia el estado.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	verbose := identityFromRequest(r).User != ""
+	if !verbose && r.URL.Query().Get("verbose") == "1" {
+		http.Error(w, "El detalle de readiness requiere autenticación", http.StatusUnauthorized)
+		return
+	}
+
+	status := "ok"
+	details := map[string]interface{}{
+		"clusters":     clusterStatuses(),
+		"capabilities": capabilityStatuses(),
+		"sessions":     sessionsReadiness(),
+	}
+	// Un subsistema caído no saca la réplica de servicio: se informa como degradado
+	if failed := failedSubsystems(); len(failed) > 0 {
+		status = "degraded"
+		details["failedSubsystems"] = failed
+	}
+	checked, apiErr := checkAPIServer()
+	if !checked.IsZero() {
+		details["apiServerChecked"] = checked
+	}
+	if apiErr != nil {
+		details["apiServerError"] = apiErr.Error()
+	}
+
+	code := http.StatusOK
+	if shuttingDown.Load() {
+		status, code = "shutting down", http.StatusServiceUnavailable
+	} else if apiErr != nil {
+		// Sin API server no se pueden abrir sesiones nuevas ni reconectar las abiertas
+		status, code = "unavailable", http.StatusServiceUnavailable
+		details["reason"] = "API server de Kubernetes inalcanzable o credenciales rechazadas"
+	} else if reason := localUnsupported(); reason != "" {
+		// Con un cluster que no cumple el mínimo la réplica no recibe tráfico
+		status, code = "unsupported", http.StatusServiceUnavailable
+		details["reason"] = reason
+	} else if persistence != nil && !restoreProgress.finished.Load() {
+		status, code = "restoring", http.StatusServiceUnavailable
+	}
+
+	readyzLastStatus.mu.Lock()
+	changed := readyzLastStatus.status != status
+	readyzLastStatus.status = status
+	readyzLastStatus.mu.Unlock()
+	if changed {
+		level := slog.LevelInfo
+		if status != "ok" {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "Cambio de estado de readiness", "component", "readyz", "status", status, "details", details)
+	}
+	if !verbose {
+		w.WriteHeader(code)
+		return
+	}
+	details["status"] = status
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(details)
+}
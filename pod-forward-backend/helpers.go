@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// helperLabel identifica los pods auxiliares creados por el backend y su tipo
+	helperLabel = "pod-forward.argocd/helper"
+	// helperUserAnnotation guarda el usuario que pidió el pod auxiliar
+	helperUserAnnotation = "pod-forward.argocd/user"
+)
+
+// helperSpec describe un pod auxiliar de corta vida al que se abre una sesión
+type helperSpec struct {
+	Kind  string
+	Image string
+	Port  int
+	Args  []string
+	Env   []corev1.EnvVar
+}
+
+// helperClientset se usa para borrar los pods auxiliares al cerrar su sesión
+var helperClientset *kubernetes.Clientset
+
+// startHelpers guarda el cliente con el que se borran los pods auxiliares
+func startHelpers(clientset *kubernetes.Clientset) {
+	helperClientset = clientset
+}
+
+// dbHelpers son las interfaces web de base de datos disponibles, con el puerto en
+// que escucha cada imagen; la imagen se puede cambiar con HELPER_IMAGES
+var dbHelpers = map[string]helperSpec{
+	"pgweb":   {Kind: "pgweb", Image: "sosedoff/pgweb:0.15.0", Port: 8081, Args: []string{"--bind=0.0.0.0", "--listen=8081"}},
+	"adminer": {Kind: "adminer", Image: "adminer:4.8.1", Port: 8080},
+}
+
+// helperImage devuelve la imagen configurada en HELPER_IMAGES o la de por defecto
+func helperImage(spec helperSpec) string {
+	if image := appConfig.HelperImages[spec.Kind]; image != "" {
+		return image
+	}
+	return spec.Image
+}
+
+// launchHelperPod crea el pod auxiliar y espera a que esté listo. Si no arranca a
+// tiempo se borra. ActiveDeadlineSeconds limita su vida aunque el backend no llegue
+// a borrarlo.
+func launchHelperPod(ctx context.Context, clientset *kubernetes.Clientset, namespace string, identity RequestIdentity, spec helperSpec) (*corev1.Pod, error) {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	deadline := int64(appConfig.HelperMaxLifetime.Seconds())
+	noEscalation := false
+	automount := false
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("pod-forward-%s-%s", spec.Kind, hex.EncodeToString(suffix)),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "pod-forward-backend",
+				helperLabel:                    spec.Kind,
+			},
+			Annotations: map[string]string{
+				helperUserAnnotation:  identity.User,
+				helperOwnerAnnotation: backendInstance,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &deadline,
+			AutomountServiceAccountToken: &automount,
+			Containers: []corev1.Container{{
+				Name:  spec.Kind,
+				Image: helperImage(spec),
+				Args:  spec.Args,
+				Env:   spec.Env,
+				Ports: []corev1.ContainerPort{{ContainerPort: int32(spec.Port)}},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler:  corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(spec.Port)}},
+					PeriodSeconds: 1,
+				},
+				SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &noEscalation},
+			}},
+		},
+	}
+
+	created, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el pod auxiliar: %v", err)
+	}
+	slog.Info("Pod auxiliar creado", "component", "launchHelperPod", "namespace", namespace, "pod", created.Name, "user", identity.User, "image", helperImage(spec))
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, appConfig.HelperStartTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := clientset.CoreV1().Pods(namespace).Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if current.Status.Phase == corev1.PodFailed || current.Status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("el pod terminó en fase %s", current.Status.Phase)
+		}
+		for _, cond := range current.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		deleteHelperPod(namespace, created.Name)
+		return nil, fmt.Errorf("el pod auxiliar %s/%s no quedó listo: %v", namespace, created.Name, err)
+	}
+	return created, nil
+}
+
+// deleteHelperPod borra un pod auxiliar sin esperar a que sus contenedores terminen
+func deleteHelperPod(namespace, name string) {
+	if helperClientset == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := helperClientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		slog.Error("Error al borrar el pod auxiliar", "component", "deleteHelperPod", "namespace", namespace, "pod", name, "error", err)
+		return
+	}
+	slog.Info("Pod auxiliar borrado", "component", "deleteHelperPod", "namespace", namespace, "pod", name)
+}
+
+// authorizeHelperPod valida que el usuario pueda crear un pod auxiliar en el
+// namespace: el pod corre con la identidad del backend, así que se exige el rol
+// operator o admin, que el namespace esté en HELPER_NAMESPACES cuando no hay
+// política y que el usuario pueda crear pods ahí en Kubernetes. Devuelve el status
+// HTTP a responder.
+func authorizeHelperPod(ctx context.Context, clientset *kubernetes.Clientset, identity RequestIdentity, namespace string) (int, error) {
+	if identity.Role() < RoleOperator {
+		return http.StatusForbidden, fmt.Errorf("los pods auxiliares requieren el rol operator o admin")
+	}
+	if getPolicy() == nil && !matchesAny(appConfig.HelperNamespaces, namespace) {
+		return http.StatusForbidden, fmt.Errorf("el namespace %s no está en HELPER_NAMESPACES", namespace)
+	}
+	return checkResourceAccess(ctx, clientset, identity, "pod auxiliar", authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "create",
+		Resource:  "pods",
+	})
+}
+
+// openHelperSession lanza el pod auxiliar y abre una sesión hacia él. La sesión
+// queda marcada con Helper para que al cerrarse se borre el pod.
+func openHelperSession(ctx context.Context, identity RequestIdentity, namespace string, spec helperSpec, clientset *kubernetes.Clientset, config *rest.Config) (*PortForwardSession, int, error) {
+	if !appConfig.HelpersEnabled {
+		return nil, http.StatusNotFound, fmt.Errorf("los pods auxiliares están deshabilitados (HELPERS_ENABLED)")
+	}
+	if draining.Load() {
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("el backend está en modo drain y no acepta sesiones nuevas")
+	}
+	if status, err := authorizeHelperPod(ctx, clientset, identity, namespace); err != nil {
+		return nil, status, err
+	}
+	// Validar antes de crear el pod; openSession vuelve a validar con la clave final
+	if err := getPolicy().checkForward("", identity.Project, identity.User, namespace, spec.Port); err != nil {
+		return nil, http.StatusForbidden, fmt.Errorf("port-forward denegado: %v", err)
+	}
+
+	pod, err := launchHelperPod(ctx, clientset, namespace, identity, spec)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	// Los pods auxiliares se crean siempre en el cluster local
+	session, status, err := openSession(ctx, identity, &kubeTarget{Clientset: clientset, Config: config}, namespace, pod.Name, spec.Port)
+	if err != nil {
+		deleteHelperPod(namespace, pod.Name)
+		return nil, status, err
+	}
+	session.mu.Lock()
+	session.Helper = spec.Kind
+	session.mu.Unlock()
+	persistSessions()
+	return session, http.StatusCreated, nil
+}
+
+// dbHelperRequest es el cuerpo de POST /api/v2/helpers/db
+type dbHelperRequest struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Port      int    `json:"port"`
+	Kind      string `json:"kind"`
+	// SecretName/SecretKey apuntan a un Secret del namespace con la URL de conexión
+	// (solo pgweb); el pod la lee directamente y el backend nunca la ve
+	SecretName string `json:"secretName,omitempty"`
+	SecretKey  string `json:"secretKey,omitempty"`
+	// User, Database y SSLMode arman la URL de pgweb hacia el Service cuando no hay
+	// Secret; una base con contraseña necesita el Secret
+	User     string `json:"user,omitempty"`
+	Database string `json:"database,omitempty"`
+	SSLMode  string `json:"sslMode,omitempty"`
+}
+
+// pgwebURL arma la URL de conexión de pgweb hacia el Service de la base de datos
+func pgwebURL(host string, body dbHelperRequest) string {
+	user, database, sslMode := body.User, body.Database, body.SSLMode
+	if user == "" {
+		user = "postgres"
+	}
+	if database == "" {
+		database = "postgres"
+	}
+	// Como el formulario de pgweb: el driver de pgweb no admite prefer
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	target := url.URL{
+		Scheme:   "postgres",
+		User:     url.User(user),
+		Host:     net.JoinHostPort(host, strconv.Itoa(body.Port)),
+		Path:     "/" + database,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	return target.String()
+}
+
+// handleDBHelper lanza una interfaz web de base de datos apuntando a un Service
+// de la aplicación y devuelve la sesión hacia ella
+func handleDBHelper(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, config *rest.Config) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body dbHelperRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Kind == "" {
+		body.Kind = "pgweb"
+	}
+	spec, ok := dbHelpers[body.Kind]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Tipo de helper desconocido: %s", body.Kind), http.StatusBadRequest)
+		return
+	}
+	if body.Namespace == "" || body.Service == "" || body.Port <= 0 || body.Port > 65535 {
+		http.Error(w, "Faltan parámetros requeridos: namespace, service, port", http.StatusBadRequest)
+		return
+	}
+
+	// El Service de la base de datos también tiene que estar permitido por la política
+	if err := getPolicy().checkTarget(body.Namespace, body.Port); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	identity := identityFromRequest(r)
+	host := body.Service + "." + body.Namespace + ".svc"
+	switch spec.Kind {
+	case "pgweb":
+		// Sin Secret pgweb conecta al Service con User y Database; con Secret usa la
+		// URL completa del Secret
+		spec.Env = []corev1.EnvVar{{Name: "DATABASE_URL", Value: pgwebURL(host, body)}}
+		if body.SecretName != "" && body.SecretKey != "" {
+			// El pod lee el Secret con la identidad del backend: el usuario tiene que
+			// poder leerlo por su cuenta
+			status, err := checkResourceAccess(r.Context(), clientset, identity, "pod auxiliar", authorizationv1.ResourceAttributes{
+				Namespace: body.Namespace,
+				Verb:      "get",
+				Resource:  "secrets",
+				Name:      body.SecretName,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			spec.Env = []corev1.EnvVar{{
+				Name: "DATABASE_URL",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: body.SecretName},
+					Key:                  body.SecretKey,
+				}},
+			}}
+		}
+	case "adminer":
+		spec.Env = []corev1.EnvVar{{Name: "ADMINER_DEFAULT_SERVER", Value: host + ":" + strconv.Itoa(body.Port)}}
+	}
+
+	session, status, err := openHelperSession(r.Context(), identity, body.Namespace, spec, clientset, config)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	slog.Info("Helper listo", "component", "handleDBHelper", "kind", spec.Kind, "user", identity.User, "host", host, "port", body.Port, "session", session.ID)
+	writeCreatedSession(w, r, session, http.StatusCreated)
+}
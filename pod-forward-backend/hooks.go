@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// sessionOpening es la sesión que se está por abrir, tal como la ven los hooks
+// pre-create
+type sessionOpening struct {
+	Identity  RequestIdentity
+	Kube      *kubeTarget
+	Key       string
+	Namespace string
+	Pod       string
+	Port      int
+}
+
+// preCreateHook decide si se puede abrir la sesión antes de crear el port-forward;
+// junto al error devuelve el código HTTP a responder
+type preCreateHook func(ctx context.Context, opening sessionOpening) (int, error)
+
+// postCreateHook se ejecuta una vez por sesión, cuando el port-forward quedó registrado
+type postCreateHook func(ctx context.Context, session *PortForwardSession)
+
+// preProxyHook se ejecuta antes de enviar cada petición al pod (HTTP o handshake
+// WebSocket) y puede modificar upstream. Devuelve false si ya respondió al cliente
+// y la petición no se envía.
+type preProxyHook func(w http.ResponseWriter, r *http.Request, upstream *http.Request, session *PortForwardSession) bool
+
+// namedHook es un hook registrado; el nombre aparece en los logs
+type namedHook[T any] struct {
+	name string
+	fn   T
+}
+
+var (
+	hooksMu         sync.RWMutex
+	preCreateHooks  []namedHook[preCreateHook]
+	postCreateHooks []namedHook[postCreateHook]
+	preProxyHooks   []namedHook[preProxyHook]
+)
+
+// Hooks propios del backend, en el orden en que se ejecutan. Las funcionalidades
+// nuevas se registran igual en su propio archivo en lugar de agregarse a
+// openSession o proxyHTTP.
+func init() {
+	registerPreCreateHook("policy", policyPreCreateHook)
+	registerPreCreateHook("access-review", accessReviewPreCreateHook)
+	registerPostCreateHook("audit", auditPostCreateHook)
+	registerPreProxyHook("quota", quotaPreProxyHook)
+	registerPreProxyHook("credentials", credentialsPreProxyHook)
+}
+
+// registerPreCreateHook agrega un hook al final de los pre-create
+func registerPreCreateHook(name string, hook preCreateHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	preCreateHooks = append(preCreateHooks, namedHook[preCreateHook]{name, hook})
+}
+
+// registerPostCreateHook agrega un hook al final de los post-create
+func registerPostCreateHook(name string, hook postCreateHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	postCreateHooks = append(postCreateHooks, namedHook[postCreateHook]{name, hook})
+}
+
+// registerPreProxyHook agrega un hook al final de los pre-proxy
+func registerPreProxyHook(name string, hook preProxyHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	preProxyHooks = append(preProxyHooks, namedHook[preProxyHook]{name, hook})
+}
+
+// runPreCreateHooks ejecuta los pre-create en orden; el primero que rechaza la
+// sesión corta la cadena
+func runPreCreateHooks(ctx context.Context, opening sessionOpening) (int, error) {
+	hooksMu.RLock()
+	hooks := preCreateHooks
+	hooksMu.RUnlock()
+	for _, hook := range hooks {
+		if status, err := hook.fn(ctx, opening); err != nil {
+			slog.Info("Hook pre-create rechazó la sesión", "component", "hooks", "hook", hook.name, "key", opening.Key, "error", err)
+			return status, err
+		}
+	}
+	return 0, nil
+}
+
+// runPostCreateHooks ejecuta los post-create en orden
+func runPostCreateHooks(ctx context.Context, session *PortForwardSession) {
+	hooksMu.RLock()
+	hooks := postCreateHooks
+	hooksMu.RUnlock()
+	for _, hook := range hooks {
+		hook.fn(ctx, session)
+	}
+}
+
+// runPreProxyHooks ejecuta los pre-proxy en orden y devuelve false si alguno ya
+// respondió al cliente
+func runPreProxyHooks(w http.ResponseWriter, r *http.Request, upstream *http.Request, session *PortForwardSession) bool {
+	hooksMu.RLock()
+	hooks := preProxyHooks
+	hooksMu.RUnlock()
+	for _, hook := range hooks {
+		if !hook.fn(w, r, upstream, session) {
+			slog.Debug("Hook pre-proxy respondió la petición", "component", "hooks", "hook", hook.name, "method", r.Method, "path", r.URL.Path, "session", session.ID)
+			return false
+		}
+	}
+	return true
+}
+
+// policyPreCreateHook valida la sesión contra la PodForwardPolicy vigente
+func policyPreCreateHook(ctx context.Context, opening sessionOpening) (int, error) {
+	identity := opening.Identity
+	if err := getPolicy().checkForward(opening.Key, identity.Project, identity.User, opening.Namespace, opening.Port); err != nil {
+		return http.StatusForbidden, fmt.Errorf("port-forward denegado: %v", err)
+	}
+	return 0, nil
+}
+
+// accessReviewPreCreateHook valida que el propio usuario tenga permiso de
+// port-forward en Kubernetes
+func accessReviewPreCreateHook(ctx context.Context, opening sessionOpening) (int, error) {
+	return checkPortForwardAccess(ctx, opening.Kube.Clientset, opening.Identity, opening.Namespace, opening.Pod)
+}
+
+// auditPostCreateHook registra la apertura de la sesión en la auditoría
+func auditPostCreateHook(ctx context.Context, session *PortForwardSession) {
+	auditAction(nil, sessionAuditRecord(session, "session-create"))
+}
+
+// quotaPreProxyHook muestra la página de cuota cuando la sesión superó la cuota
+// de bytes de la política y se está cerrando
+func quotaPreProxyHook(w http.ResponseWriter, r *http.Request, upstream *http.Request, session *PortForwardSession) bool {
+	if !session.overByteQuota() {
+		return true
+	}
+	exceeded, _ := quotaExceededFor(session.ID)
+	serveQuotaExceededPage(w, exceeded)
+	return false
+}
+
+// credentialsPreProxyHook agrega las credenciales configuradas en la política para el pod
+func credentialsPreProxyHook(w http.ResponseWriter, r *http.Request, upstream *http.Request, session *PortForwardSession) bool {
+	getPolicy().injectCredentials(upstream.Header, session.Namespace, session.Pod)
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+const (
+	// htmlRewriteTail son los bytes que se retienen entre lecturas para que un
+	// atributo partido entre dos bloques se reescriba igual
+	htmlRewriteTail = 1 << 10
+	// maxHeadBytes es cuánto se espera el cierre de <head> para decidir si hace falta
+	// declarar el favicon
+	maxHeadBytes = 64 << 10
+)
+
+// headClose encuentra el cierre de </head>
+var headClose = regexp.MustCompile(`(?i)</head\s*>`)
+
+// absoluteURLRewriter reescribe las rutas absolutas de href, src y action a medida
+// que el HTML pasa por el proxy, sin cargar el documento completo en memoria
+type absoluteURLRewriter struct {
+	src      io.Reader
+	prefix   string
+	buf      []byte
+	pending  []byte
+	out      bytes.Buffer
+	headDone bool
+	eof      bool
+}
+
+func newAbsoluteURLRewriter(src io.Reader, prefix string) *absoluteURLRewriter {
+	return &absoluteURLRewriter{src: src, prefix: prefix, buf: make([]byte, 32<<10)}
+}
+
+func (r *absoluteURLRewriter) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.eof {
+			if len(r.pending) == 0 {
+				return 0, io.EOF
+			}
+			r.flush(len(r.pending))
+			continue
+		}
+		n, err := r.src.Read(r.buf)
+		r.pending = append(r.pending, r.buf[:n]...)
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+
+		// El favicon se decide con el <head> completo
+		if !r.headDone {
+			if !r.eof && len(r.pending) < maxHeadBytes && !headClose.Match(r.pending) {
+				continue
+			}
+			r.pending = addFaviconLink(r.pending, r.prefix)
+			r.headDone = true
+		}
+		if !r.eof && len(r.pending) > htmlRewriteTail {
+			r.flush(len(r.pending) - htmlRewriteTail)
+		}
+	}
+	return r.out.Read(p)
+}
+
+// flush reescribe y emite pending hasta cut. Un atributo que empieza antes de cut
+// pero cuya ruta todavía no se puede comparar con el prefijo queda para la próxima vuelta.
+func (r *absoluteURLRewriter) flush(cut int) {
+	if !r.eof {
+		for _, match := range absoluteURLAttr.FindAllIndex(r.pending, -1) {
+			if match[0] < cut && match[1]+len(extensionBasePath)+1 > cut {
+				cut = match[0]
+				break
+			}
+		}
+	}
+	r.out.Write(rewriteAbsoluteURLs(r.pending[:cut], r.prefix))
+	r.pending = append([]byte(nil), r.pending[cut:]...)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// gRPC nativo solo funciona sobre HTTP/2, y algunas aplicaciones solo sirven h2c
+// (HTTP/2 sin TLS). Esas peticiones van al pod por un transporte HTTP/2 propio de
+// la sesión: las gRPC siempre y todas las de una sesión creada con protocol=h2.
+// Los trailers (grpc-status, grpc-message) los reenvía el ReverseProxy. gRPC-Web
+// funciona sobre HTTP/1.1 y no necesita nada de esto.
+
+// isGRPC indica si la petición es gRPC nativo (no gRPC-Web)
+func isGRPC(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web")
+}
+
+// parseProtocol valida el parámetro protocol: vacío o http1 es HTTP/1.1, h2 es
+// HTTP/2 (h2c con scheme http)
+func parseProtocol(protocol string) (bool, error) {
+	switch protocol {
+	case "", "http1":
+		return false, nil
+	case "h2", "h2c":
+		return true, nil
+	}
+	return false, fmt.Errorf("protocol inválido %q: debe ser http1 o h2", protocol)
+}
+
+// protocolName es el protocol de la sesión que muestra la API
+func protocolName(http2 bool) string {
+	if http2 {
+		return "h2"
+	}
+	return ""
+}
+
+// setHTTP2 aplica protocol=h2 al crear o reutilizar la sesión; como raw, una
+// petición sin el parámetro no vuelve la sesión a HTTP/1.1
+func (s *PortForwardSession) setHTTP2(enabled bool) {
+	if !enabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.HTTP2 = true
+}
+
+// usesHTTP2 indica si la petición va al pod por HTTP/2
+func (s *PortForwardSession) usesHTTP2(r *http.Request) bool {
+	if isGRPC(r) {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.HTTP2
+}
+
+// http2Transport devuelve el transporte HTTP/2 de la sesión. Con scheme http habla
+// h2c sobre la conexión del túnel; con https negocia h2 por ALPN. Todas las
+// peticiones comparten una conexión, así que ocupan un solo stream del túnel.
+func (s *PortForwardSession) http2Transport() http.RoundTripper {
+	s.transport.h2once.Do(func() {
+		s.transport.h2 = &http2.Transport{
+			AllowHTTP: true,
+			// gRPC comprime sus mensajes; el cuerpo pasa tal cual
+			DisableCompression: true,
+			// Detectar una conexión muerta (túnel cortado) aunque no haya peticiones
+			ReadIdleTimeout: appConfig.UpstreamIdleTimeout,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				if s.upstreamScheme() == "https" {
+					return s.dialUpstreamTLSWith(ctx, network, addr, []string{http2.NextProtoTLS})
+				}
+				return dialUpstream(ctx, network, addr)
+			},
+		}
+	})
+	return s.transport.h2
+}
+
+// serveH2C agrega HTTP/2 sin TLS (h2c) al handler si H2C_ENABLED está activo, para
+// que los clientes gRPC que llegan directo al backend (sin pasar por el proxy de
+// Argo CD, que habla HTTP/1.1) puedan usar la extensión. Con TLS el servidor ya
+// negocia HTTP/2 por ALPN.
+func serveH2C(handler http.Handler) http.Handler {
+	if !appConfig.H2CEnabled {
+		return handler
+	}
+	return h2c.NewHandler(handler, &http2.Server{})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// maxClientTokenLength limita el largo de los clientToken
+const maxClientTokenLength = 128
+
+var (
+	// pendingTokens serializa las creaciones concurrentes con el mismo clientToken
+	pendingTokens   = make(map[string]chan struct{})
+	pendingTokensMu sync.Mutex
+)
+
+// requestFingerprint resume el destino y las opciones de la petición para detectar
+// un clientToken reutilizado con otra petición. Las opciones de espera no cuentan.
+func requestFingerprint(body createSessionRequest) string {
+	body.ClientToken, body.Wait, body.WaitTimeout = "", false, ""
+	data, _ := json.Marshal(body)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// acquireClientToken espera a que termine otra creación con el mismo clientToken
+// del mismo usuario y devuelve la función que lo libera
+func acquireClientToken(identity RequestIdentity, token string) func() {
+	key := identity.Project + "|" + identity.User + "|" + token
+	for {
+		pendingTokensMu.Lock()
+		pending, busy := pendingTokens[key]
+		if !busy {
+			done := make(chan struct{})
+			pendingTokens[key] = done
+			pendingTokensMu.Unlock()
+			return func() {
+				pendingTokensMu.Lock()
+				delete(pendingTokens, key)
+				pendingTokensMu.Unlock()
+				close(done)
+			}
+		}
+		pendingTokensMu.Unlock()
+		<-pending
+	}
+}
+
+// findTokenSession busca la sesión activa que el usuario creó con ese clientToken.
+// Devuelve 409 si el token se usó con una petición distinta.
+func findTokenSession(identity RequestIdentity, token, fingerprint string) (*PortForwardSession, int, error) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, sess := range activeSessions {
+		if sess.Project != identity.Project || sess.User != identity.User {
+			continue
+		}
+		sess.mu.Lock()
+		used, ok := sess.ClientTokens[token]
+		sess.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if used != fingerprint {
+			return nil, http.StatusConflict, fmt.Errorf("el clientToken ya se usó con una petición distinta")
+		}
+		return sess, http.StatusOK, nil
+	}
+	return nil, http.StatusOK, nil
+}
+
+// rememberClientToken asocia el clientToken a la sesión creada
+func rememberClientToken(session *PortForwardSession, token, fingerprint string) {
+	session.mu.Lock()
+	if session.ClientTokens == nil {
+		session.ClientTokens = make(map[string]string)
+	}
+	session.ClientTokens[token] = fingerprint
+	session.mu.Unlock()
+	persistSessions()
+}
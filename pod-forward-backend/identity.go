@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Role es el nivel de acceso de un usuario a los endpoints de gestión
+type Role int
+
+const (
+	// RoleViewer ve solo sus propias sesiones y no puede cerrarlas
+	RoleViewer Role = iota
+	// RoleOperator ve y cierra las sesiones de su proyecto
+	RoleOperator
+	// RoleAdmin gestiona todas las sesiones y accede a drain y reportes
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleAdmin:
+		return "admin"
+	case RoleOperator:
+		return "operator"
+	default:
+		return "viewer"
+	}
+}
+
+// RequestIdentity es el usuario que hace la petición, según los headers que agrega
+// el proxy de extensiones de Argo CD
+type RequestIdentity struct {
+	User    string
+	Groups  []string
+	Project string
+}
+
+// identityFromRequest lee Argocd-Username, Argocd-User-Groups y Argocd-Project-Name
+func identityFromRequest(r *http.Request) RequestIdentity {
+	identity := RequestIdentity{
+		User:    r.Header.Get("Argocd-Username"),
+		Project: r.Header.Get("Argocd-Project-Name"),
+	}
+	for _, group := range strings.Split(r.Header.Get("Argocd-User-Groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			identity.Groups = append(identity.Groups, group)
+		}
+	}
+	return identity
+}
+
+// Role devuelve el rol más alto que otorgan los grupos del usuario
+// según ADMIN_GROUPS y OPERATOR_GROUPS; sin coincidencias el rol es viewer
+func (id RequestIdentity) Role() Role {
+	if id.inAnyGroup(appConfig.AdminGroups) {
+		return RoleAdmin
+	}
+	if id.inAnyGroup(appConfig.OperatorGroups) {
+		return RoleOperator
+	}
+	return RoleViewer
+}
+
+func (id RequestIdentity) inAnyGroup(groups []string) bool {
+	for _, group := range id.Groups {
+		for _, candidate := range groups {
+			if group == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsAdmin indica si el usuario tiene el rol admin
+func (id RequestIdentity) IsAdmin() bool {
+	return id.Role() == RoleAdmin
+}
+
+// CanView indica si el usuario puede ver una sesión: los viewers solo las propias,
+// los operators las de su proyecto y los admins todas
+func (id RequestIdentity) CanView(session *PortForwardSession) bool {
+	switch id.Role() {
+	case RoleAdmin:
+		return true
+	case RoleOperator:
+		return session.Project == id.Project
+	default:
+		return session.Project == id.Project && session.User == id.User
+	}
+}
+
+// CanClose indica si el usuario puede cerrar una sesión: hace falta poder verla y
+// ser operator o admin, así que los viewers no cierran ni las propias
+func (id RequestIdentity) CanClose(session *PortForwardSession) bool {
+	return id.Role() >= RoleOperator && id.CanView(session)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// resolveJobTarget devuelve el pod al que apuntar para un Job o un CronJob: el pod
+// más reciente del Job, o del Job más reciente del CronJob. Junto al error
+// devuelve el código HTTP a responder.
+func resolveJobTarget(ctx context.Context, clientset *kubernetes.Clientset, namespace, job, cronJob string) (string, int, error) {
+	if cronJob != "" {
+		var status int
+		var err error
+		job, status, err = latestCronJobJob(ctx, clientset, namespace, cronJob)
+		if err != nil {
+			return "", status, err
+		}
+	}
+	return latestJobPod(ctx, clientset, namespace, job)
+}
+
+// latestCronJobJob busca el Job más reciente creado por el CronJob
+func latestCronJobJob(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) (string, int, error) {
+	cronJob, err := clientset.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", lookupStatus(err), fmt.Errorf("error al obtener el CronJob %s/%s: %v", namespace, name, err)
+	}
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("error al listar los Jobs de %s: %v", namespace, err)
+	}
+
+	var latest *batchv1.Job
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !ownedBy(job.OwnerReferences, cronJob.UID) {
+			continue
+		}
+		if latest == nil || job.CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = job
+		}
+	}
+	if latest == nil {
+		return "", http.StatusNotFound, fmt.Errorf("el CronJob %s/%s todavía no creó ningún Job", namespace, name)
+	}
+	slog.Debug("CronJob resuelto", "component", "resolveJobTarget", "namespace", namespace, "cronJob", name, "job", latest.Name)
+	return latest.Name, http.StatusOK, nil
+}
+
+// latestJobPod busca el pod más reciente del Job. Los pods terminados no aceptan
+// port-forward, así que si el último ya terminó se devuelve un error que lo explica.
+func latestJobPod(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) (string, int, error) {
+	job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", lookupStatus(err), fmt.Errorf("error al obtener el Job %s/%s: %v", namespace, name, err)
+	}
+	selector := ""
+	if job.Spec.Selector != nil {
+		selector = metav1.FormatLabelSelector(job.Spec.Selector)
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("error al listar los pods del Job %s/%s: %v", namespace, name, err)
+	}
+
+	var latest *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !ownedBy(pod.OwnerReferences, job.UID) {
+			continue
+		}
+		if latest == nil || pod.CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = pod
+		}
+	}
+	if latest == nil {
+		return "", http.StatusNotFound, fmt.Errorf("el Job %s/%s no tiene pods", namespace, name)
+	}
+	if latest.Status.Phase == corev1.PodSucceeded || latest.Status.Phase == corev1.PodFailed {
+		return "", http.StatusConflict, fmt.Errorf("el pod más reciente del Job %s/%s (%s) ya terminó con fase %s; no se puede hacer port-forward a un pod completado",
+			namespace, name, latest.Name, latest.Status.Phase)
+	}
+	slog.Debug("Job resuelto", "component", "resolveJobTarget", "namespace", namespace, "job", name, "pod", latest.Name, "phase", latest.Status.Phase)
+	return latest.Name, http.StatusOK, nil
+}
+
+// ownedBy indica si alguna ownerReference apunta al UID indicado
+func ownedBy(refs []metav1.OwnerReference, uid types.UID) bool {
+	for _, ref := range refs {
+		if ref.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupStatus traduce el error de la API de Kubernetes al código HTTP a responder
+func lookupStatus(err error) int {
+	if apierrors.IsNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func init() {
+	// Los flags tienen prioridad sobre KUBECONFIG y KUBE_CONTEXT
+	flag.StringVar(&appConfig.Kubeconfig, "kubeconfig", appConfig.Kubeconfig,
+		"kubeconfig para ejecutar fuera del cluster (por defecto $KUBECONFIG o ~/.kube/config)")
+	flag.StringVar(&appConfig.KubeContext, "context", appConfig.KubeContext,
+		"contexto del kubeconfig a usar (por defecto el contexto actual)")
+}
+
+// kubeRESTConfig devuelve la configuración de Kubernetes del backend: el API
+// server simulado en MOCK_MODE, el kubeconfig si se indicó con --kubeconfig,
+// --context o KUBECONFIG, y si no la del ServiceAccount del pod. Fuera de un
+// cluster y sin kubeconfig explícito se usan las reglas de kubectl
+// (~/.kube/config), para poder probar contra kind o minikube.
+func kubeRESTConfig() (*rest.Config, error) {
+	if appConfig.MockMode {
+		return startMockCluster()
+	}
+	if appConfig.Kubeconfig != "" || appConfig.KubeContext != "" {
+		return kubeconfigRESTConfig()
+	}
+	config, err := rest.InClusterConfig()
+	if errors.Is(err, rest.ErrNotInCluster) {
+		return kubeconfigRESTConfig()
+	}
+	return config, err
+}
+
+// kubeconfigRESTConfig carga el kubeconfig con las mismas reglas que kubectl:
+// --kubeconfig, KUBECONFIG (lista de ficheros) o ~/.kube/config
+func kubeconfigRESTConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if paths := filepath.SplitList(appConfig.Kubeconfig); len(paths) > 1 {
+		rules.Precedence = paths
+	} else {
+		rules.ExplicitPath = appConfig.Kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: appConfig.KubeContext}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	raw, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig: %w", err)
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig: %w", err)
+	}
+	contextName := raw.CurrentContext
+	if appConfig.KubeContext != "" {
+		contextName = appConfig.KubeContext
+	}
+	slog.Info("Ejecutando fuera del cluster", "component", "kubeconfig", "context", contextName, "host", config.Host)
+	return config, nil
+}
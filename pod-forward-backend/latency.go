@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// La latencia hacia el pod se mide por perfil y se separa en dos fases para
+// saber si "Grafana a través de Argo CD está lento" es el túnel o la aplicación:
+// connect es obtener una conexión por el túnel (abrir un stream SPDY si no hay
+// una en el pool) y response es desde que se envió la petición hasta el primer
+// byte de la respuesta del pod. total es desde el inicio hasta el primer byte y
+// es la que se compara contra LATENCY_SLO.
+
+// latencyWindow es la cantidad de muestras recientes con que se calculan los
+// percentiles y el burn rate de cada serie
+const latencyWindow = 1024
+
+var latencyPhases = []string{"connect", "response", "total"}
+
+// latencyQuantiles son los percentiles que se exponen
+var latencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+// latencySeries guarda las últimas muestras de una fase y los acumulados
+type latencySeries struct {
+	samples []time.Duration
+	next    int
+	sum     time.Duration
+	count   int64
+}
+
+func (s *latencySeries) observe(d time.Duration) {
+	if len(s.samples) < latencyWindow {
+		s.samples = append(s.samples, d)
+	} else {
+		s.samples[s.next] = d
+		s.next = (s.next + 1) % latencyWindow
+	}
+	s.sum += d
+	s.count++
+}
+
+// quantiles devuelve los percentiles de la ventana
+func (s *latencySeries) quantiles() []time.Duration {
+	sorted := append([]time.Duration(nil), s.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	values := make([]time.Duration, len(latencyQuantiles))
+	for i, q := range latencyQuantiles {
+		if len(sorted) > 0 {
+			values[i] = sorted[min(int(q*float64(len(sorted))), len(sorted)-1)]
+		}
+	}
+	return values
+}
+
+// slowFraction es la fracción de la ventana por encima del SLO
+func (s *latencySeries) slowFraction() float64 {
+	if len(s.samples) == 0 {
+		return 0
+	}
+	slow := 0
+	for _, d := range s.samples {
+		if d > appConfig.LatencySLO {
+			slow++
+		}
+	}
+	return float64(slow) / float64(len(s.samples))
+}
+
+// latencyKey identifica la serie; project permite filtrar por proyecto en /metrics
+type latencyKey struct {
+	project, profile, phase string
+}
+
+var (
+	latencies   = make(map[latencyKey]*latencySeries)
+	latenciesMu sync.Mutex
+)
+
+// observeLatency suma una muestra de la fase
+func observeLatency(project, profile, phase string, d time.Duration) {
+	latenciesMu.Lock()
+	defer latenciesMu.Unlock()
+	key := latencyKey{project, profile, phase}
+	series, ok := latencies[key]
+	if !ok {
+		series = &latencySeries{}
+		latencies[key] = series
+	}
+	series.observe(d)
+}
+
+// latencyTrace mide las fases de una petición al pod con httptrace
+type latencyTrace struct {
+	start, gotConn, wroteRequest, firstByte time.Time
+}
+
+// withLatencyTrace devuelve la petición con el trace que completa las marcas de tiempo
+func (t *latencyTrace) withLatencyTrace(req *http.Request) *http.Request {
+	t.start = time.Now()
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { t.gotConn = time.Now() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}))
+}
+
+// record suma las fases medidas a la serie del perfil y a los contadores del SLO
+func (t *latencyTrace) record(project, profile string) {
+	if t.gotConn.IsZero() || t.wroteRequest.IsZero() || t.firstByte.IsZero() {
+		return
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	total := t.firstByte.Sub(t.start)
+	observeLatency(project, profile, "connect", t.gotConn.Sub(t.start))
+	observeLatency(project, profile, "response", t.firstByte.Sub(t.wroteRequest))
+	observeLatency(project, profile, "total", total)
+
+	labels := map[string]string{"project": project, "profile": profile}
+	addCounter("pod_forward_upstream_slo_requests_total", labels, 1)
+	if total > appConfig.LatencySLO {
+		addCounter("pod_forward_upstream_slo_slow_requests_total", labels, 1)
+	}
+}
+
+// writeLatencyMetrics expone los percentiles como summary y el burn rate del SLO
+// por perfil: 1 consume el presupuesto de error justo al ritmo que permite el
+// objetivo, más de 1 lo agota antes
+func writeLatencyMetrics(w http.ResponseWriter, visible func(labels string) bool) {
+	latenciesMu.Lock()
+	defer latenciesMu.Unlock()
+	keys := make([]latencyKey, 0, len(latencies))
+	for key := range latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.project != b.project {
+			return a.project < b.project
+		}
+		if a.profile != b.profile {
+			return a.profile < b.profile
+		}
+		return a.phase < b.phase
+	})
+
+	fmt.Fprintf(w, "# TYPE pod_forward_upstream_latency_seconds summary\n")
+	for _, key := range keys {
+		labels := map[string]string{"project": key.project, "profile": key.profile, "phase": key.phase}
+		if !visible(metricLabels(labels)) {
+			continue
+		}
+		series := latencies[key]
+		for i, value := range series.quantiles() {
+			labels["quantile"] = fmt.Sprintf("%g", latencyQuantiles[i])
+			fmt.Fprintf(w, "pod_forward_upstream_latency_seconds%s %g\n", metricLabels(labels), value.Seconds())
+		}
+		delete(labels, "quantile")
+		fmt.Fprintf(w, "pod_forward_upstream_latency_seconds_sum%s %g\n", metricLabels(labels), series.sum.Seconds())
+		fmt.Fprintf(w, "pod_forward_upstream_latency_seconds_count%s %d\n", metricLabels(labels), series.count)
+	}
+
+	fmt.Fprintf(w, "# TYPE pod_forward_upstream_slo_burn_rate gauge\n")
+	budget := 1 - appConfig.LatencySLOObjective
+	for _, key := range keys {
+		if key.phase != "total" {
+			continue
+		}
+		labels := metricLabels(map[string]string{"project": key.project, "profile": key.profile})
+		if !visible(labels) {
+			continue
+		}
+		fmt.Fprintf(w, "pod_forward_upstream_slo_burn_rate%s %g\n", labels, latencies[key].slowFraction()/budget)
+	}
+	fmt.Fprintf(w, "# TYPE pod_forward_upstream_slo_seconds gauge\npod_forward_upstream_slo_seconds %g\n", appConfig.LatencySLO.Seconds())
+}
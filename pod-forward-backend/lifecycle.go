@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// lifecycle agrupa las goroutines de fondo del backend bajo un contexto raíz:
+// los subsistemas (health checks, informers, reaper, persistencia) y las tareas
+// de cada sesión (ForwardPorts, supervisión, warm). Al apagar se cancela el
+// contexto y se espera a que todas terminen, así el apagado es determinista y
+// las que no terminan a tiempo quedan en el log con su nombre.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  *errgroup.Group
+
+	mu sync.Mutex
+	// stopped rechaza tareas nuevas una vez que empezó stop, así Go nunca corre
+	// en paralelo con Wait
+	stopped bool
+	running map[string]int
+	// failed guarda el último error de cada subsistema que terminó con error; se
+	// borra cuando una tarea con el mismo nombre vuelve a arrancar o termina bien
+	failed map[string]string
+}
+
+// background es el lifecycle del proceso
+var background = newLifecycle()
+
+func newLifecycle() *lifecycle {
+	root, cancel := context.WithCancel(context.Background())
+	group, ctx := errgroup.WithContext(root)
+	return &lifecycle{ctx: ctx, cancel: cancel, group: group, running: make(map[string]int), failed: make(map[string]string)}
+}
+
+// goTask ejecuta fn en una goroutine bajo el contexto raíz. Si fn devuelve un
+// error o entra en pánico el subsistema queda marcado como degradado en /readyz
+// y en las métricas, pero el resto del backend sigue funcionando. Después de
+// stopBackground las tareas nuevas se descartan.
+func goTask(name string, fn func(ctx context.Context) error) {
+	background.goTask(name, fn)
+}
+
+func (l *lifecycle) goTask(name string, fn func(ctx context.Context) error) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		slog.Debug("Tarea descartada: el backend se está apagando", "component", "lifecycle", "task", name)
+		return false
+	}
+	l.running[name]++
+	delete(l.failed, name)
+	l.group.Go(func() error {
+		err := l.run(fn)
+		l.mu.Lock()
+		l.running[name]--
+		if l.running[name] == 0 {
+			delete(l.running, name)
+		}
+		failed := err != nil && l.ctx.Err() == nil
+		if failed {
+			l.failed[name] = err.Error()
+		} else if err == nil {
+			delete(l.failed, name)
+		}
+		l.mu.Unlock()
+		if failed {
+			slog.Error("Tarea terminada con error, funcionando en modo degradado", "component", "lifecycle", "task", name, "error", err)
+			addCounter("pod_forward_subsystem_failures_total", map[string]string{"subsystem": name}, 1)
+		}
+		// El error ya quedó registrado: devolverlo cancelaría el contexto de todas
+		// las demás tareas
+		return nil
+	})
+	return true
+}
+
+// run ejecuta fn convirtiendo un pánico en error
+func (l *lifecycle) run(fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return fn(l.ctx)
+}
+
+// stop cancela el contexto raíz y espera a que terminen las goroutines hasta que
+// venza ctx; devuelve los nombres de las que siguen corriendo
+func (l *lifecycle) stop(ctx context.Context) []string {
+	l.mu.Lock()
+	l.stopped = true
+	l.mu.Unlock()
+	l.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		l.group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var pending []string
+	for name, count := range l.running {
+		pending = append(pending, fmt.Sprintf("%s (%d)", name, count))
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// failedTasks devuelve los subsistemas que terminaron con error y el error
+func (l *lifecycle) failedTasks() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failed := make(map[string]string, len(l.failed))
+	for name, err := range l.failed {
+		failed[name] = err
+	}
+	return failed
+}
+
+// failedSubsystems devuelve los subsistemas del proceso que terminaron con error
+func failedSubsystems() map[string]string {
+	return background.failedTasks()
+}
+
+// stopBackground se llama al final del apagado, con las sesiones ya cerradas
+func stopBackground(ctx context.Context) {
+	if pending := background.stop(ctx); len(pending) > 0 {
+		slog.Warn("Goroutines sin terminar al apagar", "component", "lifecycle", "tasks", pending)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestLifecycleStopLeavesNoGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	l := newLifecycle()
+	for i := 0; i < 10; i++ {
+		l.goTask("esperar", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}
+	l.goTask("falla", func(context.Context) error { return errors.New("sin conexión") })
+	l.goTask("panico", func(context.Context) error { panic("nil map") })
+
+	// Las tareas que fallan terminan solas; esperar a que queden registradas
+	waitFor(t, func() bool { return len(l.failedTasks()) == 2 })
+	failed := l.failedTasks()
+	if failed["falla"] != "sin conexión" || failed["panico"] != "panic: nil map" {
+		t.Fatalf("failed = %v", failed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if pending := l.stop(ctx); len(pending) > 0 {
+		t.Fatalf("tareas sin terminar: %v", pending)
+	}
+	if _, ok := l.failedTasks()["esperar"]; ok {
+		t.Error("una tarea cancelada por stop quedó marcada como fallida")
+	}
+}
+
+func TestLifecycleRejectsTasksAfterStop(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	l := newLifecycle()
+	l.stop(context.Background())
+	if l.goTask("tarde", func(context.Context) error {
+		t.Error("la tarea corrió después de stop")
+		return nil
+	}) {
+		t.Fatal("goTask aceptó una tarea después de stop")
+	}
+}
+
+func TestLifecycleConcurrentStop(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	l := newLifecycle()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Una tarea que lanza otra, como la supervisión de una sesión
+			l.goTask("sesion", func(ctx context.Context) error {
+				l.goTask("supervisar", func(ctx context.Context) error {
+					<-ctx.Done()
+					return nil
+				})
+				<-ctx.Done()
+				return nil
+			})
+		}()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if pending := l.stop(ctx); len(pending) > 0 {
+		t.Fatalf("tareas sin terminar: %v", pending)
+	}
+	wg.Wait()
+}
+
+func TestLifecycleClearsFailedTask(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	l := newLifecycle()
+	defer l.stop(context.Background())
+
+	done := make(chan struct{})
+	l.goTask("informer", func(context.Context) error {
+		defer close(done)
+		return errors.New("watch cerrado")
+	})
+	<-done
+	waitFor(t, func() bool { return l.failedTasks()["informer"] != "" })
+
+	// El subsistema vuelve a arrancar: deja de figurar como degradado
+	restarted := make(chan struct{})
+	l.goTask("informer", func(ctx context.Context) error {
+		close(restarted)
+		<-ctx.Done()
+		return nil
+	})
+	<-restarted
+	if failed := l.failedTasks(); len(failed) != 0 {
+		t.Fatalf("failed = %v, se esperaba vacío", failed)
+	}
+}
+
+// waitFor espera hasta que cond se cumpla o pasen 5 segundos
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("la condición no se cumplió a tiempo")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// defaultLinkTTL es la vigencia de un link de un solo uso si no se pide otra
+const defaultLinkTTL = 15 * time.Minute
+
+// errLinkUsed indica que el link ya se consumió
+var errLinkUsed = errors.New("el link ya fue usado")
+
+// linkSecret firma los links de un solo uso; sin LINK_SECRET se genera uno al
+// iniciar y los links dejan de ser válidos tras un reinicio o entre réplicas
+var linkSecret = loadLinkSecret()
+
+func loadLinkSecret() []byte {
+	if appConfig.LinkSecret != "" {
+		return []byte(appConfig.LinkSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error("Error al generar el secreto", "component", "links", "error", err)
+	}
+	return secret
+}
+
+// linkPayload es el contenido firmado de un link: la sesión a crear, la identidad
+// de quien lo generó y la expiración
+type linkPayload struct {
+	Nonce   string               `json:"nonce"`
+	Expires int64                `json:"exp"`
+	User    string               `json:"user"`
+	Groups  []string             `json:"groups,omitempty"`
+	Project string               `json:"project,omitempty"`
+	Request createSessionRequest `json:"request"`
+}
+
+func (p linkPayload) identity() RequestIdentity {
+	return RequestIdentity{User: p.User, Groups: p.Groups, Project: p.Project}
+}
+
+// createLinkRequest es el cuerpo de POST /api/v2/links
+type createLinkRequest struct {
+	createSessionRequest
+	// TTL es la vigencia del link (ej: 10m), hasta LINK_MAX_TTL
+	TTL string `json:"ttl,omitempty"`
+}
+
+// signLink codifica el payload como <json base64>.<hmac>
+func signLink(payload linkPayload) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + linkSignature(encoded), nil
+}
+
+func linkSignature(encoded string) string {
+	mac := hmac.New(sha256.New, linkSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseLink verifica la firma y la expiración del link
+func parseLink(token string) (linkPayload, error) {
+	var payload linkPayload
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(linkSignature(encoded))) {
+		return payload, fmt.Errorf("firma inválida")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return payload, fmt.Errorf("link mal formado: %v", err)
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("link mal formado: %v", err)
+	}
+	if time.Now().Unix() > payload.Expires {
+		return payload, fmt.Errorf("link expirado")
+	}
+	return payload, nil
+}
+
+// handleCreateLink genera un link firmado que, en su primer uso, crea la sesión
+// con la identidad de quien lo generó y redirige a la aplicación del pod
+func handleCreateLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	identity := identityFromRequest(r)
+	if identity.User == "" {
+		http.Error(w, "Los links requieren un usuario autenticado", http.StatusUnauthorized)
+		return
+	}
+	var body createLinkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Namespace == "" || !body.hasPort() ||
+		(body.Pod == "" && body.Job == "" && body.CronJob == "" && body.Selector == "" && body.Service == "" && body.Workload == "") {
+		http.Error(w, "Faltan parámetros requeridos: namespace, pod (o job/cronJob/selector/service/workload), port (o portName)", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultLinkTTL
+	if body.TTL != "" {
+		parsed, err := time.ParseDuration(body.TTL)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("ttl inválido: %q", body.TTL), http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+	if ttl > appConfig.LinkMaxTTL {
+		ttl = appConfig.LinkMaxTTL
+	}
+	// Validar de antemano contra la política para no entregar un link que no sirve;
+	// un puerto con nombre recién se conoce al abrir la sesión
+	if body.PortName == "" {
+		if err := getPolicy().checkForward("", identity.Project, identity.User, body.Namespace, body.Port); err != nil {
+			http.Error(w, fmt.Sprintf("port-forward denegado: %v", err), http.StatusForbidden)
+			return
+		}
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	expires := time.Now().Add(ttl)
+	body.createSessionRequest.ClientToken = ""
+	token, err := signLink(linkPayload{
+		Nonce:   base64.RawURLEncoding.EncodeToString(nonce),
+		Expires: expires.Unix(),
+		User:    identity.User,
+		Groups:  identity.Groups,
+		Project: identity.Project,
+		Request: body.createSessionRequest,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al firmar el link: %v", err), http.StatusInternalServerError)
+		return
+	}
+	auditAction(r, auditRecord{
+		User:      identity.User,
+		Project:   identity.Project,
+		Namespace: body.Namespace,
+		Port:      body.Port,
+		Action:    "link-create",
+		Detail:    linkTarget(body.createSessionRequest) + " hasta " + expires.Format(time.RFC3339),
+	})
+	addCounter("pod_forward_links_total", map[string]string{"project": identity.Project, "action": "create"}, 1)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":       externalBaseURL(r) + extensionBasePath + "/links/" + token,
+		"expiresAt": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// linkTarget describe el destino del link para la auditoría
+func linkTarget(req createSessionRequest) string {
+	name := req.Pod
+	switch {
+	case req.Job != "":
+		name = "job/" + req.Job
+	case req.CronJob != "":
+		name = "cronjob/" + req.CronJob
+	case req.Selector != "":
+		name = "selector/" + req.Selector
+	case req.Service != "":
+		name = "svc/" + req.Service
+	case req.Workload != "":
+		name = req.Workload
+	}
+	return fmt.Sprintf("%s/%s:%d", req.Namespace, name, req.Port)
+}
+
+// linkPrefixes son las rutas de los links: la que entrega handleCreateLink, bajo el
+// prefijo del proxy, y la corta para los despliegues que lo quitan
+var linkPrefixes = []string{extensionBasePath + "/links/", "/links/"}
+
+// registerLinkRoutes registra la apertura de links en todas sus rutas
+func registerLinkRoutes(mux *http.ServeMux, clientset *kubernetes.Clientset) {
+	for _, prefix := range linkPrefixes {
+		mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+			slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", "/links/")
+			handleOpenLink(w, r, clientset)
+		})
+	}
+}
+
+// isLinkPath indica si la ruta es la de un link de un solo uso, que se autentica
+// con su propia firma
+func isLinkPath(path string) bool {
+	_, ok := linkToken(path)
+	return ok
+}
+
+// linkToken devuelve el token de la ruta de un link
+func linkToken(path string) (string, bool) {
+	for _, prefix := range linkPrefixes {
+		if token, ok := strings.CutPrefix(path, prefix); ok {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// linkGuestKey identifica en PortForwardSession.guests a quien abrió un link
+func linkGuestKey(identity RequestIdentity) string {
+	return identity.Project + "\x00" + identity.User
+}
+
+// addLinkGuest da a quien abrió el link acceso a la sesión que el link creó con la
+// identidad de quien lo generó
+func addLinkGuest(session *PortForwardSession, visitor RequestIdentity) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.guests == nil {
+		session.guests = make(map[string]bool)
+	}
+	session.guests[linkGuestKey(visitor)] = true
+}
+
+// handleOpenLink consume el link: verifica firma, expiración y que no se haya usado,
+// crea la sesión como el usuario que lo generó, le da acceso a quien lo abrió y lo
+// redirige a la ruta de la sesión
+func handleOpenLink(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	// Quien abre el link necesita una identidad propia para llegar a la sesión
+	visitor := identityFromRequest(r)
+	if visitor.User == "" {
+		http.Error(w, "Abrir un link requiere un usuario autenticado", http.StatusUnauthorized)
+		return
+	}
+	token, _ := linkToken(r.URL.Path)
+	payload, err := parseLink(token)
+	if err != nil {
+		auditAction(r, auditRecord{Action: "link-open", Result: err.Error()})
+		http.Error(w, "Link inválido: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	identity := payload.identity()
+	if err := consumeLinkNonce(r.Context(), clientset, payload.Nonce, payload.Expires); err != nil {
+		auditAction(r, auditRecord{User: identity.User, Project: identity.Project, Action: "link-open", Result: err.Error()})
+		if errors.Is(err, errLinkUsed) {
+			http.Error(w, "Link inválido: "+err.Error(), http.StatusGone)
+		} else {
+			http.Error(w, fmt.Sprintf("Error al registrar el uso del link: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	session, status, err := createSession(r.Context(), identity, payload.Request, clientset, nil)
+	record := auditRecord{
+		User:      identity.User,
+		Project:   identity.Project,
+		Namespace: payload.Request.Namespace,
+		Port:      payload.Request.Port,
+		Action:    "link-open",
+		Detail:    linkTarget(payload.Request),
+	}
+	if err != nil {
+		record.Result = err.Error()
+	} else {
+		record.Pod, record.Session, record.kube = session.Pod, session.ID, session.kube
+	}
+	auditAction(r, record)
+	addCounter("pod_forward_links_total", map[string]string{"project": identity.Project, "action": "open"}, 1)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	addLinkGuest(session, visitor)
+	http.Redirect(w, r, externalBaseURL(r)+sessionPrefix(session)+"/", http.StatusSeeOther)
+}
+
+// consumeLinkNonce marca el link como usado en LINKS_CONFIGMAP. El conflicto de
+// escritura entre réplicas garantiza que solo un acceso lo consuma. Los nonces
+// expirados se descartan en cada escritura.
+func consumeLinkNonce(ctx context.Context, clientset *kubernetes.Clientset, nonce string, expires int64) error {
+	configMaps := clientset.CoreV1().ConfigMaps(appConfig.PodNamespace)
+	name := appConfig.LinksConfigMap
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: appConfig.PodNamespace,
+					Labels:    map[string]string{"app.kubernetes.io/managed-by": "pod-forward-backend"},
+				},
+				Data: map[string]string{nonce: strconv.FormatInt(expires, 10)},
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if _, used := cm.Data[nonce]; used {
+			return errLinkUsed
+		}
+		now := time.Now().Unix()
+		for key, value := range cm.Data {
+			if exp, err := strconv.ParseInt(value, 10, 64); err != nil || exp < now {
+				delete(cm.Data, key)
+			}
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[nonce] = strconv.FormatInt(expires, 10)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
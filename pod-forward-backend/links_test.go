@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// TestOpenLinkThroughProxyPrefix abre un link por la ruta que entrega
+// handleCreateLink, con el prefijo del proxy, como lo hace quien lo recibe: debe
+// crear la sesión una sola vez y redirigir a la ruta de la sesión, a la que quien
+// abrió el link tiene acceso
+func TestOpenLinkThroughProxyPrefix(t *testing.T) {
+	config, err := startMockCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localKube = &kubeTarget{Clientset: clientset, Config: config}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiV2Prefix+"/links", handleCreateLink)
+	registerLinkRoutes(mux, clientset)
+	handler := argocdProxyCompat(authenticate([]Authenticator{argocdAuthenticator{}}, csrfProtect(mux)))
+
+	body, _ := json.Marshal(createLinkRequest{createSessionRequest: createSessionRequest{Namespace: mockNamespace, Pod: "sample-app-0", Port: mockAppPort}})
+	req := httptest.NewRequest(http.MethodPost, apiV2Prefix+"/links", bytes.NewReader(body))
+	req.Header.Set("Argocd-Username", "alice")
+	req.Header.Set("Argocd-Project-Name", "default")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("crear link: status %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.URL, extensionBasePath+"/links/") {
+		t.Fatalf("url = %q, se esperaba el prefijo %s/links/", created.URL, extensionBasePath)
+	}
+
+	visitor := RequestIdentity{User: "bob", Project: "other"}
+	open := func(identity RequestIdentity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, created.URL, nil)
+		if identity.User != "" {
+			req.Header.Set("Argocd-Username", identity.User)
+			req.Header.Set("Argocd-Project-Name", identity.Project)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := open(RequestIdentity{}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("sin usuario: status %d, se esperaba 401", rec.Code)
+	}
+
+	rec = open(visitor)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("abrir link: status %d: %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	id, _, ok := splitSessionPath(location)
+	if !ok {
+		t.Fatalf("Location = %q, se esperaba la ruta de una sesión", location)
+	}
+	session := ownedSession(id, visitor)
+	if session == nil {
+		t.Fatalf("quien abrió el link no tiene acceso a la sesión %s", id)
+	}
+	defer session.Close("test")
+	if session.User != "alice" || session.Project != "default" {
+		t.Errorf("sesión de %s/%s, se esperaba la de quien generó el link", session.Project, session.User)
+	}
+	if ownedSession(id, RequestIdentity{User: "mallory", Project: "other"}) != nil {
+		t.Error("otro usuario tiene acceso a la sesión del link")
+	}
+
+	if rec := open(visitor); rec.Code != http.StatusGone {
+		t.Fatalf("segundo uso: status %d, se esperaba 410", rec.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+)
+
+// listenAndServe sirve HTTP o, si TLS_CERT_FILE y TLS_KEY_FILE están configurados,
+// HTTPS; con TLS_CLIENT_CA_FILE además verifica los certificados de cliente que
+// se presenten (el authenticator mtls decide si son obligatorios). En texto plano
+// las conexiones pasan por framingListener. Al apagar devuelve http.ErrServerClosed.
+func listenAndServe(addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, MaxHeaderBytes: maxHeaderBytes}
+	trackServer(server)
+	if appConfig.TLSCertFile == "" || appConfig.TLSKeyFile == "" {
+		if appConfig.TLSClientCAFile != "" {
+			return fmt.Errorf("TLS_CLIENT_CA_FILE requiere TLS_CERT_FILE y TLS_KEY_FILE")
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return server.Serve(framingListener{listener})
+	}
+
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+	slog.Info("Sirviendo HTTPS", "component", "listener", "cert", appConfig.TLSCertFile)
+	return server.ListenAndServeTLS(appConfig.TLSCertFile, appConfig.TLSKeyFile)
+}
+
+// serverTLSConfig arma la configuración TLS de los listeners; con TLS_CLIENT_CA_FILE
+// se verifican los certificados de cliente que se presenten
+func serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if appConfig.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(appConfig.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error al leer TLS_CLIENT_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE no contiene certificados válidos")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// checkAdminListener valida que los endpoints de administración no queden expuestos
+// en texto plano: la dirección debe ser loopback, o el backend debe servir TLS y
+// autenticar por su cuenta (el authenticator argocd confía en headers que cualquier
+// pod del cluster puede enviar)
+func checkAdminListener(name, addr string, chain []Authenticator) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s inválida %q: %v", name, addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	if appConfig.TLSCertFile == "" || appConfig.TLSKeyFile == "" {
+		return fmt.Errorf("%s %s no es loopback y TLS no está configurado", name, addr)
+	}
+	for _, a := range chain {
+		if a.Name() == "argocd" {
+			return fmt.Errorf("%s %s no es loopback y la autenticación incluye argocd", name, addr)
+		}
+	}
+	return nil
+}
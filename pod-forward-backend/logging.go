@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// requestIDHeader es el header con el ID de la petición; se respeta el que llega
+// del proxy de delante y se devuelve en la respuesta
+const requestIDHeader = "X-Request-Id"
+
+// setupLogging configura el logger según LOG_FORMAT (text o json) y LOG_LEVEL
+// (debug, info, warn, error). Cada registro indica su componente en el atributo
+// component; lo que escriben las dependencias con el paquete log sale en nivel info.
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(appConfig.LogLevel)); err != nil {
+		slog.Warn("Valor inválido para LOG_LEVEL, usando info", "component", "config", "value", appConfig.LogLevel)
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if appConfig.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal registra el error y termina el proceso, como log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestIDKey guarda el ID de la petición en el contexto
+type requestIDKey struct{}
+
+// withRequestID asigna el ID de la petición (X-Request-Id) y lo devuelve en la respuesta
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+		r.Header.Set(requestIDHeader, id)
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// requestID devuelve el ID de la petición, o vacío fuera de una petición
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
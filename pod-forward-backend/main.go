@@ -2,39 +2,100 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"html"
+	"log/slog"
 	"net/http"
-	"net/url"
-	"os"
+	"net/http/httputil"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
 )
 
 const (
 	defaultPort = "8080"
+	// Prefijo bajo el que el navegador accede al backend
+	extensionBasePath = "/api/v1/extensions/pod-forward"
 )
 
 // PortForwardSession mantiene una sesión de port-forward activa
 type PortForwardSession struct {
+	ID        string
+	Key       string // Clave en activeSessions
+	Project   string // Proyecto de Argo CD que creó la sesión
+	User      string // Usuario de Argo CD que creó la sesión
+	Cluster   string // Cluster de Argo CD del pod (cluster=); vacío para el cluster local
 	Namespace string
 	Pod       string
 	Port      int
 	LocalPort int
+	Profile   string // Perfil de PodForwardPolicy elegido con el parámetro profile
 	PF        *portforward.PortForwarder
 	StopChan  chan struct{}
+	closeOnce sync.Once
+	done      chan struct{} // Se cierra cuando termina la goroutine de ForwardPorts
 	mu        sync.Mutex
 	LastUsed  time.Time
+	WSConns   int    // Conexiones WebSocket abiertas, cuentan como actividad
+	Raw       bool   // Paso byte a byte sin reescritura (raw=true al crear la sesión)
+	token     string // Token de la aplicación que inyecta el perfil (ej: Jupyter)
+	Helper    string // Tipo de pod auxiliar creado para la sesión; se borra al cerrarla
+	kube      *kubeTarget // Cliente del cluster del pod
+
+	// ClientTokens guarda los clientToken de creación idempotente que devolvieron
+	// esta sesión, con la huella de la petición que los usó
+	ClientTokens map[string]string
+
+	closed   bool          // Close ya se llamó; la reconexión no debe reemplazar el port-forward
+	Degraded bool          // Se perdió la conexión con el pod y se está reconectando
+	resumed  chan struct{} // Se cierra cuando termina la reconexión, con éxito o no
+
+	// Timing es el desglose del tiempo de creación de la sesión
+	Timing sessionTiming
+
+	// Workload es el Deployment o StatefulSet del pod (deployment/<nombre>); si el pod
+	// desaparece, la reconexión sigue con otro pod listo del mismo workload
+	Workload string
+
+	// Bytes transferidos por el proxy: BytesIn del cliente al pod y BytesOut del pod al cliente
+	BytesIn  atomic.Int64
+	BytesOut atomic.Int64
+
+	// streams cuenta las conexiones al puerto local, que usan streams del túnel SPDY
+	streams streamGate
+
+	// quotaExceeded se marca al superar maxBytesPerSession; la sesión se cierra
+	quotaExceeded atomic.Bool
+	// limiter es el token bucket de RATE_LIMIT_SESSION_RPS
+	limiter sessionLimiter
+
+	// Túneles adicionales hacia el mismo pod para repartir las peticiones (tunnels)
+	TunnelsWanted int
+	tunnels       []*extraTunnel
+	nextTunnel    atomic.Uint32
+
+	// transport es el pool de conexiones HTTP de la sesión hacia sus puertos locales
+	transport sessionTransport
+	// affinity mantiene una conexión autenticada por conexión del navegador cuando
+	// el pod autentica la conexión (NTLM, Negotiate)
+	affinity authAffinity
+	// TLS son las opciones para los pods que solo sirven HTTPS (scheme=https)
+	TLS upstreamTLS
+	// HTTP2 envía todas las peticiones al pod por HTTP/2 (protocol=h2)
+	HTTP2 bool
+	// guests son quienes abrieron la sesión con un link de un solo uso (ver
+	// linkGuestKey): llegan a ella por su prefijo /s/<id> igual que el creador
+	guests map[string]bool
 }
 
 var (
@@ -46,103 +107,290 @@ var (
 )
 
 func main() {
-	// Configurar cliente de Kubernetes
-	config, err := rest.InClusterConfig()
+	flag.Parse()
+	setupLogging()
+	if err := setupTracing(context.Background()); err != nil {
+		fatal("Error al configurar el tracing", "error", err)
+	}
+
+	// Configurar cliente de Kubernetes: in-cluster, kubeconfig o MOCK_MODE
+	config, err := kubeRESTConfig()
 	if err != nil {
-		log.Fatalf("Error al obtener configuración de Kubernetes: %v", err)
+		fatal("Error al obtener configuración de Kubernetes", "error", err)
 	}
+	traceKubeClient(config)
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Fatalf("Error al crear cliente de Kubernetes: %v", err)
+		fatal("Error al crear cliente de Kubernetes", "error", err)
+	}
+	localKube = &kubeTarget{Clientset: clientset, Config: config}
+
+	// Verificar los permisos de la ServiceAccount antes de aceptar peticiones
+	if appConfig.SelfTestEnabled {
+		startSelfTest(clientset)
+	}
+
+	// Chequeo periódico de conectividad con el API server de cada cluster
+	registerCluster(localCluster, clientset)
+	startClusterHealthChecks()
+
+	// Versión y recursos del cluster: lo que no soporta se deshabilita con un error
+	// claro y /readyz lo informa
+	localCaps := capabilitiesFor(background.ctx, localKube)
+
+	// Cache de EndpointSlices para resolver los targets service=
+	if localCaps.Error != "" || localCaps.Features[capabilityEndpointSlices] {
+		startEndpointSliceInformer(background.ctx, clientset)
+	}
+
+	// Cliente dinámico para leer recursos de Argo CD (Applications)
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		fatal("Error al crear cliente dinámico de Kubernetes", "error", err)
+	}
+
+	// Reconciliar PodForwardPolicy si el CRD está habilitado
+	if appConfig.PolicyCRDEnabled {
+		if localCaps.Error == "" && !localCaps.Features[capabilityPolicyCRD] {
+			// Sin el CRD la política niega todo hasta que se instale
+			slog.Warn("POLICY_CRD_ENABLED pero el CRD PodForwardPolicy no está instalado; se niegan los port-forwards", "component", "policy")
+		}
+		startPolicyReconciler(background.ctx, clientset, dynamicClient)
 	}
 
 	// Handler para el endpoint de port-forward
 	// Manejar tanto /forward como /api/v1/extensions/pod-forward/forward
 	http.HandleFunc("/forward", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[REQUEST] %s %s - Query: %s", r.Method, r.URL.Path, r.URL.RawQuery)
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
 		handlePortForward(w, r, clientset, config)
 	})
 	
 	// Manejar todas las rutas bajo /api/v1/extensions/pod-forward/
 	// Esto permite que aplicaciones como Grafana funcionen correctamente con sus rutas
 	http.HandleFunc("/api/v1/extensions/pod-forward/", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[REQUEST] %s %s - Query: %s", r.Method, r.URL.Path, r.URL.RawQuery)
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
 		handlePortForward(w, r, clientset, config)
 	})
 
-	// Handler de health check
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	// Pods, contenedores y puertos de un namespace para el selector de la UI
+	http.HandleFunc(extensionBasePath+"/discover", handleDiscover)
+	http.HandleFunc("/discover", handleDiscover)
+
+	// API de gestión de sesiones
+	http.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+		setDeprecationHeaders(w)
+		handleListSessions(w, r)
+	})
+	http.HandleFunc("/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+		handleSessions(w, r)
+	})
+
+	// API v2 de sesiones y targets
+	http.HandleFunc(apiV2Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+		handleAPIv2(w, r, clientset, config, dynamicClient)
+	})
+
+	// Targets por defecto declarados con anotaciones en la Application
+	http.HandleFunc("/targets", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+		handleApplicationTargets(w, r, dynamicClient)
+	})
+
+	// Historial de targets recientes y favoritos del usuario
+	http.HandleFunc("/recent", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+		handleRecent(w, r, "", clientset)
+	})
+
+	// Estado de varios pods/services a la vez para el árbol de recursos
+	http.HandleFunc("/targets/status", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+		handleTargetsStatus(w, r, clientset)
 	})
+
+	// Links de un solo uso: crean la sesión y redirigen a la aplicación del pod
+	registerLinkRoutes(http.DefaultServeMux, clientset)
+
+	// Readiness: chequea el API server local y el estado de las sesiones
+	http.HandleFunc("/readyz", methods(handleReadyz, readMethods...))
+
+	// Liveness: solo el proceso, sin depender del API server
+	http.HandleFunc("/livez", methods(handleLivez, readMethods...))
+
+	// Métricas en formato Prometheus
+	http.HandleFunc("/metrics", methods(handleMetrics, readMethods...))
+
+	// Alias de /livez para los probes configurados antes de que existiera
+	http.HandleFunc("/health", methods(handleLivez, readMethods...))
 	
-	// Handler raíz para debugging
+	// Handler raíz para debugging. Por defecto solo responde "/" y las rutas no
+	// declaradas son 404; con CATCH_ALL_FORWARD las que contienen /forward se
+	// manejan como port-forward (comportamiento anterior)
+	if appConfig.CatchAllForward {
+		slog.Info("CATCH_ALL_FORWARD activo: las rutas no declaradas que contienen /forward se manejan como port-forward", "component", "config")
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[REQUEST] %s %s - Query: %s", r.Method, r.URL.Path, r.URL.RawQuery)
+		slog.Debug("request received", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
 		if r.URL.Path == "/" {
+			if !allowMethods(w, r, readMethods...) {
+				return
+			}
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, "Pod Forward Backend - Path: %s\n", r.URL.Path)
 			return
 		}
 		// Si la ruta contiene /forward o /api/v1/extensions/pod-forward/, intentar manejarla
-		if strings.Contains(r.URL.Path, "/forward") || strings.HasPrefix(r.URL.Path, "/api/v1/extensions/pod-forward/") {
+		if appConfig.CatchAllForward && (strings.Contains(r.URL.Path, "/forward") || strings.HasPrefix(r.URL.Path, "/api/v1/extensions/pod-forward/")) {
 			handlePortForward(w, r, clientset, config)
 			return
 		}
 		http.NotFound(w, r)
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+	// Pods auxiliares (interfaces de base de datos) que se borran al cerrar su sesión
+	startHelpers(clientset)
+	// Borrar los pods auxiliares que quedaron sin sesión en una ejecución anterior
+	startHelperGC(clientset)
+
+	// Registro de auditoría de las peticiones al pod (AUDIT_LOG)
+	startAuditLog()
+
+	// Ajustar el límite de conexiones de una sesión cuando el túnel rechaza streams
+	watchStreamErrors()
+
+	// Cerrar las sesiones inactivas para no acumular conexiones SPDY y puertos locales
+	startIdleReaper(appConfig.SessionIdleTTL)
+
+	// Restaurar en segundo plano las sesiones guardadas antes del reinicio
+	if appConfig.PersistenceConfigMap != "" {
+		startSessionPersistence(clientset)
+		goTask("restore", restoreSessions)
+	}
+
+	slog.Info("Servidor iniciado", "port", appConfig.Port)
+	// La autenticación depende del modo: detrás del proxy de Argo CD se confía en sus
+	// headers y en modo standalone el backend se autentica solo
+	authenticators, err := buildAuthenticators(resolveDeploymentMode(clientset))
+	if err != nil {
+		fatal("Error al configurar la autenticación", "error", err)
+	}
+	handler := accessLog(traceRequests(rejectAmbiguousFraming(pageSecurityHeaders(argocdProxyCompat(authenticate(authenticators, rateLimitUsers(csrfProtect(http.DefaultServeMux))))))))
+
+	// Endpoints de administración en un listener separado: solo loopback, o TLS con
+	// autenticación propia, para no exponer el control de sesiones dentro del cluster
+	if err := checkAdminListener("ADMIN_ADDR", appConfig.AdminAddr, authenticators); err != nil {
+		fatal("Listener de administración inseguro", "error", err)
+	}
+	// API gRPC de sesiones para clientes programáticos
+	if appConfig.GRPCAddr != "" {
+		if err := startGRPCServer(authenticators, clientset, config); err != nil {
+			fatal("Error al iniciar la API gRPC", "error", err)
+		}
 	}
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/admin/drain", handleAdminDrain)
+	adminMux.HandleFunc("/admin/report", handleAdminReport)
+	adminMux.HandleFunc("/admin/rbac-manifest", handleAdminRBACManifest)
+	adminMux.HandleFunc("/admin/selftest", func(w http.ResponseWriter, r *http.Request) {
+		handleAdminSelfTest(w, r, clientset)
+	})
+	handleShutdownSignals()
+	go func() {
+		slog.Info("Endpoints de administración iniciados", "addr", appConfig.AdminAddr)
+		err := listenAndServe(appConfig.AdminAddr, pageSecurityHeaders(authenticate(authenticators, csrfProtect(adminMux))))
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("Error en el listener de administración", "error", err)
+		}
+	}()
 
-	log.Printf("Servidor iniciado en el puerto %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	if err := listenAndServe(":"+appConfig.Port, serveH2C(handler)); !errors.Is(err, http.ErrServerClosed) {
+		fatal("Error en el servidor", "error", err)
+	}
+	// Shutdown vuelve de ListenAndServe en el momento: esperar a que cierre las sesiones
+	<-shutdownDone
 }
 
 func handlePortForward(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, config *rest.Config) {
-	log.Printf("[handlePortForward] Iniciando - Path: %s, Query: %s", r.URL.Path, r.URL.RawQuery)
+	identity := identityFromRequest(r)
+	if id, _, ok := splitSessionPath(r.URL.EscapedPath()); ok {
+		serveSessionPath(w, r, identity, id)
+		return
+	}
+	// La creación de sesiones por query params es una navegación del iframe: GET.
+	// Las peticiones a una sesión abierta van al pod con cualquier método.
+	if isSessionCreation(r.URL.Query()) && !allowMethods(w, r, readMethods...) {
+		return
+	}
 	
 	// Obtener parámetros de la query
 	namespace := r.URL.Query().Get("namespace")
 	pod := r.URL.Query().Get("pod")
 	portStr := r.URL.Query().Get("port")
 	
-	log.Printf("[handlePortForward] Parámetros - namespace: %s, pod: %s, port: %s", namespace, pod, portStr)
+	slog.Debug("Parámetros del port-forward", "component", "handlePortForward", "requestId", requestID(r.Context()), "namespace", namespace, "pod", pod, "port", portStr)
+
+	// La cadena de resolvers completa el cluster (cluster=) y el pod a partir de
+	// job, cronjob, selector, workload o service. clientset sigue siendo el local
+	// para los ConfigMaps propios.
+	query := r.URL.Query()
+	spec := targetSpec{
+		Cluster:   query.Get("cluster"),
+		Namespace: namespace,
+		Pod:       pod,
+		Job:       query.Get("job"),
+		CronJob:   query.Get("cronjob"),
+		Selector:  query.Get("selector"),
+		Workload:  query.Get("workload"),
+		Endpoint: endpointOptions{
+			Strategy: query.Get("strategy"),
+			Zone:     query.Get("zone"),
+			IP:       query.Get("endpoint"),
+		},
+		Wait:        query.Get("wait") == "true",
+		WaitTimeout: waitTimeout(query.Get("timeout")),
+	}
+	// Con service, port es el puerto del Service y se reemplaza por el targetPort
+	if service := query.Get("service"); service != "" && portStr != "" {
+		servicePort, err := strconv.Atoi(portStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Puerto inválido: %s", portStr), http.StatusBadRequest)
+			return
+		}
+		spec.Service, spec.Port = service, servicePort
+	} else if _, err := strconv.Atoi(portStr); err != nil && portStr != "" {
+		// port=http es un puerto con nombre del contenedor
+		spec.PortName = portStr
+	}
+	if status, err := resolveTarget(r.Context(), &spec); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	kube := spec.Kube
+	if spec.Port != 0 {
+		portStr = strconv.Itoa(spec.Port)
+	}
+	pod = spec.Pod
 
-	// Si faltan parámetros en la query, intentar obtenerlos de la sesión activa
-	// Esto permite que las peticiones subsecuentes (como navegación en Grafana) funcionen
+	// Si faltan parámetros en la query, usar la sesión de la página que hizo la
+	// petición: rutas absolutas que la aplicación arma sin el prefijo de la sesión
 	if namespace == "" || pod == "" || portStr == "" {
-		// Buscar una sesión activa
-		// Si hay múltiples sesiones, usar la más reciente (LastUsed más reciente)
-		sessionsMu.RLock()
-		var activeSession *PortForwardSession
-		var mostRecentTime time.Time
-		for _, sess := range activeSessions {
-			sess.mu.Lock()
-			if sess.PF != nil && sess.LastUsed.After(mostRecentTime) {
-				mostRecentTime = sess.LastUsed
-				activeSession = sess
+		if id := refererSessionID(r); id != "" {
+			if session := ownedSession(id, identity); session != nil {
+				slog.Debug("Usando la sesión del Referer", "component", "handlePortForward", "requestId", requestID(r.Context()),
+					"session", session.ID, "namespace", session.Namespace, "pod", session.Pod, "port", session.Port)
+				touchSession(session)
+				proxyHTTP(w, r, session)
+				return
+			}
+			// La sesión se cerró por superar la cuota de bytes
+			if exceeded, ok := quotaExceededFor(id); ok {
+				serveQuotaExceededPage(w, exceeded)
+				return
 			}
-			sess.mu.Unlock()
-		}
-		sessionsMu.RUnlock()
-		
-		if activeSession != nil {
-			// Usar la sesión activa más reciente
-			activeSession.mu.Lock()
-			activeSession.LastUsed = time.Now()
-			localPort := activeSession.LocalPort
-			activeSession.mu.Unlock()
-			
-			log.Printf("[handlePortForward] Usando sesión activa - namespace: %s, pod: %s, port: %d, localPort: %d", 
-				activeSession.Namespace, activeSession.Pod, activeSession.Port, localPort)
-			
-			// Proxear directamente al pod
-			proxyHTTP(w, r, localPort)
-			return
 		}
 		
 		// Si faltan parámetros y no hay sesión activa, servir una página HTML simple
@@ -151,7 +399,7 @@ func handlePortForward(w http.ResponseWriter, r *http.Request, clientset *kubern
 			return
 		}
 		
-		log.Printf("[handlePortForward] No hay sesión activa y faltan parámetros - Path: %s", r.URL.Path)
+		slog.Debug("No hay sesión y faltan parámetros", "component", "handlePortForward", "requestId", requestID(r.Context()), "path", r.URL.Path)
 		http.Error(w, "Faltan parámetros requeridos: namespace, pod, port. No hay sesión activa.", http.StatusBadRequest)
 		return
 	}
@@ -162,109 +410,218 @@ func handlePortForward(w http.ResponseWriter, r *http.Request, clientset *kubern
 		return
 	}
 
-	// Crear clave única para la sesión
-	sessionKey := fmt.Sprintf("%s/%s:%d", namespace, pod, port)
+	tlsOpts, status, err := loadUpstreamTLS(r.Context(), kube.Clientset, namespace, query.Get("scheme"), query.Get("insecureSkipVerify") == "true", query.Get("caSecret"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http2Enabled, err := parseProtocol(query.Get("protocol"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// La creación de sesiones por query params es la API v1: se mantiene por
+	// compatibilidad e informa la API que la reemplaza
+	setDeprecationHeaders(w)
 
 	// Obtener o crear sesión de port-forward
-	session, err := getOrCreateSession(sessionKey, namespace, pod, port, clientset, config)
+	session, status, err := openSession(r.Context(), identity, kube, namespace, pod, port)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error al crear port-forward: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	// Actualizar último uso
+	// Actualizar último uso y las opciones elegidas en la URL
 	session.mu.Lock()
 	session.LastUsed = time.Now()
-	localPort := session.LocalPort
 	session.mu.Unlock()
+	configureSession(r.Context(), kube.Clientset, session, r.URL.Query().Get("profile"), r.URL.Query().Get("raw") == "true")
+	session.setUpstreamTLS(tlsOpts)
+	session.setHTTP2(http2Enabled)
+	tunnels, _ := strconv.Atoi(r.URL.Query().Get("tunnels"))
+	session.ensureTunnels(r.Context(), tunnels, kube.Clientset, kube.Config)
+	// Con service se recuerda el puerto del Service y no el del pod elegido
+	requestedPort, _ := strconv.Atoi(r.URL.Query().Get("port"))
+	recordRecentTarget(clientset, identity.User, createSessionRequest{
+		Cluster:   kube.Name,
+		Namespace: namespace,
+		Pod:       r.URL.Query().Get("pod"),
+		Job:       r.URL.Query().Get("job"),
+		CronJob:   r.URL.Query().Get("cronjob"),
+		Selector:  r.URL.Query().Get("selector"),
+		Service:   r.URL.Query().Get("service"),
+		Workload:  r.URL.Query().Get("workload"),
+		Strategy:  r.URL.Query().Get("strategy"),
+		Zone:      r.URL.Query().Get("zone"),
+		Endpoint:  r.URL.Query().Get("endpoint"),
+		Port:      requestedPort,
+		PortName:  spec.PortName,
+		Tunnels:   tunnels,
+		Profile:   r.URL.Query().Get("profile"),
+		Raw:       r.URL.Query().Get("raw") == "true",
+		Scheme:    query.Get("scheme"),
+		CASecret:  query.Get("caSecret"),
+		Protocol:  query.Get("protocol"),
+
+		InsecureSkipVerify: query.Get("insecureSkipVerify") == "true",
+	})
 
-	// Proxear todas las peticiones al pod
-	proxyHTTP(w, r, localPort)
+	// La pestaña sigue bajo el prefijo de la sesión: las peticiones siguientes llevan
+	// su ID en la ruta
+	noteAccessLogSession(r, session)
+	http.Redirect(w, r, sessionLocation(session, r), http.StatusFound)
 }
 
-func getOrCreateSession(sessionKey, namespace, pod string, port int, clientset *kubernetes.Clientset, config *rest.Config) (*PortForwardSession, error) {
-	sessionsMu.RLock()
-	session, exists := activeSessions[sessionKey]
-	sessionsMu.RUnlock()
+// buildSessionKey arma la clave del registro de sesiones; el proyecto y el usuario
+// forman parte de la clave para que dos proyectos o dos usuarios nunca compartan el
+// mismo port-forward
+func buildSessionKey(project, user, cluster, namespace, pod string, port int) string {
+	key := fmt.Sprintf("%s/%s:%d", namespace, pod, port)
+	if cluster != "" {
+		key = cluster + "/" + key
+	}
+	if user != "" {
+		key = user + "@" + key
+	}
+	if project != "" {
+		key = project + ":" + key
+	}
+	return key
+}
 
-	if exists {
-		// Verificar que la sesión sigue activa
-		session.mu.Lock()
-		if session.PF != nil {
-			session.LastUsed = time.Now()
-			session.mu.Unlock()
+func getOrCreateSession(ctx context.Context, sessionKey, project, user string, kube *kubeTarget, namespace, pod string, port int) (*PortForwardSession, error) {
+	for {
+		if session := liveSession(sessionKey); session != nil {
 			return session, nil
 		}
-		session.mu.Unlock()
-	}
 
-	// Verificar que el pod existe
-	_, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), pod, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("error al obtener pod: %v", err)
+		// Solo una petición por clave abre el port-forward; las concurrentes esperan
+		// su resultado y comparten la sesión en lugar de abrir otro que quedaría huérfano
+		pendingCreationsMu.Lock()
+		if pending, busy := pendingCreations[sessionKey]; busy {
+			pendingCreationsMu.Unlock()
+			select {
+			case <-pending.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// Si la petición que creaba la sesión se canceló, esta lo intenta de nuevo
+			if errors.Is(pending.err, context.Canceled) || errors.Is(pending.err, context.DeadlineExceeded) {
+				continue
+			}
+			if pending.err != nil {
+				return nil, pending.err
+			}
+			slog.Debug("Sesión compartida con una creación concurrente", "component", "getOrCreateSession", "session", pending.session.ID)
+			return touchSession(pending.session), nil
+		}
+		pending := &sessionCreation{done: make(chan struct{})}
+		pendingCreations[sessionKey] = pending
+		pendingCreationsMu.Unlock()
+
+		// La cuota se valida y se reserva junto con el registro de la sesión: la
+		// validación previa de los hooks no alcanza con creaciones concurrentes
+		if err := reserveSession(sessionKey, project, user, namespace); err != nil {
+			pending.err = fmt.Errorf("%w: %v", errSessionQuota, err)
+		} else {
+			createCtx, span := tracer.Start(ctx, "portforward.create")
+			pending.session, pending.err = createForwardSession(createCtx, sessionKey, project, user, kube, namespace, pod, port)
+			endSpan(span, pending.err)
+			if pending.err != nil {
+				releaseSessionReservation(sessionKey)
+			}
+		}
+		pendingCreationsMu.Lock()
+		delete(pendingCreations, sessionKey)
+		pendingCreationsMu.Unlock()
+		close(pending.done)
+		return pending.session, pending.err
 	}
+}
 
-	// Crear nueva sesión
-	req := clientset.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Namespace(namespace).
-		Name(pod).
-		SubResource("portforward")
-
-	transport, upgrader, err := spdy.RoundTripperFor(config)
-	if err != nil {
-		return nil, fmt.Errorf("error al configurar transport: %v", err)
-	}
+// errSessionQuota indica que la sesión no se creó por superar una cuota de sesiones
+var errSessionQuota = errors.New("port-forward denegado")
 
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+// sessionCreation es una creación de sesión en curso para una clave
+type sessionCreation struct {
+	done    chan struct{}
+	session *PortForwardSession
+	err     error
+}
 
-	stopChan := make(chan struct{}, 1)
-	readyChan := make(chan struct{}, 1)
+var (
+	// pendingCreations deduplica las creaciones concurrentes de la misma sesión
+	pendingCreations   = make(map[string]*sessionCreation)
+	pendingCreationsMu sync.Mutex
+)
 
-	// Crear el port-forward
-	ports := []string{fmt.Sprintf("0:%d", port)}
-	pf, err := portforward.New(dialer, ports, stopChan, readyChan, io.Discard, io.Discard)
-	if err != nil {
-		return nil, fmt.Errorf("error al crear port-forward: %v", err)
+// liveSession devuelve la sesión registrada con esa clave si su port-forward
+// sigue activo, y actualiza su último uso
+func liveSession(sessionKey string) *PortForwardSession {
+	sessionsMu.RLock()
+	session, exists := activeSessions[sessionKey]
+	sessionsMu.RUnlock()
+	if !exists {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.PF == nil {
+		return nil
 	}
+	session.LastUsed = time.Now()
+	return session
+}
 
-	// Iniciar el port-forward en una goroutine
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- pf.ForwardPorts()
-	}()
+// touchSession actualiza el último uso de la sesión recién creada por otra petición
+func touchSession(session *PortForwardSession) *PortForwardSession {
+	session.mu.Lock()
+	session.LastUsed = time.Now()
+	session.mu.Unlock()
+	return session
+}
 
-	// Esperar a que el port-forward esté listo
-	select {
-	case <-readyChan:
-		// Port-forward listo
-	case err := <-errChan:
-		if err != nil {
-			return nil, fmt.Errorf("error al iniciar port-forward: %v", err)
-		}
-	case <-time.After(5 * time.Second):
-		return nil, fmt.Errorf("timeout al iniciar port-forward")
-	}
+// createForwardSession abre el port-forward hacia el pod y registra la sesión
+func createForwardSession(ctx context.Context, sessionKey, project, user string, kube *kubeTarget, namespace, pod string, port int) (*PortForwardSession, error) {
+	clientset, config := kube.Clientset, kube.Config
 
-	// Obtener el puerto local asignado
-	forwardedPorts, err := pf.GetPorts()
-	if err != nil || len(forwardedPorts) == 0 {
-		return nil, fmt.Errorf("error al obtener puerto local")
+	// Verificar que el pod existe
+	lookupStarted := time.Now()
+	podObj, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener pod: %v", err)
 	}
+	podLookup := time.Since(lookupStarted)
 
-	localPort := int(forwardedPorts[0].Local)
+	// Crear nueva sesión con un puerto local libre
+	var phases [2]time.Duration
+	pf, stopChan, errChan, localPort, err := dialPortForward(ctx, clientset, config, namespace, pod, 0, port, &phases)
+	if err != nil {
+		return nil, err
+	}
 
-	session = &PortForwardSession{
+	session := &PortForwardSession{
+		ID:        newSessionID(),
+		Key:       sessionKey,
+		Project:   project,
+		User:      user,
+		Cluster:   kube.Name,
+		kube:      kube,
 		Namespace: namespace,
 		Pod:       pod,
 		Port:      port,
 		LocalPort: localPort,
 		PF:        pf,
 		StopChan:  stopChan,
+		done:      make(chan struct{}),
 		LastUsed:  time.Now(),
+		Workload:  podWorkload(ctx, clientset, podObj),
 	}
+	session.Timing.recordSetup(project, podLookup, phases[0], phases[1])
 
 	sessionsMu.Lock()
+	delete(sessionReservations, sessionKey)
 	activeSessions[sessionKey] = session
 	sessionsMu.Unlock()
 	
@@ -273,139 +630,255 @@ func getOrCreateSession(sessionKey, namespace, pod string, port int, clientset *
 	localPortToSession[localPort] = sessionKey
 	localPortMu.Unlock()
 
-	// Limpiar sesión cuando termine
-	go func() {
-		<-errChan
+	persistSessions()
+	runPostCreateHooks(ctx, session)
+
+	// Cerrar la sesión cuando termine el port-forward, o reconectar si se perdió la conexión
+	goTask("session-supervise", func(context.Context) error {
+		session.supervise(errChan, clientset, config)
+		return nil
+	})
+
+	// Mantener conexiones abiertas hacia el pod para evitar la latencia de la primera petición
+	if appConfig.WarmConnections > 0 {
+		goTask("session-warm", func(context.Context) error {
+			session.keepWarm(appConfig.WarmConnections, appConfig.WarmInterval)
+			return nil
+		})
+	}
+
+	return session, nil
+}
+
+// Close termina la sesión: detiene el port-forward y la quita de activeSessions y
+// localPortToSession. Es idempotente y es el único camino de terminación, tanto
+// para cierres explícitos como para errores del port-forward.
+func (s *PortForwardSession) Close(reason string) {
+	s.closeOnce.Do(func() {
+		slog.Info("Cerrando sesión", "component", "Close", "session", s.ID, "key", s.Key, "reason", reason)
+
+		// StopChan cambia con cada reconexión: leerlo con el lock
+		s.mu.Lock()
+		s.closed = true
+		stopChan := s.StopChan
+		s.PF = nil
+		if s.Degraded {
+			// Las peticiones que esperan la reconexión dejan de esperar
+			close(s.resumed)
+		}
+		s.mu.Unlock()
+		close(stopChan)
+		s.closeTunnels()
+		s.closeIdleConnections()
+
+		// Solo se borran las entradas si todavía apuntan a esta sesión,
+		// por si ya se creó una nueva con la misma clave
 		sessionsMu.Lock()
-		delete(activeSessions, sessionKey)
+		if activeSessions[s.Key] == s {
+			delete(activeSessions, s.Key)
+		}
 		sessionsMu.Unlock()
-		
+
 		localPortMu.Lock()
-		delete(localPortToSession, localPort)
+		if localPortToSession[s.LocalPort] == s.Key {
+			delete(localPortToSession, s.LocalPort)
+		}
 		localPortMu.Unlock()
-	}()
 
-	return session, nil
+		// El pod auxiliar solo existe para esta sesión; al apagar con persistencia
+		// se conserva para que la sesión restaurada lo vuelva a usar
+		s.mu.Lock()
+		helper := s.Helper
+		s.mu.Unlock()
+		if helper != "" && !(shuttingDown.Load() && persistence != nil) {
+			go deleteHelperPod(s.Namespace, s.Pod)
+		}
+
+		persistSessions()
+	})
+}
+
+// Done devuelve un canal que se cierra cuando la goroutine del port-forward terminó
+func (s *PortForwardSession) Done() <-chan struct{} {
+	return s.done
 }
 
 func serveForwardPage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, `<!DOCTYPE html>
-<html>
-<head>
-    <title>Port Forward</title>
-    <meta charset="utf-8">
-</head>
-<body>
-    <h1>Port Forward Activo</h1>
-    <p>El port-forward está activo. Puedes acceder a la aplicación del pod directamente.</p>
+	writeBrandedPage(w, http.StatusOK, "Port Forward Activo", fmt.Sprintf(`    <p>El port-forward está activo. Puedes acceder a la aplicación del pod directamente.</p>
     <p>Parámetros: namespace=%s, pod=%s, port=%s</p>
-</body>
-</html>`, r.URL.Query().Get("namespace"), r.URL.Query().Get("pod"), r.URL.Query().Get("port"))
+`, html.EscapeString(r.URL.Query().Get("namespace")), html.EscapeString(r.URL.Query().Get("pod")), html.EscapeString(r.URL.Query().Get("port"))))
 }
 
-func proxyHTTP(w http.ResponseWriter, r *http.Request, localPort int) {
-	// Construir la URL del pod local
-	// Remover el prefijo /api/v1/extensions/pod-forward/ de la ruta
-	path := r.URL.Path
-	
-	// Si la ruta es /forward o /api/v1/extensions/pod-forward/forward, usar la raíz del pod
-	if path == "/forward" || path == "/api/v1/extensions/pod-forward/forward" {
-		path = "/"
-	} else if strings.HasPrefix(path, "/api/v1/extensions/pod-forward/") {
-		// Remover el prefijo /api/v1/extensions/pod-forward/ para obtener la ruta real
-		path = strings.TrimPrefix(path, "/api/v1/extensions/pod-forward")
-		if path == "" {
-			path = "/"
-		}
+func proxyHTTP(w http.ResponseWriter, r *http.Request, session *PortForwardSession) {
+	noteAccessLogSession(r, session)
+
+	// Mientras se reconecta con el pod la petición espera hasta SESSION_RECONNECT_WAIT
+	if !session.awaitReconnect(r.Context()) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Se perdió la conexión con el pod y se está reconectando; reintentar en unos segundos", http.StatusServiceUnavailable)
+		return
 	}
-	
-	targetURL := fmt.Sprintf("http://localhost:%d%s", localPort, path)
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
+
+	// Los upgrades a WebSocket se conectan a nivel TCP en lugar de proxificarse
+	if isWebSocketUpgrade(r) {
+		proxyWebSocket(w, r, session)
+		return
 	}
-	
-	log.Printf("[proxyHTTP] Proxying %s %s -> http://localhost:%d%s", r.Method, r.URL.Path, localPort, path)
 
-	// Crear la petición al pod
-	req, err := http.NewRequest(r.Method, targetURL, r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error al crear petición: %v", err), http.StatusInternalServerError)
+	localPort := session.pickLocalPort()
+	if session.usesAuthAffinity() {
+		// La conexión autenticada es hacia un túnel: no repartir entre túneles
+		localPort = session.LocalPort
+	}
+	policy := getPolicy()
+	session.mu.Lock()
+	raw := session.Raw || rawRequested(r)
+	session.mu.Unlock()
+	// gRPC es binario y depende de sus headers y trailers: pasa sin reescritura
+	useHTTP2 := session.usesHTTP2(r)
+	raw = raw || isGRPC(r)
+	profile := sessionProfile(policy, session)
+	session.mu.Lock()
+	token := session.token
+	session.mu.Unlock()
+	if blockServiceWorker(w, r, profile) {
 		return
 	}
 
-	// Copiar headers importantes (excluir algunos que pueden causar problemas)
-	for key, values := range r.Header {
-		// Excluir headers de conexión y host
-		if key == "Connection" || key == "Upgrade" || key == "Host" {
-			continue
+	// Plazos de la petición: los de UPSTREAM_*_TIMEOUT o los que pide el cliente
+	timeouts := requestTimeouts(r)
+
+	// Construir la URL del pod local a partir de la ruta escapada
+	target := upstreamURL(fmt.Sprintf("localhost:%d", localPort), r.URL.EscapedPath(), r.URL.RawQuery)
+	target.Scheme = session.upstreamScheme()
+
+	slog.Debug("Proxying", "component", "proxyHTTP", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "target", target.String())
+
+	// La petición al pod se cancela si el cliente se desconecta o vence un plazo
+	ctx, deadline := startUpstreamDeadline(r.Context(), timeouts, target)
+	defer deadline.stop()
+
+	// Pool de conexiones de la sesión, o la conexión ya autenticada de esta
+	// conexión del navegador; gRPC y protocol=h2 van por el transporte HTTP/2
+	transport := &sessionRoundTripper{
+		base:      session.clientFor(r, raw).Transport,
+		session:   session,
+		localPort: localPort,
+		profile:   profile.Name,
+	}
+	if useHTTP2 {
+		transport.base = session.http2Transport()
+	}
+	proxy := &httputil.ReverseProxy{Transport: transport}
+
+	// Rewrite recibe la petición ya sin headers de conexión ni X-Forwarded-* del
+	// cliente y con el framing a regenerar a partir del cuerpo
+	proxy.Rewrite = func(pr *httputil.ProxyRequest) {
+		pr.Out.URL = target
+		// Host queda el del puerto local, como lo vería un cliente del pod
+		pr.Out.Host = ""
+		removeHopByHopHeaders(pr.Out.Header)
+		stripBackendCredentials(pr.Out.Header, r)
+		setForwardedHeaders(pr.Out.Header, r, sessionPrefix(session))
+		if pr.Out.Body != nil {
+			pr.Out.Body = readCloser{&transferCounter{pr.Out.Body, &session.BytesIn, session}, pr.Out.Body}
+		}
+
+		// Cuota, credenciales de la política y demás hooks pre-proxy. Si un hook
+		// responde, el transporte corta la petición sin enviarla.
+		if !runPreProxyHooks(w, r, pr.Out, session) {
+			transport.responded = true
+			return
 		}
-		for _, value := range values {
-			req.Header.Add(key, value)
+		if !raw {
+			applyProfileToRequest(profile, pr.Out, token)
 		}
+		addCounter("pod_forward_proxied_requests_total", map[string]string{"project": session.Project}, 1)
 	}
 
-	// Realizar la petición
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-	}
-	
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error al realizar petición: %v", err), http.StatusBadGateway)
-		return
-	}
-	defer resp.Body.Close()
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		session.noteUpstreamAuth(resp)
+		deadline.headersReceived(resp)
+		session.Timing.recordFirstByte(session.Project)
+		if !raw {
+			rewriteResponseHeaders(r, resp, session)
+		}
+
+		// Detectar headers que impiden mostrar la aplicación dentro del iframe de Argo CD
+		// Las descargas se devuelven tal cual aunque la navegación ocurra en el iframe
+		download := isAttachment(resp.Header)
+		if !raw && r.Method != http.MethodHead && (appConfig.SniffContentType || profile.SniffContentType) {
+			sniffContentType(resp)
+		}
+		if raw || download {
+			// En modo raw la respuesta del pod se devuelve tal cual
+		} else if appConfig.StripFrameHeaders || profile.StripFrameHeaders {
+			stripFrameHeaders(resp.Header)
+		} else if isFramedNavigation(r) {
+			if reason := embeddingBlockReason(resp.Header); reason != "" {
+				return &frameBlockedError{reason: reason}
+			}
+		}
 
-	// Copiar headers de respuesta (excluir algunos)
-	// Primero, buscar y modificar el header Location si existe
-	log.Printf("[proxyHTTP] Status Code: %d, Headers recibidos: %v", resp.StatusCode, resp.Header)
-	locationHeader := resp.Header.Get("Location")
-	log.Printf("[proxyHTTP] Location header obtenido: '%s'", locationHeader)
-	if locationHeader != "" {
+		slog.Debug("Respuesta del pod", "component", "proxyHTTP", "requestId", requestID(r.Context()), "status", resp.StatusCode)
 		// Si es un redirect relativo o absoluto, convertirlo a la ruta del proxy
-		location := locationHeader
-		if strings.HasPrefix(location, "/") {
-			// Redirect relativo: agregar el prefijo del proxy
-			location = "/api/v1/extensions/pod-forward" + location
-		} else if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
-			// Redirect absoluto: extraer el path y agregar el prefijo del proxy
-			// Parsear la URL
-			parsedURL, err := url.Parse(location)
-			if err == nil {
-				location = "/api/v1/extensions/pod-forward" + parsedURL.Path
-				if parsedURL.RawQuery != "" {
-					location += "?" + parsedURL.RawQuery
-				}
+		if location := resp.Header.Get("Location"); location != "" && !raw {
+			resp.Header.Set("Location", rewriteLocation(location, sessionPrefix(session), appHostsFor(r, profile)))
+			slog.Debug("Redirect modificado", "component", "proxyHTTP", "requestId", requestID(r.Context()), "location", location,
+				"rewritten", resp.Header.Get("Location"), "status", resp.StatusCode)
+		}
+		if !raw {
+			if err := trackRedirect(r, session, resp); err != nil {
+				return err
 			}
 		}
-		// IMPORTANTE: Usar Set en lugar de Add para Location (solo debe haber uno)
-		w.Header().Set("Location", location)
-		log.Printf("[proxyHTTP] Redirect modificado: %s -> %s (Status: %d)", locationHeader, location, resp.StatusCode)
-	} else {
-		log.Printf("[proxyHTTP] No se encontró header Location en la respuesta")
-	}
-	
-	for key, values := range resp.Header {
-		// Excluir headers de conexión y Location (ya lo manejamos arriba)
-		if key == "Connection" || key == "Upgrade" || key == "Location" {
-			continue
+
+		clearOwnPageHeaders(w.Header())
+		if !raw && appConfig.RewriteCookiePaths {
+			rewriteSetCookies(resp.Header, sessionPrefix(session))
 		}
-		
-		for _, value := range values {
-			w.Header().Add(key, value)
+		deadline.watchIdle(resp)
+		if !raw && !download {
+			resp.Body = readCloser{applyProfileToResponse(profile, r, resp, sessionPrefix(session)), resp.Body}
 		}
+		resp.Body = readCloser{&transferCounter{resp.Body, &session.BytesOut, session}, resp.Body}
+		// Descargas y streams se envían a medida que llegan
+		if download || isStreamingResponse(resp) {
+			proxy.FlushInterval = -1
+		}
+		return nil
 	}
 
-	log.Printf("[proxyHTTP] Respondiendo con Status: %d, Headers: %v", resp.StatusCode, w.Header())
-	w.WriteHeader(resp.StatusCode)
-
-	// Copiar el cuerpo de la respuesta
-	_, err = io.Copy(w, resp.Body)
-	if err != nil {
-		log.Printf("Error al copiar respuesta: %v", err)
+	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
+		var blocked *frameBlockedError
+		var loop *redirectLoopError
+		switch {
+		case errors.Is(err, errPreProxyResponded):
+		case errors.As(err, &blocked):
+			slog.Info("Respuesta no embebible, sirviendo página de ayuda", "component", "proxyHTTP", "requestId", requestID(r.Context()), "reason", blocked.reason)
+			serveFrameBlockedPage(rw, r, blocked.reason)
+		case errors.As(err, &loop):
+			serveRedirectLoopPage(rw, session, loop)
+		case timedOut(ctx) != nil:
+			http.Error(rw, fmt.Sprintf("Error al realizar petición: %v", timedOut(ctx)), http.StatusGatewayTimeout)
+		default:
+			http.Error(rw, fmt.Sprintf("Error al realizar petición: %v", err), http.StatusBadGateway)
+		}
 	}
+
+	// Si la copia del cuerpo se corta el ReverseProxy aborta la respuesta con
+	// http.ErrAbortHandler para que el cliente no la tome por completa
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered == http.ErrAbortHandler {
+				if cause := timedOut(ctx); cause != nil {
+					slog.Error("Error al copiar la respuesta", "component", "proxyHTTP", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path, "error", cause)
+				} else {
+					slog.Error("Error al copiar la respuesta", "component", "proxyHTTP", "requestId", requestID(r.Context()), "method", r.Method, "path", r.URL.Path)
+				}
+			}
+			panic(recovered)
+		}
+	}()
+	proxy.ServeHTTP(deadline.clientWriter(w), r.WithContext(ctx))
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Métodos aceptados por los endpoints que solo leen. HEAD se acepta donde se
+// acepta GET: net/http descarta el cuerpo de la respuesta.
+var readMethods = []string{http.MethodGet, http.MethodHead}
+
+// allowMethods responde 405 con el header Allow si el método de la petición no
+// está en methods, y devuelve si la petición puede seguir
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if slices.Contains(methods, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	return false
+}
+
+// methods envuelve un handler registrado en el mux con allowMethods
+func methods(handler http.HandlerFunc, allowed ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowMethods(w, r, allowed...) {
+			handler(w, r)
+		}
+	}
+}
+
+// sessionCreationParams son los parámetros de la API v1 que eligen el pod: si
+// alguno está presente la petición crea (o reutiliza) una sesión en lugar de ir al
+// pod de una sesión abierta
+var sessionCreationParams = []string{"namespace", "pod", "job", "cronjob", "selector", "workload", "service"}
+
+// isSessionCreation indica si la petición de la API v1 crea una sesión
+func isSessionCreation(query url.Values) bool {
+	for _, param := range sessionCreationParams {
+		if query.Get(param) != "" {
+			return true
+		}
+	}
+	return false
+}
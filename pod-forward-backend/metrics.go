@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Contadores en formato de exposición de Prometheus. Las etiquetas se guardan
+// ya serializadas para poder ordenarlas al exponerlas.
+var (
+	counters   = make(map[string]map[string]float64)
+	countersMu sync.Mutex
+)
+
+// metricLabels serializa etiquetas en el formato {k="v",...} con orden estable
+func metricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, k, v))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// hasLabel indica si una serie serializada con metricLabels tiene la etiqueta key=value
+func hasLabel(labels, key, value string) bool {
+	pair := metricLabels(map[string]string{key: value})
+	pair = pair[1 : len(pair)-1]
+	return strings.HasPrefix(labels, "{"+pair+",") || strings.HasPrefix(labels, "{"+pair+"}") ||
+		strings.Contains(labels, ","+pair+",") || strings.HasSuffix(labels, ","+pair+"}")
+}
+
+// addCounter incrementa un contador con las etiquetas indicadas
+func addCounter(name string, labels map[string]string, delta float64) {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	if counters[name] == nil {
+		counters[name] = make(map[string]float64)
+	}
+	counters[name][metricLabels(labels)] += delta
+}
+
+// handleMetrics expone los contadores y los gauges de sesiones por proyecto.
+// Un usuario de un proyecto que no es administrador solo ve las series de su proyecto.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	identity := identityFromRequest(r)
+	visible := func(labels string) bool {
+		return identity.Project == "" || identity.IsAdmin() || hasLabel(labels, "project", identity.Project)
+	}
+
+	// Gauge calculado en el momento a partir del registro de sesiones
+	perProject := map[string]int{}
+	wsPerProject := map[string]int{}
+	streamsPerProject := map[string]int{}
+	queuedPerProject := map[string]int{}
+	sessionsMu.RLock()
+	for _, sess := range activeSessions {
+		perProject[sess.Project]++
+		sess.mu.Lock()
+		wsPerProject[sess.Project] += sess.WSConns
+		sess.mu.Unlock()
+		open, queued := sess.streamUsage()
+		streamsPerProject[sess.Project] += open
+		queuedPerProject[sess.Project] += queued
+	}
+	sessionsMu.RUnlock()
+	projects := make([]string, 0, len(perProject))
+	for project := range perProject {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	writeGauge := func(name string, values map[string]int) {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, project := range projects {
+			labels := metricLabels(map[string]string{"project": project})
+			if !visible(labels) {
+				continue
+			}
+			fmt.Fprintf(w, "%s%s %d\n", name, labels, values[project])
+		}
+	}
+	writeGauge("pod_forward_active_sessions", perProject)
+	writeGauge("pod_forward_websocket_connections", wsPerProject)
+	writeGauge("pod_forward_spdy_streams", streamsPerProject)
+	writeGauge("pod_forward_spdy_streams_queued", queuedPerProject)
+	writeClusterMetrics(w)
+	writeLatencyMetrics(w, visible)
+
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		series := make([]string, 0, len(counters[name]))
+		for labels := range counters[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			if !visible(labels) {
+				continue
+			}
+			fmt.Fprintf(w, "%s%s %g\n", name, labels, counters[name][labels])
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// mockNamespace es el namespace de los pods simulados en MOCK_MODE
+const mockNamespace = "demo"
+
+// mockAppPort es el puerto en que escucha la aplicación de ejemplo de los pods simulados
+const mockAppPort = 8080
+
+// mockCluster simula el API server de Kubernetes en MOCK_MODE: pods de ejemplo con
+// su port-forward, ConfigMaps en memoria y SubjectAccessReviews siempre permitidas.
+// El resto del backend no sabe que no hay cluster.
+type mockCluster struct {
+	pods map[string]*corev1.Pod
+	// apps es la aplicación de ejemplo de cada pod, servida sin abrir puertos
+	apps map[string]*pipeListener
+
+	mu              sync.Mutex
+	configMaps      map[string]*corev1.ConfigMap
+	resourceVersion int
+}
+
+// startMockCluster levanta el API server simulado en loopback y devuelve la
+// configuración para conectarse a él
+func startMockCluster() (*rest.Config, error) {
+	mock := &mockCluster{
+		pods:       make(map[string]*corev1.Pod),
+		apps:       make(map[string]*pipeListener),
+		configMaps: make(map[string]*corev1.ConfigMap),
+	}
+	for _, name := range []string{"sample-app-0", "sample-app-1"} {
+		mock.pods[name] = mockPod(name)
+		listener := newPipeListener()
+		mock.apps[name] = listener
+		// La aplicación acepta también h2c, para probar gRPC y protocol=h2
+		go http.Serve(listener, h2c.NewHandler(mockSampleApp(name), &http2.Server{}))
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		fatal("Error en el API server simulado", "component", "mock", "error", http.Serve(listener, mock))
+	}()
+	slog.Info("MOCK_MODE activo: API server simulado", "component", "mock", "addr", listener.Addr().String(),
+		"pods", []string{mockNamespace + "/sample-app-0", mockNamespace + "/sample-app-1"}, "port", mockAppPort)
+	return &rest.Config{Host: "http://" + listener.Addr().String()}, nil
+}
+
+// mockPod arma un pod listo con el puerto de la aplicación de ejemplo
+func mockPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         mockNamespace,
+			Labels:            map[string]string{"app": "sample-app"},
+			CreationTimestamp: metav1.Now(),
+			ResourceVersion:   "1",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "app",
+				Image: "pod-forward-backend/sample-app:mock",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: mockAppPort, Protocol: corev1.ProtocolTCP}},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "app",
+				Ready: true,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}},
+			}},
+			PodIP: "10.0.0.1",
+		},
+	}
+}
+
+// mockRequest son las partes de una ruta de la API de Kubernetes
+type mockRequest struct {
+	Group       string
+	Version     string
+	Namespace   string
+	Resource    string
+	Name        string
+	Subresource string
+}
+
+// mockDiscovery son los recursos que el cluster simulado publica en la discovery
+var mockDiscovery = map[string][]string{
+	"/api/v1":                   {"pods", "pods/portforward", "pods/exec", "configmaps", "services", "secrets", "events"},
+	"/apis/batch/v1":            {"jobs", "cronjobs"},
+	"/apis/discovery.k8s.io/v1": {"endpointslices"},
+}
+
+// parseMockPath interpreta /api/v1/... y /apis/<grupo>/<versión>/...
+func parseMockPath(path string) (mockRequest, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	var req mockRequest
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		req.Version, parts = parts[1], parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		req.Group, req.Version, parts = parts[1], parts[2], parts[3:]
+	default:
+		return req, false
+	}
+	if len(parts) >= 2 && parts[0] == "namespaces" && len(parts) != 2 {
+		req.Namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) == 0 {
+		return req, false
+	}
+	req.Resource = parts[0]
+	if len(parts) > 1 {
+		req.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		req.Subresource = parts[2]
+	}
+	return req, true
+}
+
+func (m *mockCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/version" {
+		writeMockJSON(w, http.StatusOK, map[string]string{"major": "1", "minor": "28", "gitVersion": "v1.28.0-mock", "platform": "mock"})
+		return
+	}
+	if resources, ok := mockDiscovery[r.URL.Path]; ok {
+		list := metav1.APIResourceList{TypeMeta: metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"}, GroupVersion: strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/"), "/apis/")}
+		for _, name := range resources {
+			list.APIResources = append(list.APIResources, metav1.APIResource{Name: name, Namespaced: true})
+		}
+		writeMockJSON(w, http.StatusOK, list)
+		return
+	}
+	req, ok := parseMockPath(r.URL.Path)
+	if !ok {
+		writeMockError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		return
+	}
+
+	// Los watch quedan abiertos sin eventos: nada cambia en el cluster simulado
+	if r.URL.Query().Get("watch") == "true" || r.URL.Query().Get("watch") == "1" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+		return
+	}
+
+	switch {
+	case req.Group == "" && req.Resource == "pods":
+		m.servePods(w, r, req)
+	case req.Group == "" && req.Resource == "configmaps":
+		m.serveConfigMaps(w, r, req)
+	case req.Group == "" && req.Resource == "services" && req.Name != "":
+		if req.Namespace == appConfig.ArgoCDNamespace && req.Name == appConfig.ArgoCDServerService {
+			// Con el Service de Argo CD el backend confía en los headers Argocd-*
+			writeMockJSON(w, http.StatusOK, &corev1.Service{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+				ObjectMeta: metav1.ObjectMeta{Name: req.Name, Namespace: req.Namespace},
+			})
+			return
+		}
+		writeMockError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "services"}, req.Name))
+	case req.Group == "" && req.Resource == "events" && r.Method == http.MethodPost:
+		// Los Events (AUDIT_LOG=events) se aceptan y se muestran en el log
+		var event corev1.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			writeMockError(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		slog.Info("Event creado", "component", "mock", "reason", event.Reason, "namespace", event.InvolvedObject.Namespace, "name", event.InvolvedObject.Name, "message", event.Message)
+		writeMockJSON(w, http.StatusCreated, &event)
+	case req.Group == "authorization.k8s.io" && r.Method == http.MethodPost:
+		// SubjectAccessReview y SelfSubjectAccessReview: todo está permitido
+		var review map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			writeMockError(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		review["status"] = map[string]interface{}{"allowed": true, "reason": "MOCK_MODE"}
+		writeMockJSON(w, http.StatusCreated, review)
+	case r.Method == http.MethodGet && req.Name == "":
+		// Cualquier otra lista está vacía; los grupos de CRDs necesitan kind para el
+		// cliente dinámico
+		list := map[string]interface{}{"metadata": map[string]string{"resourceVersion": "1"}, "items": []interface{}{}}
+		if strings.Contains(req.Group, ".") && !strings.HasSuffix(req.Group, ".k8s.io") {
+			list["apiVersion"], list["kind"] = req.Group+"/"+req.Version, "List"
+		}
+		writeMockJSON(w, http.StatusOK, list)
+	default:
+		writeMockError(w, apierrors.NewNotFound(schema.GroupResource{Group: req.Group, Resource: req.Resource}, req.Name))
+	}
+}
+
+// servePods atiende get y list de pods y el port-forward
+func (m *mockCluster) servePods(w http.ResponseWriter, r *http.Request, req mockRequest) {
+	if req.Name == "" {
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			writeMockError(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		list := &corev1.PodList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+		if req.Namespace == "" || req.Namespace == mockNamespace {
+			for _, name := range []string{"sample-app-0", "sample-app-1"} {
+				if pod := m.pods[name]; selector.Matches(labels.Set(pod.Labels)) {
+					list.Items = append(list.Items, *pod)
+				}
+			}
+		}
+		writeMockJSON(w, http.StatusOK, list)
+		return
+	}
+	pod, ok := m.pods[req.Name]
+	if !ok || req.Namespace != mockNamespace {
+		writeMockError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, req.Name))
+		return
+	}
+	switch req.Subresource {
+	case "":
+		writeMockJSON(w, http.StatusOK, pod)
+	case "portforward":
+		m.servePortForward(w, r, req.Name)
+	default:
+		writeMockError(w, apierrors.NewMethodNotSupported(schema.GroupResource{Resource: "pods/" + req.Subresource}, r.Method))
+	}
+}
+
+// servePortForward implementa el protocolo portforward.k8s.io sobre SPDY, como el
+// kubelet: cada conexión del cliente abre un stream de error y uno de datos, y los
+// datos se conectan con la aplicación de ejemplo del pod
+func (m *mockCluster) servePortForward(w http.ResponseWriter, r *http.Request, pod string) {
+	if _, err := httpstream.Handshake(r, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+		return
+	}
+	streams := make(chan httpstream.Stream, 4)
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		streams <- stream
+		return nil
+	})
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	errorStreams := make(map[string]httpstream.Stream)
+	for {
+		select {
+		case <-conn.CloseChan():
+			return
+		case stream := <-streams:
+			requestID := stream.Headers().Get(corev1.PortForwardRequestIDHeader)
+			switch stream.Headers().Get(corev1.StreamType) {
+			case corev1.StreamTypeError:
+				errorStreams[requestID] = stream
+			case corev1.StreamTypeData:
+				errorStream := errorStreams[requestID]
+				delete(errorStreams, requestID)
+				go m.forwardMockStream(pod, stream, errorStream)
+			default:
+				stream.Reset()
+			}
+		}
+	}
+}
+
+// forwardMockStream copia un stream de datos hacia la aplicación de ejemplo del pod
+func (m *mockCluster) forwardMockStream(pod string, data, errorStream httpstream.Stream) {
+	defer data.Close()
+	if errorStream != nil {
+		defer errorStream.Close()
+	}
+	port, _ := strconv.Atoi(data.Headers().Get(corev1.PortHeader))
+	if port != mockAppPort {
+		if errorStream != nil {
+			fmt.Fprintf(errorStream, "el pod simulado %s solo escucha en el puerto %d", pod, mockAppPort)
+		}
+		return
+	}
+	app := m.apps[pod].dial()
+	go func() {
+		io.Copy(app, data)
+		app.Close()
+	}()
+	io.Copy(data, app)
+}
+
+// serveConfigMaps guarda en memoria los ConfigMaps del backend (sesiones, links,
+// plantillas)
+func (m *mockCluster) serveConfigMaps(w http.ResponseWriter, r *http.Request, req mockRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := req.Namespace + "/" + req.Name
+	switch {
+	case r.Method == http.MethodGet && req.Name != "":
+		cm, ok := m.configMaps[key]
+		if !ok {
+			writeMockError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, req.Name))
+			return
+		}
+		writeMockJSON(w, http.StatusOK, cm)
+	case r.Method == http.MethodPost || r.Method == http.MethodPut:
+		var cm corev1.ConfigMap
+		if err := json.NewDecoder(r.Body).Decode(&cm); err != nil {
+			writeMockError(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		cm.Namespace = req.Namespace
+		key = req.Namespace + "/" + cm.Name
+		existing, exists := m.configMaps[key]
+		status := http.StatusOK
+		switch {
+		case r.Method == http.MethodPost && exists:
+			writeMockError(w, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, cm.Name))
+			return
+		case r.Method == http.MethodPut && !exists:
+			writeMockError(w, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, cm.Name))
+			return
+		case r.Method == http.MethodPut && cm.ResourceVersion != "" && cm.ResourceVersion != existing.ResourceVersion:
+			writeMockError(w, apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, cm.Name, fmt.Errorf("resourceVersion desactualizado")))
+			return
+		case r.Method == http.MethodPost:
+			status = http.StatusCreated
+		}
+		m.resourceVersion++
+		cm.ResourceVersion = strconv.Itoa(m.resourceVersion)
+		m.configMaps[key] = &cm
+		writeMockJSON(w, status, &cm)
+	default:
+		writeMockError(w, apierrors.NewMethodNotSupported(schema.GroupResource{Resource: "configmaps"}, r.Method))
+	}
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeMockError(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.APIVersion, status.Kind = "v1", "Status"
+	writeMockJSON(w, int(status.Code), status)
+}
+
+// pipeListener entrega a un http.Server conexiones en memoria, para servir la
+// aplicación de ejemplo de cada pod sin abrir puertos
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// dial devuelve el extremo cliente de una conexión nueva
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+	case <-l.closed:
+		server.Close()
+	}
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mockAppPort}
+}
+
+// mockSampleApp es la aplicación que sirven los pods simulados. Usa rutas absolutas,
+// redirects, cookies y Server-Sent Events para ejercitar la reescritura del proxy.
+func mockSampleApp(pod string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sample-visit", Value: strconv.FormatInt(time.Now().Unix(), 10), Path: "/"})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Sample app - %[1]s</title>
+    <meta charset="utf-8">
+    <link rel="stylesheet" href="/static/style.css">
+</head>
+<body>
+    <h1>Sample app</h1>
+    <p>Pod simulado <strong>%[1]s</strong> en el namespace %[2]s (MOCK_MODE).</p>
+    <ul>
+        <li><a href="/api/info">/api/info</a> (JSON)</li>
+        <li><a href="/redirect">/redirect</a> (redirect a /)</li>
+        <li><a href="/loop">/loop</a> (bucle de redirects)</li>
+        <li><a href="/events">/events</a> (Server-Sent Events)</li>
+    </ul>
+    <p>Hora del pod: <span id="clock">-</span></p>
+    <script src="/static/app.js"></script>
+</body>
+</html>`, html.EscapeString(pod), mockNamespace)
+	})
+	mux.HandleFunc("/static/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		io.WriteString(w, "body { font-family: sans-serif; margin: 2em; }\n")
+	})
+	mux.HandleFunc("/static/app.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		io.WriteString(w, `new EventSource("/events").onmessage = function (e) { document.getElementById("clock").textContent = e.data; };`+"\n")
+	})
+	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{
+			"pod":       pod,
+			"namespace": mockNamespace,
+			"method":    r.Method,
+			"path":      r.URL.Path,
+			"headers":   r.Header,
+		})
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	// /loop redirige a sí mismo, para ver la detección de bucles
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		controller := http.NewResponseController(w)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			fmt.Fprintf(w, "data: %s\n\n", time.Now().Format(time.TimeOnly))
+			if controller.Flush() != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+	return mux
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcSessionCookie = "pod_forward_session"
+	oidcStateCookie   = "pod_forward_oidc_state"
+	// oidcStateTTL es el tiempo que tiene el usuario para completar el login en el IdP
+	oidcStateTTL = 10 * time.Minute
+)
+
+// oidcProvider implementa el flujo authorization code contra un IdP OIDC y guarda
+// la identidad del usuario en una cookie firmada con HMAC. El descubrimiento, el
+// JWKS y la validación del ID token los resuelve go-oidc; el intercambio del código,
+// oauth2.
+type oidcProvider struct {
+	issuer       string
+	oauth        oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	cookieSecret []byte
+	httpClient   *http.Client
+}
+
+// oidcSession es el contenido de la cookie de sesión
+type oidcSession struct {
+	User    string   `json:"u"`
+	Groups  []string `json:"g,omitempty"`
+	Expires int64    `json:"e"`
+}
+
+// oidcState es el contenido de la cookie que acompaña la redirección al IdP
+type oidcState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Redirect string `json:"r"`
+	Expires  int64  `json:"e"`
+}
+
+// newOIDCProvider lee el documento de descubrimiento del issuer configurado
+func newOIDCProvider(ctx context.Context) (*oidcProvider, error) {
+	// La redirect_uri tiene que ser absoluta y no puede salir de headers del cliente
+	if appConfig.OIDCRedirectURL == "" && appConfig.ExternalURL == "" && len(appConfig.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("OIDC requiere OIDC_REDIRECT_URL, EXTERNAL_URL o TRUSTED_PROXIES")
+	}
+	p := &oidcProvider{
+		issuer:     appConfig.OIDCIssuerURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	// NewProvider verifica que el issuer del descubrimiento coincida con el configurado
+	provider, err := oidc.NewProvider(p.clientContext(ctx), p.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el descubrimiento OIDC: %v", err)
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: appConfig.OIDCClientID})
+	p.oauth = oauth2.Config{
+		ClientID:     appConfig.OIDCClientID,
+		ClientSecret: appConfig.OIDCClientSecret,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       appConfig.OIDCScopes,
+	}
+
+	if appConfig.OIDCCookieSecret != "" {
+		p.cookieSecret = []byte(appConfig.OIDCCookieSecret)
+	} else {
+		// Sin secreto configurado las sesiones no sobreviven a un reinicio ni se comparten entre réplicas
+		slog.Warn("OIDC_COOKIE_SECRET no configurado, usando un secreto aleatorio", "component", "oidc")
+		p.cookieSecret = make([]byte, 32)
+		if _, err := rand.Read(p.cookieSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	slog.Info("Proveedor OIDC configurado", "component", "oidc", "issuer", p.issuer)
+	return p, nil
+}
+
+// callbackPath y logoutPath son las rutas propias del login, bajo el prefijo del proxy
+func oidcCallbackPath() string { return extensionBasePath + "/auth/callback" }
+func oidcLogoutPath() string   { return extensionBasePath + "/auth/logout" }
+
+// clientContext hace que go-oidc y oauth2 usen el cliente HTTP con timeout
+func (p *oidcProvider) clientContext(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, p.httpClient)
+}
+
+// redirectURL es la URL registrada en el IdP para volver al backend
+func (p *oidcProvider) redirectURL(r *http.Request) string {
+	if appConfig.OIDCRedirectURL != "" {
+		return appConfig.OIDCRedirectURL
+	}
+	return externalBaseURL(r) + oidcCallbackPath()
+}
+
+func (p *oidcProvider) Name() string { return "oidc" }
+
+// Authenticate lee la identidad de la cookie de sesión
+func (p *oidcProvider) Authenticate(r *http.Request) (RequestIdentity, error) {
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return RequestIdentity{}, errNoCredentials
+	}
+	var session oidcSession
+	// Una cookie inválida o vencida se trata como ausente para volver a iniciar el login
+	if p.decodeCookie(cookie.Value, &session) != nil || time.Now().Unix() >= session.Expires {
+		return RequestIdentity{}, errNoCredentials
+	}
+	return RequestIdentity{User: session.User, Groups: session.Groups}, nil
+}
+
+// ServeAuthRoute atiende el callback y el logout
+func (p *oidcProvider) ServeAuthRoute(w http.ResponseWriter, r *http.Request) bool {
+	switch r.URL.Path {
+	case oidcCallbackPath():
+		p.handleCallback(w, r)
+	case oidcLogoutPath():
+		p.handleLogout(w, r)
+	default:
+		return false
+	}
+	return true
+}
+
+// Challenge redirige al IdP solo las navegaciones del navegador; las APIs reciben 401
+func (p *oidcProvider) Challenge(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	p.startLogin(w, r)
+	return true
+}
+
+// startLogin redirige al IdP guardando state, nonce y la URL original en una cookie firmada
+func (p *oidcProvider) startLogin(w http.ResponseWriter, r *http.Request) {
+	stateToken, err := randomToken()
+	if err != nil {
+		slog.Error("Error al generar el state", "component", "oidc", "error", err)
+		http.Error(w, "Error al iniciar el login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		slog.Error("Error al generar el nonce", "component", "oidc", "error", err)
+		http.Error(w, "Error al iniciar el login", http.StatusInternalServerError)
+		return
+	}
+	state := oidcState{
+		State:    stateToken,
+		Nonce:    nonce,
+		Redirect: r.URL.RequestURI(),
+		Expires:  time.Now().Add(oidcStateTTL).Unix(),
+	}
+	value, err := p.encodeCookie(state)
+	if err != nil {
+		http.Error(w, "Error al iniciar el login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcCallbackPath(),
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := p.oauth.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.SetAuthURLParam("redirect_uri", p.redirectURL(r)))
+	slog.Debug("Redirigiendo al IdP", "component", "oidc", "redirect", state.Redirect)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleCallback intercambia el código por el ID token y crea la cookie de sesión
+func (p *oidcProvider) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var state oidcState
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || p.decodeCookie(cookie.Value, &state) != nil || time.Now().Unix() > state.Expires {
+		http.Error(w, "Login expirado, vuelve a intentarlo", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("state")), []byte(state.State)) {
+		http.Error(w, "State inválido", http.StatusBadRequest)
+		return
+	}
+	if idpErr := r.URL.Query().Get("error"); idpErr != "" {
+		slog.Info("El IdP rechazó el login", "component", "oidc", "error", idpErr)
+		http.Error(w, "Login rechazado por el proveedor de identidad", http.StatusForbidden)
+		return
+	}
+
+	ctx := p.clientContext(r.Context())
+	token, err := p.oauth.Exchange(ctx, r.URL.Query().Get("code"), oauth2.SetAuthURLParam("redirect_uri", p.redirectURL(r)))
+	if err != nil {
+		slog.Error("Error al intercambiar el código", "component", "oidc", "error", err)
+		http.Error(w, "Error al completar el login", http.StatusBadGateway)
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		slog.Error("La respuesta del token endpoint no incluye id_token", "component", "oidc")
+		http.Error(w, "Error al completar el login", http.StatusBadGateway)
+		return
+	}
+	// Verify valida firma, issuer, audiencia y expiración; el nonce se compara aquí
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err == nil && !hmac.Equal([]byte(idToken.Nonce), []byte(state.Nonce)) {
+		err = fmt.Errorf("nonce inválido")
+	}
+	var claims map[string]interface{}
+	if err == nil {
+		err = idToken.Claims(&claims)
+	}
+	if err != nil {
+		slog.Warn("ID token inválido", "component", "oidc", "error", err)
+		http.Error(w, "Error al completar el login", http.StatusUnauthorized)
+		return
+	}
+
+	session := oidcSession{
+		User:    claimString(claims, appConfig.OIDCUserClaim),
+		Groups:  claimStrings(claims, appConfig.OIDCGroupsClaim),
+		Expires: time.Now().Add(appConfig.OIDCSessionTTL).Unix(),
+	}
+	if session.User == "" {
+		session.User = claimString(claims, "sub")
+	}
+	value, err := p.encodeCookie(session)
+	if err != nil {
+		http.Error(w, "Error al completar el login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCallbackPath(), MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(appConfig.OIDCSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Token de doble envío para las peticiones mutantes de esta sesión (ver csrfProtect)
+	if _, err := setCSRFCookie(w, r, session.User); err != nil {
+		slog.Error("Error al generar el token CSRF", "component", "oidc", "error", err)
+		http.Error(w, "Error al completar el login", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Login completado", "component", "oidc", "user", session.User, "groups", session.Groups)
+
+	// Volver solo a rutas locales para no convertir el callback en un open redirect
+	redirect := state.Redirect
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = extensionBasePath + "/"
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// handleLogout borra la cookie de sesión. Solo acepta POST: un GET lo podría
+// disparar cualquier página con un <img>.
+func (p *oidcProvider) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	// El logout se atiende antes de csrfProtect: una página de otro sitio no debe
+	// poder cerrar la sesión
+	if !sameOriginRequest(r) {
+		http.Error(w, "Petición de otro origen rechazada", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcSessionCookie, Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Path: "/", MaxAge: -1})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Sesión cerrada")
+}
+
+// encodeCookie serializa el valor como <json base64>.<hmac base64>
+func (p *oidcProvider) encodeCookie(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + p.sign(payload), nil
+}
+
+// decodeCookie verifica la firma antes de deserializar
+func (p *oidcProvider) decodeCookie(value string, v interface{}) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(p.sign(payload))) {
+		return fmt.Errorf("firma de cookie inválida")
+	}
+	return decodeSegment(payload, v)
+}
+
+func (p *oidcProvider) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.cookieSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// decodeSegment decodifica un segmento base64url con JSON
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("segmento inválido: %v", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimString devuelve un claim de tipo string o vacío
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimStrings acepta claims string o lista de strings (como aud y groups)
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// randomToken genera un valor aleatorio para state y nonce
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
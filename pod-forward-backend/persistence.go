@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const persistedSessionsKey = "sessions.json"
+
+// persistedSession es lo necesario para recrear una sesión después de un reinicio
+type persistedSession struct {
+	Project   string `json:"project,omitempty"`
+	User      string `json:"user,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Port      int    `json:"port"`
+	Profile   string `json:"profile,omitempty"`
+	Raw       bool   `json:"raw,omitempty"`
+	Helper    string `json:"helper,omitempty"`
+	// ClientTokens mantiene la idempotencia de la creación después de un reinicio
+	ClientTokens map[string]string `json:"clientTokens,omitempty"`
+	Tunnels      int               `json:"tunnels,omitempty"`
+	// HTTPS, InsecureSkipVerify y CASecret son las opciones de scheme=https
+	HTTPS              bool   `json:"https,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	CASecret           string `json:"caSecret,omitempty"`
+	HTTP2              bool   `json:"http2,omitempty"`
+}
+
+// sessionStore guarda las sesiones activas en un ConfigMap
+type sessionStore struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+	dirty     chan struct{}
+}
+
+// persistence es nil cuando la persistencia está deshabilitada
+var persistence *sessionStore
+
+// restoreProgress reporta el avance de la restauración inicial en /readyz
+var restoreProgress struct {
+	total    atomic.Int32
+	restored atomic.Int32
+	failed   atomic.Int32
+	finished atomic.Bool
+}
+
+// startSessionPersistence habilita la escritura del ConfigMap de sesiones
+func startSessionPersistence(clientset *kubernetes.Clientset) {
+	persistence = &sessionStore{
+		clientset: clientset,
+		namespace: appConfig.PodNamespace,
+		name:      appConfig.PersistenceConfigMap,
+		dirty:     make(chan struct{}, 1),
+	}
+	goTask("persistence", persistence.run)
+}
+
+// persistSessions pide guardar el estado actual de las sesiones. Las escrituras se
+// agrupan: varias llamadas seguidas generan una sola actualización del ConfigMap.
+// Durante el apagado no se guarda nada, para que la próxima réplica restaure las
+// sesiones que se cierran al terminar.
+func persistSessions() {
+	if persistence == nil || !restoreProgress.finished.Load() || shuttingDown.Load() {
+		return
+	}
+	select {
+	case persistence.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (st *sessionStore) run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-st.dirty:
+		}
+		if err := st.save(ctx); err != nil {
+			slog.Error("Error al guardar sesiones", "component", "persistence", "error", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (st *sessionStore) save(ctx context.Context) error {
+	sessionsMu.RLock()
+	snapshot := make([]persistedSession, 0, len(activeSessions))
+	for _, sess := range activeSessions {
+		sess.mu.Lock()
+		snapshot = append(snapshot, persistedSession{
+			Project:   sess.Project,
+			User:      sess.User,
+			Cluster:   sess.Cluster,
+			Namespace: sess.Namespace,
+			Pod:       sess.Pod,
+			Port:      sess.Port,
+			Profile:   sess.Profile,
+			Raw:       sess.Raw,
+			Helper:    sess.Helper,
+			// Copia: el mapa se serializa fuera del lock de la sesión
+			ClientTokens: maps.Clone(sess.ClientTokens),
+			Tunnels:      sess.TunnelsWanted,
+
+			HTTPS:              sess.TLS.Enabled,
+			InsecureSkipVerify: sess.TLS.InsecureSkipVerify,
+			CASecret:           sess.TLS.CASecret,
+			HTTP2:              sess.HTTP2,
+		})
+		sess.mu.Unlock()
+	}
+	sessionsMu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	configMaps := st.clientset.CoreV1().ConfigMaps(st.namespace)
+	cm, err := configMaps.Get(ctx, st.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      st.name,
+				Namespace: st.namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "pod-forward-backend"},
+			},
+			Data: map[string]string{persistedSessionsKey: string(data)},
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[persistedSessionsKey] = string(data)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (st *sessionStore) load(ctx context.Context) ([]persistedSession, error) {
+	cm, err := st.clientset.CoreV1().ConfigMaps(st.namespace).Get(ctx, st.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessions []persistedSession
+	if raw := cm.Data[persistedSessionsKey]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &sessions); err != nil {
+			return nil, fmt.Errorf("contenido inválido en %s/%s: %v", st.namespace, st.name, err)
+		}
+	}
+	return sessions, nil
+}
+
+// restoreSessions recrea las sesiones guardadas en paralelo, con un máximo de
+// RESTORE_CONCURRENCY a la vez y un plazo de RESTORE_TIMEOUT por sesión
+func restoreSessions(ctx context.Context) error {
+	defer restoreProgress.finished.Store(true)
+
+	sessions, err := persistence.load(ctx)
+	if err != nil {
+		return fmt.Errorf("error al leer sesiones guardadas: %v", err)
+	}
+	restoreProgress.total.Store(int32(len(sessions)))
+	slog.Info("Restaurando sesiones", "component", "restoreSessions", "sessions", len(sessions), "concurrency", appConfig.RestoreConcurrency)
+
+	sem := make(chan struct{}, appConfig.RestoreConcurrency)
+	var wg sync.WaitGroup
+	for _, saved := range sessions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(saved persistedSession) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(ctx, appConfig.RestoreTimeout)
+			defer cancel()
+			key := buildSessionKey(saved.Project, saved.User, saved.Cluster, saved.Namespace, saved.Pod, saved.Port)
+			if err := restoreSession(ctx, key, saved); err != nil {
+				restoreProgress.failed.Add(1)
+				slog.Warn("No se pudo restaurar la sesión", "component", "restoreSessions", "key", key, "error", err)
+				if saved.Helper != "" {
+					deleteHelperPod(saved.Namespace, saved.Pod)
+				}
+				return
+			}
+			restoreProgress.restored.Add(1)
+		}(saved)
+	}
+	wg.Wait()
+
+	slog.Info("Restauración finalizada", "component", "restoreSessions",
+		"restored", restoreProgress.restored.Load(), "failed", restoreProgress.failed.Load())
+	// Guardar el resultado para descartar las sesiones que no se pudieron restaurar
+	restoreProgress.finished.Store(true)
+	persistSessions()
+	return nil
+}
+
+// restoreSession recrea una sesión guardada en su cluster con sus opciones
+func restoreSession(ctx context.Context, key string, saved persistedSession) error {
+	kube, _, err := resolveCluster(ctx, saved.Cluster)
+	if err != nil {
+		return err
+	}
+	scheme := ""
+	if saved.HTTPS {
+		scheme = "https"
+	}
+	tlsOpts, _, err := loadUpstreamTLS(ctx, kube.Clientset, saved.Namespace, scheme, saved.InsecureSkipVerify, saved.CASecret)
+	if err != nil {
+		return err
+	}
+	session, err := getOrCreateSession(ctx, key, saved.Project, saved.User, kube, saved.Namespace, saved.Pod, saved.Port)
+	if err != nil {
+		return err
+	}
+	configureSession(ctx, kube.Clientset, session, saved.Profile, saved.Raw)
+	session.setUpstreamTLS(tlsOpts)
+	session.setHTTP2(saved.HTTP2)
+	session.mu.Lock()
+	session.Helper = saved.Helper
+	session.ClientTokens = saved.ClientTokens
+	session.mu.Unlock()
+	session.ensureTunnels(ctx, saved.Tunnels, kube.Clientset, kube.Config)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Cada sesión se sirve bajo su propio prefijo, <prefijo del proxy>/s/<id>/, al que
+// redirige la petición que la abre. Las rutas relativas de la aplicación y los
+// redirects, cookies y URLs que reescribe el proxy quedan bajo ese prefijo, así cada
+// pestaña sigue en su sesión aunque el mismo navegador tenga otras abiertas. Las
+// peticiones al prefijo del proxy sin el ID (rutas que la aplicación arma con su
+// propia URL base) van a la sesión de la página que las hizo, según el Referer.
+
+// sessionPathSegment separa el prefijo del proxy del ID de la sesión
+const sessionPathSegment = "/s/"
+
+// targetParams son los parámetros de la query que eligen el target y las opciones de
+// la sesión; no se repiten en el redirect al prefijo de la sesión
+var targetParams = []string{
+	"cluster", "namespace", "pod", "port", "job", "cronjob", "selector", "workload", "service",
+	"strategy", "zone", "endpoint", "wait", "timeout", "profile", "raw", "tunnels",
+	"scheme", "caSecret", "insecureSkipVerify", "protocol",
+}
+
+// sessionPrefix es el prefijo bajo el que se sirve la sesión
+func sessionPrefix(session *PortForwardSession) string {
+	return extensionBasePath + sessionPathSegment + session.ID
+}
+
+// splitSessionPath separa una ruta escapada <prefijo del proxy>/s/<id>[/resto] en
+// el ID y el resto; ok es false si la ruta no es de una sesión
+func splitSessionPath(escapedPath string) (id, rest string, ok bool) {
+	tail, found := strings.CutPrefix(escapedPath, extensionBasePath+sessionPathSegment)
+	if !found {
+		return "", "", false
+	}
+	id, rest, _ = strings.Cut(tail, "/")
+	if id == "" {
+		return "", "", false
+	}
+	return id, "/" + rest, true
+}
+
+// sessionLocation es la URL de la sesión que corresponde a la petición que la abrió:
+// la misma ruta del pod bajo el prefijo de la sesión, sin los parámetros del target
+func sessionLocation(session *PortForwardSession, r *http.Request) string {
+	location := sessionPrefix(session) + upstreamPath(r.URL.EscapedPath())
+	query := r.URL.Query()
+	for _, param := range targetParams {
+		query.Del(param)
+	}
+	if len(query) > 0 {
+		location += "?" + query.Encode()
+	}
+	return location
+}
+
+// refererSessionID devuelve el ID de la sesión de la página que hizo la petición, o
+// vacío si el Referer no es una ruta de sesión
+func refererSessionID(r *http.Request) string {
+	referer, err := url.Parse(r.Referer())
+	if err != nil {
+		return ""
+	}
+	id, _, _ := splitSessionPath(referer.EscapedPath())
+	return id
+}
+
+// ownedSession devuelve la sesión con ese ID si sigue activa y es del usuario o de
+// un link que el usuario abrió. Un ID de otro usuario o de una sesión cerrada se
+// ignora, aunque el usuario pueda verla como operator o admin.
+func ownedSession(id string, identity RequestIdentity) *PortForwardSession {
+	if id == "" {
+		return nil
+	}
+	session := findSessionByID(id)
+	if session == nil || session.ID != id {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	owner := session.Project == identity.Project && session.User == identity.User
+	if session.PF == nil || (!owner && !session.guests[linkGuestKey(identity)]) {
+		return nil
+	}
+	return session
+}
+
+// serveSessionPath atiende las rutas <prefijo del proxy>/s/<id>/...: siempre van a
+// esa sesión, aunque la aplicación use parámetros llamados namespace, pod o port
+func serveSessionPath(w http.ResponseWriter, r *http.Request, identity RequestIdentity, id string) {
+	session := ownedSession(id, identity)
+	if session == nil {
+		// La sesión se cerró por superar la cuota de bytes
+		if exceeded, ok := quotaExceededFor(id); ok {
+			serveQuotaExceededPage(w, exceeded)
+			return
+		}
+		http.Error(w, "La sesión ya no existe; ábrela de nuevo desde Argo CD", http.StatusNotFound)
+		return
+	}
+	touchSession(session)
+	proxyHTTP(w, r, session)
+}
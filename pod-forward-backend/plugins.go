@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Los rewriters externos (REWRITER_PLUGINS) son ejecutables que el backend
+// arranca una vez y mantiene vivos. El protocolo es una línea JSON por mensaje:
+// por stdin reciben un rewriterMessage por cada petición al pod (phase=request)
+// y por cada respuesta (phase=response), y por stdout contestan un rewriterReply
+// en una línea. Lo que escriben en stderr va al log del backend. Así una
+// organización agrega headers propios (por ejemplo de su SSO interno) sin
+// mantener un fork.
+
+// rewriterMessage es lo que recibe el plugin en cada fase
+type rewriterMessage struct {
+	Phase   string          `json:"phase"`
+	Session rewriterSession `json:"session"`
+	Method  string          `json:"method"`
+	Path    string          `json:"path"`
+	Query   string          `json:"query,omitempty"`
+	// Status es el código de la respuesta del pod; solo en phase=response
+	Status  int         `json:"status,omitempty"`
+	Headers http.Header `json:"headers"`
+}
+
+// rewriterSession identifica la sesión y el usuario para el plugin
+type rewriterSession struct {
+	ID        string `json:"id"`
+	Project   string `json:"project,omitempty"`
+	User      string `json:"user,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Port      int    `json:"port"`
+}
+
+// rewriterReply es la respuesta del plugin. SetHeaders reemplaza y RemoveHeaders
+// quita headers de la petición al pod o de la respuesta al navegador. Con Reject
+// (solo en phase=request) la petición no llega al pod y el navegador recibe ese
+// código con Message.
+type rewriterReply struct {
+	SetHeaders    map[string]string `json:"setHeaders,omitempty"`
+	RemoveHeaders []string          `json:"removeHeaders,omitempty"`
+	Reject        int               `json:"reject,omitempty"`
+	Message       string            `json:"message,omitempty"`
+}
+
+// rewriterPlugin es un proceso rewriter; las llamadas se serializan porque el
+// protocolo es una línea de pregunta y una de respuesta
+type rewriterPlugin struct {
+	name string
+	path string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+var rewriterPlugins []*rewriterPlugin
+
+func init() {
+	for _, path := range appConfig.RewriterPlugins {
+		rewriterPlugins = append(rewriterPlugins, &rewriterPlugin{name: filepath.Base(path), path: path})
+	}
+	if len(rewriterPlugins) > 0 {
+		registerPreProxyHook("rewriters", rewritersPreProxyHook)
+	}
+}
+
+// start arranca el proceso; se llama con mu tomado
+func (p *rewriterPlugin) start() error {
+	cmd := exec.Command(p.path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = log.Writer()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	slog.Info("Rewriter iniciado", "component", "plugins", "plugin", p.name, "pid", cmd.Process.Pid)
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop mata el proceso para que la próxima llamada lo arranque de nuevo; se
+// llama con mu tomado
+func (p *rewriterPlugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	go p.cmd.Wait()
+	p.cmd = nil
+}
+
+// call envía el mensaje y espera la respuesta hasta REWRITER_PLUGIN_TIMEOUT. Si el
+// plugin no contesta a tiempo o contesta algo inválido se reinicia.
+func (p *rewriterPlugin) call(msg rewriterMessage) (rewriterReply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return rewriterReply{}, fmt.Errorf("no se pudo iniciar: %v", err)
+		}
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return rewriterReply{}, err
+	}
+	type result struct {
+		line []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+			done <- result{nil, err}
+			return
+		}
+		line, err := p.stdout.ReadBytes('\n')
+		done <- result{line, err}
+	}()
+
+	var reply rewriterReply
+	timer := time.NewTimer(appConfig.RewriterPluginTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			p.stop()
+			return reply, res.err
+		}
+		if err := json.Unmarshal(res.line, &reply); err != nil {
+			p.stop()
+			return reply, fmt.Errorf("respuesta inválida: %v", err)
+		}
+		return reply, nil
+	case <-timer.C:
+		p.stop()
+		return reply, errors.New("no respondió a tiempo")
+	}
+}
+
+// apply aplica los cambios de headers de la respuesta del plugin
+func (reply rewriterReply) apply(header http.Header) {
+	for _, name := range reply.RemoveHeaders {
+		header.Del(name)
+	}
+	for name, value := range reply.SetHeaders {
+		header.Set(name, value)
+	}
+}
+
+func newRewriterMessage(phase string, r *http.Request, session *PortForwardSession, headers http.Header) rewriterMessage {
+	return rewriterMessage{
+		Phase: phase,
+		Session: rewriterSession{
+			ID:        session.ID,
+			Project:   session.Project,
+			User:      session.User,
+			Cluster:   session.Cluster,
+			Namespace: session.Namespace,
+			Pod:       session.Pod,
+			Port:      session.Port,
+		},
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Headers: headers,
+	}
+}
+
+// rewritersPreProxyHook pasa la petición al pod por cada rewriter. Si un plugin
+// falla la petición no sigue: podría depender de los headers que agrega.
+func rewritersPreProxyHook(w http.ResponseWriter, r *http.Request, upstream *http.Request, session *PortForwardSession) bool {
+	for _, plugin := range rewriterPlugins {
+		reply, err := plugin.call(newRewriterMessage("request", r, session, upstream.Header))
+		if err != nil {
+			slog.Error("Rewriter falló en la petición", "component", "plugins", "plugin", plugin.name, "method", r.Method, "path", r.URL.Path, "error", err)
+			addCounter("pod_forward_rewriter_errors_total", map[string]string{"plugin": plugin.name}, 1)
+			http.Error(w, fmt.Sprintf("Error en el rewriter %s", plugin.name), http.StatusBadGateway)
+			return false
+		}
+		if reply.Reject != 0 {
+			if reply.Reject < 400 || reply.Reject > 599 {
+				slog.Error("Rewriter devolvió un código de rechazo inválido", "component", "plugins", "plugin", plugin.name, "status", reply.Reject)
+				http.Error(w, fmt.Sprintf("Error en el rewriter %s", plugin.name), http.StatusBadGateway)
+				return false
+			}
+			message := reply.Message
+			if message == "" {
+				message = http.StatusText(reply.Reject)
+			}
+			http.Error(w, message, reply.Reject)
+			return false
+		}
+		reply.apply(upstream.Header)
+	}
+	return true
+}
+
+// rewriteResponseHeaders pasa los headers de la respuesta del pod por cada
+// rewriter. Un plugin que falla deja la respuesta como está.
+func rewriteResponseHeaders(r *http.Request, resp *http.Response, session *PortForwardSession) {
+	for _, plugin := range rewriterPlugins {
+		msg := newRewriterMessage("response", r, session, resp.Header)
+		msg.Status = resp.StatusCode
+		reply, err := plugin.call(msg)
+		if err != nil {
+			slog.Error("Rewriter falló en la respuesta", "component", "plugins", "plugin", plugin.name, "method", r.Method, "path", r.URL.Path, "error", err)
+			addCounter("pod_forward_rewriter_errors_total", map[string]string{"plugin": plugin.name}, 1)
+			continue
+		}
+		reply.apply(resp.Header)
+	}
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var podForwardPolicyGVR = schema.GroupVersionResource{Group: "pod-forward.argocd", Version: "v1alpha1", Resource: "podforwardpolicies"}
+
+// PodForwardPolicySpec es la especificación del CRD PodForwardPolicy
+type PodForwardPolicySpec struct {
+	// Patrones (path.Match) de namespaces permitidos; vacío permite todos
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// Patrones de namespaces denegados; tienen prioridad sobre los permitidos
+	DeniedNamespaces []string `json:"deniedNamespaces,omitempty"`
+	// Puertos de contenedor permitidos; vacío permite todos
+	AllowedPorts       []int               `json:"allowedPorts,omitempty"`
+	Quotas             PolicyQuotas        `json:"quotas,omitempty"`
+	Profiles           []PolicyProfile     `json:"profiles,omitempty"`
+	CredentialMappings []CredentialMapping `json:"credentialMappings,omitempty"`
+	// Certificados de cliente para los pods que exigen mTLS
+	ClientCertificates []ClientCertificateMapping `json:"clientCertificates,omitempty"`
+	// Patrones de namespaces donde se puede lanzar el pod toolbox de depuración;
+	// vacío no lo permite en ningún namespace
+	ToolboxNamespaces []string `json:"toolboxNamespaces,omitempty"`
+	// Patrones de namespaces donde se pueden descargar y subir archivos de los
+	// contenedores; vacío no lo permite en ningún namespace
+	FileTransferNamespaces []string `json:"fileTransferNamespaces,omitempty"`
+	// Petición de ejemplo que se evalúa en cada reconciliación y se reporta en status.dryRun
+	DryRun *PolicyDryRun `json:"dryRun,omitempty"`
+}
+
+// PolicyDryRun describe una petición de ejemplo para validar la política
+type PolicyDryRun struct {
+	Namespace string `json:"namespace"`
+	Port      int    `json:"port"`
+}
+
+// PolicyQuotas limita la cantidad de sesiones simultáneas (0 = sin límite)
+type PolicyQuotas struct {
+	MaxSessions             int `json:"maxSessions,omitempty"`
+	MaxSessionsPerNamespace int `json:"maxSessionsPerNamespace,omitempty"`
+	MaxSessionsPerProject   int `json:"maxSessionsPerProject,omitempty"`
+	MaxSessionsPerUser      int `json:"maxSessionsPerUser,omitempty"`
+	// MaxBytesPerSession cierra la sesión cuando el total transferido en ambos
+	// sentidos supera este valor
+	MaxBytesPerSession int64 `json:"maxBytesPerSession,omitempty"`
+}
+
+// PolicyProfile agrupa opciones de proxy que se seleccionan con el parámetro profile
+type PolicyProfile struct {
+	Name              string `json:"name"`
+	StripFrameHeaders bool   `json:"stripFrameHeaders,omitempty"`
+	// RewriteOrigin reemplaza Origin por el origen del pod, para aplicaciones que
+	// rechazan WebSockets u otros POST de un origen distinto al suyo
+	RewriteOrigin bool `json:"rewriteOrigin,omitempty"`
+	// RewriteReferer traslada el Referer al origen de la aplicación, sin el prefijo del proxy
+	RewriteReferer bool `json:"rewriteReferer,omitempty"`
+	// UpstreamOrigin es el origen que usan RewriteOrigin y RewriteReferer en lugar
+	// del del pod, para aplicaciones que validan contra su URL pública
+	UpstreamOrigin string `json:"upstreamOrigin,omitempty"`
+	// ServiceWorkerScope permite que los service workers registren el prefijo del proxy como scope
+	ServiceWorkerScope bool `json:"serviceWorkerScope,omitempty"`
+	// BlockServiceWorkers impide que la aplicación registre service workers
+	BlockServiceWorkers bool `json:"blockServiceWorkers,omitempty"`
+	// RewriteAbsoluteURLs agrega el prefijo del proxy a los href/src/action absolutos del HTML
+	RewriteAbsoluteURLs bool `json:"rewriteAbsoluteURLs,omitempty"`
+	// BaseURLKeys son claves JSON embebidas en el HTML (ej: baseUrl) cuyo valor
+	// absoluto recibe el prefijo del proxy
+	BaseURLKeys []string `json:"baseURLKeys,omitempty"`
+	// TokenParam es el parámetro de query donde se inyecta el token de la aplicación,
+	// leído de la anotación TokenAnnotation del pod o del Secret TokenSecretName/TokenSecretKey
+	// en el namespace del pod
+	TokenParam      string `json:"tokenParam,omitempty"`
+	TokenAnnotation string `json:"tokenAnnotation,omitempty"`
+	TokenSecretName string `json:"tokenSecretName,omitempty"`
+	TokenSecretKey  string `json:"tokenSecretKey,omitempty"`
+	// SniffContentType detecta el Content-Type de las respuestas del pod que no lo traen
+	SniffContentType bool `json:"sniffContentType,omitempty"`
+	// Charset es el charset del HTML de la aplicación cuando no lo declara en
+	// Content-Type (ej: windows-1252); el proxy lo convierte a UTF-8
+	Charset string `json:"charset,omitempty"`
+}
+
+// CredentialMapping inyecta un header con el valor de un Secret en las peticiones
+// a los pods que coinciden con el namespace y el patrón de nombre
+type CredentialMapping struct {
+	Namespace  string `json:"namespace"`
+	Pod        string `json:"pod,omitempty"`
+	Header     string `json:"header"`
+	SecretName string `json:"secretName"`
+	SecretKey  string `json:"secretKey"`
+}
+
+// resolvedCredential es un CredentialMapping con el valor del Secret ya leído
+type resolvedCredential struct {
+	CredentialMapping
+	Value string
+}
+
+// ClientCertificateMapping presenta el certificado de un Secret kubernetes.io/tls
+// a los pods que coinciden con el namespace, el patrón de nombre y el puerto; el
+// proxy se conecta a esos pods por HTTPS
+type ClientCertificateMapping struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	// SecretName tiene tls.crt y tls.key; ca.crt, si está, valida el certificado del pod
+	SecretName string `json:"secretName"`
+	// ServerName es el nombre esperado en el certificado del pod y el SNI; vacío
+	// solo valida la cadena, porque el pod se alcanza por localhost
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// resolvedClientCertificate es un ClientCertificateMapping con el Secret ya leído
+type resolvedClientCertificate struct {
+	ClientCertificateMapping
+	certificate tls.Certificate
+	// roots es nil si el Secret no trae ca.crt: se valida con las CA del sistema
+	roots *x509.CertPool
+}
+
+// effectivePolicy es la combinación de todas las PodForwardPolicy válidas
+type effectivePolicy struct {
+	allowedNamespaces []string
+	deniedNamespaces  []string
+	allowedPorts      map[int]bool
+	quotas            PolicyQuotas
+	profiles          map[string]PolicyProfile
+	credentials       []resolvedCredential
+	clientCerts       []resolvedClientCertificate
+	toolboxNamespaces []string
+	fileNamespaces    []string
+}
+
+var (
+	currentPolicy   *effectivePolicy
+	currentPolicyMu sync.RWMutex
+)
+
+// getPolicy devuelve la política vigente o nil si el CRD no está habilitado
+func getPolicy() *effectivePolicy {
+	currentPolicyMu.RLock()
+	defer currentPolicyMu.RUnlock()
+	return currentPolicy
+}
+
+// checkForward valida un port-forward contra la política.
+// Las cuotas no aplican si la sesión ya existe y solo se reutiliza.
+func (p *effectivePolicy) checkForward(sessionKey, project, user, namespace string, port int) error {
+	if err := p.checkTarget(namespace, port); err != nil {
+		return err
+	}
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	return p.checkQuotasLocked(sessionKey, project, user, namespace)
+}
+
+// sessionQuotas devuelve las cuotas de sesiones vigentes: las de la política o, sin
+// PodForwardPolicy, el límite por usuario de MAX_SESSIONS_PER_USER
+func (p *effectivePolicy) sessionQuotas() PolicyQuotas {
+	if p == nil {
+		return PolicyQuotas{MaxSessionsPerUser: appConfig.MaxSessionsPerUser}
+	}
+	return p.quotas
+}
+
+// checkQuotasLocked valida las cuotas contando las sesiones activas y las que se
+// están creando (sessionReservations). Se llama con sessionsMu tomado.
+func (p *effectivePolicy) checkQuotasLocked(sessionKey, project, user, namespace string) error {
+	quotas := p.sessionQuotas()
+	if quotas.MaxSessions <= 0 && quotas.MaxSessionsPerNamespace <= 0 && quotas.MaxSessionsPerProject <= 0 && quotas.MaxSessionsPerUser <= 0 {
+		return nil
+	}
+	if _, exists := activeSessions[sessionKey]; exists {
+		return nil
+	}
+	if _, reserved := sessionReservations[sessionKey]; reserved {
+		return nil
+	}
+	total, inNamespace, inProject, ofUser := len(activeSessions)+len(sessionReservations), 0, 0, 0
+	count := func(sessNamespace, sessProject, sessUser string) {
+		if sessNamespace == namespace {
+			inNamespace++
+		}
+		if sessProject == project {
+			inProject++
+		}
+		// El límite por usuario suma sus sesiones de todos los proyectos
+		if sessUser == user {
+			ofUser++
+		}
+	}
+	for _, sess := range activeSessions {
+		count(sess.Namespace, sess.Project, sess.User)
+	}
+	for _, reservation := range sessionReservations {
+		count(reservation.Namespace, reservation.Project, reservation.User)
+	}
+	if quotas.MaxSessions > 0 && total >= quotas.MaxSessions {
+		return fmt.Errorf("se alcanzó el máximo de %d sesiones", quotas.MaxSessions)
+	}
+	if quotas.MaxSessionsPerNamespace > 0 && inNamespace >= quotas.MaxSessionsPerNamespace {
+		return fmt.Errorf("se alcanzó el máximo de %d sesiones en el namespace %s", quotas.MaxSessionsPerNamespace, namespace)
+	}
+	if quotas.MaxSessionsPerProject > 0 && inProject >= quotas.MaxSessionsPerProject {
+		return fmt.Errorf("se alcanzó el máximo de %d sesiones en el proyecto %s", quotas.MaxSessionsPerProject, project)
+	}
+	if quotas.MaxSessionsPerUser > 0 && ofUser >= quotas.MaxSessionsPerUser {
+		return fmt.Errorf("se alcanzó el máximo de %d sesiones por usuario", quotas.MaxSessionsPerUser)
+	}
+	return nil
+}
+
+// sessionReservation es una sesión que pasó las cuotas y todavía está abriendo su
+// port-forward; cuenta para las cuotas hasta que se registra en activeSessions
+type sessionReservation struct {
+	Project, User, Namespace string
+}
+
+// sessionReservations son las sesiones en creación por clave, protegidas por sessionsMu
+var sessionReservations = make(map[string]sessionReservation)
+
+// reserveSession valida las cuotas y reserva el lugar de la sesión en la misma
+// sección crítica, para que dos creaciones concurrentes no superen el límite
+func reserveSession(sessionKey, project, user, namespace string) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if err := getPolicy().checkQuotasLocked(sessionKey, project, user, namespace); err != nil {
+		return err
+	}
+	sessionReservations[sessionKey] = sessionReservation{Project: project, User: user, Namespace: namespace}
+	return nil
+}
+
+// releaseSessionReservation libera la reserva de una creación que falló
+func releaseSessionReservation(sessionKey string) {
+	sessionsMu.Lock()
+	delete(sessionReservations, sessionKey)
+	sessionsMu.Unlock()
+}
+
+// checkTarget valida el namespace y el puerto contra las listas de la política
+func (p *effectivePolicy) checkTarget(namespace string, port int) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.checkNamespace(namespace); err != nil {
+		return err
+	}
+	if len(p.allowedPorts) > 0 && !p.allowedPorts[port] {
+		return fmt.Errorf("el puerto %d no está permitido por política", port)
+	}
+	return nil
+}
+
+// checkNamespace valida el namespace contra las listas de permitidos y denegados
+func (p *effectivePolicy) checkNamespace(namespace string) error {
+	for _, pattern := range p.deniedNamespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return fmt.Errorf("el namespace %s está denegado por política", namespace)
+		}
+	}
+	if len(p.allowedNamespaces) > 0 {
+		allowed := false
+		for _, pattern := range p.allowedNamespaces {
+			if ok, _ := path.Match(pattern, namespace); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("el namespace %s no está permitido por política", namespace)
+		}
+	}
+	return nil
+}
+
+// checkToolbox valida que la política permita el pod toolbox en el namespace.
+// Sin política (CRD deshabilitado) decide HELPER_NAMESPACES (ver authorizeHelperPod).
+func (p *effectivePolicy) checkToolbox(namespace string) error {
+	if p == nil || matchesAny(p.toolboxNamespaces, namespace) {
+		return nil
+	}
+	return fmt.Errorf("el toolbox no está permitido por política en el namespace %s", namespace)
+}
+
+// checkFileTransfer valida que la política permita copiar archivos en el namespace.
+// Sin política (CRD deshabilitado) se requiere FILE_TRANSFER_ENABLED.
+func (p *effectivePolicy) checkFileTransfer(namespace string) error {
+	if p == nil {
+		if !appConfig.FileTransferEnabled {
+			return fmt.Errorf("la transferencia de archivos está deshabilitada (FILE_TRANSFER_ENABLED)")
+		}
+		return nil
+	}
+	if err := p.checkNamespace(namespace); err != nil {
+		return err
+	}
+	if !matchesAny(p.fileNamespaces, namespace) {
+		return fmt.Errorf("la transferencia de archivos no está permitida por política en el namespace %s", namespace)
+	}
+	return nil
+}
+
+// matchesAny indica si el namespace coincide con alguno de los patrones
+func matchesAny(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// profile devuelve el perfil con ese nombre, de la política o predefinido
+func (p *effectivePolicy) profile(name string) (PolicyProfile, bool) {
+	if name == "" {
+		return PolicyProfile{}, false
+	}
+	// Los perfiles de la política reemplazan a los predefinidos con el mismo nombre
+	if p != nil {
+		if profile, ok := p.profiles[name]; ok {
+			return profile, true
+		}
+	}
+	profile, ok := builtinProfiles[name]
+	return profile, ok
+}
+
+// injectCredentials agrega los headers de credenciales que correspondan al pod
+func (p *effectivePolicy) injectCredentials(header http.Header, namespace, pod string) {
+	if p == nil {
+		return
+	}
+	for _, cred := range p.credentials {
+		if cred.Namespace != namespace {
+			continue
+		}
+		if cred.Pod != "" {
+			if ok, _ := path.Match(cred.Pod, pod); !ok {
+				continue
+			}
+		}
+		header.Set(cred.Header, cred.Value)
+	}
+}
+
+// clientCertificateFor devuelve el certificado de cliente para el pod y puerto, o
+// nil si el pod no se alcanza por mTLS
+func (p *effectivePolicy) clientCertificateFor(namespace, pod string, port int) *resolvedClientCertificate {
+	if p == nil {
+		return nil
+	}
+	for i := range p.clientCerts {
+		cert := &p.clientCerts[i]
+		if cert.Namespace != namespace || (cert.Port != 0 && cert.Port != port) {
+			continue
+		}
+		if cert.Pod != "" {
+			if ok, _ := path.Match(cert.Pod, pod); !ok {
+				continue
+			}
+		}
+		return cert
+	}
+	return nil
+}
+
+// validatePolicySpec revisa los campos que el schema del CRD no puede validar
+func validatePolicySpec(spec *PodForwardPolicySpec) error {
+	patterns := append(append([]string{}, spec.AllowedNamespaces...), spec.DeniedNamespaces...)
+	patterns = append(append(patterns, spec.ToolboxNamespaces...), spec.FileTransferNamespaces...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("patrón de namespace inválido %q: %v", pattern, err)
+		}
+	}
+	for _, port := range spec.AllowedPorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("puerto inválido %d", port)
+		}
+	}
+	if spec.Quotas.MaxSessions < 0 || spec.Quotas.MaxSessionsPerNamespace < 0 || spec.Quotas.MaxSessionsPerProject < 0 || spec.Quotas.MaxSessionsPerUser < 0 || spec.Quotas.MaxBytesPerSession < 0 {
+		return fmt.Errorf("las cuotas no pueden ser negativas")
+	}
+	seen := map[string]bool{}
+	for _, profile := range spec.Profiles {
+		if profile.Name == "" {
+			return fmt.Errorf("los perfiles requieren nombre")
+		}
+		if seen[profile.Name] {
+			return fmt.Errorf("perfil duplicado %q", profile.Name)
+		}
+		if (profile.TokenSecretName == "") != (profile.TokenSecretKey == "") {
+			return fmt.Errorf("el perfil %q requiere tokenSecretName y tokenSecretKey juntos", profile.Name)
+		}
+		if profile.Charset != "" {
+			if _, err := htmlindex.Get(profile.Charset); err != nil {
+				return fmt.Errorf("el perfil %q tiene un charset desconocido %q", profile.Name, profile.Charset)
+			}
+		}
+		seen[profile.Name] = true
+	}
+	for _, cred := range spec.CredentialMappings {
+		if cred.Namespace == "" || cred.Header == "" || cred.SecretName == "" || cred.SecretKey == "" {
+			return fmt.Errorf("credentialMappings requiere namespace, header, secretName y secretKey")
+		}
+		if cred.Pod != "" {
+			if _, err := path.Match(cred.Pod, ""); err != nil {
+				return fmt.Errorf("patrón de pod inválido %q: %v", cred.Pod, err)
+			}
+		}
+	}
+	for _, cert := range spec.ClientCertificates {
+		if cert.Namespace == "" || cert.SecretName == "" {
+			return fmt.Errorf("clientCertificates requiere namespace y secretName")
+		}
+		if cert.Port < 0 || cert.Port > 65535 {
+			return fmt.Errorf("puerto inválido %d en clientCertificates", cert.Port)
+		}
+		if cert.Pod != "" {
+			if _, err := path.Match(cert.Pod, ""); err != nil {
+				return fmt.Errorf("patrón de pod inválido %q: %v", cert.Pod, err)
+			}
+		}
+	}
+	return nil
+}
+
+// policyReconciler mantiene la política efectiva sincronizada con los objetos PodForwardPolicy
+type policyReconciler struct {
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+	informer      cache.SharedIndexInformer
+	mu            sync.Mutex
+}
+
+// startPolicyReconciler observa los PodForwardPolicy del cluster y recalcula la política en cada cambio
+func startPolicyReconciler(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 5*time.Minute)
+	rec := &policyReconciler{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		informer:      factory.ForResource(podForwardPolicyGVR).Informer(),
+	}
+
+	// Hasta la primera sincronización se niega todo para no abrir forwards sin política
+	currentPolicyMu.Lock()
+	currentPolicy = &effectivePolicy{deniedNamespaces: []string{"*"}}
+	currentPolicyMu.Unlock()
+
+	reconcile := func(interface{}) { rec.reconcile(ctx) }
+	rec.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    reconcile,
+		UpdateFunc: func(_, obj interface{}) { reconcile(obj) },
+		DeleteFunc: reconcile,
+	})
+
+	factory.Start(ctx.Done())
+	goTask("policy-informer", func(ctx context.Context) error {
+		if !cache.WaitForCacheSync(ctx.Done(), rec.informer.HasSynced) {
+			return fmt.Errorf("no se pudo sincronizar el cache de PodForwardPolicy")
+		}
+		slog.Info("Cache de PodForwardPolicy sincronizado", "component", "policy")
+		rec.reconcile(ctx)
+		return nil
+	})
+}
+
+// reconcile recalcula la política efectiva y actualiza el status de cada PodForwardPolicy
+func (rec *policyReconciler) reconcile(ctx context.Context) {
+	if !rec.informer.HasSynced() {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	objs := rec.informer.GetStore().List()
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].(*unstructured.Unstructured).GetName() < objs[j].(*unstructured.Unstructured).GetName()
+	})
+
+	type policyResult struct {
+		obj  *unstructured.Unstructured
+		spec PodForwardPolicySpec
+		err  error
+	}
+	results := make([]policyResult, 0, len(objs))
+
+	policy := &effectivePolicy{
+		allowedPorts: map[int]bool{},
+		profiles:     map[string]PolicyProfile{},
+	}
+	for _, obj := range objs {
+		res := policyResult{obj: obj.(*unstructured.Unstructured)}
+		res.err = runtime.DefaultUnstructuredConverter.FromUnstructured(mapOrEmpty(res.obj.Object["spec"]), &res.spec)
+		if res.err == nil {
+			res.err = validatePolicySpec(&res.spec)
+		}
+		var credentials []resolvedCredential
+		var clientCerts []resolvedClientCertificate
+		if res.err == nil {
+			credentials, res.err = rec.resolveCredentials(ctx, res.spec.CredentialMappings)
+		}
+		if res.err == nil {
+			clientCerts, res.err = rec.resolveClientCertificates(ctx, res.spec.ClientCertificates)
+		}
+		if res.err != nil {
+			slog.Warn("PodForwardPolicy inválida", "component", "policy", "policy", res.obj.GetName(), "error", res.err)
+		} else {
+			policy.merge(&res.spec, credentials, clientCerts)
+		}
+		results = append(results, res)
+	}
+
+	currentPolicyMu.Lock()
+	currentPolicy = policy
+	currentPolicyMu.Unlock()
+	slog.Info("Política efectiva recalculada", "component", "policy", "policies", len(objs))
+
+	// El status se escribe después de combinar todas las políticas para que el
+	// dry-run refleje la decisión real del backend
+	for _, res := range results {
+		if res.err != nil {
+			rec.updateStatus(ctx, res.obj, metav1.ConditionFalse, "Invalid", res.err.Error(), nil)
+			continue
+		}
+		rec.updateStatus(ctx, res.obj, metav1.ConditionTrue, "Applied", "La política está aplicada", policy.dryRun(res.spec.DryRun))
+	}
+}
+
+// dryRun evalúa la petición de ejemplo de la política sin considerar las cuotas,
+// que dependen de las sesiones activas en cada momento
+func (p *effectivePolicy) dryRun(sample *PolicyDryRun) map[string]interface{} {
+	if sample == nil {
+		return nil
+	}
+	result := map[string]interface{}{
+		"namespace": sample.Namespace,
+		"port":      int64(sample.Port),
+		"allowed":   true,
+	}
+	if err := p.checkTarget(sample.Namespace, sample.Port); err != nil {
+		result["allowed"] = false
+		result["reason"] = err.Error()
+	}
+	return result
+}
+
+// merge combina una política: se suman permisos y denegaciones y se toma la cuota más estricta
+func (p *effectivePolicy) merge(spec *PodForwardPolicySpec, credentials []resolvedCredential, clientCerts []resolvedClientCertificate) {
+	p.allowedNamespaces = append(p.allowedNamespaces, spec.AllowedNamespaces...)
+	p.deniedNamespaces = append(p.deniedNamespaces, spec.DeniedNamespaces...)
+	for _, port := range spec.AllowedPorts {
+		p.allowedPorts[port] = true
+	}
+	p.quotas.MaxSessions = minQuota(p.quotas.MaxSessions, spec.Quotas.MaxSessions)
+	p.quotas.MaxSessionsPerNamespace = minQuota(p.quotas.MaxSessionsPerNamespace, spec.Quotas.MaxSessionsPerNamespace)
+	p.quotas.MaxSessionsPerProject = minQuota(p.quotas.MaxSessionsPerProject, spec.Quotas.MaxSessionsPerProject)
+	p.quotas.MaxSessionsPerUser = minQuota(p.quotas.MaxSessionsPerUser, spec.Quotas.MaxSessionsPerUser)
+	p.quotas.MaxBytesPerSession = minQuota(p.quotas.MaxBytesPerSession, spec.Quotas.MaxBytesPerSession)
+	for _, profile := range spec.Profiles {
+		p.profiles[profile.Name] = profile
+	}
+	p.credentials = append(p.credentials, credentials...)
+	p.clientCerts = append(p.clientCerts, clientCerts...)
+	p.toolboxNamespaces = append(p.toolboxNamespaces, spec.ToolboxNamespaces...)
+	p.fileNamespaces = append(p.fileNamespaces, spec.FileTransferNamespaces...)
+}
+
+// minQuota devuelve la cuota más estricta, donde 0 significa sin límite
+func minQuota[T int | int64](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// resolveCredentials lee los Secrets referenciados por los credentialMappings
+func (rec *policyReconciler) resolveCredentials(ctx context.Context, mappings []CredentialMapping) ([]resolvedCredential, error) {
+	var resolved []resolvedCredential
+	for _, mapping := range mappings {
+		secret, err := rec.clientset.CoreV1().Secrets(mapping.Namespace).Get(ctx, mapping.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error al leer el Secret %s/%s: %v", mapping.Namespace, mapping.SecretName, err)
+		}
+		value, ok := secret.Data[mapping.SecretKey]
+		if !ok {
+			return nil, fmt.Errorf("el Secret %s/%s no tiene la clave %s", mapping.Namespace, mapping.SecretName, mapping.SecretKey)
+		}
+		resolved = append(resolved, resolvedCredential{CredentialMapping: mapping, Value: string(value)})
+	}
+	return resolved, nil
+}
+
+// resolveClientCertificates lee los Secrets TLS referenciados por los clientCertificates
+func (rec *policyReconciler) resolveClientCertificates(ctx context.Context, mappings []ClientCertificateMapping) ([]resolvedClientCertificate, error) {
+	var resolved []resolvedClientCertificate
+	for _, mapping := range mappings {
+		secret, err := rec.clientset.CoreV1().Secrets(mapping.Namespace).Get(ctx, mapping.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error al leer el Secret %s/%s: %v", mapping.Namespace, mapping.SecretName, err)
+		}
+		certificate, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, fmt.Errorf("el Secret %s/%s no tiene un certificado válido en %s y %s: %v",
+				mapping.Namespace, mapping.SecretName, corev1.TLSCertKey, corev1.TLSPrivateKeyKey, err)
+		}
+		cert := resolvedClientCertificate{ClientCertificateMapping: mapping, certificate: certificate}
+		if ca, ok := secret.Data[tlsCAKey]; ok {
+			cert.roots = x509.NewCertPool()
+			if !cert.roots.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("el Secret %s/%s tiene un %s inválido", mapping.Namespace, mapping.SecretName, tlsCAKey)
+			}
+		}
+		resolved = append(resolved, cert)
+	}
+	return resolved, nil
+}
+
+// updateStatus escribe la condición Ready y el resultado del dry-run si cambiaron,
+// para no generar eventos en bucle
+func (rec *policyReconciler) updateStatus(ctx context.Context, u *unstructured.Unstructured, status metav1.ConditionStatus, reason, message string, dryRun map[string]interface{}) {
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	observed, _, _ := unstructured.NestedInt64(u.Object, "status", "observedGeneration")
+	currentDryRun, _, _ := unstructured.NestedMap(u.Object, "status", "dryRun")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == "Ready" && cond["status"] == string(status) && cond["reason"] == reason &&
+			cond["message"] == message && observed == u.GetGeneration() && reflect.DeepEqual(currentDryRun, dryRun) {
+			return
+		}
+	}
+
+	updated := u.DeepCopy()
+	condition := map[string]interface{}{
+		"type":               "Ready",
+		"status":             string(status),
+		"reason":             reason,
+		"message":            message,
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+	}
+	unstructured.SetNestedSlice(updated.Object, []interface{}{condition}, "status", "conditions")
+	unstructured.SetNestedField(updated.Object, u.GetGeneration(), "status", "observedGeneration")
+	if dryRun != nil {
+		unstructured.SetNestedMap(updated.Object, dryRun, "status", "dryRun")
+	} else {
+		unstructured.RemoveNestedField(updated.Object, "status", "dryRun")
+	}
+
+	_, err := rec.dynamicClient.Resource(podForwardPolicyGVR).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		slog.Error("Error al actualizar el status de la PodForwardPolicy", "component", "policy", "policy", u.GetName(), "error", err)
+	}
+}
+
+// mapOrEmpty convierte un campo de un objeto unstructured en mapa
+func mapOrEmpty(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
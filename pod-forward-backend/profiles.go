@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxRewriteBodyBytes es el tamaño máximo de HTML que se reescribe en memoria;
+// documentos más grandes se envían sin cambios
+const maxRewriteBodyBytes = 10 << 20
+
+// builtinProfiles son perfiles predefinidos que se pueden elegir con el parámetro
+// profile sin declararlos en una PodForwardPolicy
+var builtinProfiles = map[string]PolicyProfile{
+	// code-server y otros IDEs web: se embeben en el panel, validan el Origin del
+	// WebSocket, registran un service worker y usan algunas rutas absolutas
+	"code-server": {
+		Name:                "code-server",
+		StripFrameHeaders:   true,
+		RewriteOrigin:       true,
+		ServiceWorkerScope:  true,
+		RewriteAbsoluteURLs: true,
+	},
+	// Jupyter: token de acceso inyectado desde una anotación del pod, WebSockets de
+	// kernels con validación de Origin y base_url "/" en los links y la configuración
+	"jupyter": {
+		Name:                "jupyter",
+		StripFrameHeaders:   true,
+		RewriteOrigin:       true,
+		RewriteAbsoluteURLs: true,
+		BaseURLKeys:         []string{"baseUrl", "base_url"},
+		TokenParam:          "token",
+		TokenAnnotation:     "pod-forward.argocd/token",
+	},
+}
+
+// resolveProfileToken lee el token de la aplicación que indica el perfil
+func resolveProfileToken(ctx context.Context, clientset *kubernetes.Clientset, profile PolicyProfile, namespace, pod string) (string, error) {
+	switch {
+	case profile.TokenParam == "":
+		return "", nil
+	case profile.TokenSecretName != "":
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, profile.TokenSecretName, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("error al leer el Secret %s/%s: %v", namespace, profile.TokenSecretName, err)
+		}
+		token, ok := secret.Data[profile.TokenSecretKey]
+		if !ok {
+			return "", fmt.Errorf("el Secret %s/%s no tiene la clave %s", namespace, profile.TokenSecretName, profile.TokenSecretKey)
+		}
+		return string(token), nil
+	case profile.TokenAnnotation != "":
+		p, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("error al leer el pod %s/%s: %v", namespace, pod, err)
+		}
+		return p.Annotations[profile.TokenAnnotation], nil
+	}
+	return "", nil
+}
+
+// sessionProfile devuelve el perfil elegido para la sesión
+func sessionProfile(policy *effectivePolicy, session *PortForwardSession) PolicyProfile {
+	session.mu.Lock()
+	name := session.Profile
+	session.mu.Unlock()
+	profile, _ := policy.profile(name)
+	return profile
+}
+
+// applyProfileToRequest ajusta la petición al pod según el perfil
+func applyProfileToRequest(profile PolicyProfile, req *http.Request, token string) {
+	origin := upstreamOrigin(profile, req)
+	if (profile.RewriteOrigin || appConfig.RewriteOrigin) && req.Header.Get("Origin") != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if referer := req.Header.Get("Referer"); referer != "" && (profile.RewriteReferer || appConfig.RewriteReferer) {
+		req.Header.Set("Referer", rewriteReferer(referer, origin))
+	}
+	if profile.TokenParam != "" && token != "" {
+		query := req.URL.Query()
+		if query.Get(profile.TokenParam) == "" {
+			query.Set(profile.TokenParam, token)
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+	if profile.RewriteAbsoluteURLs || appConfig.RewriteAbsoluteURLs || len(profile.BaseURLKeys) > 0 {
+		// Sin Accept-Encoding del cliente el transporte pide gzip y descomprime,
+		// así el HTML llega en texto plano para poder reescribirlo
+		req.Header.Del("Accept-Encoding")
+	}
+}
+
+// upstreamOrigin es el origen que la aplicación espera ver en Origin y Referer: el
+// UpstreamOrigin del perfil (ej: el root_url de Grafana) o el del pod
+func upstreamOrigin(profile PolicyProfile, req *http.Request) string {
+	if profile.UpstreamOrigin != "" {
+		return profile.UpstreamOrigin
+	}
+	if appConfig.UpstreamOrigin != "" {
+		return appConfig.UpstreamOrigin
+	}
+	return "http://" + req.URL.Host
+}
+
+// rewriteReferer traslada el Referer del navegador (host de Argo CD y ruta bajo el
+// prefijo del proxy) al origen de la aplicación, con la ruta que ve el pod. Un
+// Referer de otra página de Argo CD se reduce al origen de la aplicación.
+func rewriteReferer(referer, origin string) string {
+	parsed, err := url.Parse(referer)
+	if err != nil {
+		return origin + "/"
+	}
+	if !hasProxyPrefix(parsed.EscapedPath()) {
+		return origin + "/"
+	}
+	rewritten := origin + upstreamPath(parsed.EscapedPath())
+	if parsed.RawQuery != "" {
+		rewritten += "?" + parsed.RawQuery
+	}
+	return rewritten
+}
+
+// applyProfileToResponse ajusta los headers y el cuerpo de la respuesta del pod
+// según el perfil. Devuelve el cuerpo a copiar al cliente.
+func applyProfileToResponse(profile PolicyProfile, r *http.Request, resp *http.Response, prefix string) io.Reader {
+	if profile.ServiceWorkerScope && isServiceWorkerScript(r) {
+		resp.Header.Set("Service-Worker-Allowed", prefix+"/")
+	}
+	clampServiceWorkerAllowed(resp.Header, prefix)
+	charset := profile.Charset
+	if charset == "" {
+		charset = appConfig.DefaultCharset
+	}
+	if err := normalizeCharset(resp, charset); err != nil {
+		slog.Warn("Error al aplicar el perfil a la respuesta", "component", "applyProfileToResponse", "error", err)
+	}
+	rewriteURLs := profile.RewriteAbsoluteURLs || appConfig.RewriteAbsoluteURLs
+	if (!rewriteURLs && len(profile.BaseURLKeys) == 0) || !isPlainHTML(resp) {
+		return resp.Body
+	}
+	if len(profile.BaseURLKeys) == 0 {
+		// Solo rutas absolutas: se reescriben a medida que llega el documento
+		resp.Header.Del("Content-Length")
+		return newAbsoluteURLRewriter(resp.Body, prefix)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRewriteBodyBytes+1))
+	if err != nil || len(body) > maxRewriteBodyBytes {
+		// Documento demasiado grande o ilegible: enviar lo leído y el resto sin cambios
+		return io.MultiReader(bytes.NewReader(body), resp.Body)
+	}
+	if rewriteURLs {
+		body = rewriteAbsoluteURLs(body, prefix)
+		body = addFaviconLink(body, prefix)
+	}
+	body = rewriteBaseURLKeys(body, profile.BaseURLKeys, prefix)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return bytes.NewReader(body)
+}
+
+// isPlainHTML indica si la respuesta es HTML sin comprimir
+func isPlainHTML(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	encoding := resp.Header.Get("Content-Encoding")
+	return err == nil && mediaType == "text/html" && (encoding == "" || encoding == "identity")
+}
+
+// absoluteURLAttr encuentra atributos href, src y action con rutas absolutas;
+// el grupo es la posición de la barra inicial
+var absoluteURLAttr = regexp.MustCompile(`\s(?:href|src|action)\s*=\s*["'](/)`)
+
+// rewriteAbsoluteURLs agrega el prefijo de la sesión a las rutas absolutas del HTML
+// que todavía no están bajo el del proxy. Las URLs relativas al protocolo (//host) no
+// se tocan.
+func rewriteAbsoluteURLs(body []byte, prefix string) []byte {
+	var out bytes.Buffer
+	last := 0
+	for _, match := range absoluteURLAttr.FindAllSubmatchIndex(body, -1) {
+		slash := match[2]
+		rest := body[slash:]
+		if bytes.HasPrefix(rest, []byte("//")) || hasPrefixedPath(rest) {
+			continue
+		}
+		out.Write(body[last:slash])
+		out.WriteString(prefix)
+		last = slash
+	}
+	if last == 0 {
+		return body
+	}
+	out.Write(body[last:])
+	return out.Bytes()
+}
+
+// iconLink encuentra un <link> con rel icon o shortcut icon
+var iconLink = regexp.MustCompile(`(?i)<link[^>]+rel\s*=\s*["']?(?:shortcut\s+)?icon`)
+
+// headOpen encuentra la etiqueta de apertura de <head>
+var headOpen = regexp.MustCompile(`(?i)<head(?:\s[^>]*)?>`)
+
+// addFaviconLink declara el favicon bajo el prefijo de la sesión en los documentos
+// que no declaran uno; sin él el navegador pide /favicon.ico a Argo CD y la pestaña
+// muestra el ícono equivocado
+func addFaviconLink(body []byte, prefix string) []byte {
+	if iconLink.Match(body) {
+		return body
+	}
+	loc := headOpen.FindIndex(body)
+	if loc == nil {
+		return body
+	}
+	link := `<link rel="icon" href="` + prefix + `/favicon.ico">`
+	out := make([]byte, 0, len(body)+len(link))
+	out = append(out, body[:loc[1]]...)
+	out = append(out, link...)
+	return append(out, body[loc[1]:]...)
+}
+
+// hasPrefixedPath indica si la URL del atributo ya empieza con el prefijo del proxy
+func hasPrefixedPath(rest []byte) bool {
+	if !bytes.HasPrefix(rest, []byte(extensionBasePath)) {
+		return false
+	}
+	rest = rest[len(extensionBasePath):]
+	return len(rest) == 0 || bytes.IndexByte([]byte(`/"'?#`), rest[0]) >= 0
+}
+
+// rewriteBaseURLKeys agrega el prefijo de la sesión a los valores absolutos de las
+// claves JSON indicadas (ej: "baseUrl": "/") dentro del HTML
+func rewriteBaseURLKeys(body []byte, keys []string, prefix string) []byte {
+	for _, key := range keys {
+		pattern := regexp.MustCompile(`("` + regexp.QuoteMeta(key) + `"\s*:\s*")(/)`)
+		var out bytes.Buffer
+		last := 0
+		for _, match := range pattern.FindAllSubmatchIndex(body, -1) {
+			slash := match[4]
+			if hasPrefixedPath(body[slash:]) {
+				continue
+			}
+			out.Write(body[last:slash])
+			out.WriteString(prefix)
+			last = slash
+		}
+		if last > 0 {
+			out.Write(body[last:])
+			body = out.Bytes()
+		}
+	}
+	return body
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// El proxy HTTP hacia el pod se arma sobre httputil.ReverseProxy (ver proxyHTTP):
+// los headers de conexión, el framing, los trailers, las respuestas 1xx y el
+// flush de los streams los resuelve la biblioteca estándar. Acá quedan las
+// piezas propias del backend que se enchufan en sus puntos de extensión.
+
+// errPreProxyResponded indica que un hook pre-proxy ya respondió al cliente y la
+// petición no se envía al pod
+var errPreProxyResponded = errors.New("la petición fue respondida por un hook pre-proxy")
+
+// frameBlockedError indica que la respuesta no se puede mostrar en el iframe de
+// Argo CD; en su lugar se sirve la página de ayuda
+type frameBlockedError struct {
+	reason string
+}
+
+func (e *frameBlockedError) Error() string {
+	return fmt.Sprintf("respuesta no embebible: %s", e.reason)
+}
+
+// readCloser combina el cuerpo transformado con el Close del cuerpo original
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sessionRoundTripper envía la petición por el transporte de la sesión y la
+// reintenta una vez si el túnel la rechazó por falta de streams o si el
+// port-forward se cortó y la sesión reconectó. Solo se reintentan GET y HEAD sin
+// cuerpo, que se pueden repetir sin efectos.
+type sessionRoundTripper struct {
+	base      http.RoundTripper
+	session   *PortForwardSession
+	localPort int
+	// profile es el perfil con el que se agrupa la latencia medida
+	profile string
+	// responded lo marca el Rewrite cuando un hook pre-proxy respondió
+	responded bool
+}
+
+func (t *sessionRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.responded {
+		return nil, errPreProxyResponded
+	}
+	started := time.Now()
+	resp, err := t.roundTrip(req)
+	if err == nil || !isRetryable(req) {
+		return resp, err
+	}
+	if t.session.streamGateFor(t.localPort).failedSince(started) {
+		// El túnel rechazó el stream de esta conexión; con el límite ya ajustado la
+		// petición espera un stream libre en lugar de fallar
+		slog.Debug("Reintentando tras agotar los streams SPDY", "component", "proxyHTTP", "requestId", requestID(req.Context()), "method", req.Method, "path", req.URL.Path)
+		return t.roundTrip(req)
+	}
+	if t.session.awaitBrokenForward(req.Context()) {
+		// Se cortó el port-forward durante la petición y la sesión ya reconectó
+		// (quizás a otro pod del workload): reintentar una vez
+		slog.Debug("Reintentando tras reconectar el port-forward", "component", "proxyHTTP", "requestId", requestID(req.Context()), "method", req.Method, "path", req.URL.Path)
+		retry := req.Clone(req.Context())
+		retry.URL.Host = fmt.Sprintf("localhost:%d", t.session.pickLocalPort())
+		return t.roundTrip(retry)
+	}
+	return resp, err
+}
+
+// roundTrip hace un intento, con su span, y registra su latencia si el pod respondió
+func (t *sessionRoundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	req, span := startProxySpan(req, t.session)
+	var trace latencyTrace
+	resp, err := t.base.RoundTrip(trace.withLatencyTrace(req))
+	endHTTPSpan(span, resp, err)
+	if err == nil {
+		trace.record(t.session.Project, t.profile)
+	}
+	return resp, err
+}
+
+// isRetryable indica si la petición se puede repetir sin efectos
+func isRetryable(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errByteQuota corta la copia del cuerpo cuando la sesión superó su cuota de bytes
+var errByteQuota = errors.New("la sesión superó la cuota de bytes transferidos")
+
+// quotaExceededTTL es cuánto se recuerda una sesión cerrada por cuota para mostrar
+// la página explicativa en lugar del formulario de forward
+const quotaExceededTTL = time.Hour
+
+// exceededSession es una sesión cerrada por superar maxBytesPerSession
+type exceededSession struct {
+	Target string
+	Bytes  int64
+	Limit  int64
+	At     time.Time
+}
+
+var (
+	exceededSessions   = make(map[string]exceededSession)
+	exceededSessionsMu sync.Mutex
+)
+
+// maxBytesPerSession devuelve la cuota de bytes por sesión de la política; 0 es sin límite
+func (p *effectivePolicy) maxBytesPerSession() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.quotas.MaxBytesPerSession
+}
+
+// overByteQuota indica si la sesión ya superó la cuota y, la primera vez que la supera,
+// la cierra y lo registra en la auditoría
+func (s *PortForwardSession) overByteQuota() bool {
+	if s.quotaExceeded.Load() {
+		return true
+	}
+	limit := getPolicy().maxBytesPerSession()
+	if limit <= 0 {
+		return false
+	}
+	total := s.BytesIn.Load() + s.BytesOut.Load()
+	if total <= limit || !s.quotaExceeded.CompareAndSwap(false, true) {
+		return total > limit
+	}
+
+	target := fmt.Sprintf("%s/%s:%d", s.Namespace, s.Pod, s.Port)
+	record := sessionAuditRecord(s, "session-quota-exceeded")
+	record.Bytes = total
+	record.Result = fmt.Sprintf("cuota de %d bytes superada", limit)
+	auditAction(nil, record)
+	addCounter("pod_forward_sessions_quota_exceeded_total", map[string]string{"project": s.Project}, 1)
+
+	exceededSessionsMu.Lock()
+	for id, exceeded := range exceededSessions {
+		if time.Since(exceeded.At) > quotaExceededTTL {
+			delete(exceededSessions, id)
+		}
+	}
+	exceededSessions[s.ID] = exceededSession{Target: target, Bytes: total, Limit: limit, At: time.Now()}
+	exceededSessionsMu.Unlock()
+
+	s.Close(fmt.Sprintf("cuota de %d bytes superada (%d transferidos)", limit, total))
+	return true
+}
+
+// quotaExceededFor devuelve la sesión cerrada por cuota con ese ID, si se recuerda
+func quotaExceededFor(id string) (exceededSession, bool) {
+	exceededSessionsMu.Lock()
+	defer exceededSessionsMu.Unlock()
+	exceeded, ok := exceededSessions[id]
+	if !ok || time.Since(exceeded.At) > quotaExceededTTL {
+		return exceededSession{}, false
+	}
+	return exceeded, true
+}
+
+// serveQuotaExceededPage explica que la sesión se cerró por superar la cuota de bytes
+func serveQuotaExceededPage(w http.ResponseWriter, exceeded exceededSession) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeBrandedPage(w, http.StatusForbidden, "Cuota de transferencia superada", fmt.Sprintf(`    <p>La sesión hacia %s se cerró porque transfirió %d bytes y la política permite %d por sesión.</p>
+    <p>Puedes abrir una sesión nueva desde Argo CD.</p>
+`, html.EscapeString(exceeded.Target), exceeded.Bytes, exceeded.Limit))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Un dashboard que se refresca seguido puede acaparar el backend o saturar el pod.
+// Cada usuario (o IP, si la petición no trae usuario) tiene un token bucket de
+// RATE_LIMIT_USER_RPS/RATE_LIMIT_USER_BURST para todas sus peticiones, y cada sesión
+// otro de RATE_LIMIT_SESSION_RPS/RATE_LIMIT_SESSION_BURST para las que van al pod.
+// Al agotarse se responde 429 con Retry-After. Un RPS de 0 deshabilita el límite.
+
+// userLimiterTTL es cuánto se conserva el bucket de un usuario sin peticiones; al
+// volver empieza con el burst completo, igual que si se hubiera recargado
+const userLimiterTTL = 10 * time.Minute
+
+// userLimiter es el bucket de un usuario con su último uso
+type userLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+var (
+	userLimiters   = make(map[string]*userLimiter)
+	userLimitersMu sync.Mutex
+)
+
+// sessionLimiter es el bucket de la sesión, creado en su primera petición
+type sessionLimiter struct {
+	once    sync.Once
+	limiter *rate.Limiter
+}
+
+func init() {
+	registerPreProxyHook("rate-limit", rateLimitPreProxyHook)
+}
+
+// rateLimitUsers aplica el límite por usuario a todas las peticiones autenticadas;
+// los health checks y las métricas no cuentan
+func rateLimitUsers(next http.Handler) http.Handler {
+	if appConfig.RateLimitUserRPS <= 0 {
+		return next
+	}
+	goTask("rate-limit-sweep", sweepUserLimiters)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := identityFromRequest(r).User
+		if key == "" {
+			key = "ip:" + remoteHost(r)
+		}
+		if !allowRequest(w, userLimiterFor(key), "user") {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userLimiterFor devuelve el bucket del usuario, creándolo si no existe
+func userLimiterFor(key string) *rate.Limiter {
+	userLimitersMu.Lock()
+	defer userLimitersMu.Unlock()
+	entry := userLimiters[key]
+	if entry == nil {
+		entry = &userLimiter{limiter: rate.NewLimiter(rate.Limit(appConfig.RateLimitUserRPS), appConfig.RateLimitUserBurst)}
+		userLimiters[key] = entry
+	}
+	entry.lastSeen = time.Now()
+	return entry.limiter
+}
+
+// sweepUserLimiters descarta los buckets de los usuarios inactivos
+func sweepUserLimiters(ctx context.Context) error {
+	ticker := time.NewTicker(userLimiterTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		userLimitersMu.Lock()
+		for key, entry := range userLimiters {
+			if time.Since(entry.lastSeen) > userLimiterTTL {
+				delete(userLimiters, key)
+			}
+		}
+		userLimitersMu.Unlock()
+	}
+}
+
+// rateLimitPreProxyHook aplica el límite de la sesión antes de enviar la petición al pod
+func rateLimitPreProxyHook(w http.ResponseWriter, r *http.Request, upstream *http.Request, session *PortForwardSession) bool {
+	if appConfig.RateLimitSessionRPS <= 0 {
+		return true
+	}
+	session.limiter.once.Do(func() {
+		session.limiter.limiter = rate.NewLimiter(rate.Limit(appConfig.RateLimitSessionRPS), appConfig.RateLimitSessionBurst)
+	})
+	return allowRequest(w, session.limiter.limiter, "session")
+}
+
+// allowRequest toma un token del bucket o responde 429 con el tiempo hasta el
+// próximo token en Retry-After
+func allowRequest(w http.ResponseWriter, limiter *rate.Limiter, scope string) bool {
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if reservation.OK() && delay == 0 {
+		return true
+	}
+	reservation.Cancel()
+	addCounter("pod_forward_rate_limited_total", map[string]string{"scope": scope}, 1)
+	retryAfter := int(math.Ceil(delay.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, fmt.Sprintf("Demasiadas peticiones (límite por %s), reintentar en %ds", scopeName(scope), retryAfter), http.StatusTooManyRequests)
+	return false
+}
+
+// scopeName es el nombre del alcance del límite en el mensaje al usuario
+func scopeName(scope string) string {
+	if scope == "session" {
+		return "sesión"
+	}
+	return "usuario"
+}
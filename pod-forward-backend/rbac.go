@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// rbacRule es un permiso que necesita el backend con las opciones activas
+type rbacRule struct {
+	Rule rbacv1.PolicyRule
+	// Scope indica dónde aplica la regla: rbacCluster (ClusterRole), rbacTargets
+	// (namespaces de los pods) o el nombre de un namespace concreto
+	Scope string
+	// Reason es la opción que pide un permiso que no hace falta para el
+	// port-forward; se lista como comentario al principio del manifiesto
+	Reason string
+}
+
+// because documenta la opción que requiere la regla
+func (r rbacRule) because(reason string) rbacRule {
+	r.Reason = reason
+	return r
+}
+
+const (
+	rbacCluster = ""
+	rbacTargets = "*"
+)
+
+// rbacRules arma las reglas mínimas para las opciones activas: port-forward, exec
+// (archivos), pods auxiliares, targets service/job/workload, PodForwardPolicy,
+// multi-cluster, SubjectAccessReview y los ConfigMaps propios del backend. En los
+// clusters remotos los permisos son los de las credenciales de Argo CD.
+func rbacRules() []rbacRule {
+	rule := func(scope, group string, resources []string, verbs ...string) rbacRule {
+		return rbacRule{Scope: scope, Rule: rbacv1.PolicyRule{APIGroups: []string{group}, Resources: resources, Verbs: verbs}}
+	}
+	rules := []rbacRule{
+		rule(rbacTargets, "", []string{"pods"}, "get", "list"),
+		rule(rbacTargets, "", []string{"pods/portforward"}, "create", "get"),
+		rule(rbacTargets, "", []string{"services"}, "get"),
+		rule(rbacTargets, "discovery.k8s.io", []string{"endpointslices"}, "get", "list", "watch"),
+		rule(rbacTargets, "batch", []string{"jobs", "cronjobs"}, "get", "list"),
+		rule(rbacTargets, "apps", []string{"deployments", "statefulsets", "replicasets"}, "get"),
+		rule(rbacCluster, "", []string{"nodes"}, "get"),
+		rule(appConfig.ArgoCDNamespace, "argoproj.io", []string{"applications"}, "get", "list"),
+		rule(appConfig.ArgoCDNamespace, "", []string{"services"}, "get"),
+	}
+	if appConfig.HelpersEnabled {
+		for _, scope := range helperScopes() {
+			rules = append(rules, rule(scope, "", []string{"pods"}, "create", "delete").
+				because("HELPERS_ENABLED: pods auxiliares (pgweb, adminer) en HELPER_NAMESPACES"))
+		}
+	}
+	switch {
+	case appConfig.FileTransferEnabled:
+		rules = append(rules, rule(rbacTargets, "", []string{"pods/exec"}, "create", "get").
+			because("FILE_TRANSFER_ENABLED: descarga y subida de archivos (/api/v2/files) con exec en el contenedor"))
+	case appConfig.PolicyCRDEnabled:
+		rules = append(rules, rule(rbacTargets, "", []string{"pods/exec"}, "create", "get").
+			because("POLICY_CRD_ENABLED: transferencia de archivos en los fileTransferNamespaces de las políticas; limitar con namespaces="))
+	}
+	if appConfig.PolicyCRDEnabled {
+		rules = append(rules,
+			rule(rbacCluster, "pod-forward.argocd", []string{"podforwardpolicies"}, "get", "list", "watch"),
+			rule(rbacCluster, "pod-forward.argocd", []string{"podforwardpolicies/status"}, "update"),
+			rule(rbacTargets, "", []string{"secrets"}, "get").
+				because("POLICY_CRD_ENABLED: Secrets de los tokens de los perfiles y de los mappings de las políticas"))
+	}
+	if appConfig.MultiClusterEnabled {
+		rules = append(rules, rule(appConfig.ArgoCDNamespace, "", []string{"secrets"}, "get", "list").
+			because("MULTI_CLUSTER_ENABLED: Secrets de cluster de Argo CD con las credenciales de los clusters remotos"))
+	}
+	if appConfig.SubjectAccessReview {
+		rules = append(rules, rule(rbacCluster, "authorization.k8s.io", []string{"subjectaccessreviews"}, "create"))
+	}
+	if appConfig.SelfTestEnabled {
+		rules = append(rules, rule(rbacCluster, "authorization.k8s.io", []string{"selfsubjectaccessreviews"}, "create"))
+	}
+
+	// ConfigMaps propios: create no admite resourceNames, get y update sí
+	var configMaps []string
+	for _, name := range []string{appConfig.PersistenceConfigMap, appConfig.TemplatesConfigMap, appConfig.LinksConfigMap} {
+		if name != "" {
+			configMaps = append(configMaps, name)
+		}
+	}
+	if len(configMaps) > 0 {
+		named := rule(appConfig.PodNamespace, "", []string{"configmaps"}, "get", "update")
+		named.Rule.ResourceNames = configMaps
+		rules = append(rules, named, rule(appConfig.PodNamespace, "", []string{"configmaps"}, "create"))
+	}
+	return rules
+}
+
+// helperScopes devuelve dónde se crean pods auxiliares: los namespaces de
+// HELPER_NAMESPACES, o los de los targets si hay política o algún patrón
+func helperScopes() []string {
+	if appConfig.PolicyCRDEnabled {
+		return []string{rbacTargets}
+	}
+	for _, namespace := range appConfig.HelperNamespaces {
+		if strings.ContainsAny(namespace, "*?[") {
+			return []string{rbacTargets}
+		}
+	}
+	return appConfig.HelperNamespaces
+}
+
+// renderRBACManifest genera el YAML de RBAC para la ServiceAccount indicada. Sin
+// namespaces, las reglas de los targets van en el ClusterRole; con namespaces se
+// generan Roles solo en esos namespaces (el cache de EndpointSlices no sincroniza
+// sin permisos en todo el cluster y los targets service= consultan la API).
+func renderRBACManifest(name, serviceAccount, saNamespace string, namespaces []string) ([]byte, error) {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount, Namespace: saNamespace}}
+	labels := map[string]string{"app": "pod-forward-backend"}
+
+	clusterRole := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}
+	roles := make(map[string]*rbacv1.Role)
+	var roleOrder []string
+	addRole := func(namespace string, rule rbacv1.PolicyRule) {
+		role, ok := roles[namespace]
+		if !ok {
+			role = &rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			}
+			roles[namespace] = role
+			roleOrder = append(roleOrder, namespace)
+		}
+		role.Rules = append(role.Rules, rule)
+	}
+	for _, r := range rbacRules() {
+		switch {
+		case r.Scope == rbacCluster:
+			clusterRole.Rules = append(clusterRole.Rules, r.Rule)
+		case r.Scope == rbacTargets && len(namespaces) == 0:
+			clusterRole.Rules = append(clusterRole.Rules, r.Rule)
+		case r.Scope == rbacTargets:
+			for _, namespace := range namespaces {
+				addRole(namespace, r.Rule)
+			}
+		case len(namespaces) == 0 && ruleCovered(clusterRole.Rules, r.Rule):
+			// Ya la cubre una regla de los targets en el ClusterRole
+		default:
+			addRole(r.Scope, r.Rule)
+		}
+	}
+
+	objects := []interface{}{
+		clusterRole,
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+			Subjects:   subjects,
+		},
+	}
+	for _, namespace := range roleOrder {
+		objects = append(objects, roles[namespace], &rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			Subjects:   subjects,
+		})
+	}
+
+	var manifest []string
+	for _, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		// creationTimestamp: null no aporta nada al manifiesto
+		manifest = append(manifest, strings.ReplaceAll(string(data), "  creationTimestamp: null\n", ""))
+	}
+	return []byte(rbacReasons() + strings.Join(manifest, "---\n")), nil
+}
+
+// rbacReasons arma el comentario que explica los permisos opcionales del manifiesto
+func rbacReasons() string {
+	var header strings.Builder
+	for _, r := range rbacRules() {
+		if r.Reason == "" {
+			continue
+		}
+		if header.Len() == 0 {
+			header.WriteString("# Permisos opcionales por la configuración actual:\n")
+		}
+		fmt.Fprintf(&header, "# - %s %s: %s\n", strings.Join(r.Rule.Resources, ","), strings.Join(r.Rule.Verbs, ","), r.Reason)
+	}
+	return header.String()
+}
+
+// ruleCovered indica si alguna de las reglas ya otorga todo lo que pide rule
+func ruleCovered(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) bool {
+	for _, existing := range rules {
+		if len(existing.ResourceNames) == 0 &&
+			slices.Equal(existing.APIGroups, rule.APIGroups) &&
+			slices.Equal(existing.Resources, rule.Resources) &&
+			!slices.ContainsFunc(rule.Verbs, func(verb string) bool { return !slices.Contains(existing.Verbs, verb) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdminRBACManifest devuelve el RBAC mínimo para la configuración actual.
+// Parámetros opcionales: name, serviceAccount, namespace (de la ServiceAccount) y
+// namespaces (lista separada por comas para limitar los targets con Roles).
+func handleAdminRBACManifest(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, RoleAdmin) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		name = "pod-forward-backend"
+	}
+	serviceAccount := query.Get("serviceAccount")
+	if serviceAccount == "" {
+		serviceAccount = "pod-forward-backend"
+	}
+	saNamespace := query.Get("namespace")
+	if saNamespace == "" {
+		saNamespace = appConfig.PodNamespace
+	}
+	var namespaces []string
+	for _, namespace := range strings.Split(query.Get("namespaces"), ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	manifest, err := renderRBACManifest(name, serviceAccount, saNamespace, namespaces)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al generar el manifiesto: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(manifest)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// startIdleReaper cierra periódicamente las sesiones sin actividad durante más de
+// SESSION_IDLE_TTL, para liberar la conexión SPDY y el puerto local. Las sesiones
+// con WebSockets abiertos no se consideran inactivas.
+func startIdleReaper(ttl time.Duration) {
+	if ttl <= 0 {
+		slog.Info("Deshabilitado (SESSION_IDLE_TTL=0)", "component", "idleReaper")
+		return
+	}
+	interval := ttl / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	slog.Info("Cerrando sesiones inactivas", "component", "idleReaper", "ttl", ttl.String(), "interval", interval.String())
+	goTask("idle-reaper", func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				reapIdleSessions(ttl)
+			}
+		}
+	})
+}
+
+// reapIdleSessions cierra las sesiones inactivas. Close detiene el port-forward y
+// limpia activeSessions y localPortToSession.
+func reapIdleSessions(ttl time.Duration) {
+	var idle []*PortForwardSession
+	sessionsMu.RLock()
+	for _, sess := range activeSessions {
+		if sess.isIdle(ttl) {
+			idle = append(idle, sess)
+		}
+	}
+	sessionsMu.RUnlock()
+
+	for _, sess := range idle {
+		sess.Close(fmt.Sprintf("inactiva por más de %s", ttl))
+		addCounter("pod_forward_sessions_reaped_total", map[string]string{"project": sess.Project}, 1)
+	}
+}
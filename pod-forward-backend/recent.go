@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxRecentTargets es la cantidad de targets recientes que se guardan por usuario;
+// los favoritos no cuentan para el límite
+const maxRecentTargets = 20
+
+// recentTarget es un destino abierto por el usuario. Guarda la petición original
+// (job, cronJob o selector incluidos) para que se pueda reabrir aunque el pod cambie.
+type recentTarget struct {
+	createSessionRequest
+	LastUsed time.Time `json:"lastUsed"`
+	Favorite bool      `json:"favorite,omitempty"`
+}
+
+// key identifica el destino sin importar las opciones de la sesión
+func (t recentTarget) key() string {
+	port := fmt.Sprint(t.Port)
+	if t.PortName != "" {
+		port = t.PortName
+	}
+	return strings.Join([]string{t.Namespace, t.Pod, t.Job, t.CronJob, t.Selector, t.Service, t.Workload, port}, "|")
+}
+
+var (
+	// lastRecorded evita reescribir el ConfigMap en cada petición al mismo target
+	lastRecorded   = make(map[string]time.Time)
+	lastRecordedMu sync.Mutex
+)
+
+// recordRecentTarget agrega el target al historial del usuario en segundo plano
+func recordRecentTarget(clientset *kubernetes.Clientset, user string, target createSessionRequest) {
+	if user == "" {
+		return
+	}
+	entry := recentTarget{createSessionRequest: target, LastUsed: time.Now()}
+	// Las opciones de espera solo aplican al momento de la creación
+	entry.Wait, entry.WaitTimeout = false, ""
+
+	dedup := user + "|" + entry.key()
+	lastRecordedMu.Lock()
+	if time.Since(lastRecorded[dedup]) < time.Minute {
+		lastRecordedMu.Unlock()
+		return
+	}
+	lastRecorded[dedup] = entry.LastUsed
+	lastRecordedMu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := updateRecentTargets(ctx, clientset, user, func(targets []recentTarget) []recentTarget {
+			kept := []recentTarget{entry}
+			for _, target := range targets {
+				if target.key() == entry.key() {
+					kept[0].Favorite = target.Favorite
+					continue
+				}
+				kept = append(kept, target)
+			}
+			return trimRecentTargets(kept)
+		})
+		if err != nil {
+			slog.Error("Error al guardar el historial", "component", "recordRecentTarget", "user", user, "error", err)
+		}
+	}()
+}
+
+// trimRecentTargets conserva los favoritos y los maxRecentTargets más recientes
+func trimRecentTargets(targets []recentTarget) []recentTarget {
+	kept := targets[:0]
+	recent := 0
+	for _, target := range targets {
+		if !target.Favorite {
+			if recent >= maxRecentTargets {
+				continue
+			}
+			recent++
+		}
+		kept = append(kept, target)
+	}
+	return kept
+}
+
+// updateRecentTargets aplica update al historial guardado del usuario
+func updateRecentTargets(ctx context.Context, clientset *kubernetes.Clientset, user string, update func([]recentTarget) []recentTarget) error {
+	return updateUserData(ctx, clientset, appConfig.RecentConfigMap, user, func(raw string) (string, error) {
+		var targets []recentTarget
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &targets); err != nil {
+				return "", err
+			}
+		}
+		targets = update(targets)
+		if len(targets) == 0 {
+			return "", nil
+		}
+		data, err := json.Marshal(targets)
+		return string(data), err
+	})
+}
+
+// favoriteRequest es el cuerpo de POST /api/v2/recent/favorite
+type favoriteRequest struct {
+	createSessionRequest
+	Favorite bool `json:"favorite"`
+}
+
+// handleRecent devuelve (GET) el historial del usuario, con los favoritos primero,
+// y marca o desmarca favoritos (POST /favorite)
+func handleRecent(w http.ResponseWriter, r *http.Request, subpath string, clientset *kubernetes.Clientset) {
+	identity := identityFromRequest(r)
+	if identity.User == "" {
+		http.Error(w, "El historial requiere un usuario autenticado", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case subpath == "" && r.Method == http.MethodGet:
+		var targets []recentTarget
+		if err := loadUserData(r.Context(), clientset, appConfig.RecentConfigMap, identity.User, &targets); err != nil {
+			http.Error(w, fmt.Sprintf("Error al leer el historial: %v", err), http.StatusInternalServerError)
+			return
+		}
+		favorites, recent := []recentTarget{}, []recentTarget{}
+		for _, target := range targets {
+			if target.Favorite {
+				favorites = append(favorites, target)
+			} else {
+				recent = append(recent, target)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]recentTarget{"favorites": favorites, "recent": recent})
+	case subpath == "/favorite" && r.Method == http.MethodPost:
+		var body favoriteRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		entry := recentTarget{createSessionRequest: body.createSessionRequest, LastUsed: time.Now(), Favorite: body.Favorite}
+		err := updateRecentTargets(r.Context(), clientset, identity.User, func(targets []recentTarget) []recentTarget {
+			found := false
+			for i := range targets {
+				if targets[i].key() == entry.key() {
+					targets[i].Favorite = body.Favorite
+					found = true
+				}
+			}
+			if !found && body.Favorite {
+				targets = append([]recentTarget{entry}, targets...)
+			}
+			return trimRecentTargets(targets)
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error al guardar el favorito: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
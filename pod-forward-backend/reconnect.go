@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Espera entre intentos de reconexión: empieza en reconnectInitialBackoff y se
+// duplica hasta reconnectMaxBackoff
+const (
+	reconnectInitialBackoff = time.Second
+	reconnectMaxBackoff     = 30 * time.Second
+)
+
+// reconnectDetectGrace es cuánto se espera a que supervise note el corte del
+// port-forward cuando una petición en curso falla: el error de la petición puede
+// llegar antes que el de ForwardPorts
+const reconnectDetectGrace = time.Second
+
+// dialPortForward abre el port-forward SPDY hacia el pod. Con localPort 0 se elige
+// un puerto libre; una reconexión pide el mismo puerto que tenía la sesión para que
+// las URLs y el mapeo de localPortToSession sigan valiendo. errChan recibe el
+// resultado de ForwardPorts cuando termina. Si timing no es nil, recibe la duración
+// del handshake SPDY y la de la espera hasta que el puerto local está listo.
+func dialPortForward(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, namespace, pod string, localPort, port int, timing *[2]time.Duration) (*portforward.PortForwarder, chan struct{}, chan error, int, error) {
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("error al configurar transport: %v", err)
+	}
+
+	dialer := &timedDialer{Dialer: spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())}
+	started := time.Now()
+
+	stopChan := make(chan struct{}, 1)
+	readyChan := make(chan struct{}, 1)
+
+	// Crear el port-forward
+	ports := []string{fmt.Sprintf("%d:%d", localPort, port)}
+	pf, err := portforward.New(dialer, ports, stopChan, readyChan, io.Discard, io.Discard)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("error al crear port-forward: %v", err)
+	}
+
+	// Iniciar el port-forward en una goroutine
+	errChan := make(chan error, 1)
+	goTask("forward-ports", func(context.Context) error {
+		errChan <- pf.ForwardPorts()
+		return nil
+	})
+
+	// Esperar a que el port-forward esté listo. En los caminos de error se cierra
+	// stopChan para que la goroutine de ForwardPorts no quede colgada.
+	select {
+	case <-readyChan:
+		// Port-forward listo
+	case err := <-errChan:
+		close(stopChan)
+		if err != nil {
+			return nil, nil, nil, 0, fmt.Errorf("error al iniciar port-forward: %v", err)
+		}
+		return nil, nil, nil, 0, fmt.Errorf("el port-forward terminó antes de estar listo")
+	case <-time.After(5 * time.Second):
+		close(stopChan)
+		return nil, nil, nil, 0, fmt.Errorf("timeout al iniciar port-forward")
+	case <-ctx.Done():
+		close(stopChan)
+		return nil, nil, nil, 0, fmt.Errorf("cancelado al iniciar port-forward: %v", ctx.Err())
+	}
+
+	if timing != nil {
+		timing[0] = dialer.handshake
+		timing[1] = time.Since(started) - dialer.handshake
+	}
+
+	// Obtener el puerto local asignado
+	forwardedPorts, err := pf.GetPorts()
+	if err != nil || len(forwardedPorts) == 0 {
+		close(stopChan)
+		return nil, nil, nil, 0, fmt.Errorf("error al obtener puerto local")
+	}
+	return pf, stopChan, errChan, int(forwardedPorts[0].Local), nil
+}
+
+// supervise espera el fin del port-forward. Si se perdió la conexión con el pod
+// (por ejemplo, el API server no estuvo disponible un momento) marca la sesión como
+// Degraded y reintenta con backoff durante SESSION_RECONNECT_TIMEOUT; si no logra
+// reconectar, o el fin fue un cierre explícito, cierra la sesión.
+func (s *PortForwardSession) supervise(errChan chan error, clientset *kubernetes.Clientset, config *rest.Config) {
+	defer close(s.done)
+	for {
+		err := <-errChan
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+		if !errors.Is(err, portforward.ErrLostConnectionToPod) || appConfig.SessionReconnectTimeout <= 0 {
+			reason := "port-forward finalizado"
+			if err != nil {
+				reason = fmt.Sprintf("port-forward finalizado con error: %v", err)
+			}
+			s.Close(reason)
+			return
+		}
+
+		errChan, err = s.reconnect(clientset, config)
+		if err != nil {
+			s.Close(fmt.Sprintf("no se pudo reconectar: %v", err))
+			return
+		}
+		if errChan == nil {
+			// Se cerró mientras se reconectaba
+			return
+		}
+	}
+}
+
+// reconnect reabre el port-forward en el mismo puerto local. Devuelve el errChan
+// del port-forward nuevo, o nil si la sesión se cerró mientras tanto.
+func (s *PortForwardSession) reconnect(clientset *kubernetes.Clientset, config *rest.Config) (chan error, error) {
+	s.mu.Lock()
+	s.Degraded = true
+	s.resumed = make(chan struct{})
+	stop := s.StopChan
+	pod := s.Pod
+	s.mu.Unlock()
+	slog.Warn("Sesión degradada: se perdió la conexión con el pod, reconectando", "component", "reconnect", "session", s.ID, "namespace", s.Namespace, "pod", s.Pod)
+	addCounter("pod_forward_session_reconnects_total", map[string]string{"project": s.Project, "result": "degraded"}, 1)
+
+	deadline := time.Now().Add(appConfig.SessionReconnectTimeout)
+	backoff := reconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			// Close cierra el StopChan vigente
+			return nil, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), appConfig.ClusterHealthTimeout)
+		_, err := clientset.CoreV1().Pods(s.Namespace).Get(ctx, pod, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// Si el pod pertenece a un Deployment o StatefulSet se continúa con otro
+			// pod listo del mismo workload
+			replacement, _, resolveErr := s.replacementPod(ctx, clientset)
+			if resolveErr != nil {
+				cancel()
+				slog.Warn("Sin pod de reemplazo para la sesión", "component", "reconnect", "session", s.ID, "error", resolveErr)
+				addCounter("pod_forward_session_reconnects_total", map[string]string{"project": s.Project, "result": "failed"}, 1)
+				return nil, fmt.Errorf("el pod %s/%s ya no existe", s.Namespace, pod)
+			}
+			slog.Info("El pod ya no existe, la sesión continúa con otro", "component", "reconnect", "namespace", s.Namespace, "pod", pod, "session", s.ID, "replacement", replacement)
+			pod, err = replacement, nil
+		}
+		var pf *portforward.PortForwarder
+		var stopChan chan struct{}
+		var errChan chan error
+		if err == nil {
+			pf, stopChan, errChan, _, err = dialPortForward(ctx, clientset, config, s.Namespace, pod, s.LocalPort, s.Port, nil)
+		}
+		cancel()
+
+		if err == nil {
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				close(stopChan)
+				return nil, nil
+			}
+			replaced := s.Pod != pod
+			s.PF, s.StopChan, s.Degraded, s.Pod = pf, stopChan, false, pod
+			close(s.resumed)
+			s.mu.Unlock()
+			// El túnel nuevo puede admitir otra cantidad de streams, y las conexiones
+			// del pool apuntaban al port-forward anterior
+			s.streams.reset()
+			s.closeIdleConnections()
+			if replaced {
+				persistSessions()
+			}
+			slog.Info("Sesión reconectada", "component", "reconnect", "session", s.ID, "attempt", attempt)
+			addCounter("pod_forward_session_reconnects_total", map[string]string{"project": s.Project, "result": "resumed"}, 1)
+			return errChan, nil
+		}
+
+		slog.Warn("Intento de reconexión fallido", "component", "reconnect", "session", s.ID, "attempt", attempt, "error", err)
+		if time.Now().Add(backoff).After(deadline) {
+			addCounter("pod_forward_session_reconnects_total", map[string]string{"project": s.Project, "result": "failed"}, 1)
+			return nil, err
+		}
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// replacementPod devuelve un pod listo del workload al que pertenecía el pod de la sesión
+func (s *PortForwardSession) replacementPod(ctx context.Context, clientset *kubernetes.Clientset) (string, int, error) {
+	if s.Workload == "" {
+		return "", http.StatusNotFound, fmt.Errorf("el pod no pertenece a un Deployment ni a un StatefulSet")
+	}
+	return resolveWorkloadTarget(ctx, clientset, s.Namespace, s.Workload, false, 0, nil)
+}
+
+// awaitReconnect espera hasta SESSION_RECONNECT_WAIT a que la sesión degradada vuelva
+// a tener port-forward. Devuelve false si se cerró o no reconectó a tiempo.
+func (s *PortForwardSession) awaitReconnect(ctx context.Context) bool {
+	s.mu.Lock()
+	degraded, resumed := s.Degraded, s.resumed
+	s.mu.Unlock()
+	if !degraded {
+		return true
+	}
+	if appConfig.SessionReconnectWait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(appConfig.SessionReconnectWait)
+	defer timer.Stop()
+	select {
+	case <-resumed:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return !s.closed && !s.Degraded
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// awaitBrokenForward se usa cuando falla una petición hacia el pod: si el fallo se
+// debe a que se cortó el port-forward, espera a que la sesión reconecte y devuelve
+// true para que la petición se reintente una vez
+func (s *PortForwardSession) awaitBrokenForward(ctx context.Context) bool {
+	if appConfig.SessionReconnectTimeout <= 0 || appConfig.SessionReconnectWait <= 0 {
+		return false
+	}
+	deadline := time.Now().Add(reconnectDetectGrace)
+	for !s.isDegraded() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return s.awaitReconnect(ctx)
+}
+
+// isDegraded indica si la sesión está reconectando con el pod
+func (s *PortForwardSession) isDegraded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Degraded
+}
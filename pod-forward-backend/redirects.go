@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Si la reescritura del prefijo no coincide con lo que espera la aplicación (por
+// ejemplo redirige /app a /app/ y la ruta reescrita vuelve a entrar como /app), el
+// navegador sigue redirects hasta su propio límite y el iframe queda girando. Cada
+// navegador (sesión, IP y User-Agent) lleva la cadena de redirects que viene
+// siguiendo: si un salto se repite tres veces o la cadena supera MAX_REDIRECT_DEPTH, en lugar
+// del redirect se sirve una página que muestra el bucle.
+
+// redirectChainWindow es el tiempo máximo entre dos redirects de la misma cadena; el
+// navegador sigue el siguiente en el momento
+const redirectChainWindow = 5 * time.Second
+
+// redirectHop es un redirect de la cadena: la ruta pedida y el Location ya reescrito
+type redirectHop struct {
+	From   string
+	To     string
+	Status int
+}
+
+// redirectChain son los redirects consecutivos que siguió un navegador
+type redirectChain struct {
+	hops []redirectHop
+	last time.Time
+}
+
+var (
+	redirectChains   = make(map[string]*redirectChain)
+	redirectChainsMu sync.Mutex
+)
+
+// redirectLoopError corta la respuesta del pod para servir la página del bucle
+type redirectLoopError struct {
+	hops   []redirectHop
+	reason string
+}
+
+func (e *redirectLoopError) Error() string {
+	return fmt.Sprintf("bucle de redirects: %s", e.reason)
+}
+
+// trackRedirect agrega la respuesta a la cadena de redirects del navegador y
+// devuelve un redirectLoopError si detecta un bucle. Una respuesta que no redirige
+// termina la cadena, salvo las de fetch/XHR en segundo plano.
+func trackRedirect(r *http.Request, session *PortForwardSession, resp *http.Response) error {
+	key := session.ID + "|" + remoteHost(r) + "|" + r.UserAgent()
+	location := resp.Header.Get("Location")
+	redirect := resp.StatusCode >= 300 && resp.StatusCode < 400 && location != ""
+
+	redirectChainsMu.Lock()
+	defer redirectChainsMu.Unlock()
+	chain := redirectChains[key]
+	if !redirect {
+		if chain != nil && r.Header.Get("Sec-Fetch-Mode") != "cors" {
+			delete(redirectChains, key)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	if chain == nil || now.Sub(chain.last) > redirectChainWindow {
+		sweepRedirectChains(now)
+		chain = &redirectChain{}
+		redirectChains[key] = chain
+	}
+	chain.last = now
+	hop := redirectHop{From: r.URL.RequestURI(), To: location, Status: resp.StatusCode}
+	chain.hops = append(chain.hops, hop)
+	// Un salto repetido una vez puede ser legítimo (la primera respuesta fija una
+	// cookie y la siguiente la verifica); a la tercera es un bucle
+	repeats := 0
+	for _, prev := range chain.hops {
+		if prev == hop {
+			repeats++
+		}
+	}
+	if repeats >= 3 {
+		delete(redirectChains, key)
+		return &redirectLoopError{hops: chain.hops, reason: fmt.Sprintf("%s redirige a %s una y otra vez", hop.From, hop.To)}
+	}
+	if len(chain.hops) > appConfig.MaxRedirectDepth {
+		delete(redirectChains, key)
+		return &redirectLoopError{hops: chain.hops, reason: fmt.Sprintf("más de %d redirects seguidos", appConfig.MaxRedirectDepth)}
+	}
+	return nil
+}
+
+// sweepRedirectChains descarta las cadenas vencidas; se llama con el lock tomado
+// al empezar una cadena nueva
+func sweepRedirectChains(now time.Time) {
+	for key, chain := range redirectChains {
+		if now.Sub(chain.last) > redirectChainWindow {
+			delete(redirectChains, key)
+		}
+	}
+}
+
+// serveRedirectLoopPage muestra la cadena de redirects que formó el bucle
+func serveRedirectLoopPage(w http.ResponseWriter, session *PortForwardSession, loop *redirectLoopError) {
+	slog.Warn("Bucle de redirects", "component", "redirects", "session", session.ID, "namespace", session.Namespace,
+		"pod", session.Pod, "port", session.Port, "reason", loop.reason)
+	addCounter("pod_forward_redirect_loops_total", map[string]string{"project": session.Project}, 1)
+
+	var hops strings.Builder
+	for _, hop := range loop.hops {
+		fmt.Fprintf(&hops, "      <li><code>%s</code> → <code>%s</code> (%d)</li>\n",
+			html.EscapeString(hop.From), html.EscapeString(hop.To), hop.Status)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeBrandedPage(w, http.StatusLoopDetected, "La aplicación entró en un bucle de redirects", fmt.Sprintf(`    <p>Se cortó la navegación porque %s.</p>
+    <ol>
+%s    </ol>
+    <p>Suele ocurrir cuando la aplicación no sabe que se sirve bajo un prefijo: revisa su
+    configuración de URL base o usa un perfil de PodForwardPolicy que la indique.</p>
+`, html.EscapeString(loop.reason), hops.String()))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// targetSpec es el target pedido por el cliente (API v1 o v2) mientras recorre la
+// cadena de resolvers: cada uno completa Kube, Pod o Port a partir de lo pedido
+type targetSpec struct {
+	// Cluster es el cluster de Argo CD pedido; Kube es el cluster ya resuelto
+	Cluster string
+	Kube    *kubeTarget
+
+	Namespace string
+	Pod       string
+	Port      int
+	// PortName es un puerto con nombre del contenedor (port=http); Container es
+	// el contenedor que lo declara, una vez resuelto
+	PortName  string
+	Container string
+
+	Job         string
+	CronJob     string
+	Selector    string
+	Workload    string
+	Service     string
+	Endpoint    endpointOptions
+	Wait        bool
+	WaitTimeout time.Duration
+
+	// Progress recibe los cambios de estado mientras se espera un pod (puede ser nil)
+	Progress func(string)
+}
+
+// TargetResolver es una estrategia para llegar al pod de una sesión. Los
+// resolvers no conocen las sesiones: solo completan el targetSpec.
+type TargetResolver interface {
+	// Name identifica el resolver en los logs
+	Name() string
+	// Matches indica si el resolver aplica a lo pedido y a lo ya resuelto
+	Matches(spec *targetSpec) bool
+	// Resolve completa spec; junto al error devuelve el código HTTP a responder
+	Resolve(ctx context.Context, spec *targetSpec) (int, error)
+}
+
+// targetResolvers es la cadena de resolvers en orden: primero el cluster, después
+// los que eligen el pod. Una vez que uno fija el pod los siguientes no aplican.
+var targetResolvers = []TargetResolver{
+	clusterResolver{},
+	podResolver{},
+	jobResolver{},
+	selectorResolver{},
+	workloadResolver{},
+	serviceResolver{},
+	containerPortResolver{},
+}
+
+// registerTargetResolver agrega una estrategia de resolución al final de la cadena
+func registerTargetResolver(resolver TargetResolver) {
+	targetResolvers = append(targetResolvers, resolver)
+}
+
+// resolveTarget pasa spec por la cadena de resolvers. Lo que no se pudo resolver
+// (por ejemplo falta el pod) queda vacío y lo valida quien abre la sesión.
+func resolveTarget(ctx context.Context, spec *targetSpec) (int, error) {
+	for _, resolver := range targetResolvers {
+		if !resolver.Matches(spec) {
+			continue
+		}
+		if status, err := resolver.Resolve(ctx, spec); err != nil {
+			slog.Info("Resolver sin resultado", "component", "resolveTarget", "resolver", resolver.Name(), "error", err)
+			return status, err
+		}
+	}
+	return 0, nil
+}
+
+// podSelected indica si ya hay un pod elegido o no hay namespace donde buscarlo
+func (spec *targetSpec) podSelected() bool {
+	return spec.Pod != "" || spec.Namespace == ""
+}
+
+// clusterResolver resuelve el cluster: el local o uno de Argo CD (multi-cluster)
+type clusterResolver struct{}
+
+func (clusterResolver) Name() string { return "cluster" }
+
+func (clusterResolver) Matches(spec *targetSpec) bool { return spec.Kube == nil }
+
+func (clusterResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	kube, status, err := resolveCluster(ctx, spec.Cluster)
+	if err != nil {
+		return status, err
+	}
+	spec.Kube = kube
+	return 0, nil
+}
+
+// podResolver es el pod por nombre: no hay nada que buscar, solo se validan los
+// nombres antes de llegar a la API. Con el pod fijado los demás ya no aplican.
+type podResolver struct{}
+
+func (podResolver) Name() string { return "pod" }
+
+func (podResolver) Matches(spec *targetSpec) bool { return spec.Pod != "" }
+
+func (podResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	if errs := validation.IsDNS1123Label(spec.Namespace); spec.Namespace != "" && len(errs) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("namespace inválido %q: %s", spec.Namespace, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidPortName(spec.PortName); spec.PortName != "" && len(errs) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("puerto inválido %q: %s", spec.PortName, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsDNS1123Subdomain(spec.Pod); len(errs) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("pod inválido %q: %s", spec.Pod, strings.Join(errs, "; "))
+	}
+	return 0, nil
+}
+
+// jobResolver apunta al pod más reciente de un Job o del último Job de un CronJob
+type jobResolver struct{}
+
+func (jobResolver) Name() string { return "job" }
+
+func (jobResolver) Matches(spec *targetSpec) bool {
+	return !spec.podSelected() && (spec.Job != "" || spec.CronJob != "")
+}
+
+func (jobResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	if spec.CronJob != "" {
+		if status, err := requireCapability(ctx, spec.Kube, capabilityCronJobs); err != nil {
+			return status, err
+		}
+	}
+	pod, status, err := resolveJobTarget(ctx, spec.Kube.Clientset, spec.Namespace, spec.Job, spec.CronJob)
+	if err != nil {
+		return status, err
+	}
+	spec.Pod = pod
+	return 0, nil
+}
+
+// selectorResolver apunta al pod listo más reciente con las labels del selector
+type selectorResolver struct{}
+
+func (selectorResolver) Name() string { return "selector" }
+
+func (selectorResolver) Matches(spec *targetSpec) bool {
+	return !spec.podSelected() && spec.Selector != ""
+}
+
+func (selectorResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	pod, status, err := resolveSelectorTarget(ctx, spec.Kube.Clientset, spec.Namespace, spec.Selector, spec.Wait, spec.WaitTimeout, spec.Progress)
+	if err != nil {
+		return status, err
+	}
+	spec.Pod = pod
+	return 0, nil
+}
+
+// workloadResolver apunta a un pod listo de un Deployment o StatefulSet
+type workloadResolver struct{}
+
+func (workloadResolver) Name() string { return "workload" }
+
+func (workloadResolver) Matches(spec *targetSpec) bool {
+	return !spec.podSelected() && spec.Workload != ""
+}
+
+func (workloadResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	pod, status, err := resolveWorkloadTarget(ctx, spec.Kube.Clientset, spec.Namespace, spec.Workload, spec.Wait, spec.WaitTimeout, spec.Progress)
+	if err != nil {
+		return status, err
+	}
+	spec.Pod = pod
+	return 0, nil
+}
+
+// serviceResolver apunta a un pod listo detrás del Service; Port es el puerto del
+// Service y se reemplaza por el targetPort del pod elegido
+type serviceResolver struct{}
+
+func (serviceResolver) Name() string { return "service" }
+
+func (serviceResolver) Matches(spec *targetSpec) bool {
+	return !spec.podSelected() && spec.Service != ""
+}
+
+func (serviceResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	if status, err := requireCapability(ctx, spec.Kube, capabilityEndpointSlices); err != nil {
+		return status, err
+	}
+	pod, port, status, err := resolveServiceTarget(ctx, spec.Kube.Clientset, spec.Namespace, spec.Service, spec.Port, spec.Endpoint)
+	if err != nil {
+		return status, err
+	}
+	spec.Pod, spec.Port = pod, port
+	return 0, nil
+}
+
+// containerPortResolver traduce un puerto con nombre al número que declara el
+// contenedor del pod ya elegido, así las URLs no dependen del número
+type containerPortResolver struct{}
+
+func (containerPortResolver) Name() string { return "containerPort" }
+
+func (containerPortResolver) Matches(spec *targetSpec) bool {
+	return spec.PortName != "" && spec.Pod != "" && spec.Port == 0
+}
+
+func (containerPortResolver) Resolve(ctx context.Context, spec *targetSpec) (int, error) {
+	pod, err := spec.Kube.Clientset.CoreV1().Pods(spec.Namespace).Get(ctx, spec.Pod, metav1.GetOptions{})
+	if err != nil {
+		return lookupStatus(err), fmt.Errorf("error al obtener el pod %s/%s: %v", spec.Namespace, spec.Pod, err)
+	}
+	// Los sidecars nativos son initContainers con restartPolicy Always
+	containers := append(pod.Spec.Containers, pod.Spec.InitContainers...)
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			if port.Name == spec.PortName {
+				spec.Port, spec.Container = int(port.ContainerPort), container.Name
+				slog.Debug("Puerto con nombre resuelto", "component", "resolveTarget", "portName", spec.PortName, "namespace", spec.Namespace,
+					"pod", spec.Pod, "port", spec.Port, "container", spec.Container)
+				return 0, nil
+			}
+		}
+	}
+	return http.StatusBadRequest, fmt.Errorf("el pod %s/%s no declara un puerto llamado %q", spec.Namespace, spec.Pod, spec.PortName)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rawHeader pide que una petición pase sin reescritura; no se reenvía al pod
+const rawHeader = "X-Pod-Forward-Raw"
+
+// rawRequested indica si la petición pidió modo raw y quita el header
+func rawRequested(r *http.Request) bool {
+	raw := r.Header.Get(rawHeader) == "true"
+	r.Header.Del(rawHeader)
+	return raw
+}
+
+// newUpstreamTransport arma el transporte con plazos separados para conectar, el
+// handshake TLS y las conexiones inactivas, así una aplicación lenta en responder no
+// comparte plazo con un pod que no acepta conexiones. La espera de los headers se
+// controla por petición (requestTimeouts). Con disableCompression no agrega
+// Accept-Encoding por su cuenta, de modo que el cuerpo nunca se descomprime en el
+// backend y llega al cliente byte a byte (modo raw).
+func newUpstreamTransport(disableCompression bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialUpstream
+	transport.TLSHandshakeTimeout = appConfig.UpstreamTLSTimeout
+	transport.IdleConnTimeout = appConfig.UpstreamIdleTimeout
+	// Cada túnel de la sesión es un host (localhost:<puerto local>); el pool debe
+	// poder guardar al menos las conexiones que mantiene keepWarm
+	transport.MaxIdleConnsPerHost = max(appConfig.WarmConnections, appConfig.UpstreamMaxIdleConns)
+	transport.MaxIdleConns = transport.MaxIdleConnsPerHost * max(appConfig.MaxSessionTunnels, 1)
+	transport.DisableCompression = disableCompression
+	return transport
+}
+
+// maxRewriteHeaderLen es el tamaño máximo de un header que se intenta reescribir;
+// valores más grandes se dejan tal cual en lugar de parsearlos
+const maxRewriteHeaderLen = 8 << 10
+
+// upstreamPath convierte la ruta escapada que recibió el backend en la ruta del pod.
+// Se trabaja siempre sobre la forma escapada (URL.EscapedPath) para que secuencias
+// como %2F o %3F no se decodifiquen y cambien el significado de la URL en el pod.
+func upstreamPath(escapedPath string) string {
+	// Si la ruta es /forward o <prefijo>/forward, usar la raíz del pod
+	if escapedPath == "/forward" || escapedPath == extensionBasePath+"/forward" {
+		return "/"
+	}
+	// Remover el prefijo de la sesión o el del proxy para obtener la ruta real
+	if _, rest, ok := splitSessionPath(escapedPath); ok {
+		escapedPath = rest
+	} else if strings.HasPrefix(escapedPath, extensionBasePath+"/") {
+		escapedPath = strings.TrimPrefix(escapedPath, extensionBasePath)
+	}
+	// Una sola barra inicial: //host/ruta es una referencia a otro host para el pod
+	return "/" + strings.TrimLeft(escapedPath, "/")
+}
+
+// upstreamURL arma la URL hacia el puerto local del port-forward conservando
+// la ruta escapada y la query tal como llegaron
+func upstreamURL(host, escapedPath, rawQuery string) *url.URL {
+	target := &url.URL{Scheme: "http", Host: host, RawQuery: rawQuery}
+	path := upstreamPath(escapedPath)
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		target.Path = unescaped
+		target.RawPath = path
+	} else {
+		// Escapes inválidos: se envía la ruta literal y url.URL la re-escapa
+		target.Path = path
+	}
+	return target
+}
+
+// hasProxyPrefix indica si una ruta ya está bajo el prefijo del proxy
+func hasProxyPrefix(path string) bool {
+	return path == extensionBasePath || strings.HasPrefix(path, extensionBasePath+"/")
+}
+
+// rewriteLocation convierte el header Location del pod en una ruta bajo el prefijo de
+// la sesión. Los redirects relativos sin barra inicial se dejan tal cual porque el
+// navegador los resuelve contra la URL actual, que ya incluye el prefijo; las rutas
+// que ya están bajo el prefijo del proxy también. Los absolutos solo se reescriben si
+// apuntan a la aplicación (isAppHost): un redirect a un IdP sigue yendo al IdP.
+func rewriteLocation(location, prefix string, appHosts []string) string {
+	if location == "" || len(location) > maxRewriteHeaderLen {
+		return location
+	}
+
+	lower := strings.ToLower(location)
+	absolute := strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(location, "//")
+	if !absolute {
+		if !strings.HasPrefix(location, "/") || hasProxyPrefix(location) {
+			return location
+		}
+		// Redirect relativo: agregar el prefijo de la sesión
+		return prefix + location
+	}
+
+	// Redirect absoluto (o relativo al protocolo) a la aplicación: conservar ruta,
+	// query y fragmento
+	parsedURL, err := url.Parse(location)
+	if err != nil || !isAppHost(parsedURL.Host, appHosts) {
+		return location
+	}
+	path := parsedURL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if !hasProxyPrefix(path) {
+		path = prefix + path
+	}
+	if parsedURL.RawQuery != "" {
+		path += "?" + parsedURL.RawQuery
+	}
+	if parsedURL.Fragment != "" {
+		path += "#" + parsedURL.EscapedFragment()
+	}
+	return path
+}
+
+// isAppHost indica si el host de un redirect absoluto es la propia aplicación: un
+// localhost (el puerto local o el que tiene configurado la aplicación), un nombre
+// interno del cluster (sin dominio, o terminado en .svc o .cluster.local) o uno de
+// appHosts, los hosts públicos por los que el navegador llega al backend
+func isAppHost(host string, appHosts []string) bool {
+	name := hostName(host)
+	if name == "" {
+		return false
+	}
+	if name == "localhost" || !strings.Contains(name, ".") || strings.HasSuffix(name, ".svc") || strings.HasSuffix(name, ".cluster.local") {
+		return true
+	}
+	if ip := net.ParseIP(name); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, appHost := range appHosts {
+		if hostName(appHost) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hostName es el host sin puerto, en minúsculas
+func hostName(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// appHostsFor son los hosts públicos de la aplicación para rewriteLocation: el de la
+// petición, el de la URL pública y el origen que espera la aplicación
+func appHostsFor(r *http.Request, profile PolicyProfile) []string {
+	hosts := []string{r.Host}
+	for _, origin := range []string{externalBaseURL(r), profile.UpstreamOrigin, appConfig.UpstreamOrigin} {
+		if parsed, err := url.Parse(origin); err == nil && parsed.Host != "" {
+			hosts = append(hosts, parsed.Host)
+		}
+	}
+	return hosts
+}
+
+// rewriteSetCookies ajusta los Set-Cookie del pod al prefijo de la sesión: Path=/login
+// pasa a ser <prefijo>/login, para que el navegador envíe la cookie a las rutas de
+// la aplicación, y se quita Domain, que nombra el host del pod y no el de Argo CD.
+// Sin Path el navegador usa el directorio de la petición, que ya está bajo el prefijo.
+func rewriteSetCookies(header http.Header, prefix string) {
+	cookies := header.Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+	header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		header.Add("Set-Cookie", rewriteSetCookie(cookie, prefix))
+	}
+}
+
+// rewriteSetCookie reescribe los atributos Path y Domain de un Set-Cookie y deja el
+// resto tal cual llegó
+func rewriteSetCookie(cookie, prefix string) string {
+	if len(cookie) > maxRewriteHeaderLen {
+		return cookie
+	}
+	parts := strings.Split(cookie, ";")
+	kept := parts[:1]
+	for _, attr := range parts[1:] {
+		// El navegador ignora los espacios alrededor del nombre y del valor
+		name, value, _ := strings.Cut(attr, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Domain"):
+			continue
+		case strings.EqualFold(name, "Path") && strings.HasPrefix(value, "/") && !hasProxyPrefix(value):
+			attr = " Path=" + prefix + value
+		}
+		kept = append(kept, attr)
+	}
+	return strings.Join(kept, ";")
+}
+
+// isAttachment indica si la respuesta es una descarga (Content-Disposition: attachment)
+func isAttachment(header http.Header) bool {
+	disposition, _, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	return err == nil && disposition == "attachment"
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const fuzzPrefix = extensionBasePath + sessionPathSegment + "abc123"
+
+func FuzzUpstreamPath(f *testing.F) {
+	for _, seed := range []string{
+		"/forward",
+		extensionBasePath + "/forward",
+		extensionBasePath + "/login",
+		fuzzPrefix + "/api/a%2Fb",
+		fuzzPrefix + "//evil.example/x",
+		extensionBasePath + "//evil.example",
+		"//evil.example/x",
+		"",
+		"%zz",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, escapedPath string) {
+		path := upstreamPath(escapedPath)
+		if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+			t.Fatalf("upstreamPath(%q) = %q: debe empezar con una sola barra", escapedPath, path)
+		}
+		target := upstreamURL("127.0.0.1:4000", escapedPath, "")
+		reparsed, err := url.Parse(target.String())
+		if err != nil {
+			t.Fatalf("upstreamURL(%q) = %q no se puede parsear: %v", escapedPath, target, err)
+		}
+		if reparsed.Host != "127.0.0.1:4000" {
+			t.Fatalf("upstreamURL(%q) = %q apunta a %q", escapedPath, target, reparsed.Host)
+		}
+	})
+}
+
+func FuzzRewriteLocation(f *testing.F) {
+	for _, seed := range []string{
+		"/login",
+		"login",
+		"//evil.example/x",
+		"http://localhost:3000/dashboard?x=1#top",
+		"https://argocd.example.com/app",
+		"http://grafana.monitoring.svc:3000/",
+		"https://idp.example.org/authorize?redirect_uri=http://localhost/",
+		extensionBasePath + "/x",
+		"/\\evil.example",
+		"http://[::1]:8080/",
+	} {
+		f.Add(seed)
+	}
+	appHosts := []string{"argocd.example.com"}
+	f.Fuzz(func(t *testing.T, location string) {
+		rewritten := rewriteLocation(location, fuzzPrefix, appHosts)
+		if rewritten != location {
+			if !hasProxyPrefix(rewritten) {
+				t.Fatalf("rewriteLocation(%q) = %q: fuera del prefijo", location, rewritten)
+			}
+			if parsed, err := url.Parse(rewritten); err == nil && parsed.Host != "" {
+				t.Fatalf("rewriteLocation(%q) = %q: apunta al host %q", location, rewritten, parsed.Host)
+			}
+		}
+
+		// Un redirect a otro sitio se deja tal cual
+		foreign := "https://idp.example.org/" + strings.TrimLeft(location, "/")
+		if got := rewriteLocation(foreign, fuzzPrefix, appHosts); got != foreign {
+			t.Fatalf("rewriteLocation(%q) = %q: reescribió un host ajeno", foreign, got)
+		}
+	})
+}
+
+func FuzzRewriteSetCookie(f *testing.F) {
+	for _, seed := range []string{
+		"sid=abc; Path=/; HttpOnly",
+		"sid=abc; Path= /admin ; Domain=example.com",
+		"sid=abc; path=/x; DOMAIN =.example.com; Secure",
+		"sid=abc; Path=" + extensionBasePath + "/x",
+		"sid=abc; Path=relative",
+		"sid",
+		";;;",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, cookie string) {
+		rewritten := rewriteSetCookie(cookie, fuzzPrefix)
+		nameValue, _, _ := strings.Cut(cookie, ";")
+		if got, _, _ := strings.Cut(rewritten, ";"); got != nameValue {
+			t.Fatalf("rewriteSetCookie(%q) = %q: cambió el nombre o el valor", cookie, rewritten)
+		}
+		if len(cookie) > maxRewriteHeaderLen {
+			return
+		}
+		for _, attr := range strings.Split(rewritten, ";")[1:] {
+			name, value, _ := strings.Cut(attr, "=")
+			name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+			if strings.EqualFold(name, "Domain") {
+				t.Fatalf("rewriteSetCookie(%q) = %q: conserva Domain", cookie, rewritten)
+			}
+			if strings.EqualFold(name, "Path") && strings.HasPrefix(value, "/") && !hasProxyPrefix(value) {
+				t.Fatalf("rewriteSetCookie(%q) = %q: Path fuera del prefijo", cookie, rewritten)
+			}
+		}
+	})
+}
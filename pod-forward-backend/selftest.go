@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permissionCheck es un permiso que necesita la ServiceAccount del backend
+type permissionCheck struct {
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Verb        string `json:"verb"`
+	Namespace   string `json:"namespace,omitempty"`
+	// Feature es la opción que requiere el permiso; vacío para los permisos básicos
+	Feature string `json:"feature,omitempty"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// selfTestResult es el resultado del último self-test de permisos
+type selfTestResult struct {
+	OK      bool              `json:"ok"`
+	Time    time.Time         `json:"time"`
+	Missing []string          `json:"missing,omitempty"`
+	Checks  []permissionCheck `json:"checks"`
+}
+
+var (
+	lastSelfTest   *selfTestResult
+	lastSelfTestMu sync.Mutex
+)
+
+// requiredPermissions arma la lista de permisos a verificar según las opciones activas
+func requiredPermissions(namespace string) []permissionCheck {
+	checks := []permissionCheck{
+		{Resource: "pods", Verb: "get", Namespace: namespace},
+		{Resource: "pods", Verb: "list", Namespace: namespace},
+		{Resource: "pods", Subresource: "portforward", Verb: "create", Namespace: namespace},
+	}
+	if appConfig.HelpersEnabled {
+		checks = append(checks,
+			permissionCheck{Resource: "pods", Verb: "create", Namespace: namespace, Feature: "HELPERS_ENABLED"},
+			permissionCheck{Resource: "pods", Verb: "delete", Namespace: namespace, Feature: "HELPERS_ENABLED"})
+	}
+	if appConfig.FileTransferEnabled || appConfig.PolicyCRDEnabled {
+		checks = append(checks, permissionCheck{Resource: "pods", Subresource: "exec", Verb: "create", Namespace: namespace, Feature: "FILE_TRANSFER_ENABLED"})
+	}
+	if appConfig.PersistenceConfigMap != "" {
+		checks = append(checks,
+			permissionCheck{Resource: "configmaps", Verb: "get", Namespace: appConfig.PodNamespace, Feature: "PERSISTENCE_CONFIGMAP"},
+			permissionCheck{Resource: "configmaps", Verb: "update", Namespace: appConfig.PodNamespace, Feature: "PERSISTENCE_CONFIGMAP"})
+	}
+	if appConfig.PolicyCRDEnabled {
+		checks = append(checks, permissionCheck{Group: "pod-forward.argocd", Resource: "podforwardpolicies", Verb: "watch", Feature: "POLICY_CRD_ENABLED"})
+	}
+	if appConfig.MultiClusterEnabled {
+		checks = append(checks, permissionCheck{Resource: "secrets", Verb: "list", Namespace: appConfig.ArgoCDNamespace, Feature: "MULTI_CLUSTER_ENABLED"})
+	}
+	if slices.Contains(appConfig.AuditLog, auditSinkEvents) {
+		checks = append(checks, permissionCheck{Resource: "events", Verb: "create", Namespace: namespace, Feature: "AUDIT_LOG"})
+	}
+	// Los pods auxiliares y las transferencias de archivos verifican siempre los
+	// permisos del usuario
+	switch {
+	case appConfig.SubjectAccessReview:
+		checks = append(checks, permissionCheck{Group: "authorization.k8s.io", Resource: "subjectaccessreviews", Verb: "create", Feature: "SUBJECT_ACCESS_REVIEW"})
+	case appConfig.HelpersEnabled:
+		checks = append(checks, permissionCheck{Group: "authorization.k8s.io", Resource: "subjectaccessreviews", Verb: "create", Feature: "HELPERS_ENABLED"})
+	case appConfig.FileTransferEnabled:
+		checks = append(checks, permissionCheck{Group: "authorization.k8s.io", Resource: "subjectaccessreviews", Verb: "create", Feature: "FILE_TRANSFER_ENABLED"})
+	}
+	return checks
+}
+
+// runSelfTest verifica con SelfSubjectAccessReview que la ServiceAccount tenga los
+// permisos que usa el backend en SELFTEST_NAMESPACE, para informar un RBAC incompleto
+// al iniciar en lugar de fallar con la primera petición de un usuario
+func runSelfTest(ctx context.Context, clientset *kubernetes.Clientset) *selfTestResult {
+	result := &selfTestResult{OK: true, Time: time.Now()}
+	for _, check := range requiredPermissions(appConfig.SelfTestNamespace) {
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   check.Namespace,
+					Verb:        check.Verb,
+					Group:       check.Group,
+					Resource:    check.Resource,
+					Subresource: check.Subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			check.Reason = fmt.Sprintf("error al verificar: %v", err)
+		} else {
+			check.Allowed = review.Status.Allowed
+			check.Reason = review.Status.Reason
+		}
+		if !check.Allowed {
+			result.OK = false
+			result.Missing = append(result.Missing, describePermission(check))
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	lastSelfTestMu.Lock()
+	lastSelfTest = result
+	lastSelfTestMu.Unlock()
+	if result.OK {
+		slog.Info("Permisos verificados", "component", "selftest", "namespace", appConfig.SelfTestNamespace, "checks", len(result.Checks))
+	} else {
+		for _, missing := range result.Missing {
+			slog.Error("Falta un permiso de la ServiceAccount", "component", "selftest", "permission", missing)
+		}
+	}
+	return result
+}
+
+// describePermission arma una descripción legible del permiso (ej: create pods/portforward en default)
+func describePermission(check permissionCheck) string {
+	resource := check.Resource
+	if check.Subresource != "" {
+		resource += "/" + check.Subresource
+	}
+	if check.Group != "" {
+		resource += "." + check.Group
+	}
+	description := check.Verb + " " + resource
+	if check.Namespace != "" {
+		description += " en " + check.Namespace
+	}
+	if check.Feature != "" {
+		description += " (" + check.Feature + ")"
+	}
+	return description
+}
+
+// startSelfTest corre el self-test al iniciar; con SELFTEST_STRICT el backend no
+// arranca si falta algún permiso
+func startSelfTest(clientset *kubernetes.Clientset) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if result := runSelfTest(ctx, clientset); !result.OK && appConfig.SelfTestStrict {
+		fatal("Faltan permisos de la ServiceAccount", "component", "selftest", "missing", result.Missing)
+	}
+}
+
+// handleAdminSelfTest corre el self-test (POST) o devuelve el último resultado (GET)
+func handleAdminSelfTest(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset) {
+	if !requireRole(w, r, RoleAdmin) {
+		return
+	}
+	var result *selfTestResult
+	switch r.Method {
+	case http.MethodPost:
+		result = runSelfTest(r.Context(), clientset)
+	case http.MethodGet:
+		lastSelfTestMu.Lock()
+		result = lastSelfTest
+		lastSelfTestMu.Unlock()
+		if result == nil {
+			http.Error(w, "El self-test todavía no se ejecutó", http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !result.OK {
+		w.WriteHeader(http.StatusFailedDependency)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Estrategias para elegir el endpoint de un Service
+const (
+	// endpointStrategyRandom elige un endpoint listo al azar (default)
+	endpointStrategyRandom = "random"
+	// endpointStrategyZone elige un endpoint listo en la zona pedida o, si no se
+	// indica, en la zona del nodo del backend
+	endpointStrategyZone = "zone"
+	// endpointStrategyIP elige el endpoint con la IP indicada
+	endpointStrategyIP = "ip"
+)
+
+// zoneLabel es la label estándar con la zona del nodo
+const zoneLabel = "topology.kubernetes.io/zone"
+
+// endpointOptions son las opciones de resolución de un Service pedidas en la sesión
+type endpointOptions struct {
+	Strategy string
+	Zone     string
+	IP       string
+}
+
+// serviceEndpoint es un pod detrás del Service que puede recibir tráfico
+type serviceEndpoint struct {
+	Pod      string
+	IP       string
+	NodeName string
+	Zone     string
+	Port     int
+}
+
+var (
+	// nodeZones guarda la zona de cada nodo; los nodos no cambian de zona
+	nodeZones   = make(map[string]string)
+	nodeZonesMu sync.Mutex
+
+	// endpointSlices es el lister del informer de EndpointSlices; nil hasta que el
+	// cache se sincroniza, y mientras tanto se consulta la API directamente
+	endpointSlices   discoverylisters.EndpointSliceLister
+	endpointSlicesMu sync.RWMutex
+)
+
+// startEndpointSliceInformer mantiene en memoria las EndpointSlices del cluster para
+// resolver los targets service= sin consultar la API en cada petición
+func startEndpointSliceInformer(ctx context.Context, clientset *kubernetes.Clientset) {
+	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
+	informer := factory.Discovery().V1().EndpointSlices()
+	lister := informer.Lister()
+	hasSynced := informer.Informer().HasSynced
+	factory.Start(ctx.Done())
+	goTask("endpointslice-informer", func(ctx context.Context) error {
+		if !cache.WaitForCacheSync(ctx.Done(), hasSynced) {
+			return fmt.Errorf("no se pudo sincronizar el cache de EndpointSlices")
+		}
+		slog.Info("Cache de EndpointSlices sincronizado", "component", "endpointSlices")
+		endpointSlicesMu.Lock()
+		endpointSlices = lister
+		endpointSlicesMu.Unlock()
+		return nil
+	})
+}
+
+// resolveServiceTarget elige un pod listo detrás del Service según la estrategia y
+// devuelve el pod y el puerto del contenedor (targetPort) que corresponde al puerto
+// del Service pedido
+func resolveServiceTarget(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string, port int, opts endpointOptions) (string, int, int, error) {
+	svc, err := clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, lookupStatus(err), fmt.Errorf("error al obtener el Service %s/%s: %v", namespace, name, err)
+	}
+	var svcPort *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if int(svc.Spec.Ports[i].Port) == port {
+			svcPort = &svc.Spec.Ports[i]
+		}
+	}
+	if svcPort == nil {
+		return "", 0, http.StatusBadRequest, fmt.Errorf("el Service %s/%s no expone el puerto %d", namespace, name, port)
+	}
+
+	slices, err := serviceEndpointSlices(ctx, clientset, namespace, name)
+	if err != nil {
+		return "", 0, http.StatusInternalServerError, fmt.Errorf("error al obtener los endpoints de %s/%s: %v", namespace, name, err)
+	}
+	candidates := usableServiceEndpoints(slices, svcPort.Name)
+	if len(candidates) == 0 {
+		return "", 0, http.StatusServiceUnavailable, fmt.Errorf("el Service %s/%s no tiene endpoints listos en el puerto %d", namespace, name, port)
+	}
+
+	chosen, status, err := chooseEndpoint(ctx, clientset, candidates, opts)
+	if err != nil {
+		return "", 0, status, fmt.Errorf("Service %s/%s: %v", namespace, name, err)
+	}
+	slog.Debug("Service resuelto", "component", "resolveServiceTarget", "namespace", namespace, "service", name, "port", port,
+		"pod", chosen.Pod, "ip", chosen.IP, "targetPort", chosen.Port, "strategy", opts.Strategy)
+	return chosen.Pod, chosen.Port, http.StatusOK, nil
+}
+
+// serviceEndpointSlices devuelve las EndpointSlices del Service, del cache si ya
+// está sincronizado
+func serviceEndpointSlices(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) ([]*discoveryv1.EndpointSlice, error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: name})
+	endpointSlicesMu.RLock()
+	lister := endpointSlices
+	endpointSlicesMu.RUnlock()
+	if lister != nil {
+		return lister.EndpointSlices(namespace).List(selector)
+	}
+	list, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	slices := make([]*discoveryv1.EndpointSlice, 0, len(list.Items))
+	for i := range list.Items {
+		slices = append(slices, &list.Items[i])
+	}
+	return slices, nil
+}
+
+// usableServiceEndpoints devuelve los endpoints respaldados por un pod, con el puerto
+// que tiene el nombre del puerto del Service. Se usan los endpoints ready y, si no
+// hay ninguno, los que siguen serving aunque estén terminando, igual que kube-proxy.
+func usableServiceEndpoints(slices []*discoveryv1.EndpointSlice, portName string) []serviceEndpoint {
+	var ready, terminating []serviceEndpoint
+	for _, slice := range slices {
+		port := 0
+		for _, p := range slice.Ports {
+			name := ""
+			if p.Name != nil {
+				name = *p.Name
+			}
+			if p.Port != nil && name == portName {
+				port = int(*p.Port)
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" || len(endpoint.Addresses) == 0 {
+				continue
+			}
+			candidate := serviceEndpoint{Pod: endpoint.TargetRef.Name, IP: endpoint.Addresses[0], Port: port}
+			if endpoint.NodeName != nil {
+				candidate.NodeName = *endpoint.NodeName
+			}
+			if endpoint.Zone != nil {
+				candidate.Zone = *endpoint.Zone
+			}
+			conditions := endpoint.Conditions
+			switch {
+			case conditions.Ready == nil || *conditions.Ready:
+				// Sin la condición la API indica asumir ready
+				ready = append(ready, candidate)
+			case conditions.Serving != nil && *conditions.Serving && conditions.Terminating != nil && *conditions.Terminating:
+				terminating = append(terminating, candidate)
+			}
+		}
+	}
+	if len(ready) > 0 {
+		return ready
+	}
+	return terminating
+}
+
+// chooseEndpoint aplica la estrategia pedida sobre los endpoints listos
+func chooseEndpoint(ctx context.Context, clientset *kubernetes.Clientset, candidates []serviceEndpoint, opts endpointOptions) (serviceEndpoint, int, error) {
+	switch opts.Strategy {
+	case "", endpointStrategyRandom:
+		return candidates[rand.Intn(len(candidates))], http.StatusOK, nil
+	case endpointStrategyIP:
+		if opts.IP == "" {
+			return serviceEndpoint{}, http.StatusBadRequest, fmt.Errorf("la estrategia ip requiere endpoint")
+		}
+		for _, candidate := range candidates {
+			if candidate.IP == opts.IP {
+				return candidate, http.StatusOK, nil
+			}
+		}
+		return serviceEndpoint{}, http.StatusNotFound, fmt.Errorf("no hay un endpoint listo con IP %s", opts.IP)
+	case endpointStrategyZone:
+		zone := opts.Zone
+		if zone == "" {
+			if appConfig.NodeName == "" {
+				return serviceEndpoint{}, http.StatusBadRequest, fmt.Errorf("la estrategia zone requiere zone si el backend no conoce su nodo (NODE_NAME)")
+			}
+			zone = nodeZone(ctx, clientset, appConfig.NodeName)
+		}
+		var inZone []serviceEndpoint
+		for _, candidate := range candidates {
+			candidateZone := candidate.Zone
+			if candidateZone == "" && candidate.NodeName != "" {
+				candidateZone = nodeZone(ctx, clientset, candidate.NodeName)
+			}
+			if candidateZone != "" && candidateZone == zone {
+				inZone = append(inZone, candidate)
+			}
+		}
+		// Sin fallback a otras zonas: el objetivo es reproducir lo que ve esa zona
+		if len(inZone) == 0 {
+			return serviceEndpoint{}, http.StatusServiceUnavailable, fmt.Errorf("no hay endpoints listos en la zona %q", zone)
+		}
+		return inZone[rand.Intn(len(inZone))], http.StatusOK, nil
+	default:
+		return serviceEndpoint{}, http.StatusBadRequest, fmt.Errorf("estrategia desconocida %q (random, zone, ip)", opts.Strategy)
+	}
+}
+
+// nodeZone devuelve la zona del nodo, o "" si no se pudo obtener
+func nodeZone(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) string {
+	nodeZonesMu.Lock()
+	zone, ok := nodeZones[nodeName]
+	nodeZonesMu.Unlock()
+	if ok {
+		return zone
+	}
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		slog.Warn("Error al obtener el nodo", "component", "nodeZone", "node", nodeName, "error", err)
+		return ""
+	}
+	zone = node.Labels[zoneLabel]
+	nodeZonesMu.Lock()
+	nodeZones[nodeName] = zone
+	nodeZonesMu.Unlock()
+	return zone
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Un service worker registrado desde el proxy tiene como scope máximo el directorio
+// de su script, que ya está bajo el prefijo del proxy. Solo puede controlar las
+// páginas de Argo CD si la aplicación amplía el scope con Service-Worker-Allowed.
+
+// isServiceWorkerScript indica si el navegador está descargando el script de un
+// service worker para registrarlo
+func isServiceWorkerScript(r *http.Request) bool {
+	return r.Header.Get("Service-Worker") == "script"
+}
+
+// blockServiceWorker responde 403 al script de un service worker cuando
+// BLOCK_SERVICE_WORKERS o el perfil lo piden; el registro falla en el navegador
+// y la aplicación sigue funcionando sin el worker
+func blockServiceWorker(w http.ResponseWriter, r *http.Request, profile PolicyProfile) bool {
+	if !isServiceWorkerScript(r) || (!appConfig.BlockServiceWorkers && !profile.BlockServiceWorkers) {
+		return false
+	}
+	slog.Info("Registro de service worker bloqueado", "component", "blockServiceWorker", "path", r.URL.Path)
+	http.Error(w, "Los service workers están deshabilitados en el proxy", http.StatusForbidden)
+	return true
+}
+
+// clampServiceWorkerAllowed limita el Service-Worker-Allowed de la respuesta del pod
+// al prefijo de la sesión: un scope como / haría que el worker intercepte las
+// peticiones de Argo CD
+func clampServiceWorkerAllowed(header http.Header, prefix string) {
+	allowed := header.Get("Service-Worker-Allowed")
+	if allowed == "" || hasProxyPrefix(allowed) {
+		return
+	}
+	clamped := prefix + "/"
+	if strings.HasPrefix(allowed, "/") && !strings.HasPrefix(allowed, "//") {
+		clamped = prefix + allowed
+	}
+	slog.Debug("Service-Worker-Allowed limitado al prefijo", "component", "clampServiceWorkerAllowed", "allowed", allowed, "clamped", clamped)
+	header.Set("Service-Worker-Allowed", clamped)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// newSessionID genera un identificador aleatorio para exponer la sesión en la API
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		slog.Error("Error al generar ID aleatorio", "component", "newSessionID", "error", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// configureSession aplica el perfil y el modo raw elegidos al crear o reutilizar
+// la sesión, y resuelve el token de la aplicación si el perfil lo requiere
+func configureSession(ctx context.Context, clientset *kubernetes.Clientset, session *PortForwardSession, profileName string, raw bool) {
+	session.mu.Lock()
+	if profileName != "" {
+		session.Profile = profileName
+	}
+	if raw {
+		session.Raw = true
+	}
+	needsToken := session.token == ""
+	session.mu.Unlock()
+
+	profile := sessionProfile(getPolicy(), session)
+	if profile.TokenParam == "" || !needsToken {
+		return
+	}
+	token, err := resolveProfileToken(ctx, clientset, profile, session.Namespace, session.Pod)
+	if err != nil {
+		slog.Warn("No se pudo obtener el token del perfil", "component", "configureSession", "profile", profile.Name, "error", err)
+		return
+	}
+	session.mu.Lock()
+	session.token = token
+	session.mu.Unlock()
+}
+
+// openSession valida el target contra el modo drain y la política y devuelve la
+// sesión existente o una nueva en el cluster kube. Si falla devuelve el código HTTP
+// para responder.
+func openSession(ctx context.Context, identity RequestIdentity, kube *kubeTarget, namespace, pod string, port int) (session *PortForwardSession, status int, err error) {
+	// Crear clave única para la sesión, separada por proyecto, usuario y cluster
+	sessionKey := buildSessionKey(identity.Project, identity.User, kube.Name, namespace, pod, port)
+	ctx, span := startSessionSpan(ctx, sessionKey, namespace, pod, port)
+	defer func() { endSpan(span, err) }()
+
+	// En modo drain no se crean sesiones nuevas
+	sessionsMu.RLock()
+	_, exists := activeSessions[sessionKey]
+	sessionsMu.RUnlock()
+	if draining.Load() && !exists {
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("el backend está en modo drain y no acepta sesiones nuevas")
+	}
+	// Fallar en el momento si el último chequeo del cluster falló, en vez de esperar el timeout
+	if !exists {
+		if status, err := checkClusterReachable(kube.healthName()); err != nil {
+			return nil, status, err
+		}
+	}
+
+	// Política, permisos en Kubernetes y demás validaciones registradas como hooks
+	opening := sessionOpening{Identity: identity, Kube: kube, Key: sessionKey, Namespace: namespace, Pod: pod, Port: port}
+	if status, err := runPreCreateHooks(ctx, opening); err != nil {
+		return nil, status, err
+	}
+
+	session, err = getOrCreateSession(ctx, sessionKey, identity.Project, identity.User, kube, namespace, pod, port)
+	if errors.Is(err, errSessionQuota) {
+		return nil, http.StatusForbidden, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("error al crear port-forward: %v", err)
+	}
+	return session, http.StatusOK, nil
+}
+
+// sessionView es la representación JSON de una sesión en la API de gestión
+type sessionView struct {
+	ID        string    `json:"id"`
+	Project   string    `json:"project,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Port      int       `json:"port"`
+	LocalPort int       `json:"localPort"`
+	Profile   string    `json:"profile,omitempty"`
+	LastUsed  time.Time `json:"lastUsed"`
+	// WebSockets es la cantidad de conexiones WebSocket abiertas en la sesión
+	WebSockets int `json:"webSockets"`
+	// Raw indica que la sesión pasa las respuestas sin reescritura
+	Raw bool `json:"raw,omitempty"`
+	// Scheme es https si la sesión se conecta con el pod por TLS
+	Scheme string `json:"scheme"`
+	// Protocol es h2 si todas las peticiones van al pod por HTTP/2
+	Protocol string `json:"protocol,omitempty"`
+	// Helper es el tipo de pod auxiliar que se borra al cerrar la sesión
+	Helper string `json:"helper,omitempty"`
+	// State es active, o degraded mientras se reconecta con el pod
+	State string `json:"state"`
+	// Key es la clave de la sesión (<proyecto>:<usuario>@[<cluster>/]<namespace>/<pod>:<puerto>), también
+	// aceptada en lugar del ID en /sessions/{id}
+	Key string `json:"key"`
+	// BytesIn y BytesOut son los bytes que pasaron por el proxy hacia el pod y desde el pod
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+	// Tunnels es la cantidad de port-forwards abiertos entre los que se reparten las peticiones
+	Tunnels int `json:"tunnels"`
+	// Timing desglosa el tiempo de creación: búsqueda del pod, handshake SPDY, puerto
+	// local listo y primera respuesta del pod
+	Timing *sessionTimingView `json:"timing,omitempty"`
+}
+
+func newSessionView(session *PortForwardSession) sessionView {
+	scheme := session.upstreamScheme()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	state := "active"
+	if session.Degraded {
+		state = "degraded"
+	}
+	return sessionView{
+		ID:         session.ID,
+		Project:    session.Project,
+		User:       session.User,
+		Cluster:    session.Cluster,
+		Namespace:  session.Namespace,
+		Pod:        session.Pod,
+		Port:       session.Port,
+		LocalPort:  session.LocalPort,
+		Profile:    session.Profile,
+		LastUsed:   session.LastUsed,
+		WebSockets: session.WSConns,
+		Raw:        session.Raw,
+		Scheme:     scheme,
+		Protocol:   protocolName(session.HTTP2),
+		Helper:     session.Helper,
+		State:      state,
+		Key:        session.Key,
+		BytesIn:    session.BytesIn.Load(),
+		BytesOut:   session.BytesOut.Load(),
+		Tunnels:    1 + len(session.tunnels),
+		Timing:     session.Timing.view(),
+	}
+}
+
+// transferCounter suma a la sesión los bytes leídos del cuerpo que pasa por el proxy
+// y corta la copia si la sesión supera la cuota de bytes de la política
+type transferCounter struct {
+	reader  io.Reader
+	counter *atomic.Int64
+	session *PortForwardSession
+}
+
+func (t *transferCounter) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	t.counter.Add(int64(n))
+	if t.session.overByteQuota() {
+		return n, errByteQuota
+	}
+	return n, err
+}
+
+// isIdle indica si la sesión no tuvo actividad en el plazo indicado. Una sesión con
+// WebSockets abiertos nunca está inactiva, aunque no reciba peticiones HTTP nuevas.
+func (session *PortForwardSession) isIdle(ttl time.Duration) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.WSConns == 0 && time.Since(session.LastUsed) > ttl
+}
+
+// findSessionByID busca una sesión activa por su ID o, si no hay ninguna con ese
+// ID, por su clave
+func findSessionByID(id string) *PortForwardSession {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, sess := range activeSessions {
+		if sess.ID == id {
+			return sess
+		}
+	}
+	return activeSessions[id]
+}
+
+// visibleSession busca la sesión por ID o clave si el usuario puede verla
+func visibleSession(identity RequestIdentity, id string) *PortForwardSession {
+	session := findSessionByID(id)
+	if session == nil || !identity.CanView(session) {
+		return nil
+	}
+	return session
+}
+
+// visibleSessions devuelve las sesiones que el usuario puede ver, ordenadas por ID
+func visibleSessions(identity RequestIdentity) []*PortForwardSession {
+	sessionsMu.RLock()
+	var sessions []*PortForwardSession
+	for _, sess := range activeSessions {
+		if identity.CanView(sess) {
+			sessions = append(sessions, sess)
+		}
+	}
+	sessionsMu.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions
+}
+
+// closeSessionAs cierra la sesión si el usuario tiene permiso; devuelve false si no
+func closeSessionAs(identity RequestIdentity, session *PortForwardSession) bool {
+	if !identity.CanClose(session) {
+		return false
+	}
+	session.Close(fmt.Sprintf("cerrada por %q", identity.User))
+	return true
+}
+
+// handleSessions atiende las rutas v1 /sessions/{id} y /sessions/{id}/...
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	setDeprecationHeaders(w)
+	handleSessionByID(w, r, strings.TrimPrefix(r.URL.Path, "/sessions"))
+}
+
+// handleListSessions devuelve las sesiones activas que el usuario puede ver
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	views := []sessionView{}
+	for _, session := range visibleSessions(identityFromRequest(r)) {
+		views = append(views, newSessionView(session))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// handleSessionByID atiende {id} y {id}/external-url, compartido por las API v1 y v2.
+// En lugar del ID se acepta la clave de la sesión, que contiene "/".
+func handleSessionByID(w http.ResponseWriter, r *http.Request, rest string) {
+	identity := identityFromRequest(r)
+	id := strings.Trim(rest, "/")
+	parts := []string{id}
+	// La clave termina en :<puerto>, así que un sufijo /external-url no es parte de ella
+	if trimmed, ok := strings.CutSuffix(id, "/external-url"); ok {
+		id = trimmed
+		parts = []string{id, "external-url"}
+	}
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Las sesiones que el usuario no puede ver se reportan como inexistentes
+	session := visibleSession(identity, id)
+	if session == nil {
+		http.Error(w, fmt.Sprintf("Sesión no encontrada: %s", id), http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newSessionView(session))
+		case http.MethodDelete:
+			if !closeSessionAs(identity, session) {
+				http.Error(w, "Permisos insuficientes para cerrar la sesión", http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch parts[1] {
+	case "external-url":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		base := externalBaseURL(r)
+		if base == "" {
+			http.Error(w, "No se puede armar una URL absoluta: configurar EXTERNAL_URL o TRUSTED_PROXIES", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"id":  session.ID,
+			"url": base + sessionForwardPath(session),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sessionExternalURL construye la URL para abrir la aplicación del pod en su propia
+// pestaña: absoluta si se conoce la URL pública y, si no, relativa al host de Argo CD
+func sessionExternalURL(r *http.Request, session *PortForwardSession) string {
+	return externalBaseURL(r) + sessionForwardPath(session)
+}
+
+// sessionForwardPath es la ruta del proxy de extensiones que abre la sesión. Lleva
+// los parámetros del target y no el puerto local, así sigue siendo válida aunque la
+// sesión se recree.
+func sessionForwardPath(session *PortForwardSession) string {
+	query := url.Values{}
+	if session.Cluster != "" {
+		query.Set("cluster", session.Cluster)
+	}
+	query.Set("namespace", session.Namespace)
+	query.Set("pod", session.Pod)
+	query.Set("port", strconv.Itoa(session.Port))
+	return extensionBasePath + "/forward?" + query.Encode()
+}
+
+// externalBaseURL es EXTERNAL_URL o, si no está configurada, el esquema y host que
+// informa en X-Forwarded-Proto/Host un proxy de TRUSTED_PROXIES. Sin ninguno de los
+// dos devuelve "": esos headers, como el Host, los elige el cliente, y una URL armada
+// con ellos podría apuntar a otro sitio.
+func externalBaseURL(r *http.Request) string {
+	if appConfig.ExternalURL != "" {
+		return appConfig.ExternalURL
+	}
+	if !fromTrustedProxy(r) {
+		return ""
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return ""
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// TestDeleteSessionRequiresOperator cierra una sesión con DELETE: quien la creó con
+// rol viewer recibe 403 y la sesión sigue activa; un operator del proyecto la cierra
+func TestDeleteSessionRequiresOperator(t *testing.T) {
+	config, err := startMockCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localKube = &kubeTarget{Clientset: clientset, Config: config}
+
+	saved := *appConfig
+	t.Cleanup(func() { *appConfig = saved })
+	appConfig.OperatorGroups = []string{"operators"}
+
+	viewer := RequestIdentity{User: "alice", Project: "default"}
+	session, _, err := createSession(context.Background(), viewer, createSessionRequest{Namespace: mockNamespace, Pod: "sample-app-0", Port: mockAppPort}, clientset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close("test")
+
+	remove := func(identity RequestIdentity, groups string) int {
+		req := httptest.NewRequest(http.MethodDelete, apiV2Prefix+"/sessions/"+session.ID, nil)
+		req.Header.Set("Argocd-Username", identity.User)
+		req.Header.Set("Argocd-Project-Name", identity.Project)
+		req.Header.Set("Argocd-User-Groups", groups)
+		rec := httptest.NewRecorder()
+		handleSessionByID(rec, req, "/"+session.ID)
+		return rec.Code
+	}
+
+	if code := remove(viewer, ""); code != http.StatusForbidden {
+		t.Fatalf("viewer: status %d, se esperaba 403", code)
+	}
+	if findSessionByID(session.ID) == nil {
+		t.Fatal("el viewer cerró la sesión")
+	}
+	if code := remove(RequestIdentity{User: "bob", Project: "default"}, "operators"); code != http.StatusNoContent {
+		t.Fatalf("operator: status %d, se esperaba 204", code)
+	}
+	if findSessionByID(session.ID) != nil {
+		t.Error("la sesión sigue activa después del DELETE del operator")
+	}
+}
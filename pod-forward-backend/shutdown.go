@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// backgroundStopTimeout es cuánto se espera al final del apagado a que terminen
+// las goroutines de fondo
+const backgroundStopTimeout = 5 * time.Second
+
+// shuttingDown indica que el backend recibió SIGTERM y está terminando
+var shuttingDown atomic.Bool
+
+var (
+	serversMu   sync.Mutex
+	httpServers []*http.Server
+	grpcServer  *grpc.Server
+)
+
+// shutdownDone se cierra cuando terminó el apagado ordenado
+var shutdownDone = make(chan struct{})
+
+// trackServer registra un servidor HTTP para apagarlo al recibir SIGTERM
+func trackServer(server *http.Server) {
+	serversMu.Lock()
+	httpServers = append(httpServers, server)
+	serversMu.Unlock()
+}
+
+// handleShutdownSignals espera SIGTERM o SIGINT y apaga el backend en orden: deja
+// de aceptar sesiones, espera hasta SHUTDOWN_TIMEOUT a que terminen las peticiones
+// en curso y después cierra todas las sesiones. Una segunda señal termina en el momento.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		slog.Info("Señal recibida, apagando", "component", "shutdown", "signal", sig.String(), "timeout", appConfig.ShutdownTimeout.String())
+		go func() {
+			<-signals
+			fatal("Segunda señal recibida, terminando sin esperar", "component", "shutdown")
+		}()
+		shutdown()
+		close(shutdownDone)
+	}()
+}
+
+func shutdown() {
+	shuttingDown.Store(true)
+	draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), appConfig.ShutdownTimeout)
+	defer cancel()
+
+	serversMu.Lock()
+	servers := append([]*http.Server(nil), httpServers...)
+	grpcSrv := grpcServer
+	serversMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Error("Error al apagar el listener", "component", "shutdown", "addr", server.Addr, "error", err)
+			}
+		}(server)
+	}
+	if grpcSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcSrv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				grpcSrv.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Las sesiones se cierran después de las peticiones para no cortarlas; los
+	// WebSockets y los túneles no los espera Shutdown y se cortan acá
+	sessionsMu.RLock()
+	sessions := make([]*PortForwardSession, 0, len(activeSessions))
+	for _, sess := range activeSessions {
+		sessions = append(sessions, sess)
+	}
+	sessionsMu.RUnlock()
+	for _, sess := range sessions {
+		sess.Close("shutdown")
+	}
+
+	// Con las sesiones cerradas terminan sus goroutines; los subsistemas de fondo
+	// terminan al cancelar el contexto raíz
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), backgroundStopTimeout)
+	defer stopCancel()
+	stopBackground(stopCtx)
+	shutdownTracing(stopCtx)
+	slog.Info("Apagado completo", "component", "shutdown", "closedSessions", len(sessions))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// sniffLen es la cantidad de bytes que usa http.DetectContentType
+const sniffLen = 512
+
+// sniffContentType completa el Content-Type de las respuestas del pod que no lo
+// traen, con el tipo detectado a partir de los primeros bytes del cuerpo. Sin
+// Content-Type algunos navegadores descargan la página en lugar de mostrarla.
+// Los cuerpos comprimidos no se inspeccionan porque el tipo detectado sería el del
+// formato de compresión.
+func sniffContentType(resp *http.Response) {
+	if resp.Header.Get("Content-Type") != "" || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(resp.Body, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// Lo leído se sigue enviando; el error vuelve a aparecer al copiar el cuerpo
+		slog.Warn("Error al leer el inicio del cuerpo", "component", "sniffContentType", "error", err)
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf[:n]), resp.Body), resp.Body}
+	if n == 0 {
+		return
+	}
+	contentType := http.DetectContentType(buf[:n])
+	resp.Header.Set("Content-Type", contentType)
+	slog.Debug("Respuesta sin Content-Type", "component", "sniffContentType", "detected", contentType)
+}
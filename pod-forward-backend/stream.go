@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// isStreamingResponse indica si la respuesta se consume a medida que llega
+// (Server-Sent Events, gRPC o cuerpo sin tamaño conocido)
+func isStreamingResponse(resp *http.Response) bool {
+	return isEventStream(resp) || resp.ContentLength < 0
+}
+
+// isEventStream indica si la respuesta es un stream de duración indefinida:
+// Server-Sent Events o gRPC (que puede ser un streaming RPC)
+func isEventStream(resp *http.Response) bool {
+	contentType := resp.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "text/event-stream") || strings.HasPrefix(contentType, "application/grpc")
+}
+
+// copyResponseBody copia el cuerpo del pod al cliente. Con flush, cada bloque se
+// envía apenas se lee para que el navegador muestre el progreso de la descarga
+// en lugar de esperar a que se llene el buffer del servidor.
+func copyResponseBody(w http.ResponseWriter, body io.Reader, flush bool) error {
+	flusher, ok := w.(http.Flusher)
+	if !flush || !ok {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			// Un flush que falla (cliente que dejó de leer) corta la copia
+			if flushErr := flushClient(w, flusher); flushErr != nil {
+				return flushErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// flushClient envía al cliente lo escrito y devuelve el error si el writer lo informa
+func flushClient(w http.ResponseWriter, flusher http.Flusher) error {
+	if f, ok := w.(interface{ FlushError() error }); ok {
+		return f.FlushError()
+	}
+	flusher.Flush()
+	return nil
+}
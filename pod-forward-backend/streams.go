@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// Cada conexión TCP al puerto local del port-forward abre un par de streams (datos y
+// errores) en la conexión SPDY con el API server, y el API server o el kubelet limitan
+// cuántos puede haber a la vez. Al superar el límite la conexión local se corta sin
+// respuesta; portforward solo lo informa por utilruntime.HandleError.
+
+// streamErrorPattern reconoce los errores de creación de streams que informa portforward
+var streamErrorPattern = regexp.MustCompile(`error creating (?:forwarding|error) stream for port (\d+) -> \d+`)
+
+// streamGate cuenta las conexiones abiertas hacia el puerto local de una sesión y
+// hace esperar a las nuevas cuando se alcanza el límite de streams
+type streamGate struct {
+	mu     sync.Mutex
+	open   int
+	queued int
+	// learned es el límite detectado al fallar la creación de un stream; 0 usa SPDY_MAX_STREAMS
+	learned   int
+	lastError time.Time
+	// freed se cierra al liberar una conexión para despertar a las que esperan
+	freed chan struct{}
+}
+
+// limit es el límite vigente; 0 es sin límite
+func (g *streamGate) limit() int {
+	if g.learned > 0 {
+		return g.learned
+	}
+	return appConfig.SPDYMaxStreams
+}
+
+// acquire reserva un stream, esperando si se alcanzó el límite hasta que se libere
+// uno, se cancele ctx o se cierre la sesión
+func (g *streamGate) acquire(ctx context.Context, done <-chan struct{}) (bool, error) {
+	waited := false
+	for {
+		g.mu.Lock()
+		if limit := g.limit(); limit == 0 || g.open < limit {
+			g.open++
+			g.mu.Unlock()
+			return waited, nil
+		}
+		if g.freed == nil {
+			g.freed = make(chan struct{})
+		}
+		freed := g.freed
+		g.queued++
+		g.mu.Unlock()
+		waited = true
+
+		var err error
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			err = fmt.Errorf("sin streams SPDY libres: %v", ctx.Err())
+		case <-done:
+			err = fmt.Errorf("la sesión se cerró mientras esperaba un stream SPDY")
+		}
+		g.mu.Lock()
+		g.queued--
+		g.mu.Unlock()
+		if err != nil {
+			return waited, err
+		}
+	}
+}
+
+// release libera un stream y despierta a las conexiones que esperan
+func (g *streamGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.open--
+	if g.freed != nil {
+		close(g.freed)
+		g.freed = nil
+	}
+}
+
+// exhausted registra que el túnel rechazó un stream: el límite pasa a ser la cantidad
+// de conexiones que sí se pudieron abrir
+func (g *streamGate) exhausted() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.learned = max(g.open-1, 1)
+	g.lastError = time.Now()
+	return g.learned
+}
+
+// failedSince indica si se rechazó un stream después de t
+func (g *streamGate) failedSince(t time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lastError.After(t)
+}
+
+// reset olvida el límite detectado; se usa cuando la sesión abre un túnel nuevo
+func (g *streamGate) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.learned = 0
+}
+
+// usage devuelve las conexiones abiertas y en espera
+func (g *streamGate) usage() (int, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.open, g.queued
+}
+
+// streamConn libera su stream al cerrarse
+type streamConn struct {
+	net.Conn
+	once sync.Once
+	gate *streamGate
+}
+
+func (c *streamConn) Close() error {
+	c.once.Do(c.gate.release)
+	return c.Conn.Close()
+}
+
+// dialUpstream abre una conexión al puerto local de una sesión respetando el límite
+// de streams SPDY. Se usa en el transporte del proxy y en los WebSockets.
+func dialUpstream(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: appConfig.UpstreamDialTimeout, KeepAlive: 30 * time.Second}
+	port := localPortOf(addr)
+	session := sessionForLocalPort(port)
+	if session == nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	gate := session.streamGateFor(port)
+	waited, err := gate.acquire(ctx, session.Done())
+	if waited {
+		addCounter("pod_forward_spdy_stream_waits_total", map[string]string{"project": session.Project}, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		gate.release()
+		return nil, err
+	}
+	return &streamConn{Conn: conn, gate: gate}, nil
+}
+
+// localPortOf devuelve el puerto de addr (localhost:<puerto>), o 0 si no tiene
+func localPortOf(addr string) int {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
+
+// sessionForLocalPort busca la sesión por su puerto local
+func sessionForLocalPort(port int) *PortForwardSession {
+	localPortMu.RLock()
+	key, ok := localPortToSession[port]
+	localPortMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	return activeSessions[key]
+}
+
+// watchStreamErrors agrega un handler a utilruntime para detectar los streams
+// rechazados por portforward y ajustar el límite de la sesión
+func watchStreamErrors() {
+	utilruntime.ErrorHandlers = append(utilruntime.ErrorHandlers, func(err error) {
+		match := streamErrorPattern.FindStringSubmatch(err.Error())
+		if match == nil {
+			return
+		}
+		port, _ := strconv.Atoi(match[1])
+		session := sessionForLocalPort(port)
+		if session == nil {
+			return
+		}
+		limit := session.streamGateFor(port).exhausted()
+		slog.Warn("Sin streams SPDY disponibles, se limitan las conexiones simultáneas", "component", "streams", "session", session.ID, "limit", limit, "error", err)
+		addCounter("pod_forward_spdy_stream_errors_total", map[string]string{"project": session.Project}, 1)
+	})
+}
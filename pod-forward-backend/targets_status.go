@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxStatusTargets limita la cantidad de targets por petición a /targets/status
+const maxStatusTargets = 500
+
+// targetRef es un nodo del árbol de recursos de la Application
+type targetRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// targetSessionStatus describe una sesión abierta hacia el target. El ID solo se
+// informa si el usuario puede ver la sesión.
+type targetSessionStatus struct {
+	ID   string `json:"id,omitempty"`
+	User string `json:"user,omitempty"`
+	Pod  string `json:"pod"`
+	Port int    `json:"port"`
+}
+
+// targetStatus es el estado de un target en la respuesta de /targets/status
+type targetStatus struct {
+	targetRef
+	Open     bool                  `json:"open"`
+	Sessions []targetSessionStatus `json:"sessions"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// handleTargetsStatus recibe una lista de pods y services y devuelve, para cada
+// uno, si hay un port-forward abierto y de quién, para marcar los nodos del árbol
+// de recursos con una sola llamada. Solo se consideran las sesiones del proyecto
+// del usuario (todas para admins).
+func handleTargetsStatus(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	var refs []targetRef
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&refs); err != nil {
+		http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(refs) > maxStatusTargets {
+		http.Error(w, fmt.Sprintf("Se aceptan hasta %d targets por petición", maxStatusTargets), http.StatusRequestEntityTooLarge)
+		return
+	}
+	identity := identityFromRequest(r)
+
+	// Sesiones visibles agrupadas por namespace/pod
+	byPod := make(map[string][]targetSessionStatus)
+	sessionsMu.RLock()
+	for _, sess := range activeSessions {
+		if !identity.IsAdmin() && sess.Project != identity.Project {
+			continue
+		}
+		status := targetSessionStatus{User: sess.User, Pod: sess.Pod, Port: sess.Port}
+		if identity.CanView(sess) {
+			status.ID = sess.ID
+		}
+		key := sess.Namespace + "/" + sess.Pod
+		byPod[key] = append(byPod[key], status)
+	}
+	sessionsMu.RUnlock()
+
+	results := make([]targetStatus, 0, len(refs))
+	for _, ref := range refs {
+		result := targetStatus{targetRef: ref, Sessions: []targetSessionStatus{}}
+		switch targetKinds[strings.ToLower(ref.Kind)] {
+		case "pod":
+			result.Sessions = append(result.Sessions, byPod[ref.Namespace+"/"+ref.Name]...)
+		case "service":
+			// Un service está abierto si hay sesiones hacia alguno de sus pods
+			pods, err := servicePods(r, clientset, ref.Namespace, ref.Name)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			for _, pod := range pods {
+				result.Sessions = append(result.Sessions, byPod[ref.Namespace+"/"+pod]...)
+			}
+		default:
+			result.Error = fmt.Sprintf("tipo no soportado: %s", ref.Kind)
+		}
+		result.Open = len(result.Sessions) > 0
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// servicePods devuelve los nombres de los pods que respaldan el Service según sus
+// EndpointSlices, estén listos o no
+func servicePods(r *http.Request, clientset *kubernetes.Clientset, namespace, name string) ([]string, error) {
+	slices, err := serviceEndpointSlices(r.Context(), clientset, namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener los endpoints del Service %s/%s: %v", namespace, name, err)
+	}
+	var names []string
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				names = append(names, endpoint.TargetRef.Name)
+			}
+		}
+	}
+	return names, nil
+}
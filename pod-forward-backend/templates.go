@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// maxTemplatesPerUser limita las plantillas guardadas por usuario
+const maxTemplatesPerUser = 50
+
+// sessionTemplate es una sesión guardada con nombre que se puede volver a abrir
+type sessionTemplate struct {
+	Name string `json:"name"`
+	createSessionRequest
+}
+
+// userDataKey es la clave del ConfigMap para el usuario. Las claves solo admiten
+// [-._a-zA-Z0-9], así que el nombre se codifica en base64 URL.
+func userDataKey(user string) string {
+	return "user." + base64.RawURLEncoding.EncodeToString([]byte(user))
+}
+
+// loadUserData lee del ConfigMap el JSON guardado para el usuario
+func loadUserData(ctx context.Context, clientset *kubernetes.Clientset, name, user string, v interface{}) error {
+	cm, err := clientset.CoreV1().ConfigMaps(appConfig.PodNamespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	raw := cm.Data[userDataKey(user)]
+	if raw == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), v)
+}
+
+// updateUserData modifica el JSON guardado para el usuario. update recibe el valor
+// actual ("" si no existe) y devuelve el nuevo; los conflictos de escritura entre
+// réplicas se reintentan.
+func updateUserData(ctx context.Context, clientset *kubernetes.Clientset, name, user string, update func(raw string) (string, error)) error {
+	configMaps := clientset.CoreV1().ConfigMaps(appConfig.PodNamespace)
+	key := userDataKey(user)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			value, err := update("")
+			if err != nil || value == "" {
+				return err
+			}
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: appConfig.PodNamespace,
+					Labels:    map[string]string{"app.kubernetes.io/managed-by": "pod-forward-backend"},
+				},
+				Data: map[string]string{key: value},
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Otra réplica lo creó al mismo tiempo: reintentar como conflicto
+				return apierrors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		value, err := update(cm.Data[key])
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if value == "" {
+			delete(cm.Data, key)
+		} else {
+			cm.Data[key] = value
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// handleTemplates atiende /api/v2/templates (GET lista) y /api/v2/templates/{name}
+// (PUT guarda, DELETE borra, POST .../open abre la sesión). Cada usuario solo ve
+// sus propias plantillas.
+func handleTemplates(w http.ResponseWriter, r *http.Request, subpath string, clientset *kubernetes.Clientset) {
+	identity := identityFromRequest(r)
+	if identity.User == "" {
+		http.Error(w, "Las plantillas requieren un usuario autenticado", http.StatusUnauthorized)
+		return
+	}
+	name, action, _ := strings.Cut(strings.Trim(subpath, "/"), "/")
+
+	var templates []sessionTemplate
+	if err := loadUserData(r.Context(), clientset, appConfig.TemplatesConfigMap, identity.User, &templates); err != nil {
+		http.Error(w, fmt.Sprintf("Error al leer las plantillas: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		if templates == nil {
+			templates = []sessionTemplate{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(templates)
+	case name != "" && action == "" && r.Method == http.MethodPut:
+		saveTemplate(w, r, identity, name, clientset)
+	case name != "" && action == "" && r.Method == http.MethodDelete:
+		deleteTemplate(w, r, identity, name, clientset)
+	case name != "" && action == "open" && r.Method == http.MethodPost:
+		for _, template := range templates {
+			if template.Name != name {
+				continue
+			}
+			session, status, err := createSession(r.Context(), identity, template.createSessionRequest, clientset, nil)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			writeCreatedSession(w, r, session, status)
+			return
+		}
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveTemplate crea o reemplaza la plantilla con ese nombre
+func saveTemplate(w http.ResponseWriter, r *http.Request, identity RequestIdentity, name string, clientset *kubernetes.Clientset) {
+	var template sessionTemplate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&template); err != nil {
+		http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	template.Name = name
+	if template.Namespace == "" || !template.hasPort() ||
+		(template.Pod == "" && template.Job == "" && template.CronJob == "" && template.Selector == "" && template.Service == "" && template.Workload == "") {
+		http.Error(w, "Faltan parámetros requeridos: namespace, pod (o job/cronJob/selector/service/workload), port (o portName)", http.StatusBadRequest)
+		return
+	}
+
+	err := updateUserData(r.Context(), clientset, appConfig.TemplatesConfigMap, identity.User, func(raw string) (string, error) {
+		var templates []sessionTemplate
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &templates); err != nil {
+				return "", err
+			}
+		}
+		replaced := false
+		for i := range templates {
+			if templates[i].Name == name {
+				templates[i] = template
+				replaced = true
+			}
+		}
+		if !replaced {
+			if len(templates) >= maxTemplatesPerUser {
+				return "", fmt.Errorf("se alcanzó el máximo de %d plantillas", maxTemplatesPerUser)
+			}
+			templates = append(templates, template)
+		}
+		sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+		data, err := json.Marshal(templates)
+		return string(data), err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al guardar la plantilla: %v", err), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Plantilla guardada", "component", "saveTemplate", "template", name, "user", identity.User)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// deleteTemplate borra la plantilla con ese nombre
+func deleteTemplate(w http.ResponseWriter, r *http.Request, identity RequestIdentity, name string, clientset *kubernetes.Clientset) {
+	found := false
+	err := updateUserData(r.Context(), clientset, appConfig.TemplatesConfigMap, identity.User, func(raw string) (string, error) {
+		var templates []sessionTemplate
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &templates); err != nil {
+				return "", err
+			}
+		}
+		kept := templates[:0]
+		for _, template := range templates {
+			if template.Name == name {
+				found = true
+				continue
+			}
+			kept = append(kept, template)
+		}
+		if len(kept) == 0 {
+			return "", nil
+		}
+		data, err := json.Marshal(kept)
+		return string(data), err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al borrar la plantilla: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	slog.Info("Plantilla borrada", "component", "deleteTemplate", "template", name, "user", identity.User)
+	w.WriteHeader(http.StatusNoContent)
+}
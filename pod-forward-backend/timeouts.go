@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers que ajustan los plazos de una petición; no se reenvían al pod
+const (
+	timeoutHeader      = "X-Pod-Forward-Timeout"
+	readTimeoutHeader  = "X-Pod-Forward-Read-Timeout"
+	idleTimeoutHeader  = "X-Pod-Forward-Idle-Timeout"
+	writeTimeoutHeader = "X-Pod-Forward-Write-Timeout"
+)
+
+// timeoutQueryParams son los parámetros de query equivalentes a los headers, para
+// los clientes que no pueden enviar headers (ej: EventSource); se quitan de la
+// query antes de enviarla al pod
+var timeoutQueryParams = map[string]string{
+	"pfTimeout":      timeoutHeader,
+	"pfReadTimeout":  readTimeoutHeader,
+	"pfIdleTimeout":  idleTimeoutHeader,
+	"pfWriteTimeout": writeTimeoutHeader,
+}
+
+// Causas con las que se cancela la petición al pod cuando vence un plazo
+var (
+	errUpstreamTimeout      = errors.New("se agotó el plazo de la petición al pod")
+	errUpstreamReadTimeout  = errors.New("el pod no envió los headers de la respuesta a tiempo")
+	errUpstreamIdleTimeout  = errors.New("el pod dejó de enviar datos")
+	errUpstreamWriteTimeout = errors.New("el cliente dejó de recibir datos")
+)
+
+// upstreamTimeouts son los plazos de una petición proxificada; 0 no limita.
+// Total cubre la petición completa, Read la espera de los headers, Idle el
+// silencio entre bloques del cuerpo y Write cada escritura hacia el cliente.
+type upstreamTimeouts struct {
+	Total time.Duration
+	Read  time.Duration
+	Idle  time.Duration
+	Write time.Duration
+}
+
+// requestTimeouts combina los plazos de UPSTREAM_*_TIMEOUT con los que pide la
+// petición por header o query. Los pedidos se limitan a UPSTREAM_MAX_TIMEOUT y 0
+// equivale a ese máximo, para que un long polling pueda esperar sin quedar abierto
+// para siempre. Los headers y parámetros se quitan de la petición.
+func requestTimeouts(r *http.Request) upstreamTimeouts {
+	timeouts := upstreamTimeouts{
+		Total: appConfig.UpstreamTimeout,
+		Read:  appConfig.UpstreamHeaderTimeout,
+		Idle:  appConfig.UpstreamReadIdleTimeout,
+		Write: appConfig.UpstreamWriteTimeout,
+	}
+	requested := make(map[string]string)
+	for _, header := range timeoutQueryParams {
+		if value := r.Header.Get(header); value != "" {
+			requested[header] = value
+		}
+		r.Header.Del(header)
+	}
+	if r.URL.RawQuery != "" {
+		query := r.URL.Query()
+		found := false
+		for param, header := range timeoutQueryParams {
+			if !query.Has(param) {
+				continue
+			}
+			found = true
+			if _, ok := requested[header]; !ok {
+				requested[header] = query.Get(param)
+			}
+			query.Del(param)
+		}
+		// Solo se re-codifica la query si traía parámetros propios, para no
+		// alterar la codificación original de las demás
+		if found {
+			r.URL.RawQuery = query.Encode()
+		}
+	}
+
+	for header, target := range map[string]*time.Duration{
+		timeoutHeader:      &timeouts.Total,
+		readTimeoutHeader:  &timeouts.Read,
+		idleTimeoutHeader:  &timeouts.Idle,
+		writeTimeoutHeader: &timeouts.Write,
+	} {
+		if value, ok := requested[header]; ok {
+			if d, ok := parseRequestTimeout(value); ok {
+				*target = d
+			}
+		}
+	}
+	return timeouts
+}
+
+// parseRequestTimeout acepta una duración de Go (90s, 5m) o una cantidad de segundos
+func parseRequestTimeout(value string) (time.Duration, bool) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < 0 {
+		return 0, false
+	}
+	if limit := appConfig.UpstreamMaxTimeout; limit > 0 && (d == 0 || d > limit) {
+		d = limit
+	}
+	return d, true
+}
+
+// isStreamingPath indica si la ruta del pod está en UPSTREAM_STREAMING_PATHS, donde
+// no aplica el plazo total
+func isStreamingPath(path string) bool {
+	for _, prefix := range appConfig.UpstreamStreamingPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamDeadline aplica los plazos a la petición al pod cancelando su contexto
+// con la causa correspondiente
+type upstreamDeadline struct {
+	timeouts upstreamTimeouts
+	cancel   context.CancelCauseFunc
+	total    *time.Timer
+	read     *time.Timer
+	// client es el controller de la respuesta si se fijaron plazos de escritura
+	client *http.ResponseController
+}
+
+// startUpstreamDeadline deriva el contexto de la petición al pod y arranca los
+// plazos total y de headers. En las rutas de streaming no hay plazo total.
+func startUpstreamDeadline(ctx context.Context, timeouts upstreamTimeouts, target *url.URL) (context.Context, *upstreamDeadline) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	d := &upstreamDeadline{timeouts: timeouts, cancel: cancel}
+	if timeouts.Total > 0 && !isStreamingPath(target.Path) {
+		d.total = time.AfterFunc(timeouts.Total, func() { cancel(errUpstreamTimeout) })
+	}
+	if timeouts.Read > 0 {
+		d.read = time.AfterFunc(timeouts.Read, func() { cancel(errUpstreamReadTimeout) })
+	}
+	return ctx, d
+}
+
+// headersReceived detiene el plazo de headers; si la respuesta es un stream
+// (Server-Sent Events o gRPC) también el total, que cortaría la conexión del cliente
+func (d *upstreamDeadline) headersReceived(resp *http.Response) {
+	if d.read != nil {
+		d.read.Stop()
+	}
+	if d.total != nil && isEventStream(resp) {
+		d.total.Stop()
+	}
+}
+
+// stop libera los timers y el contexto al terminar la petición
+func (d *upstreamDeadline) stop() {
+	if d.total != nil {
+		d.total.Stop()
+	}
+	if d.read != nil {
+		d.read.Stop()
+	}
+	// El plazo de escritura es absoluto y queda en la conexión: sin quitarlo, la
+	// próxima respuesta keep-alive fallaría aunque el cliente esté leyendo
+	if d.client != nil {
+		d.client.SetWriteDeadline(time.Time{})
+	}
+	d.cancel(nil)
+}
+
+// timedOut devuelve el plazo que canceló la petición, o nil si no fue un plazo
+func timedOut(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	for _, err := range []error{errUpstreamTimeout, errUpstreamReadTimeout, errUpstreamIdleTimeout, errUpstreamWriteTimeout} {
+		if errors.Is(cause, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchIdle cancela la petición si el pod pasa Idle sin enviar datos del cuerpo
+func (d *upstreamDeadline) watchIdle(resp *http.Response) {
+	if d.timeouts.Idle <= 0 {
+		return
+	}
+	timer := time.AfterFunc(d.timeouts.Idle, func() { d.cancel(errUpstreamIdleTimeout) })
+	resp.Body = &idleTimeoutBody{ReadCloser: resp.Body, timer: timer, idle: d.timeouts.Idle}
+}
+
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.timer.Stop()
+	} else {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+// clientWriter fija un plazo para cada escritura y cada flush hacia el cliente: un
+// navegador que deja de leer llena el buffer del socket y la escritura se bloquea.
+// Si el plazo vence la escritura falla y se cancela la petición al pod, que deja
+// de ocupar el túnel.
+func (d *upstreamDeadline) clientWriter(w http.ResponseWriter) http.ResponseWriter {
+	if d.timeouts.Write <= 0 {
+		return w
+	}
+	d.client = http.NewResponseController(w)
+	return &deadlineWriter{ResponseWriter: w, controller: d.client, deadline: d}
+}
+
+type deadlineWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	deadline   *upstreamDeadline
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.controller.SetWriteDeadline(time.Now().Add(w.deadline.timeouts.Write))
+	n, err := w.ResponseWriter.Write(p)
+	if isTimeout(err) {
+		w.deadline.cancel(errUpstreamWriteTimeout)
+	}
+	return n, err
+}
+
+func (w *deadlineWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError es Flush con el plazo de escritura; lo usa http.ResponseController
+func (w *deadlineWriter) FlushError() error {
+	w.controller.SetWriteDeadline(time.Now().Add(w.deadline.timeouts.Write))
+	err := w.controller.Flush()
+	if isTimeout(err) {
+		w.deadline.cancel(errUpstreamWriteTimeout)
+	}
+	return err
+}
+
+// isTimeout indica si el error es un plazo vencido de la conexión
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
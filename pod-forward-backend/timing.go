@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// sessionTiming desglosa cuánto tardó en abrirse una sesión, para distinguir un
+// cluster lento (búsqueda del pod, handshake SPDY) de una aplicación lenta (primer byte)
+type sessionTiming struct {
+	mu sync.Mutex
+	// PodLookup es la consulta del pod al API server
+	PodLookup time.Duration
+	// Handshake es el upgrade SPDY de pods/portforward
+	Handshake time.Duration
+	// ListenerReady es desde el handshake hasta que el puerto local acepta conexiones
+	ListenerReady time.Duration
+	// FirstByte es desde que la sesión quedó lista hasta la primera respuesta del pod
+	FirstByte time.Duration
+	readyAt   time.Time
+}
+
+// sessionTimingView es la representación JSON del desglose, en milisegundos
+type sessionTimingView struct {
+	PodLookupMs     int64  `json:"podLookupMs"`
+	HandshakeMs     int64  `json:"handshakeMs"`
+	ListenerReadyMs int64  `json:"listenerReadyMs"`
+	FirstByteMs     *int64 `json:"firstByteMs,omitempty"`
+}
+
+func (t *sessionTiming) view() *sessionTimingView {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	view := &sessionTimingView{
+		PodLookupMs:     t.PodLookup.Milliseconds(),
+		HandshakeMs:     t.Handshake.Milliseconds(),
+		ListenerReadyMs: t.ListenerReady.Milliseconds(),
+	}
+	if t.FirstByte > 0 {
+		firstByte := t.FirstByte.Milliseconds()
+		view.FirstByteMs = &firstByte
+	}
+	return view
+}
+
+// recordSetup guarda las fases de la creación y las suma a las métricas
+func (t *sessionTiming) recordSetup(project string, podLookup, handshake, listenerReady time.Duration) {
+	t.mu.Lock()
+	t.PodLookup, t.Handshake, t.ListenerReady = podLookup, handshake, listenerReady
+	t.readyAt = time.Now()
+	t.mu.Unlock()
+	observeSetupPhase(project, "pod_lookup", podLookup)
+	observeSetupPhase(project, "spdy_handshake", handshake)
+	observeSetupPhase(project, "listener_ready", listenerReady)
+}
+
+// recordFirstByte guarda el tiempo hasta la primera respuesta del pod; solo cuenta la primera
+func (t *sessionTiming) recordFirstByte(project string) {
+	t.mu.Lock()
+	if t.FirstByte > 0 || t.readyAt.IsZero() {
+		t.mu.Unlock()
+		return
+	}
+	t.FirstByte = max(time.Since(t.readyAt), time.Nanosecond)
+	firstByte := t.FirstByte
+	t.mu.Unlock()
+	observeSetupPhase(project, "first_byte", firstByte)
+}
+
+// observeSetupPhase suma la duración de una fase en pod_forward_session_setup_seconds_sum
+// y _count; el promedio por fase es sum/count
+func observeSetupPhase(project, phase string, d time.Duration) {
+	labels := map[string]string{"project": project, "phase": phase}
+	addCounter("pod_forward_session_setup_seconds_sum", labels, d.Seconds())
+	addCounter("pod_forward_session_setup_seconds_count", labels, 1)
+}
+
+// timedDialer mide cuánto tarda el handshake SPDY del port-forward
+type timedDialer struct {
+	httpstream.Dialer
+	handshake time.Duration
+}
+
+func (d *timedDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	started := time.Now()
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	d.handshake = time.Since(started)
+	return conn, protocol, err
+}
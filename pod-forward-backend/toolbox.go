@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// toolboxHelpers son las herramientas web de depuración que se pueden lanzar en
+// un pod temporal; la imagen se puede cambiar con HELPER_IMAGES
+var toolboxHelpers = map[string]helperSpec{
+	// Terminal web con una shell en el pod toolbox
+	"ttyd": {Kind: "ttyd", Image: "tsl0922/ttyd:1.7.4", Port: 7681, Args: []string{"ttyd", "--writable", "--port", "7681", "bash"}},
+	// Explorador de archivos; la autenticación la hace el backend
+	"filebrowser": {Kind: "filebrowser", Image: "filebrowser/filebrowser:v2.27.0", Port: 8080, Args: []string{"--address=0.0.0.0", "--port=8080", "--noauth", "--root=/srv"}},
+}
+
+// toolboxRequest es el cuerpo de POST /api/v2/toolbox
+type toolboxRequest struct {
+	Namespace string `json:"namespace"`
+	// Tool es ttyd (por defecto) o filebrowser
+	Tool string `json:"tool,omitempty"`
+}
+
+// handleToolbox lanza un pod toolbox temporal en el namespace y devuelve la sesión
+// hacia su herramienta web. El pod se borra cuando la sesión se cierra o expira.
+func handleToolbox(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset, config *rest.Config) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body toolboxRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, "Cuerpo JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Namespace == "" {
+		http.Error(w, "Falta el parámetro requerido: namespace", http.StatusBadRequest)
+		return
+	}
+	if body.Tool == "" {
+		body.Tool = "ttyd"
+	}
+	spec, ok := toolboxHelpers[body.Tool]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Herramienta desconocida: %s", body.Tool), http.StatusBadRequest)
+		return
+	}
+	if err := getPolicy().checkToolbox(body.Namespace); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	identity := identityFromRequest(r)
+	session, status, err := openHelperSession(r.Context(), identity, body.Namespace, spec, clientset, config)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	slog.Info("Toolbox listo", "component", "handleToolbox", "kind", spec.Kind, "user", identity.User, "namespace", body.Namespace, "session", session.ID)
+	writeCreatedSession(w, r, session, http.StatusCreated)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/rest"
+)
+
+// Con TRACING_ENABLED el backend envía spans por OTLP/HTTP: uno por petición
+// recibida, la apertura de sesiones con sus llamadas al API de Kubernetes y el
+// salto al pod, que recibe el traceparent para continuar la traza. El destino, el
+// muestreo y el nombre del servicio se configuran con las variables estándar de
+// OpenTelemetry (OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_TRACES_SAMPLER,
+// OTEL_SERVICE_NAME, ...). Deshabilitado, el tracer es no-op y el traceparent del
+// cliente llega al pod sin cambios.
+
+// tracer crea los spans del backend; hasta que setupTracing registra el proveedor
+// es no-op
+var tracer = otel.Tracer("pod-forward-backend")
+
+// tracerProvider es nil con el tracing deshabilitado
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing registra el proveedor de spans con el exporter OTLP
+func setupTracing(ctx context.Context) error {
+	if !appConfig.TracingEnabled {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return fmt.Errorf("error al crear el exporter OTLP: %v", err)
+	}
+	// OTEL_SERVICE_NAME y OTEL_RESOURCE_ATTRIBUTES reemplazan a los valores por defecto
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "pod-forward-backend"),
+			attribute.String("service.instance.id", backendInstance),
+		),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return fmt.Errorf("error al armar el resource de OpenTelemetry: %v", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Error("Error en el tracing", "component", "tracing", "error", err)
+	}))
+	slog.Info("Tracing OpenTelemetry activo", "component", "tracing")
+	return nil
+}
+
+// shutdownTracing envía los spans pendientes; se llama al final del apagado
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		slog.Error("Error al enviar los spans pendientes", "component", "tracing", "error", err)
+	}
+}
+
+// traceRequests abre el span de cada petición recibida, continuando la traza del
+// traceparent del cliente. Va dentro de accessLog, que registra el status.
+func traceRequests(next http.Handler) http.Handler {
+	if !appConfig.TracingEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("user_agent.original", r.UserAgent()),
+				attribute.String("pod_forward.request_id", requestID(r.Context())),
+			))
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		if user := identityFromRequest(r).User; user != "" {
+			span.SetAttributes(attribute.String("enduser.id", user))
+		}
+		if entry, ok := r.Context().Value(accessLogEntryKey{}).(*accessLogEntry); ok && entry.Session != "" {
+			span.SetAttributes(
+				attribute.String("pod_forward.session", entry.Session),
+				attribute.String("k8s.namespace.name", entry.Namespace),
+				attribute.String("k8s.pod.name", entry.Pod),
+				attribute.Int("pod_forward.port", entry.Port))
+		}
+		if recorder, ok := w.(*accessLogWriter); ok && recorder.status != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+			if recorder.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(recorder.status))
+			}
+		}
+	})
+}
+
+// endSpan marca el span con el error, si hubo, y lo cierra
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceKubeClient agrega un span por cada llamada al API de Kubernetes hecha
+// dentro de una traza (apertura de sesión, resolución del pod); las de fondo
+// (informers, health checks) no tienen padre y no se registran
+func traceKubeClient(config *rest.Config) {
+	if !appConfig.TracingEnabled {
+		return
+	}
+	config.Wrap(func(base http.RoundTripper) http.RoundTripper {
+		return &kubeTracingRoundTripper{base: base, host: config.Host}
+	})
+}
+
+// kubeTracingRoundTripper abre un span de cliente por petición al API server
+type kubeTracingRoundTripper struct {
+	base http.RoundTripper
+	host string
+}
+
+func (t *kubeTracingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanFromContext(req.Context()).SpanContext().IsValid() {
+		return t.base.RoundTrip(req)
+	}
+	ctx, span := tracer.Start(req.Context(), "k8s "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", t.host),
+			attribute.String("url.path", req.URL.Path),
+		))
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	endHTTPSpan(span, resp, err)
+	return resp, err
+}
+
+// endHTTPSpan registra el status de la respuesta (o el error) en el span de
+// cliente y lo cierra. El span termina con los headers: el cuerpo no se espera.
+func endHTTPSpan(span trace.Span, resp *http.Response, err error) {
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	endSpan(span, err)
+}
+
+// startSessionSpan abre el span de la apertura de una sesión: validaciones, llamadas
+// al API de Kubernetes y creación del port-forward
+func startSessionSpan(ctx context.Context, sessionKey, namespace, pod string, port int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "session.open", trace.WithAttributes(
+		attribute.String("pod_forward.session_key", sessionKey),
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.pod.name", pod),
+		attribute.Int("pod_forward.port", port)))
+}
+
+// startProxySpan abre el span del salto al pod y agrega el traceparent a la
+// petición, para que la aplicación continúe la traza
+func startProxySpan(req *http.Request, session *PortForwardSession) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(req.Context(), "proxy "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("k8s.namespace.name", session.Namespace),
+			attribute.String("k8s.pod.name", session.Pod),
+			attribute.Int("pod_forward.port", session.Port),
+		))
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// sessionTransport es el pool de conexiones de una sesión hacia sus puertos
+// locales: uno para las peticiones normales y otro para las raw, con un
+// http.Client reutilizable cada uno. Al ser propio de la sesión, las conexiones
+// se cierran con ella y nunca se reusan contra el puerto local de otra sesión.
+type sessionTransport struct {
+	once      sync.Once
+	normal    *http.Transport
+	raw       *http.Transport
+	client    *http.Client
+	rawClient *http.Client
+	// h2 es el transporte HTTP/2 (gRPC y protocol=h2), creado en el primer uso
+	h2once sync.Once
+	h2     *http2.Transport
+}
+
+// noRedirects devuelve las redirecciones del pod al navegador en lugar de seguirlas
+func noRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+func (t *sessionTransport) init(s *PortForwardSession) {
+	t.once.Do(func() {
+		t.normal, t.raw = s.newSessionTransport(false), s.newSessionTransport(true)
+		t.client = &http.Client{Transport: t.normal, CheckRedirect: noRedirects}
+		t.rawClient = &http.Client{Transport: t.raw, CheckRedirect: noRedirects}
+	})
+}
+
+// httpClient devuelve el cliente de la sesión para el modo de la petición. El
+// transporte limita la conexión; los plazos de la respuesta los aplica
+// upstreamDeadline.
+func (s *PortForwardSession) httpClient(raw bool) *http.Client {
+	s.transport.init(s)
+	if raw {
+		return s.transport.rawClient
+	}
+	return s.transport.client
+}
+
+// upstreamTransport devuelve el transporte de la sesión para el modo de la petición
+func (s *PortForwardSession) upstreamTransport(raw bool) http.RoundTripper {
+	return s.httpClient(raw).Transport
+}
+
+// closeIdleConnections descarta las conexiones guardadas en el pool, por ejemplo
+// cuando el port-forward se reabre y las anteriores quedaron muertas
+func (s *PortForwardSession) closeIdleConnections() {
+	s.transport.init(s)
+	s.transport.normal.CloseIdleConnections()
+	s.transport.raw.CloseIdleConnections()
+	if s.transport.h2 != nil {
+		s.transport.h2.CloseIdleConnections()
+	}
+	s.closeAffineConnections()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// extraTunnel es un port-forward adicional hacia el mismo pod de la sesión, con su
+// propio puerto local y su propia conexión SPDY
+type extraTunnel struct {
+	LocalPort int
+	StopChan  chan struct{}
+	streams   streamGate
+	stopped   bool
+}
+
+// ensureTunnels abre o cierra túneles adicionales hasta que la sesión tenga count en
+// total (el túnel principal incluido). Las peticiones se reparten entre todos para
+// sumar el ancho de banda de varias conexiones SPDY. count se limita a
+// MAX_SESSION_TUNNELS; 0 deja la sesión como está.
+func (s *PortForwardSession) ensureTunnels(ctx context.Context, count int, clientset *kubernetes.Clientset, config *rest.Config) {
+	if count <= 0 {
+		return
+	}
+	count = min(count, max(appConfig.MaxSessionTunnels, 1))
+
+	s.mu.Lock()
+	s.TunnelsWanted = count
+	var surplus []*extraTunnel
+	for len(s.tunnels) > count-1 {
+		surplus = append(surplus, s.tunnels[len(s.tunnels)-1])
+		s.tunnels = s.tunnels[:len(s.tunnels)-1]
+	}
+	missing := count - 1 - len(s.tunnels)
+	s.mu.Unlock()
+	for _, tunnel := range surplus {
+		s.stopTunnel(tunnel)
+	}
+
+	for i := 0; i < missing; i++ {
+		_, stopChan, errChan, localPort, err := dialPortForward(ctx, clientset, config, s.Namespace, s.Pod, 0, s.Port, nil)
+		if err != nil {
+			slog.Warn("No se pudo abrir un túnel adicional", "component", "ensureTunnels", "session", s.ID, "error", err)
+			return
+		}
+		tunnel := &extraTunnel{LocalPort: localPort, StopChan: stopChan}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			close(stopChan)
+			return
+		}
+		s.tunnels = append(s.tunnels, tunnel)
+		s.mu.Unlock()
+
+		localPortMu.Lock()
+		localPortToSession[localPort] = s.Key
+		localPortMu.Unlock()
+
+		goTask("tunnel-supervise", func(context.Context) error {
+			err := <-errChan
+			// Un túnel adicional que se corta no se reconecta: la sesión sigue con el resto
+			if s.removeTunnel(tunnel) {
+				slog.Info("Túnel adicional finalizado", "component", "ensureTunnels", "session", s.ID, "localPort", tunnel.LocalPort, "error", err)
+				s.stopTunnel(tunnel)
+			}
+			return nil
+		})
+	}
+	slog.Debug("Túneles de la sesión", "component", "ensureTunnels", "session", s.ID, "tunnels", s.tunnelCount())
+}
+
+// removeTunnel quita el túnel de la sesión; devuelve false si ya no estaba
+func (s *PortForwardSession) removeTunnel(tunnel *extraTunnel) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, candidate := range s.tunnels {
+		if candidate == tunnel {
+			s.tunnels = append(s.tunnels[:i], s.tunnels[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// stopTunnel detiene el port-forward del túnel y libera su puerto local
+func (s *PortForwardSession) stopTunnel(tunnel *extraTunnel) {
+	s.mu.Lock()
+	stopped := tunnel.stopped
+	tunnel.stopped = true
+	s.mu.Unlock()
+	if !stopped {
+		close(tunnel.StopChan)
+	}
+	localPortMu.Lock()
+	if localPortToSession[tunnel.LocalPort] == s.Key {
+		delete(localPortToSession, tunnel.LocalPort)
+	}
+	localPortMu.Unlock()
+}
+
+// closeTunnels detiene todos los túneles adicionales; lo usa Close
+func (s *PortForwardSession) closeTunnels() {
+	s.mu.Lock()
+	tunnels := s.tunnels
+	s.tunnels = nil
+	s.mu.Unlock()
+	for _, tunnel := range tunnels {
+		s.stopTunnel(tunnel)
+	}
+}
+
+// tunnelPorts devuelve los puertos locales de todos los túneles, el principal primero
+func (s *PortForwardSession) tunnelPorts() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ports := []int{s.LocalPort}
+	for _, tunnel := range s.tunnels {
+		ports = append(ports, tunnel.LocalPort)
+	}
+	return ports
+}
+
+// tunnelCount es la cantidad de túneles abiertos, el principal incluido
+func (s *PortForwardSession) tunnelCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return 1 + len(s.tunnels)
+}
+
+// pickLocalPort reparte las conexiones entre los túneles en round-robin
+func (s *PortForwardSession) pickLocalPort() int {
+	ports := s.tunnelPorts()
+	return ports[int(s.nextTunnel.Add(1)-1)%len(ports)]
+}
+
+// streamGateFor devuelve el contador de streams del túnel con ese puerto local
+func (s *PortForwardSession) streamGateFor(localPort int) *streamGate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tunnel := range s.tunnels {
+		if tunnel.LocalPort == localPort {
+			return &tunnel.streams
+		}
+	}
+	return &s.streams
+}
+
+// streamUsage suma las conexiones abiertas y en espera de todos los túneles
+func (s *PortForwardSession) streamUsage() (int, int) {
+	s.mu.Lock()
+	gates := []*streamGate{&s.streams}
+	for _, tunnel := range s.tunnels {
+		gates = append(gates, &tunnel.streams)
+	}
+	s.mu.Unlock()
+	open, queued := 0, 0
+	for _, gate := range gates {
+		o, q := gate.usage()
+		open += o
+		queued += q
+	}
+	return open, queued
+}
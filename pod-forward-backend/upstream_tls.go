@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// tlsCAKey es la clave opcional del Secret TLS con la CA que firmó el certificado del pod
+const tlsCAKey = "ca.crt"
+
+// Los pods que solo sirven TLS se alcanzan por HTTPS a través del túnel: por
+// elección al crear la sesión (scheme=https) o porque la PodForwardPolicy les
+// asocia un certificado de cliente (clientCertificates) para mTLS. El mapping se
+// consulta en cada conexión nueva, así un Secret rotado o una política cambiada
+// aplican sin reabrir la sesión.
+
+// upstreamTLS son las opciones de TLS hacia el pod elegidas al crear la sesión
+type upstreamTLS struct {
+	Enabled            bool
+	InsecureSkipVerify bool
+	// CASecret es el Secret del namespace del pod cuyo ca.crt valida el certificado del pod
+	CASecret string
+	roots    *x509.CertPool
+}
+
+// loadUpstreamTLS valida los parámetros scheme, insecureSkipVerify y caSecret y lee
+// la CA del Secret. Si falla devuelve el código HTTP para responder.
+func loadUpstreamTLS(ctx context.Context, clientset kubernetes.Interface, namespace, scheme string, insecure bool, caSecret string) (upstreamTLS, int, error) {
+	switch scheme {
+	case "", "http":
+		if insecure || caSecret != "" {
+			return upstreamTLS{}, http.StatusBadRequest, errors.New("insecureSkipVerify y caSecret requieren scheme=https")
+		}
+		return upstreamTLS{}, http.StatusOK, nil
+	case "https":
+	default:
+		return upstreamTLS{}, http.StatusBadRequest, fmt.Errorf("scheme inválido %q: debe ser http o https", scheme)
+	}
+	opts := upstreamTLS{Enabled: true, InsecureSkipVerify: insecure, CASecret: caSecret}
+	if caSecret == "" {
+		return opts, http.StatusOK, nil
+	}
+	if insecure {
+		return upstreamTLS{}, http.StatusBadRequest, errors.New("insecureSkipVerify y caSecret son excluyentes")
+	}
+	if errs := validation.IsDNS1123Subdomain(caSecret); len(errs) > 0 {
+		return upstreamTLS{}, http.StatusBadRequest, fmt.Errorf("caSecret inválido %q: %s", caSecret, strings.Join(errs, ", "))
+	}
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, caSecret, metav1.GetOptions{})
+	if err != nil {
+		return upstreamTLS{}, lookupStatus(err), fmt.Errorf("error al leer el Secret %s/%s: %v", namespace, caSecret, err)
+	}
+	opts.roots = x509.NewCertPool()
+	if !opts.roots.AppendCertsFromPEM(secret.Data[tlsCAKey]) {
+		return upstreamTLS{}, http.StatusBadRequest, fmt.Errorf("el Secret %s/%s no tiene un %s válido", namespace, caSecret, tlsCAKey)
+	}
+	return opts, http.StatusOK, nil
+}
+
+// setUpstreamTLS aplica las opciones elegidas al crear o reutilizar la sesión. Como
+// raw, una petición sin scheme=https no vuelve la sesión a HTTP.
+func (s *PortForwardSession) setUpstreamTLS(opts upstreamTLS) {
+	if !opts.Enabled {
+		return
+	}
+	s.mu.Lock()
+	changed := s.TLS.InsecureSkipVerify != opts.InsecureSkipVerify || s.TLS.CASecret != opts.CASecret || !s.TLS.Enabled
+	s.TLS = opts
+	s.mu.Unlock()
+	if changed {
+		// Las conexiones abiertas se validaron con las opciones anteriores
+		s.closeIdleConnections()
+	}
+}
+
+// upstreamTLSOptions devuelve las opciones de TLS de la sesión
+func (s *PortForwardSession) upstreamTLSOptions() upstreamTLS {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TLS
+}
+
+// upstreamClientCertificate devuelve el certificado de cliente vigente para el pod
+// de la sesión, o nil si el pod se alcanza por HTTP
+func (s *PortForwardSession) upstreamClientCertificate() *resolvedClientCertificate {
+	return getPolicy().clientCertificateFor(s.Namespace, s.Pod, s.Port)
+}
+
+// upstreamScheme es el esquema con el que se habla con el pod
+func (s *PortForwardSession) upstreamScheme() string {
+	if s.upstreamTLSOptions().Enabled || s.upstreamClientCertificate() != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// upstreamTLSConfig arma la configuración TLS hacia el pod. El pod se alcanza por
+// localhost, que no figura en su certificado: la verificación estándar se
+// reemplaza por una que valida la cadena (con la CA de caSecret, la del Secret del
+// certificado de cliente o las del sistema) y, si el mapping lo indica, ServerName.
+func upstreamTLSConfig(opts upstreamTLS, cert *resolvedClientCertificate) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: true}
+	roots, serverName := opts.roots, ""
+	if cert != nil {
+		config.Certificates = []tls.Certificate{cert.certificate}
+		config.ServerName = cert.ServerName
+		serverName = cert.ServerName
+		if roots == nil {
+			roots = cert.roots
+		}
+	}
+	if opts.InsecureSkipVerify || (cert != nil && cert.InsecureSkipVerify) {
+		return config
+	}
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("el pod no presentó certificado")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, intermediate := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(intermediate)
+		}
+		_, err := state.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return config
+}
+
+// dialUpstreamTLS abre la conexión al puerto local por dialUpstream (respetando el
+// límite de streams del túnel) y hace el handshake TLS con el certificado vigente
+func (s *PortForwardSession) dialUpstreamTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	return s.dialUpstreamTLSWith(ctx, network, addr, nil)
+}
+
+// dialUpstreamTLSWith es dialUpstreamTLS ofreciendo los protocolos ALPN indicados;
+// si el pod no acepta ninguno la conexión falla
+func (s *PortForwardSession) dialUpstreamTLSWith(ctx context.Context, network, addr string, nextProtos []string) (net.Conn, error) {
+	conn, err := dialUpstream(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	handshakeCtx, cancel := context.WithTimeout(ctx, appConfig.UpstreamTLSTimeout)
+	defer cancel()
+	config := upstreamTLSConfig(s.upstreamTLSOptions(), s.upstreamClientCertificate())
+	config.NextProtos = nextProtos
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		conn.Close()
+		addCounter("pod_forward_upstream_tls_errors_total", map[string]string{"project": s.Project}, 1)
+		return nil, fmt.Errorf("error en el handshake TLS con el pod: %v", err)
+	}
+	if len(nextProtos) > 0 && tlsConn.ConnectionState().NegotiatedProtocol == "" {
+		conn.Close()
+		return nil, fmt.Errorf("el pod no acepta %s por ALPN", strings.Join(nextProtos, ", "))
+	}
+	return tlsConn, nil
+}
+
+// dialTarget abre una conexión al pod con el esquema de la sesión, para los
+// proxies a nivel de conexión (WebSocket)
+func (s *PortForwardSession) dialTarget(ctx context.Context, addr string) (net.Conn, error) {
+	if s.upstreamScheme() == "https" {
+		return s.dialUpstreamTLS(ctx, "tcp", addr)
+	}
+	return dialUpstream(ctx, "tcp", addr)
+}
+
+// newSessionTransport es el transporte hacia los puertos locales de la sesión;
+// las conexiones HTTPS usan dialUpstreamTLS
+func (s *PortForwardSession) newSessionTransport(raw bool) *http.Transport {
+	transport := newUpstreamTransport(raw)
+	transport.DialTLSContext = s.dialUpstreamTLS
+	return transport
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// findReadyPod busca el pod listo más reciente que coincide con el selector.
+// Si no hay ninguno devuelve "" y un resumen del estado de los pods encontrados.
+func findReadyPod(ctx context.Context, clientset *kubernetes.Clientset, namespace, selector string) (string, string, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", "", fmt.Errorf("error al listar pods con %q: %v", selector, err)
+	}
+
+	var ready *corev1.Pod
+	var states []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			states = append(states, pod.Name+": Terminating")
+			continue
+		}
+		if isPodReady(pod) {
+			if ready == nil || pod.CreationTimestamp.After(ready.CreationTimestamp.Time) {
+				ready = pod
+			}
+			continue
+		}
+		states = append(states, pod.Name+": "+podWaitingReason(pod))
+	}
+	if ready != nil {
+		return ready.Name, "", nil
+	}
+	if len(states) == 0 {
+		return "", fmt.Sprintf("ningún pod coincide con %q", selector), nil
+	}
+	return "", strings.Join(states, ", "), nil
+}
+
+// isPodReady indica si el pod está corriendo con la condición Ready
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podWaitingReason describe por qué el pod todavía no está listo (ej: Pending (ContainerCreating))
+func podWaitingReason(pod *corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return fmt.Sprintf("%s (%s)", pod.Status.Phase, status.State.Waiting.Reason)
+		}
+	}
+	return string(pod.Status.Phase)
+}
+
+// resolveSelectorTarget devuelve el pod listo que coincide con el selector. Con
+// waitReady espera hasta timeout a que aparezca uno e informa cada cambio de
+// estado a progress (puede ser nil).
+func resolveSelectorTarget(ctx context.Context, clientset *kubernetes.Clientset, namespace, selector string, waitReady bool, timeout time.Duration, progress func(string)) (string, int, error) {
+	if _, err := labels.Parse(selector); err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("selector inválido %q: %v", selector, err)
+	}
+	if !waitReady {
+		pod, summary, err := findReadyPod(ctx, clientset, namespace, selector)
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		if pod == "" {
+			return "", http.StatusNotFound, fmt.Errorf("no hay pods listos en %s: %s", namespace, summary)
+		}
+		return pod, http.StatusOK, nil
+	}
+
+	slog.Info("Esperando un pod listo", "component", "resolveSelectorTarget", "namespace", namespace, "selector", selector, "timeout", timeout.String())
+	var pod, last string
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		found, summary, err := findReadyPod(ctx, clientset, namespace, selector)
+		if err != nil {
+			return false, err
+		}
+		if found != "" {
+			pod = found
+			return true, nil
+		}
+		if summary != last && progress != nil {
+			progress(summary)
+		}
+		last = summary
+		return false, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			return "", http.StatusGatewayTimeout, fmt.Errorf("ningún pod quedó listo en %s después de %s (%s)", namespace, timeout, last)
+		}
+		return "", http.StatusInternalServerError, err
+	}
+	slog.Info("Pod listo", "component", "resolveSelectorTarget", "namespace", namespace, "pod", pod, "selector", selector)
+	return pod, http.StatusOK, nil
+}
+
+// waitTimeout es el plazo de espera pedido por el cliente, limitado por WAIT_MAX_TIMEOUT
+func waitTimeout(requested string) time.Duration {
+	timeout := appConfig.WaitMaxTimeout
+	if d, err := time.ParseDuration(requested); err == nil && d > 0 && d < timeout {
+		timeout = d
+	}
+	return timeout
+}
+
+// eventStream envía eventos Server-Sent Events al cliente
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// wantsEventStream indica si el cliente acepta Server-Sent Events
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// newEventStream escribe los headers de la respuesta SSE
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	stream := &eventStream{w: w, flusher: flusher}
+	stream.flush()
+	return stream
+}
+
+// send escribe un evento con los datos codificados en JSON
+func (s *eventStream) send(event string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload)
+	s.flush()
+}
+
+func (s *eventStream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
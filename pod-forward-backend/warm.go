@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keepWarm mantiene WARM_CONNECTIONS conexiones keep-alive abiertas hacia el pod a
+// través del túnel. Cada WARM_INTERVAL envía en paralelo un HEAD a WARM_PATH por el
+// mismo transporte que usa el proxy, así las conexiones vuelven al pool de idle y la
+// primera petición después de un rato sin uso no paga la apertura del stream SPDY.
+// El ping no cuenta como actividad de la sesión, de modo que no impide el cierre por
+// inactividad. Termina cuando se cierra la sesión.
+func (s *PortForwardSession) keepWarm(connections int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.isDegraded() {
+			continue
+		}
+		s.mu.Lock()
+		transport := s.upstreamTransport(s.Raw)
+		s.mu.Unlock()
+
+		// Con varios túneles se calienta cada uno
+		var wg sync.WaitGroup
+		for _, port := range s.tunnelPorts() {
+			target := upstreamURL(fmt.Sprintf("localhost:%d", port), appConfig.WarmPath, "")
+			target.Scheme = s.upstreamScheme()
+			for i := 0; i < connections; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := warmConnection(transport, target.String()); err != nil {
+						slog.Warn("Error al mantener la sesión activa", "component", "keepWarm", "session", s.ID, "error", err)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+	}
+}
+
+// warmConnection hace un HEAD y descarta el cuerpo para que la conexión vuelva al pool
+func warmConnection(transport http.RoundTripper, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), appConfig.UpstreamHeaderTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return fmt.Errorf("error al crear el ping: %v", err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("error en el ping de conexión: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
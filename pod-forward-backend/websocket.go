@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// wsHalfCloseGrace es cuánto se espera al otro sentido de la conexión después de
+// que uno de los extremos dejó de enviar
+const wsHalfCloseGrace = 10 * time.Second
+
+// isWebSocketUpgrade indica si la petición pide cambiar a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, token := range connectionTokens(r.Header) {
+		if token == "Upgrade" {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyWebSocket reenvía el handshake al pod y, si responde 101, conecta ambos
+// extremos a nivel TCP. Los headers Sec-WebSocket-* (Protocol, Extensions, Key,
+// Version) viajan sin modificar en los dos sentidos, así que la negociación de
+// subprotocolo y de permessage-deflate la resuelven el navegador y la aplicación.
+func proxyWebSocket(w http.ResponseWriter, r *http.Request, session *PortForwardSession) {
+	target := upstreamURL(fmt.Sprintf("localhost:%d", session.pickLocalPort()), r.URL.EscapedPath(), r.URL.RawQuery)
+	target.Scheme = session.upstreamScheme()
+	slog.Debug("Upgrade", "component", "proxyWebSocket", "requestId", requestID(r.Context()), "path", r.URL.Path, "target", target.String(),
+		"subprotocols", r.Header.Get("Sec-WebSocket-Protocol"), "extensions", r.Header.Get("Sec-WebSocket-Extensions"))
+
+	dialCtx, cancel := context.WithTimeout(r.Context(), appConfig.UpstreamHeaderTimeout)
+	upstream, err := session.dialTarget(dialCtx, target.Host)
+	cancel()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al conectar con el pod: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	req, err := http.NewRequest(r.Method, target.String(), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al crear petición: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for key, values := range r.Header {
+		if key == "Host" {
+			continue
+		}
+		req.Header[key] = values
+	}
+	// Solo se conservan los headers de conexión propios del handshake
+	removeHopByHopHeaders(req.Header)
+	stripBackendCredentials(req.Header, r)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	setForwardedHeaders(req.Header, r, sessionPrefix(session))
+	if !runPreProxyHooks(w, r, req, session) {
+		return
+	}
+	policy := getPolicy()
+	session.mu.Lock()
+	token := session.token
+	session.mu.Unlock()
+	applyProfileToRequest(sessionProfile(policy, session), req, token)
+	addCounter("pod_forward_proxied_requests_total", map[string]string{"project": session.Project}, 1)
+
+	// El span cubre el handshake; el traceparent viaja en el upgrade
+	req, span := startProxySpan(req.WithContext(r.Context()), session)
+	upstream.SetDeadline(time.Now().Add(appConfig.UpstreamHeaderTimeout))
+	if err := req.Write(upstream); err != nil {
+		endSpan(span, err)
+		http.Error(w, fmt.Sprintf("Error al enviar el handshake: %v", err), http.StatusBadGateway)
+		return
+	}
+	upstreamReader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(upstreamReader, req)
+	endHTTPSpan(span, resp, err)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al leer el handshake: %v", err), http.StatusBadGateway)
+		return
+	}
+	upstream.SetDeadline(time.Time{})
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// El pod rechazó el upgrade: devolver su respuesta como una petición normal
+		defer resp.Body.Close()
+		slog.Info("El pod rechazó el upgrade", "component", "proxyWebSocket", "requestId", requestID(r.Context()), "status", resp.StatusCode)
+		removeHopByHopHeaders(resp.Header)
+		clearOwnPageHeaders(w.Header())
+		if location := resp.Header.Get("Location"); location != "" {
+			resp.Header.Set("Location", rewriteLocation(location, sessionPrefix(session), appHostsFor(r, sessionProfile(getPolicy(), session))))
+		}
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "El servidor no soporta WebSocket", http.StatusInternalServerError)
+		return
+	}
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		slog.Error("Error al tomar la conexión del cliente", "component", "proxyWebSocket", "requestId", requestID(r.Context()), "error", err)
+		return
+	}
+	defer client.Close()
+
+	// Devolver el 101 tal cual lo envió el pod, con Sec-WebSocket-Protocol y
+	// Sec-WebSocket-Extensions incluidos
+	if err := resp.Write(client); err != nil {
+		slog.Error("Error al enviar el 101 al cliente", "component", "proxyWebSocket", "requestId", requestID(r.Context()), "error", err)
+		return
+	}
+	slog.Debug("Conexión establecida", "component", "proxyWebSocket", "session", session.ID,
+		"subprotocol", resp.Header.Get("Sec-WebSocket-Protocol"), "extensions", resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	// La conexión abierta cuenta como actividad de la sesión hasta que se cierra
+	session.mu.Lock()
+	session.WSConns++
+	session.LastUsed = time.Now()
+	session.mu.Unlock()
+	addCounter("pod_forward_websocket_connections_total", map[string]string{"project": session.Project}, 1)
+	defer func() {
+		session.mu.Lock()
+		session.WSConns--
+		session.LastUsed = time.Now()
+		session.mu.Unlock()
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// Incluir lo que el servidor HTTP ya había leído del cliente
+		io.Copy(upstream, &transferCounter{io.MultiReader(clientBuf.Reader, client), &session.BytesIn, session})
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		// Un navegador que deja de leer no retiene el túnel: la escritura vence y se
+		// corta la conexión
+		_, err := io.Copy(&clientConnWriter{client, appConfig.UpstreamWriteTimeout}, &transferCounter{upstreamReader, &session.BytesOut, session})
+		if isTimeout(err) {
+			slog.Info("El cliente dejó de recibir datos, cortando WebSocket", "component", "proxyWebSocket", "session", session.ID)
+			client.Close()
+			upstream.Close()
+		} else {
+			closeWrite(client)
+		}
+		done <- struct{}{}
+	}()
+	// Cuando un extremo termina de enviar, el otro sentido sigue abierto hasta que
+	// también termina o pasa wsHalfCloseGrace, para no perder el cierre del protocolo
+	select {
+	case <-done:
+	case <-session.Done():
+		slog.Info("Sesión cerrada, cortando WebSocket", "component", "proxyWebSocket", "session", session.ID)
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(wsHalfCloseGrace):
+	case <-session.Done():
+	}
+}
+
+// closeWrite cierra la mitad de escritura si la conexión lo permite, para que el
+// otro extremo vea el fin de los datos sin cortar la lectura
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// clientConnWriter fija un plazo para cada escritura hacia el cliente; 0 no limita
+type clientConnWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *clientConnWriter) Write(p []byte) (int, error) {
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	return w.conn.Write(p)
+}
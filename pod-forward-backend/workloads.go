@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// resolveWorkloadTarget devuelve un pod listo de un Deployment o StatefulSet indicado
+// como <tipo>/<nombre> (deployment/web, statefulset/db; también deploy y sts). Se usa
+// el selector del workload, así el target sigue valiendo después de cada rollout.
+func resolveWorkloadTarget(ctx context.Context, clientset *kubernetes.Clientset, namespace, workload string, waitReady bool, timeout time.Duration, progress func(string)) (string, int, error) {
+	kind, name, ok := strings.Cut(workload, "/")
+	if !ok || name == "" {
+		return "", http.StatusBadRequest, fmt.Errorf("workload inválido %q: se espera deployment/<nombre> o statefulset/<nombre>", workload)
+	}
+
+	var selector *metav1.LabelSelector
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", lookupStatus(err), fmt.Errorf("error al obtener el Deployment %s/%s: %v", namespace, name, err)
+		}
+		selector = deployment.Spec.Selector
+	case "statefulset", "statefulsets", "sts":
+		statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", lookupStatus(err), fmt.Errorf("error al obtener el StatefulSet %s/%s: %v", namespace, name, err)
+		}
+		selector = statefulSet.Spec.Selector
+	default:
+		return "", http.StatusBadRequest, fmt.Errorf("tipo de workload no soportado %q (deployment, statefulset)", kind)
+	}
+	if selector == nil {
+		return "", http.StatusUnprocessableEntity, fmt.Errorf("el workload %s en %s no tiene selector", workload, namespace)
+	}
+
+	pod, status, err := resolveSelectorTarget(ctx, clientset, namespace, metav1.FormatLabelSelector(selector), waitReady, timeout, progress)
+	if err != nil {
+		return "", status, fmt.Errorf("workload %s: %v", workload, err)
+	}
+	slog.Debug("Workload resuelto", "component", "resolveWorkloadTarget", "namespace", namespace, "workload", workload, "pod", pod)
+	return pod, http.StatusOK, nil
+}
+
+// podWorkload devuelve el Deployment o StatefulSet dueño del pod como <tipo>/<nombre>,
+// o vacío si el pod no pertenece a ninguno. Los pods de un Deployment pertenecen a
+// un ReplicaSet, que a su vez pertenece al Deployment.
+func podWorkload(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+	switch owner.Kind {
+	case "StatefulSet":
+		return "statefulset/" + owner.Name
+	case "ReplicaSet":
+		replicaSet, err := clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			slog.Warn("No se pudo obtener el ReplicaSet", "component", "podWorkload", "namespace", pod.Namespace, "replicaSet", owner.Name, "error", err)
+			return ""
+		}
+		if deployment := metav1.GetControllerOf(replicaSet); deployment != nil && deployment.Kind == "Deployment" {
+			return "deployment/" + deployment.Name
+		}
+	}
+	return ""
+}